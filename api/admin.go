@@ -0,0 +1,54 @@
+package api
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// requireAdmin protects management endpoints with the admin token. When no
+// token is configured the endpoints stay open.
+func (h *APIHandler) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.adminToken == "" {
+			next(w, r)
+			return
+		}
+
+		token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !found || subtle.ConstantTimeCompare([]byte(token), []byte(h.adminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			h.sendError(w, http.StatusUnauthorized, "Unauthorized", "A valid admin token is required")
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// requireIngest protects batch ingestion, which accepts client-supplied
+// timestamps, IPs and user agents, with the ingest or the admin token. Unlike
+// management endpoints it is disabled when neither token is configured.
+func (h *APIHandler) requireIngest(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.ingestToken == "" && h.adminToken == "" {
+			h.sendError(w, http.StatusForbidden, "Batch ingestion disabled", "Configure an ingest token to enable batch ingestion")
+			return
+		}
+
+		token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !found || !(matchToken(token, h.ingestToken) || matchToken(token, h.adminToken)) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="ingest"`)
+			h.sendError(w, http.StatusUnauthorized, "Unauthorized", "A valid ingest token is required")
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// matchToken compares a presented token with a configured one in constant
+// time; an unconfigured token matches nothing
+func matchToken(token, configured string) bool {
+	return configured != "" && subtle.ConstantTimeCompare([]byte(token), []byte(configured)) == 1
+}
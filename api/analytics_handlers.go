@@ -0,0 +1,189 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/tyagnii/ecom_test/app"
+	"github.com/tyagnii/ecom_test/db"
+)
+
+// TopBannersResponse represents a top banners response
+type TopBannersResponse struct {
+	Banners []*db.BannerClickCount `json:"banners"`
+	// Total is the number of banners across all pages, as in PerformanceResponse
+	Total  int            `json:"total"`
+	Limit  int            `json:"limit"`
+	Offset int            `json:"offset"`
+	Sort   string         `json:"sort"`
+	From   *time.Time     `json:"from,omitempty"`
+	To     *time.Time     `json:"to,omitempty"`
+	Source db.RollupLevel `json:"source,omitempty"`
+}
+
+// PerformanceResponse represents a banner performance response
+type PerformanceResponse struct {
+	*db.BannerPerformancePage
+	Limit  int        `json:"limit"`
+	Offset int        `json:"offset"`
+	Sort   string     `json:"sort"`
+	Order  string     `json:"order,omitempty"`
+	From   *time.Time `json:"from,omitempty"`
+	To     *time.Time `json:"to,omitempty"`
+}
+
+// TopBannersHandler handles GET /api/v1/analytics/top
+func (h *APIHandler) TopBannersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.sendError(w, http.StatusMethodNotAllowed, "Method not allowed", "Use GET to read top banners")
+		return
+	}
+
+	query, err := parseAnalyticsQuery(r)
+	if err != nil {
+		h.sendError(w, http.StatusBadRequest, "Invalid query", err.Error())
+		return
+	}
+
+	response := TopBannersResponse{
+		Limit:  query.Limit,
+		Offset: query.Offset,
+		Sort:   query.Sort,
+	}
+
+	// The lifetime ranking by clicks is what the top banners cache holds
+	if !query.Windowed() && query.Offset == 0 && query.Sort == "clicks" && query.Order != "asc" {
+		banners, err := h.cachedRepo.GetTopBanners(query.Limit)
+		if err != nil {
+			log.Printf("Failed to get top banners: %v", err)
+			h.sendError(w, http.StatusInternalServerError, "Failed to get top banners", "Internal server error")
+			return
+		}
+		response.Banners = banners
+		response.Total = len(banners)
+
+		// Only a full first page leaves banners uncounted
+		if len(banners) >= query.Limit {
+			if response.Total, err = h.cachedRepo.CountBanners(); err != nil {
+				log.Printf("Failed to count banners: %v", err)
+				h.sendError(w, http.StatusInternalServerError, "Failed to get top banners", "Internal server error")
+				return
+			}
+		}
+		h.sendJSON(w, response)
+		return
+	}
+
+	page, err := app.NewAnalyticsService(h.service).GetBannerPerformance(query)
+	if err != nil {
+		log.Printf("Failed to get top banners: %v", err)
+		h.sendError(w, http.StatusInternalServerError, "Failed to get top banners", "Internal server error")
+		return
+	}
+
+	response.Banners = make([]*db.BannerClickCount, 0, len(page.Items))
+	for _, item := range page.Items {
+		response.Banners = append(response.Banners, &db.BannerClickCount{
+			BannerID:   item.BannerID,
+			BannerName: item.BannerName,
+			ClickCount: item.Clicks,
+		})
+	}
+	response.Total = page.Total
+	response.Source = page.Source
+	if query.Windowed() {
+		response.From = &query.From
+		response.To = &query.To
+	}
+
+	h.sendJSON(w, response)
+}
+
+// PerformanceHandler handles GET /api/v1/analytics/performance
+func (h *APIHandler) PerformanceHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.sendError(w, http.StatusMethodNotAllowed, "Method not allowed", "Use GET to read banner performance")
+		return
+	}
+
+	query, err := parseAnalyticsQuery(r)
+	if err != nil {
+		h.sendError(w, http.StatusBadRequest, "Invalid query", err.Error())
+		return
+	}
+
+	page, err := app.NewAnalyticsService(h.service).GetBannerPerformance(query)
+	if err != nil {
+		log.Printf("Failed to get banner performance: %v", err)
+		h.sendError(w, http.StatusInternalServerError, "Failed to get banner performance", "Internal server error")
+		return
+	}
+
+	response := PerformanceResponse{
+		BannerPerformancePage: page,
+		Limit:                 query.Limit,
+		Offset:                query.Offset,
+		Sort:                  query.Sort,
+		Order:                 query.Order,
+	}
+	if query.Windowed() {
+		response.From = &query.From
+		response.To = &query.To
+	}
+
+	h.sendJSON(w, response)
+}
+
+// parseAnalyticsQuery reads the window, sort and page of an analytics request
+// from the query string: from and to as RFC 3339 times, sort, order, limit and
+// offset. Parameters are checked in that order, so the first invalid one is
+// always the one reported.
+func parseAnalyticsQuery(r *http.Request) (*db.AnalyticsQuery, error) {
+	values := r.URL.Query()
+	query := &db.AnalyticsQuery{
+		Sort:  values.Get("sort"),
+		Order: values.Get("order"),
+	}
+
+	times := []struct {
+		name   string
+		target *time.Time
+	}{
+		{"from", &query.From},
+		{"to", &query.To},
+	}
+	for _, param := range times {
+		if value := values.Get(param.name); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return nil, fmt.Errorf("%s must be an RFC 3339 time", param.name)
+			}
+			*param.target = t
+		}
+	}
+
+	numbers := []struct {
+		name   string
+		target *int
+	}{
+		{"limit", &query.Limit},
+		{"offset", &query.Offset},
+	}
+	for _, param := range numbers {
+		if value := values.Get(param.name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s must be a number", param.name)
+			}
+			*param.target = n
+		}
+	}
+
+	if err := query.Normalize(); err != nil {
+		return nil, err
+	}
+	return query, nil
+}
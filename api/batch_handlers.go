@@ -0,0 +1,170 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/tyagnii/ecom_test/app"
+	"github.com/tyagnii/ecom_test/dto"
+)
+
+// Batch ingestion limits
+const (
+	MaxBatchClicks    = 10000
+	MaxBatchBodyBytes = 10 << 20
+)
+
+// BatchClickResponse represents a batch ingestion response
+type BatchClickResponse struct {
+	Accepted int                     `json:"accepted"`
+	Rejected int                     `json:"rejected"`
+	Results  []*app.BatchClickResult `json:"results"`
+}
+
+// BatchClickHandler handles POST /api/v1/clicks:batch. The body is either a JSON
+// array of clicks or NDJSON with one click per line.
+func (h *APIHandler) BatchClickHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.sendError(w, http.StatusMethodNotAllowed, "Method not allowed", "Use POST to submit a click batch")
+		return
+	}
+
+	body := http.MaxBytesReader(w, r.Body, MaxBatchBodyBytes)
+
+	var items []*app.BatchClickItem
+	var err error
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/x-ndjson", "application/ndjson", "application/jsonl":
+		items, err = decodeNDJSONClicks(body)
+	default:
+		items, err = decodeJSONClicks(body)
+	}
+	if err != nil {
+		h.sendError(w, http.StatusBadRequest, "Invalid request body", err.Error())
+		return
+	}
+
+	if len(items) == 0 {
+		h.sendError(w, http.StatusBadRequest, "Invalid request body", "Batch contains no clicks")
+		return
+	}
+
+	if len(items) > MaxBatchClicks {
+		h.sendError(w, http.StatusRequestEntityTooLarge, "Batch too large",
+			fmt.Sprintf("A batch may contain at most %d clicks", MaxBatchClicks))
+		return
+	}
+
+	clickService := app.NewClickService(h.service)
+	results, clicks, err := clickService.RecordClickBatch(items)
+	if err != nil {
+		log.Printf("Failed to record click batch of %d items: %v", len(items), err)
+		h.sendError(w, http.StatusInternalServerError, "Failed to record clicks", "Internal server error")
+		return
+	}
+
+	// Invalidate cached stats once per affected banner
+	h.cachedRepo.InvalidateClickCaches(uniqueBannerIDs(clicks))
+
+	if h.visitors != nil {
+		h.trackBatchVisitors(items, results, clicks)
+	}
+
+	response := BatchClickResponse{
+		Accepted: len(clicks),
+		Rejected: len(items) - len(clicks),
+		Results:  results,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Failed to encode response: %v", err)
+	}
+}
+
+// trackBatchVisitors counts the visitors of the accepted clicks of a batch that
+// were not filtered, identified by the IP and User-Agent of each item. Items
+// carrying neither cannot be told apart and are not counted.
+func (h *APIHandler) trackBatchVisitors(items []*app.BatchClickItem, results []*app.BatchClickResult, clicks []*dto.Click) {
+	timestamps := make(map[int]time.Time, len(clicks))
+	for _, click := range clicks {
+		timestamps[click.ID] = click.Timestamp
+	}
+
+	for _, result := range results {
+		if !result.Accepted || result.FilteredReason != "" {
+			continue
+		}
+		item := items[result.Index]
+		if item.IP == "" && item.UserAgent == "" {
+			continue
+		}
+		h.visitors.Track(item.BannerID, fingerprint(item.IP, item.UserAgent), timestamps[result.ClickID])
+	}
+}
+
+// decodeJSONClicks decodes a JSON array of clicks. Items that fail to decode
+// are returned as nil so they can be reported individually.
+func decodeJSONClicks(body io.Reader) ([]*app.BatchClickItem, error) {
+	var raw []json.RawMessage
+	if err := json.NewDecoder(body).Decode(&raw); err != nil {
+		return nil, errors.New("body must be a JSON array of clicks")
+	}
+
+	items := make([]*app.BatchClickItem, len(raw))
+	for i, message := range raw {
+		items[i] = decodeBatchItem(message)
+	}
+	return items, nil
+}
+
+// decodeNDJSONClicks decodes one click per line, skipping blank lines
+func decodeNDJSONClicks(body io.Reader) ([]*app.BatchClickItem, error) {
+	var items []*app.BatchClickItem
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), MaxBatchBodyBytes)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		items = append(items, decodeBatchItem(line))
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read NDJSON body: %v", err)
+	}
+	return items, nil
+}
+
+// decodeBatchItem decodes a single click, returning nil if it is malformed
+func decodeBatchItem(data []byte) *app.BatchClickItem {
+	item := &app.BatchClickItem{}
+	if err := json.Unmarshal(data, item); err != nil {
+		return nil
+	}
+	return item
+}
+
+// uniqueBannerIDs returns the distinct banner IDs of clicks in order of appearance
+func uniqueBannerIDs(clicks []*dto.Click) []int {
+	var bannerIDs []int
+	seen := make(map[int]bool)
+	for _, click := range clicks {
+		if !seen[click.BannerID] {
+			seen[click.BannerID] = true
+			bannerIDs = append(bannerIDs, click.BannerID)
+		}
+	}
+	return bannerIDs
+}
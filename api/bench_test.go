@@ -0,0 +1,111 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tyagnii/ecom_test/app"
+	"github.com/tyagnii/ecom_test/cache"
+	"github.com/tyagnii/ecom_test/config"
+	"github.com/tyagnii/ecom_test/db"
+	"github.com/tyagnii/ecom_test/dto"
+	"github.com/tyagnii/ecom_test/logger"
+)
+
+// benchRepository connects to the database configured by the DB_* environment
+// variables and creates a banner to record clicks for, which is deleted with
+// its clicks when the benchmark ends. The benchmark is skipped without a
+// reachable database.
+func benchRepository(b *testing.B) (*db.Repository, int) {
+	b.Helper()
+
+	database, err := db.Connect(db.GetConfigFromEnv())
+	if err != nil {
+		b.Skipf("database unavailable: %v", err)
+	}
+	b.Cleanup(func() { database.Close() })
+
+	repo := db.NewRepository(database)
+	now := time.Now()
+	banner := &dto.Banner{Name: "benchmark", CreatedAt: now, UpdatedAt: now}
+	if err := repo.CreateBanner(banner); err != nil {
+		b.Fatalf("failed to create benchmark banner: %v", err)
+	}
+	b.Cleanup(func() {
+		if err := repo.DeleteBanner(banner.ID); err != nil {
+			b.Errorf("failed to delete benchmark banner: %v", err)
+		}
+	})
+
+	return repo, banner.ID
+}
+
+// reportStatements reports the database statements per recorded click
+func reportStatements(b *testing.B, repo *db.Repository, before int64) {
+	b.ReportMetric(float64(repo.Statements()-before)/float64(b.N), "statements/op")
+}
+
+// BenchmarkLegacyClickPath replays the statements the counter endpoint used to
+// issue: a banner lookup in the handler, another one in the click service, the
+// insert and the stats read from a cache that the insert did not invalidate
+func BenchmarkLegacyClickPath(b *testing.B) {
+	repo, bannerID := benchRepository(b)
+	cacheInstance := cache.NewInMemoryCache(cache.DefaultCleanupInterval)
+	defer cacheInstance.Stop()
+	cachedRepo := cache.NewCachedRepository(repo, cacheInstance)
+
+	before := repo.Statements()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := repo.GetBannerByID(bannerID); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := repo.GetBannerByID(bannerID); err != nil {
+			b.Fatal(err)
+		}
+		now := time.Now()
+		if err := repo.CreateClick(&dto.Click{Timestamp: now, BannerID: bannerID, CreatedAt: now}); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := cachedRepo.GetClickStats(bannerID); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+	reportStatements(b, repo, before)
+}
+
+// BenchmarkCounterHandler sends requests through the counter handler with
+// deduplication and rate limiting disabled
+func BenchmarkCounterHandler(b *testing.B) {
+	repo, bannerID := benchRepository(b)
+	service := app.NewServiceWithLogger(repo, logger.NewStructuredLogger(logger.WARN, io.Discard))
+	cacheInstance := cache.NewInMemoryCache(cache.DefaultCleanupInterval)
+	defer cacheInstance.Stop()
+	cachedRepo := cache.NewCachedRepository(repo, cacheInstance)
+
+	cfg := config.Default()
+	cfg.ClickTokenSecret = "bench"
+	cfg.Dedup.Window = 0
+	cfg.RateLimit.ClientRate = 0
+	cfg.RateLimit.BannerRate = 0
+	handler := NewAPIHandler(service, cachedRepo, cfg)
+	defer handler.Close()
+
+	path := fmt.Sprintf("/api/v1/counter/%d", bannerID)
+	before := repo.Statements()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		recorder := httptest.NewRecorder()
+		handler.CounterHandler(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		if recorder.Code != http.StatusOK {
+			b.Fatalf("counter handler returned %d: %s", recorder.Code, recorder.Body.String())
+		}
+	}
+	b.StopTimer()
+	reportStatements(b, repo, before)
+}
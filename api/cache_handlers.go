@@ -2,17 +2,32 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
 	"strconv"
 
 	"github.com/tyagnii/ecom_test/cache"
 )
 
+// Bounds of the keys listed by /api/v1/cache/keys
+const (
+	DefaultCacheKeysLimit = 100
+	MaxCacheKeysLimit     = 10000
+)
+
 // CacheStatsResponse represents cache statistics response
 type CacheStatsResponse struct {
 	Stats cache.CacheStats `json:"stats"`
 }
 
+// CacheKeysResponse represents the live cache keys matching a pattern
+type CacheKeysResponse struct {
+	Pattern   string          `json:"pattern"`
+	Keys      []cache.KeyInfo `json:"keys"`
+	Truncated bool            `json:"truncated"`
+}
+
 // CacheManagementHandler provides cache management endpoints
 type CacheManagementHandler struct {
 	cachedRepo *cache.CachedRepository
@@ -76,6 +91,64 @@ func (h *CacheManagementHandler) WarmCacheHandler(w http.ResponseWriter, r *http
 	json.NewEncoder(w).Encode(response)
 }
 
+// InspectCacheHandler handles GET /api/v1/cache/keys?pattern=<glob>&limit=<n>
+func (h *CacheManagementHandler) InspectCacheHandler(w http.ResponseWriter, r *http.Request) {
+	pattern := r.URL.Query().Get("pattern")
+	if pattern == "" {
+		pattern = "*"
+	}
+
+	limit := DefaultCacheKeysLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err != nil || parsed <= 0 || parsed > MaxCacheKeysLimit {
+			response := map[string]interface{}{
+				"error":   "Invalid limit",
+				"message": "Limit must be a number between 1 and " + strconv.Itoa(MaxCacheKeysLimit),
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+		limit = parsed
+	}
+
+	// Ask for one more key to tell whether the list is truncated
+	keys, err := h.cachedRepo.InspectCache(pattern, limit+1)
+	if err != nil {
+		status := http.StatusInternalServerError
+		response := map[string]interface{}{
+			"error":   "Failed to inspect cache",
+			"message": "Internal server error",
+		}
+		if errors.Is(err, cache.ErrInvalidPattern) {
+			status = http.StatusBadRequest
+			response["error"] = "Invalid pattern"
+			response["message"] = err.Error()
+		} else {
+			log.Printf("Failed to inspect cache keys matching %q: %v", pattern, err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	response := CacheKeysResponse{
+		Pattern: pattern,
+		Keys:    keys,
+	}
+	if len(keys) > limit {
+		response.Keys = keys[:limit]
+		response.Truncated = true
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
 // InvalidateBannerCacheHandler handles POST /api/v1/cache/banner/{id}/invalidate
 func (h *CacheManagementHandler) InvalidateBannerCacheHandler(w http.ResponseWriter, r *http.Request) {
 	// Extract banner ID from URL path
@@ -112,6 +185,7 @@ func (h *CacheManagementHandler) SetupCacheRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("/api/v1/cache/stats", h.GetCacheStatsHandler)
 	mux.HandleFunc("/api/v1/cache/clear", h.ClearCacheHandler)
 	mux.HandleFunc("/api/v1/cache/warm", h.WarmCacheHandler)
+	mux.HandleFunc("/api/v1/cache/keys", h.InspectCacheHandler)
 	mux.HandleFunc("/api/v1/cache/banner/", func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path[len("/api/v1/cache/banner/"):] == "" {
 			http.NotFound(w, r)
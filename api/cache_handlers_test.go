@@ -0,0 +1,147 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/tyagnii/ecom_test/app"
+	"github.com/tyagnii/ecom_test/cache"
+	"github.com/tyagnii/ecom_test/config"
+	"github.com/tyagnii/ecom_test/db"
+	"github.com/tyagnii/ecom_test/dto"
+	"github.com/tyagnii/ecom_test/logger"
+)
+
+// cacheKeysServer serves the cache management endpoints with banners 1 to
+// banners and the top 10 banners cached
+func cacheKeysServer(t *testing.T, banners int) *httptest.Server {
+	t.Helper()
+
+	cfg := config.Default()
+	cfg.ClickTokenSecret = "test"
+
+	repo := db.NewRepository(nil)
+	service := app.NewServiceWithLogger(repo, logger.NewStructuredLogger(logger.WARN, io.Discard))
+	cacheInstance := cache.NewInMemoryCache(cache.DefaultCleanupInterval)
+	t.Cleanup(cacheInstance.Stop)
+	for id := 1; id <= banners; id++ {
+		cacheInstance.SetBanner(&dto.Banner{ID: id}, time.Hour)
+	}
+	cacheInstance.SetTopBanners(10, nil, time.Hour)
+
+	handler := NewAPIHandler(service, cache.NewCachedRepository(repo, cacheInstance), cfg)
+	t.Cleanup(handler.Close)
+
+	server := httptest.NewServer(handler.SetupRoutes())
+	t.Cleanup(server.Close)
+	return server
+}
+
+// getCacheKeys lists cache keys and decodes the response into v
+func getCacheKeys(t *testing.T, server *httptest.Server, query url.Values, v interface{}) int {
+	t.Helper()
+
+	resp, err := http.Get(server.URL + "/api/v1/cache/keys?" + query.Encode())
+	if err != nil {
+		t.Fatalf("GET /api/v1/cache/keys: %v", err)
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	return resp.StatusCode
+}
+
+func TestInspectCacheHandler(t *testing.T) {
+	server := cacheKeysServer(t, 150)
+
+	tests := []struct {
+		name      string
+		query     url.Values
+		keys      int
+		truncated bool
+	}{
+		{"default limit", url.Values{}, DefaultCacheKeysLimit, true},
+		{"pattern", url.Values{"pattern": {"banner:1?"}}, 10, false},
+		{"limit reached exactly", url.Values{"pattern": {"banner:1?"}, "limit": {"10"}}, 10, false},
+		{"truncated", url.Values{"pattern": {"banner:1?"}, "limit": {"9"}}, 9, true},
+		{"maximum limit", url.Values{"limit": {strconv.Itoa(MaxCacheKeysLimit)}}, 151, false},
+		{"character class", url.Values{"pattern": {"banner:[^0-9]*"}}, 0, false},
+		{"top banners", url.Values{"pattern": {"top_banners:*"}}, 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var response CacheKeysResponse
+			if status := getCacheKeys(t, server, tt.query, &response); status != http.StatusOK {
+				t.Fatalf("status = %d, want 200", status)
+			}
+			if len(response.Keys) != tt.keys || response.Truncated != tt.truncated {
+				t.Errorf("listed %d keys, truncated %v, want %d, %v", len(response.Keys), response.Truncated, tt.keys, tt.truncated)
+			}
+			for _, key := range response.Keys {
+				if key.TTLMillis <= 0 || key.TTLMillis > time.Hour.Milliseconds() {
+					t.Errorf("key %s has TTL %dms", key.Key, key.TTLMillis)
+				}
+			}
+		})
+	}
+}
+
+func TestInspectCacheHandlerSortedKeys(t *testing.T) {
+	server := cacheKeysServer(t, 3)
+
+	var response CacheKeysResponse
+	if status := getCacheKeys(t, server, url.Values{"pattern": {"*"}}, &response); status != http.StatusOK {
+		t.Fatalf("status = %d, want 200", status)
+	}
+	var keys []string
+	for _, key := range response.Keys {
+		keys = append(keys, key.Key)
+	}
+	want := []string{"banner:1", "banner:2", "banner:3", "top_banners:10"}
+	if len(keys) != len(want) {
+		t.Fatalf("keys = %v, want %v", keys, want)
+	}
+	for i := range want {
+		if keys[i] != want[i] {
+			t.Fatalf("keys = %v, want %v", keys, want)
+		}
+	}
+	if response.Pattern != "*" {
+		t.Errorf("pattern = %q, want *", response.Pattern)
+	}
+}
+
+func TestInspectCacheHandlerInvalidRequests(t *testing.T) {
+	server := cacheKeysServer(t, 3)
+
+	tests := []struct {
+		name  string
+		query url.Values
+		error string
+	}{
+		{"zero limit", url.Values{"limit": {"0"}}, "Invalid limit"},
+		{"negative limit", url.Values{"limit": {"-1"}}, "Invalid limit"},
+		{"limit above maximum", url.Values{"limit": {strconv.Itoa(MaxCacheKeysLimit + 1)}}, "Invalid limit"},
+		{"non-numeric limit", url.Values{"limit": {"ten"}}, "Invalid limit"},
+		{"unterminated class", url.Values{"pattern": {"banner:["}}, "Invalid pattern"},
+		{"trailing backslash", url.Values{"pattern": {"banner:\\"}}, "Invalid pattern"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var response map[string]interface{}
+			if status := getCacheKeys(t, server, tt.query, &response); status != http.StatusBadRequest {
+				t.Fatalf("status = %d, want 400", status)
+			}
+			if response["error"] != tt.error {
+				t.Errorf("error = %v, want %q", response["error"], tt.error)
+			}
+		})
+	}
+}
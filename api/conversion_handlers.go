@@ -0,0 +1,106 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/tyagnii/ecom_test/app"
+	"github.com/tyagnii/ecom_test/db"
+)
+
+// ConversionRequest represents a conversion request
+type ConversionRequest struct {
+	ClickID  string  `json:"click_id"`
+	Value    float64 `json:"value"`
+	Currency string  `json:"currency"`
+}
+
+// ConversionResponse represents a conversion response
+type ConversionResponse struct {
+	ConversionID int       `json:"conversion_id"`
+	BannerID     int       `json:"banner_id"`
+	Value        float64   `json:"value"`
+	Currency     string    `json:"currency"`
+	Timestamp    time.Time `json:"timestamp"`
+	Message      string    `json:"message"`
+}
+
+// ConversionHandler handles POST /api/v1/conversions
+func (h *APIHandler) ConversionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.sendError(w, http.StatusMethodNotAllowed, "Method not allowed", "Use POST to record a conversion")
+		return
+	}
+
+	// Parse request body
+	var req ConversionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.sendError(w, http.StatusBadRequest, "Invalid request body", "Failed to parse JSON")
+		return
+	}
+
+	// Validate request
+	if req.ClickID == "" || req.Currency == "" {
+		h.sendError(w, http.StatusBadRequest, "Invalid request body", "click_id and currency are required")
+		return
+	}
+
+	if len(req.Currency) != 3 {
+		h.sendError(w, http.StatusBadRequest, "Invalid currency", "currency must be a 3-letter ISO 4217 code")
+		return
+	}
+
+	if req.Value < 0 {
+		h.sendError(w, http.StatusBadRequest, "Invalid value", "value cannot be negative")
+		return
+	}
+
+	// Resolve the click the conversion is attributed to
+	clickID, err := h.clickTokens.Verify(req.ClickID)
+	if err != nil {
+		if errors.Is(err, app.ErrExpiredClickToken) {
+			h.sendError(w, http.StatusBadRequest, "Expired click ID", "click_id is older than the attribution window")
+			return
+		}
+		h.sendError(w, http.StatusBadRequest, "Invalid click ID", "click_id is malformed or was not issued by this service")
+		return
+	}
+
+	conversionService := app.NewConversionService(h.service)
+	conversion, err := conversionService.RecordConversion(clickID, req.Value, req.Currency)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			h.sendError(w, http.StatusNotFound, "Click not found", "The click referenced by click_id no longer exists")
+			return
+		}
+		if errors.Is(err, db.ErrDuplicate) {
+			h.sendError(w, http.StatusConflict, "Conversion already recorded", "A conversion was already recorded for this click_id")
+			return
+		}
+		log.Printf("Failed to record conversion for click %d: %v", clickID, err)
+		h.sendError(w, http.StatusInternalServerError, "Failed to record conversion", "Internal server error")
+		return
+	}
+
+	// Prepare response
+	response := ConversionResponse{
+		ConversionID: conversion.ID,
+		BannerID:     conversion.BannerID,
+		Value:        conversion.Value,
+		Currency:     conversion.Currency,
+		Timestamp:    conversion.Timestamp,
+		Message:      "Conversion recorded successfully",
+	}
+
+	// Set headers
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+
+	// Send response
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Failed to encode response: %v", err)
+	}
+}
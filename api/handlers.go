@@ -2,6 +2,7 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -10,21 +11,76 @@ import (
 
 	"github.com/tyagnii/ecom_test/app"
 	"github.com/tyagnii/ecom_test/cache"
+	"github.com/tyagnii/ecom_test/config"
 	"github.com/tyagnii/ecom_test/db"
+	"github.com/tyagnii/ecom_test/dto"
+	"github.com/tyagnii/ecom_test/events"
+	"github.com/tyagnii/ecom_test/metrics"
+	"github.com/tyagnii/ecom_test/ratelimit"
+	"github.com/tyagnii/ecom_test/resilience"
 )
 
 // APIHandler provides HTTP API handlers
 type APIHandler struct {
 	service     *app.Service
 	cachedRepo  *cache.CachedRepository
+	clickTokens *app.ClickTokenSigner
+	dedup       *cache.ClickDeduplicator
+
+	clientLimiter *ratelimit.Limiter
+	bannerLimiter *ratelimit.Limiter
+
+	breaker *resilience.CircuitBreaker
+
+	visitors      *app.VisitorTracker
+	visitorCookie string
+
+	events                 *events.Bus
+	streamSnapshotInterval time.Duration
+	wsMaxBanners           int
+	wsPingInterval         time.Duration
+	wsAllowedOrigins       []string
+
+	trustProxyHeaders bool
+	adminToken        string
+	ingestToken       string
 }
 
 // NewAPIHandler creates a new API handler
-func NewAPIHandler(service *app.Service, cachedRepo *cache.CachedRepository) *APIHandler {
-	return &APIHandler{
-		service:    service,
-		cachedRepo: cachedRepo,
+func NewAPIHandler(service *app.Service, cachedRepo *cache.CachedRepository, cfg *config.Config) *APIHandler {
+	handler := &APIHandler{
+		service:     service,
+		cachedRepo:  cachedRepo,
+		clickTokens: app.NewClickTokenSigner([]byte(cfg.ClickTokenSecret), cfg.AttributionWindow),
+
+		clientLimiter: ratelimit.NewLimiter(ratelimit.Limit{Rate: cfg.RateLimit.ClientRate, Burst: cfg.RateLimit.ClientBurst}, cfg.RateLimit.IdleTTL),
+		bannerLimiter: ratelimit.NewLimiter(ratelimit.Limit{Rate: cfg.RateLimit.BannerRate, Burst: cfg.RateLimit.BannerBurst}, cfg.RateLimit.IdleTTL),
+
+		trustProxyHeaders: cfg.TrustProxyHeaders,
+		adminToken:        cfg.AdminToken,
+		ingestToken:       cfg.IngestToken,
+		visitorCookie:     cfg.Visitors.CookieName,
+
+		streamSnapshotInterval: cfg.Stream.SnapshotInterval,
+		wsMaxBanners:           cfg.Stream.MaxBannersPerConn,
+		wsPingInterval:         cfg.Stream.PingInterval,
+		wsAllowedOrigins:       cfg.Stream.AllowedOrigins,
+	}
+
+	metrics.NewCounterFunc("counter_rate_limited_client_total", "Counter requests rejected by the per-client rate limit",
+		func() float64 { return float64(handler.clientLimiter.Stats().Limited) })
+	metrics.NewCounterFunc("counter_rate_limited_banner_total", "Counter requests rejected by the per-banner rate limit",
+		func() float64 { return float64(handler.bannerLimiter.Stats().Limited) })
+
+	if cfg.Dedup.Window > 0 {
+		handler.dedup = cache.NewClickDeduplicator(cfg.Dedup.Window, cfg.Dedup.MaxEntries)
+		metrics.NewCounterFunc("clicks_deduplicated_total", "Clicks suppressed by the dedup window",
+			func() float64 { return float64(handler.dedup.Stats().Suppressed) })
+		metrics.NewGaugeFunc("click_dedup_entries", "Clients currently tracked by the dedup window",
+			func() float64 { return float64(handler.dedup.Stats().Size) })
 	}
+
+	return handler
 }
 
 // CounterRequest represents a counter request
@@ -37,6 +93,7 @@ type CounterResponse struct {
 	BannerID   int       `json:"banner_id"`
 	ClickCount int       `json:"click_count"`
 	Timestamp  time.Time `json:"timestamp"`
+	ClickID    string    `json:"click_id,omitempty"`
 	Message    string    `json:"message"`
 }
 
@@ -44,18 +101,76 @@ type CounterResponse struct {
 type StatsRequest struct {
 	BannerID int       `json:"banner_id"`
 	TsFrom   time.Time `json:"ts_from"`
+	// TsTo is exclusive
 	TsTo     time.Time `json:"ts_to"`
+	// Granularity requests a series of minute, hour or day buckets
+	Granularity string `json:"granularity,omitempty"`
+	// TZ is the IANA time zone buckets are aligned to, UTC by default
+	TZ string `json:"tz,omitempty"`
+	// CompareTo requests a comparison with another period
+	CompareTo *CompareTo `json:"compare_to,omitempty"`
+}
+
+// CompareTo selects the period stats are compared to: either a period name
+// such as "previous_week" or an explicit {"ts_from", "ts_to"} range
+type CompareTo struct {
+	Period string
+	TsFrom time.Time
+	TsTo   time.Time
+}
+
+// UnmarshalJSON accepts a period name or a range object
+func (c *CompareTo) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		return json.Unmarshal(data, &c.Period)
+	}
+
+	var period struct {
+		TsFrom time.Time `json:"ts_from"`
+		TsTo   time.Time `json:"ts_to"`
+	}
+	if err := json.Unmarshal(data, &period); err != nil {
+		return err
+	}
+	c.TsFrom, c.TsTo = period.TsFrom, period.TsTo
+	return nil
+}
+
+// StatsComparison represents the period stats are compared to
+type StatsComparison struct {
+	PeriodStart       time.Time         `json:"period_start"`
+	PeriodEnd         time.Time         `json:"period_end"`
+	ClicksInPeriod    int               `json:"clicks_in_period"`
+	NetClicksInPeriod int               `json:"net_clicks_in_period"`
+	Source            db.RollupLevel    `json:"source"`
+	Series            []*db.ClickBucket `json:"series,omitempty"`
+	// Delta is the change from the compared period to the requested one
+	Delta *app.SeriesDelta `json:"delta"`
 }
 
 // StatsResponse represents a stats response
 type StatsResponse struct {
 	BannerID    int       `json:"banner_id"`
 	TotalClicks int       `json:"total_clicks"`
+	NetClicks      int    `json:"net_clicks"`
+	FilteredClicks int    `json:"filtered_clicks"`
 	FirstClick  time.Time `json:"first_click,omitempty"`
 	LastClick   time.Time `json:"last_click,omitempty"`
 	PeriodStart time.Time `json:"period_start"`
 	PeriodEnd   time.Time `json:"period_end"`
 	ClicksInPeriod int    `json:"clicks_in_period"`
+	NetClicksInPeriod int `json:"net_clicks_in_period"`
+	Conversions    int                `json:"conversions"`
+	ConversionRate float64            `json:"conversion_rate"`
+	Revenue        map[string]float64 `json:"revenue"`
+	// Source is the rollup level the period was read from
+	Source      db.RollupLevel    `json:"source"`
+	Granularity db.RollupLevel    `json:"granularity,omitempty"`
+	TZ          string            `json:"tz,omitempty"`
+	Series      []*db.ClickBucket `json:"series,omitempty"`
+	Comparison  *StatsComparison  `json:"comparison,omitempty"`
+	// UniqueVisitors estimates the distinct visitors in the hours covering the period
+	UniqueVisitors *uint64 `json:"unique_visitors,omitempty"`
 }
 
 // ErrorResponse represents an error response
@@ -80,25 +195,76 @@ func (h *APIHandler) CounterHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if banner exists
-	bannerService := app.NewBannerService(h.service)
-	_, err = bannerService.GetBanner(bannerID)
-	if err != nil {
-		h.sendError(w, http.StatusNotFound, "Banner not found", fmt.Sprintf("Banner with ID %d not found", bannerID))
+	// Check if banner exists through the cache; the foreign key catches banners
+	// deleted since they were cached. If the database is unreachable the click
+	// service decides whether the click can be kept in the WAL.
+	_, err = h.cachedRepo.GetBannerByID(bannerID)
+	if err != nil && !db.IsConnectionError(err) {
+		if errors.Is(err, db.ErrNotFound) {
+			h.sendError(w, http.StatusNotFound, "Banner not found", fmt.Sprintf("Banner with ID %d not found", bannerID))
+			return
+		}
+		log.Printf("Failed to get banner %d: %v", bannerID, err)
+		h.sendError(w, http.StatusInternalServerError, "Failed to get banner", "Internal server error")
 		return
 	}
 
+	// Suppress repeated clicks from the same client within the dedup window
+	var dedupKey string
+	if h.dedup != nil {
+		dedupKey = cache.DedupKey(bannerID, h.clientFingerprint(r))
+		if original, first := h.dedup.Claim(dedupKey); !first {
+			h.writeCounterResponse(w, bannerID, original, nil)
+			return
+		}
+	}
+
 	// Record the click
 	clickService := app.NewClickService(h.service)
-	click, err := clickService.RecordClick(bannerID, time.Now())
+	click, err := clickService.RecordClick(bannerID, time.Now(), h.clickSource(r))
 	if err != nil {
+		if h.dedup != nil {
+			h.dedup.Release(dedupKey)
+		}
+		if errors.Is(err, db.ErrNotFound) {
+			h.sendError(w, http.StatusNotFound, "Banner not found", fmt.Sprintf("Banner with ID %d not found", bannerID))
+			return
+		}
 		log.Printf("Failed to record click for banner %d: %v", bannerID, err)
 		h.sendError(w, http.StatusInternalServerError, "Failed to record click", "Internal server error")
 		return
 	}
 
+	if h.dedup != nil {
+		h.dedup.Complete(dedupKey, click)
+	}
+
+	// Count the visitor unless the click was filtered as a bot
+	if h.visitors != nil && click.FilteredReason == "" {
+		h.visitors.Track(bannerID, h.visitorID(r), click.Timestamp)
+	}
+
+	// Count the click in the cached counter instead of re-aggregating
+	var stats *db.ClickStats
+	if click.ID > 0 {
+		stats, err = h.cachedRepo.CountClick(click)
+		if err != nil {
+			log.Printf("Failed to count click for banner %d: %v", bannerID, err)
+		}
+	}
+
+	h.writeCounterResponse(w, bannerID, click, stats)
+}
+
+// writeCounterResponse sends the counter response for a click. A nil click means a
+// duplicate of a click that is still being recorded, a zero click ID means the
+// click was queued in the WAL. When stats is nil they are read from the cache.
+func (h *APIHandler) writeCounterResponse(w http.ResponseWriter, bannerID int, click *dto.Click, stats *db.ClickStats) {
 	// Get updated click count for this banner using cached repository
-	stats, err := h.cachedRepo.GetClickStats(bannerID)
+	var err error
+	if stats == nil {
+		stats, err = h.cachedRepo.GetClickStats(bannerID)
+	}
 	if err != nil {
 		log.Printf("Failed to get click stats for banner %d: %v", bannerID, err)
 		// Don't fail the request, just use the click we recorded
@@ -112,13 +278,24 @@ func (h *APIHandler) CounterHandler(w http.ResponseWriter, r *http.Request) {
 	response := CounterResponse{
 		BannerID:   bannerID,
 		ClickCount: stats.TotalClicks,
-		Timestamp:  click.Timestamp,
+		Timestamp:  time.Now(),
 		Message:    "Click recorded successfully",
 	}
 
+	status := http.StatusOK
+	if click != nil {
+		response.Timestamp = click.Timestamp
+		if click.ID > 0 {
+			response.ClickID = h.clickTokens.Sign(click)
+		} else {
+			response.Message = "Click queued for recording"
+			status = http.StatusAccepted
+		}
+	}
+
 	// Set headers
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
+	w.WriteHeader(status)
 
 	// Send response
 	if err := json.NewEncoder(w).Encode(response); err != nil {
@@ -160,6 +337,38 @@ func (h *APIHandler) StatsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	granularity, err := db.ParseRollupLevel(req.Granularity)
+	if err != nil {
+		h.sendError(w, http.StatusBadRequest, "Invalid granularity", err.Error())
+		return
+	}
+
+	// The time zone may also be given as a query parameter
+	if req.TZ == "" {
+		req.TZ = r.URL.Query().Get("tz")
+	}
+	loc, err := db.LoadTimeZone(req.TZ)
+	if err != nil {
+		h.sendError(w, http.StatusBadRequest, "Invalid time zone", err.Error())
+		return
+	}
+
+	// Resolve the comparison period, if any
+	var comparison *StatsComparison
+	if req.CompareTo != nil {
+		comparison = &StatsComparison{PeriodStart: req.CompareTo.TsFrom, PeriodEnd: req.CompareTo.TsTo}
+		if req.CompareTo.Period != "" {
+			comparison.PeriodStart, comparison.PeriodEnd, err = app.ComparisonRange(req.TsFrom, req.TsTo, req.CompareTo.Period, loc)
+			if err != nil {
+				h.sendError(w, http.StatusBadRequest, "Invalid comparison", err.Error())
+				return
+			}
+		} else if comparison.PeriodStart.IsZero() || comparison.PeriodEnd.IsZero() || comparison.PeriodStart.After(comparison.PeriodEnd) {
+			h.sendError(w, http.StatusBadRequest, "Invalid comparison", "compare_to must be a period name or a range with ts_from before ts_to")
+			return
+		}
+	}
+
 	// Check if banner exists
 	bannerService := app.NewBannerService(h.service)
 	_, err = bannerService.GetBanner(bannerID)
@@ -179,21 +388,80 @@ func (h *APIHandler) StatsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get clicks in the specified time period (not cached due to time range specificity)
-	clicksInPeriod, err := clickService.GetClicksForBannerInDateRange(bannerID, req.TsFrom, req.TsTo)
+	// Get clicks in the specified time period, and in the compared one, from the
+	// coarsest usable rollup
+	var series, compared *db.ClickSeries
+	if comparison != nil {
+		series, compared, comparison.Delta, err = clickService.CompareClickSeries(bannerID, req.TsFrom, req.TsTo,
+			comparison.PeriodStart, comparison.PeriodEnd, granularity, loc)
+	} else {
+		series, err = clickService.GetClickSeries(bannerID, req.TsFrom, req.TsTo, granularity, loc)
+	}
+	if errors.Is(err, app.ErrIncomparableRange) {
+		h.sendError(w, http.StatusBadRequest, "Invalid comparison", err.Error())
+		return
+	}
 	if err != nil {
 		log.Printf("Failed to get clicks in period for banner %d: %v", bannerID, err)
 		h.sendError(w, http.StatusInternalServerError, "Failed to get period stats", "Internal server error")
 		return
 	}
 
+	// Get conversions attributed to the banner in the same period
+	conversionService := app.NewConversionService(h.service)
+	conversionStats, err := conversionService.GetConversionStats(bannerID, req.TsFrom, req.TsTo)
+	if err != nil {
+		log.Printf("Failed to get conversion stats for banner %d: %v", bannerID, err)
+		h.sendError(w, http.StatusInternalServerError, "Failed to get conversion stats", "Internal server error")
+		return
+	}
+
+	// Estimate unique visitors in the period
+	var uniqueVisitors *uint64
+	if h.visitors != nil {
+		// The estimate is optional; without it the stats are still served
+		estimate, err := h.visitors.UniqueVisitors(bannerID, req.TsFrom, req.TsTo)
+		if err != nil {
+			log.Printf("Failed to estimate unique visitors for banner %d, omitting them: %v", bannerID, err)
+		} else {
+			uniqueVisitors = &estimate
+		}
+	}
+
 	// Prepare response
 	response := StatsResponse{
 		BannerID:      bannerID,
 		TotalClicks:   overallStats.TotalClicks,
+		NetClicks:      overallStats.NetClicks,
+		FilteredClicks: overallStats.FilteredClicks,
 		PeriodStart:   req.TsFrom,
 		PeriodEnd:     req.TsTo,
-		ClicksInPeriod: len(clicksInPeriod),
+		ClicksInPeriod: series.Clicks,
+		NetClicksInPeriod: series.NetClicks,
+		Conversions:    conversionStats.Conversions,
+		Revenue:        conversionStats.Revenue,
+		Source:         series.Source,
+		UniqueVisitors: uniqueVisitors,
+	}
+
+	if granularity != db.RollupRaw {
+		response.Granularity = series.Granularity
+		response.TZ = series.TimeZone
+		response.Series = series.Buckets
+	}
+
+	if comparison != nil {
+		comparison.ClicksInPeriod = compared.Clicks
+		comparison.NetClicksInPeriod = compared.NetClicks
+		comparison.Source = compared.Source
+		if granularity != db.RollupRaw {
+			comparison.Series = compared.Buckets
+		}
+		response.Comparison = comparison
+	}
+
+	if response.NetClicksInPeriod > 0 {
+		response.ConversionRate = float64(response.Conversions) / float64(response.NetClicksInPeriod)
 	}
 
 	// Add first and last click times if available
@@ -220,11 +488,41 @@ func (h *APIHandler) HealthHandler(w http.ResponseWriter, r *http.Request) {
 		"version":   "1.0.0",
 	}
 
+	// An open breaker means the database is unreachable; the API still serves
+	// cached stats and queues clicks, so it reports degraded rather than unhealthy
+	if h.breaker != nil {
+		response["database"] = h.breaker.Stats()
+		if h.breaker.State() != resilience.StateClosed {
+			response["status"] = "degraded"
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(response)
 }
 
+// SetCircuitBreaker sets the database circuit breaker reported by the health check
+func (h *APIHandler) SetCircuitBreaker(breaker *resilience.CircuitBreaker) {
+	h.breaker = breaker
+}
+
+// SetEventBus sets the bus live click streams subscribe to
+func (h *APIHandler) SetEventBus(bus *events.Bus) {
+	h.events = bus
+}
+
+// SetVisitorTracker sets the tracker counting unique visitors of counter requests
+func (h *APIHandler) SetVisitorTracker(visitors *app.VisitorTracker) {
+	h.visitors = visitors
+}
+
+// Close stops background workers owned by the handler
+func (h *APIHandler) Close() {
+	h.clientLimiter.Stop()
+	h.bannerLimiter.Stop()
+}
+
 // sendError sends an error response
 func (h *APIHandler) sendError(w http.ResponseWriter, statusCode int, error, message string) {
 	response := ErrorResponse{
@@ -237,18 +535,40 @@ func (h *APIHandler) sendError(w http.ResponseWriter, statusCode int, error, mes
 	json.NewEncoder(w).Encode(response)
 }
 
+// sendJSON sends a 200 response with a JSON body
+func (h *APIHandler) sendJSON(w http.ResponseWriter, response interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Failed to encode response: %v", err)
+	}
+}
+
 // SetupRoutes sets up the API routes
 func (h *APIHandler) SetupRoutes() *http.ServeMux {
 	mux := http.NewServeMux()
 
 	// API routes
-	mux.HandleFunc("/api/v1/counter/", h.CounterHandler)
+	mux.HandleFunc("/api/v1/counter/", h.rateLimitCounter(h.CounterHandler))
 	mux.HandleFunc("/api/v1/stats/", h.StatsHandler)
+	mux.HandleFunc("/api/v1/stats:query", h.StatsQueryHandler)
+	mux.HandleFunc("/api/v1/conversions", h.ConversionHandler)
+	mux.HandleFunc("/api/v1/clicks:batch", h.requireIngest(h.BatchClickHandler))
+	mux.HandleFunc("/api/v1/analytics/top", h.TopBannersHandler)
+	mux.HandleFunc("/api/v1/analytics/performance", h.PerformanceHandler)
+	mux.HandleFunc("/api/v1/stream/clicks", h.StreamClicksHandler)
+	mux.HandleFunc("/api/v1/ws/counters", h.LiveCountersHandler)
 	mux.HandleFunc("/health", h.HealthHandler)
+	mux.Handle("/metrics", metrics.Handler())
+
+	// Admin routes
+	mux.HandleFunc("/api/v1/admin/ratelimit", h.requireAdmin(h.RateLimitHandler))
 
 	// Cache management routes
+	cacheMux := http.NewServeMux()
 	cacheHandler := NewCacheManagementHandler(h.cachedRepo)
-	cacheHandler.SetupCacheRoutes(mux)
+	cacheHandler.SetupCacheRoutes(cacheMux)
+	mux.HandleFunc("/api/v1/cache/", h.requireAdmin(cacheMux.ServeHTTP))
 
 	// Add middleware for logging
 	return h.addLoggingMiddleware(mux)
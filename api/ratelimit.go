@@ -0,0 +1,111 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/tyagnii/ecom_test/ratelimit"
+)
+
+// RateLimitSettings represents the counter endpoint rate limits
+type RateLimitSettings struct {
+	PerClient *ratelimit.Limit `json:"per_client,omitempty"`
+	PerBanner *ratelimit.Limit `json:"per_banner,omitempty"`
+}
+
+// RateLimitResponse represents the rate limit admin response
+type RateLimitResponse struct {
+	PerClient      ratelimit.Limit `json:"per_client"`
+	PerBanner      ratelimit.Limit `json:"per_banner"`
+	PerClientStats ratelimit.Stats `json:"per_client_stats"`
+	PerBannerStats ratelimit.Stats `json:"per_banner_stats"`
+}
+
+// rateLimitCounter wraps the counter handler with per client and per banner token buckets
+func (h *APIHandler) rateLimitCounter(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if allowed, wait := h.clientLimiter.Allow(h.clientIP(r)); !allowed {
+			h.sendRateLimited(w, wait, "Too many requests from this client")
+			return
+		}
+
+		// Invalid IDs are rejected by the handler itself. The bucket is keyed
+		// on the parsed ID so that "01" or "+1" share the bucket of "1".
+		bannerID, err := strconv.Atoi(r.URL.Path[len("/api/v1/counter/"):])
+		if err == nil && bannerID > 0 {
+			if allowed, wait := h.bannerLimiter.Allow(strconv.Itoa(bannerID)); !allowed {
+				h.sendRateLimited(w, wait, "Too many requests for this banner")
+				return
+			}
+		}
+
+		next(w, r)
+	}
+}
+
+// sendRateLimited sends a 429 response with a Retry-After header
+func (h *APIHandler) sendRateLimited(w http.ResponseWriter, wait time.Duration, message string) {
+	retryAfter := int(math.Ceil(wait.Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	h.sendError(w, http.StatusTooManyRequests, "Rate limit exceeded", message)
+}
+
+// RateLimitHandler handles GET and PUT /api/v1/admin/ratelimit
+func (h *APIHandler) RateLimitHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var req RateLimitSettings
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			h.sendError(w, http.StatusBadRequest, "Invalid request body", "Failed to parse JSON")
+			return
+		}
+
+		for _, limit := range []*ratelimit.Limit{req.PerClient, req.PerBanner} {
+			if limit != nil && (limit.Rate < 0 || limit.Burst < 0) {
+				h.sendError(w, http.StatusBadRequest, "Invalid limit", "rate and burst cannot be negative")
+				return
+			}
+		}
+
+		if req.PerClient != nil {
+			h.clientLimiter.SetLimit(*req.PerClient)
+			log.Printf("Per-client rate limit set to %s", formatLimit(*req.PerClient))
+		}
+		if req.PerBanner != nil {
+			h.bannerLimiter.SetLimit(*req.PerBanner)
+			log.Printf("Per-banner rate limit set to %s", formatLimit(*req.PerBanner))
+		}
+	default:
+		h.sendError(w, http.StatusMethodNotAllowed, "Method not allowed", "Use GET or PUT")
+		return
+	}
+
+	response := RateLimitResponse{
+		PerClient:      h.clientLimiter.Limit(),
+		PerBanner:      h.bannerLimiter.Limit(),
+		PerClientStats: h.clientLimiter.Stats(),
+		PerBannerStats: h.bannerLimiter.Stats(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// formatLimit renders a limit for logs
+func formatLimit(limit ratelimit.Limit) string {
+	if !limit.Enabled() {
+		return "unlimited"
+	}
+	return fmt.Sprintf("%g req/s (burst %d)", limit.Rate, limit.Burst)
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/tyagnii/ecom_test/app"
+	"github.com/tyagnii/ecom_test/cache"
+	"github.com/tyagnii/ecom_test/config"
+	"github.com/tyagnii/ecom_test/db"
+	"github.com/tyagnii/ecom_test/logger"
+)
+
+// rateLimitedCounter returns the counter rate limiting in front of a handler
+// that accepts every request, with one request per banner allowed
+func rateLimitedCounter(t *testing.T) http.HandlerFunc {
+	t.Helper()
+
+	cfg := config.Default()
+	cfg.ClickTokenSecret = "test"
+	cfg.RateLimit.BannerRate = 0.001
+	cfg.RateLimit.BannerBurst = 1
+
+	repo := db.NewRepository(nil)
+	service := app.NewServiceWithLogger(repo, logger.NewStructuredLogger(logger.WARN, io.Discard))
+	cacheInstance := cache.NewInMemoryCache(cache.DefaultCleanupInterval)
+	t.Cleanup(cacheInstance.Stop)
+	handler := NewAPIHandler(service, cache.NewCachedRepository(repo, cacheInstance), cfg)
+	t.Cleanup(handler.Close)
+
+	return handler.rateLimitCounter(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func TestRateLimitCounterNormalizesBannerID(t *testing.T) {
+	counter := rateLimitedCounter(t)
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/api/v1/counter/1", http.StatusNoContent},
+		// Zero padding or a sign does not get a fresh bucket
+		{"/api/v1/counter/01", http.StatusTooManyRequests},
+		{"/api/v1/counter/+1", http.StatusTooManyRequests},
+		{"/api/v1/counter/2", http.StatusNoContent},
+		// Invalid IDs are left to the handler
+		{"/api/v1/counter/0", http.StatusNoContent},
+		{"/api/v1/counter/abc", http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		counter(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if w.Code != tt.status {
+			t.Errorf("GET %s = %d, want %d", tt.path, w.Code, tt.status)
+		}
+		if tt.status == http.StatusTooManyRequests && w.Header().Get("Retry-After") == "" {
+			t.Errorf("GET %s: 429 without Retry-After", tt.path)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/tyagnii/ecom_test/dto"
+)
+
+// clientIP returns the IP address of the client that issued the request. Behind
+// a trusted proxy this is the rightmost X-Forwarded-For entry, the one the proxy
+// appended; entries left of it are sent by the client and can be forged.
+func (h *APIHandler) clientIP(r *http.Request) string {
+	if h.trustProxyHeaders {
+		if values := r.Header.Values("X-Forwarded-For"); len(values) > 0 {
+			forwarded := values[len(values)-1]
+			if i := strings.LastIndex(forwarded, ","); i >= 0 {
+				forwarded = forwarded[i+1:]
+			}
+			if ip := strings.TrimSpace(forwarded); ip != "" {
+				return ip
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// clientFingerprint identifies the client for click deduplication. An explicit
+// click_token query parameter wins over the IP and User-Agent pair.
+func (h *APIHandler) clientFingerprint(r *http.Request) string {
+	if token := r.URL.Query().Get("click_token"); token != "" {
+		return "t:" + token
+	}
+
+	return fingerprint(h.clientIP(r), r.UserAgent())
+}
+
+// fingerprint identifies a client by its IP and User-Agent
+func fingerprint(ip, userAgent string) string {
+	sum := sha256.Sum256([]byte(ip + "\x00" + userAgent))
+	return "f:" + hex.EncodeToString(sum[:16])
+}
+
+// visitorID identifies the visitor for unique visitor estimation: the visitor
+// cookie if the client sent one, otherwise its IP and User-Agent. The
+// click_token parameter identifies a click, not a visitor, and is ignored.
+func (h *APIHandler) visitorID(r *http.Request) string {
+	if h.visitorCookie != "" {
+		if cookie, err := r.Cookie(h.visitorCookie); err == nil && cookie.Value != "" {
+			return "c:" + cookie.Value
+		}
+	}
+	return fingerprint(h.clientIP(r), r.UserAgent())
+}
+
+// clickSource describes the client of a click request for the click filter
+func (h *APIHandler) clickSource(r *http.Request) *dto.ClickSource {
+	return &dto.ClickSource{
+		IP:        h.clientIP(r),
+		UserAgent: r.UserAgent(),
+	}
+}
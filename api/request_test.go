@@ -0,0 +1,54 @@
+package api
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name      string
+		trust     bool
+		forwarded []string
+		want      string
+	}{
+		{"remote address", false, nil, "192.0.2.1"},
+		{"untrusted header", false, []string{"203.0.113.7"}, "192.0.2.1"},
+		{"single entry", true, []string{"203.0.113.7"}, "203.0.113.7"},
+		{"forged entries", true, []string{"10.0.0.1, 10.0.0.2 , 203.0.113.7"}, "203.0.113.7"},
+		{"several headers", true, []string{"10.0.0.1", "203.0.113.7"}, "203.0.113.7"},
+		{"empty entry", true, []string{"10.0.0.1,"}, "192.0.2.1"},
+		{"no header", true, nil, "192.0.2.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &APIHandler{trustProxyHeaders: tt.trust}
+			r := httptest.NewRequest("GET", "/api/v1/counter/1", nil)
+			r.RemoteAddr = "192.0.2.1:4321"
+			for _, value := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			if got := h.clientIP(r); got != tt.want {
+				t.Errorf("clientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVisitorIDIgnoresClickToken(t *testing.T) {
+	h := &APIHandler{visitorCookie: "vid"}
+	request := func(target string) string {
+		r := httptest.NewRequest("GET", target, nil)
+		r.RemoteAddr = "192.0.2.1:4321"
+		r.Header.Set("User-Agent", "test-agent")
+		return h.visitorID(r)
+	}
+
+	plain := request("/api/v1/counter/1")
+	if got := request("/api/v1/counter/1?click_token=a"); got != plain {
+		t.Errorf("visitorID with a click token = %q, want the fingerprint %q", got, plain)
+	}
+	if got := request("/api/v1/counter/1?click_token=b"); got != plain {
+		t.Errorf("visitorID with another click token = %q, want the fingerprint %q", got, plain)
+	}
+}
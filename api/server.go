@@ -5,37 +5,316 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"path/filepath"
 	"time"
 
 	"github.com/tyagnii/ecom_test/app"
 	"github.com/tyagnii/ecom_test/cache"
+	"github.com/tyagnii/ecom_test/config"
 	"github.com/tyagnii/ecom_test/db"
+	"github.com/tyagnii/ecom_test/dto"
+	"github.com/tyagnii/ecom_test/events"
+	"github.com/tyagnii/ecom_test/filter"
+	"github.com/tyagnii/ecom_test/logger"
+	"github.com/tyagnii/ecom_test/metrics"
+	"github.com/tyagnii/ecom_test/resilience"
+	"github.com/tyagnii/ecom_test/wal"
 )
 
 // Server represents the API server
 type Server struct {
-	handler *APIHandler
-	server  *http.Server
+	handler     *APIHandler
+	server      *http.Server
+	clickWAL    *app.ClickWAL
+	partitions  *app.PartitionManager
+	retention   *app.RetentionJob
+	rollups     *app.RollupWorker
+	visitors    *app.VisitorTracker
+	events      *events.Bus
+	invalidator *cache.Invalidator
+	cachedRepo  *cache.CachedRepository
+	cache       cache.Cache
 }
 
 // NewServer creates a new API server
-func NewServer(database *sql.DB) *Server {
-	// Create repository and service
-	repo := db.NewRepository(database)
+func NewServer(database *sql.DB, cfg *config.Config) (*Server, error) {
+	// Create repository with retries and circuit breaker, and service
+	policy := newDatabasePolicy(cfg.Database)
+	repo := db.NewResilientRepository(database, policy)
+	metrics.NewCounterFunc("db_statements_total", "Statements sent to the database, including retries",
+		func() float64 { return float64(repo.Statements()) })
 	service := app.NewService(repo)
 	
+	// Create click filter chain
+	clickFilter, err := filter.NewDefaultChain(cfg.Filter.UserAgentRulesFile, cfg.Filter.IPDenylist, cfg.Filter.FilterEmptyUserAgent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build click filter: %w", err)
+	}
+	service.SetClickFilter(clickFilter)
+	
 	// Create cache and cached repository
-	cacheInstance := cache.NewInMemoryCache(cache.DefaultCleanupInterval)
+	cacheInstance, localCache, err := newCache(cfg.Cache)
+	if err != nil {
+		return nil, err
+	}
 	cachedRepo := cache.NewCachedRepository(repo, cacheInstance)
+	cachedRepo.SetPolicies(cache.Policies{
+		Banner:     ttlPolicy(cfg.Cache.Banner),
+		ClickStats: ttlPolicy(cfg.Cache.ClickStats),
+		TopBanners: ttlPolicy(cfg.Cache.TopBanners),
+
+		MissingBannerTTL: cfg.Cache.MissingBannerTTL,
+	})
+	// Banner writes invalidate the cache, including not-found markers
+	service.SetBannerStore(cachedRepo)
+	metrics.NewCounterFunc("cache_stale_served_total", "Stale cache entries served while they were refreshed",
+		func() float64 { return float64(cachedRepo.RevalidationStats().Stale) })
+	metrics.NewCounterFunc("cache_refresh_failures_total", "Failed background refreshes of stale cache entries",
+		func() float64 { return float64(cachedRepo.RevalidationStats().Failures) })
+	metrics.NewCounterFunc("cache_refresh_dropped_total", "Background refreshes skipped because the refresh queue was full",
+		func() float64 { return float64(cachedRepo.RevalidationStats().Dropped) })
+	metrics.NewCounterFunc("cache_loads_superseded_total", "Cache loads discarded because the entry was invalidated while they ran",
+		func() float64 { return float64(cachedRepo.RevalidationStats().Superseded) })
+	
+	// Propagate banner invalidations to the other instances, which apply them
+	// to their local cache; a shared cache needs no propagation
+	var invalidator *cache.Invalidator
+	if localCache != nil && cfg.Cache.InvalidationChannel != "" && cfg.Database.URL != "" {
+		invalidator, err = cache.NewInvalidator(repo, localCache, cfg.Database.URL, cfg.Cache.InvalidationChannel, logger.GetGlobalLogger())
+		if err != nil {
+			return nil, err
+		}
+		cachedRepo.SetInvalidator(invalidator)
+		invalidator.Start(cfg.Cache.InvalidationInterval)
+		metrics.NewCounterFunc("cache_invalidations_sent_total", "Cache invalidation notifications sent to other instances",
+			func() float64 { return float64(invalidator.Stats().Sent) })
+		metrics.NewCounterFunc("cache_invalidations_received_total", "Cache invalidation notifications received, including our own",
+			func() float64 { return float64(invalidator.Stats().Received) })
+		metrics.NewCounterFunc("cache_invalidation_failures_total", "Failed cache invalidation notifications",
+			func() float64 { return float64(invalidator.Stats().Failures) })
+		metrics.NewCounterFunc("cache_invalidation_resyncs_total", "Cache clears after the invalidation listener reconnected",
+			func() float64 { return float64(invalidator.Stats().Resyncs) })
+	}
+	
+	// Create click WAL for degraded mode
+	var clickWAL *app.ClickWAL
+	if cfg.WAL.Dir != "" {
+		clickWAL, err = openClickWAL(cfg.WAL, repo, cachedRepo)
+		if err != nil {
+			return nil, err
+		}
+		service.SetClickWAL(clickWAL)
+	}
+	
+	// Keep future click partitions created
+	var partitions *app.PartitionManager
+	if cfg.Partitions.MonthsAhead >= 0 {
+		partitions = app.NewPartitionManager(repo, cfg.Partitions.MonthsAhead, logger.GetGlobalLogger())
+		partitions.Start(cfg.Partitions.CheckInterval)
+	}
+	
+	// Fold new clicks into the rollups
+	var rollups *app.RollupWorker
+	if cfg.Rollup.Interval > 0 {
+		rollups = app.NewRollupWorker(repo, cfg.Rollup.Lag, logger.GetGlobalLogger())
+		rollups.Start(cfg.Rollup.Interval)
+		metrics.NewGaugeFunc("click_rollup_lag_seconds", "Seconds between now and the click rollup watermark",
+			func() float64 {
+				if watermark := rollups.Watermark(); !watermark.IsZero() {
+					return time.Since(watermark).Seconds()
+				}
+				return 0
+			})
+	}
+	
+	// Purge expired data in the background
+	var retention *app.RetentionJob
+	retentionPolicy := app.RetentionPolicy{
+		Raw:       cfg.Retention.Raw,
+		Minute:    cfg.Retention.Minute,
+		Hour:      cfg.Retention.Hour,
+		Day:       cfg.Retention.Day,
+		BatchSize: cfg.Retention.BatchSize,
+	}
+	if cfg.Retention.Interval > 0 && retentionPolicy.Enabled() {
+		if err := retentionPolicy.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid retention policy: %w", err)
+		}
+		if retentionPolicy.Raw > 0 && cfg.Rollup.Interval <= 0 {
+			return nil, fmt.Errorf("raw click retention requires the rollup worker")
+		}
+		retention = app.NewRetentionJob(repo, retentionPolicy, logger.GetGlobalLogger())
+		retention.Start(cfg.Retention.Interval)
+	}
+	
+	// Estimate unique visitors per banner and hour
+	var visitors *app.VisitorTracker
+	if cfg.Visitors.FlushInterval > 0 {
+		visitors, err = app.NewVisitorTracker(repo, cfg.Visitors.Precision, cfg.Visitors.MaxPending, logger.GetGlobalLogger())
+		if err != nil {
+			return nil, fmt.Errorf("invalid visitor tracking: %w", err)
+		}
+		visitors.Start(cfg.Visitors.FlushInterval)
+		metrics.NewGaugeFunc("visitor_sketches_pending", "Visitor sketches waiting to be flushed",
+			func() float64 { return float64(visitors.Stats().Pending) })
+		metrics.NewCounterFunc("visitor_sketches_dropped_total", "Visits not tracked because the sketch buffer was full",
+			func() float64 { return float64(visitors.Stats().Dropped) })
+		metrics.NewCounterFunc("visitor_sketch_flush_failures_total", "Failed flushes of visitor sketches",
+			func() float64 { return float64(visitors.Stats().Failures) })
+	}
+	
+	// Publish accepted clicks to live stream subscribers
+	var bus *events.Bus
+	if cfg.Stream.BufferSize > 0 {
+		bus = events.NewBus(cfg.Stream.BufferSize, cfg.Stream.MaxSubscribers)
+		service.SetEventBus(bus)
+		metrics.NewGaugeFunc("stream_subscribers", "Live click stream subscribers",
+			func() float64 { return float64(bus.Stats().Subscribers) })
+		metrics.NewCounterFunc("stream_subscribers_dropped_total", "Stream subscribers dropped for falling behind",
+			func() float64 { return float64(bus.Stats().Dropped) })
+		metrics.NewCounterFunc("stream_events_published_total", "Click events published to stream subscribers",
+			func() float64 { return float64(bus.Stats().Published) })
+	}
 	
 	// Create API handler with cached repository
-	handler := NewAPIHandler(service, cachedRepo)
+	handler := NewAPIHandler(service, cachedRepo, cfg)
+	handler.SetCircuitBreaker(policy.Breaker())
+	if visitors != nil {
+		handler.SetVisitorTracker(visitors)
+	}
+	if bus != nil {
+		handler.SetEventBus(bus)
+	}
 	
 	return &Server{
-		handler: handler,
+		handler:     handler,
+		clickWAL:    clickWAL,
+		partitions:  partitions,
+		retention:   retention,
+		rollups:     rollups,
+		visitors:    visitors,
+		events:      bus,
+		invalidator: invalidator,
+		cachedRepo:  cachedRepo,
+		cache:       cacheInstance,
+	}, nil
+}
+
+// newCache creates the cache backend selected by the configuration. It also
+// returns the part of the cache local to this instance, which invalidations
+// from other instances are applied to, or nil when the cache is fully shared.
+func newCache(cfg config.CacheConfig) (cache.Cache, cache.Cache, error) {
+	switch cfg.Backend {
+	case cache.BackendMemory:
+		memory := cache.NewInMemoryCache(cache.DefaultCleanupInterval)
+		return memory, memory, nil
+	case cache.BackendRedis:
+		redis, err := newRedisCache(cfg.Redis)
+		if err != nil {
+			return nil, nil, err
+		}
+		return redis, nil, nil
+	case cache.BackendTiered:
+		redis, err := newRedisCache(cfg.Redis)
+		if err != nil {
+			return nil, nil, err
+		}
+		memory := cache.NewInMemoryCache(cache.DefaultCleanupInterval)
+		return cache.NewTieredCache(memory, redis, cfg.L1TTL), memory, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown cache backend %q", cfg.Backend)
+	}
+}
+
+// ttlPolicy converts the configuration of a kind of cache entry
+func ttlPolicy(cfg config.CacheTTLConfig) cache.TTLPolicy {
+	return cache.TTLPolicy{
+		TTL:         cfg.TTL,
+		Jitter:      cfg.Jitter,
+		StaleWindow: cfg.StaleWindow,
 	}
 }
 
+// newRedisCache connects to the redis cache server
+func newRedisCache(cfg config.RedisConfig) (*cache.RedisCache, error) {
+	return cache.NewRedisCache(cache.RedisOptions{
+		Addr:      cfg.Addr,
+		Password:  cfg.Password,
+		DB:        cfg.DB,
+		KeyPrefix: cfg.KeyPrefix,
+		PoolSize:  cfg.PoolSize,
+		MaxConns:  cfg.MaxConns,
+		Timeout:   cfg.Timeout,
+	}, logger.GetGlobalLogger())
+}
+
+// newDatabasePolicy builds the retry and circuit breaker policy for repository calls
+func newDatabasePolicy(cfg config.DatabaseConfig) *resilience.Policy {
+	var breaker *resilience.CircuitBreaker
+	if cfg.BreakerThreshold > 0 {
+		breaker = resilience.NewCircuitBreaker(cfg.BreakerThreshold, cfg.BreakerOpenTimeout)
+	}
+	
+	policy := resilience.NewPolicy(resilience.RetryPolicy{
+		MaxAttempts:    cfg.MaxAttempts,
+		InitialBackoff: cfg.InitialBackoff,
+		MaxBackoff:     cfg.MaxBackoff,
+	}, breaker, db.IsTransientError)
+	
+	metrics.NewCounterFunc("db_retries_total", "Database statements retried after a transient error",
+		func() float64 { return float64(policy.Retries()) })
+	if breaker != nil {
+		metrics.NewGaugeFunc("db_circuit_breaker_state", "Database circuit breaker state (0 closed, 1 half-open, 2 open)",
+			func() float64 { return float64(breaker.State()) })
+		metrics.NewCounterFunc("db_circuit_breaker_opens_total", "Times the database circuit breaker opened",
+			func() float64 { return float64(breaker.Stats().Opens) })
+		metrics.NewCounterFunc("db_circuit_breaker_rejections_total", "Database calls rejected by the open circuit breaker",
+			func() float64 { return float64(breaker.Stats().Rejections) })
+	}
+	
+	return policy
+}
+
+// openClickWAL opens the click WAL and starts its background replayer
+func openClickWAL(cfg config.WALConfig, repo *db.Repository, cachedRepo *cache.CachedRepository) (*app.ClickWAL, error) {
+	if !filepath.IsAbs(cfg.Dir) {
+		return nil, fmt.Errorf("WAL directory %q must be an absolute path", cfg.Dir)
+	}
+
+	syncPolicy, err := wal.ParseSyncPolicy(cfg.Sync)
+	if err != nil {
+		return nil, err
+	}
+	
+	walLog, err := wal.Open(wal.Options{
+		Dir:          cfg.Dir,
+		SegmentSize:  cfg.SegmentSize,
+		MaxSize:      cfg.MaxSize,
+		Sync:         syncPolicy,
+		SyncInterval: cfg.SyncInterval,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open click WAL: %w", err)
+	}
+	
+	clickWAL := app.NewClickWAL(walLog, repo, logger.GetGlobalLogger())
+	clickWAL.OnReplay(func(clicks []*dto.Click) {
+		cachedRepo.InvalidateClickCaches(uniqueBannerIDs(clicks))
+	})
+	clickWAL.Start(cfg.ReplayInterval)
+	
+	metrics.NewGaugeFunc("click_wal_bytes", "Bytes of clicks waiting in the WAL",
+		func() float64 { return float64(clickWAL.Size()) })
+	metrics.NewCounterFunc("click_wal_appended_total", "Clicks appended to the WAL while the database was unavailable",
+		func() float64 { return float64(clickWAL.Stats().Appended) })
+	metrics.NewCounterFunc("click_wal_replayed_total", "Clicks replayed from the WAL into the database",
+		func() float64 { return float64(clickWAL.Stats().Replayed) })
+	metrics.NewCounterFunc("click_wal_corrupt_total", "WAL segments whose tail was unreadable and skipped during replay",
+		func() float64 { return float64(clickWAL.Stats().Corrupt) })
+	
+	return clickWAL, nil
+}
+
 // Start starts the API server
 func (s *Server) Start(port int) error {
 	// Setup routes
@@ -54,13 +333,48 @@ func (s *Server) Start(port int) error {
 	log.Printf("Available endpoints:")
 	log.Printf("  GET  /api/v1/counter/<bannerID>  - Record a click for a banner")
 	log.Printf("  POST /api/v1/stats/<bannerID>    - Get banner statistics")
+	log.Printf("  POST /api/v1/stats:query         - Get statistics of many banners at once")
+	log.Printf("  GET  /api/v1/analytics/top       - Rank banners by clicks")
+	log.Printf("  GET  /api/v1/analytics/performance - Get clicks and conversions of all banners")
+	log.Printf("  POST /api/v1/conversions         - Record a conversion for a click ID")
+	log.Printf("  POST /api/v1/clicks:batch        - Ingest a JSON or NDJSON batch of clicks")
+	log.Printf("  GET  /api/v1/stream/clicks       - Stream clicks and counter snapshots (SSE)")
+	log.Printf("  GET  /api/v1/ws/counters         - Live banner counters (WebSocket)")
 	log.Printf("  GET  /health                     - Health check")
+	log.Printf("  GET  /metrics                    - Prometheus metrics")
+	log.Printf("  GET  /api/v1/admin/ratelimit     - Show counter rate limits (PUT to change)")
 	
 	return s.server.ListenAndServe()
 }
 
 // Stop stops the API server
 func (s *Server) Stop() error {
+	s.handler.Close()
+	if s.events != nil {
+		s.events.Close()
+	}
+	if s.partitions != nil {
+		s.partitions.Stop()
+	}
+	if s.retention != nil {
+		s.retention.Stop()
+	}
+	if s.rollups != nil {
+		s.rollups.Stop()
+	}
+	if s.visitors != nil {
+		s.visitors.Stop()
+	}
+	if s.clickWAL != nil {
+		if err := s.clickWAL.Stop(); err != nil {
+			log.Printf("Error closing click WAL: %v", err)
+		}
+	}
+	if s.invalidator != nil {
+		s.invalidator.Stop()
+	}
+	s.cachedRepo.Stop()
+	s.cache.Stop()
 	if s.server != nil {
 		return s.server.Close()
 	}
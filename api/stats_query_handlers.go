@@ -0,0 +1,164 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/tyagnii/ecom_test/app"
+	"github.com/tyagnii/ecom_test/db"
+)
+
+// MaxStatsQueryBanners is the number of banners a stats query may cover
+const MaxStatsQueryBanners = 1000
+
+// Stats query groupings
+const (
+	StatsGroupByBanner = "banner"
+	StatsGroupByNone   = "none"
+)
+
+// StatsQueryRequest represents a multi-banner stats request
+type StatsQueryRequest struct {
+	BannerIDs []int `json:"banner_ids,omitempty"`
+	// Name selects the banners whose name contains it instead of BannerIDs
+	Name   string    `json:"name,omitempty"`
+	TsFrom time.Time `json:"ts_from"`
+	// TsTo is exclusive
+	TsTo        time.Time `json:"ts_to"`
+	Granularity string    `json:"granularity,omitempty"`
+	TZ          string    `json:"tz,omitempty"`
+	// GroupBy is "banner" for a series per banner or "none" for one combined series
+	GroupBy string `json:"group_by,omitempty"`
+}
+
+// StatsQueryResponse represents a multi-banner stats response
+type StatsQueryResponse struct {
+	PeriodStart time.Time               `json:"period_start"`
+	PeriodEnd   time.Time               `json:"period_end"`
+	Source      db.RollupLevel          `json:"source"`
+	Granularity db.RollupLevel          `json:"granularity,omitempty"`
+	TZ          string                  `json:"tz"`
+	GroupBy     string                  `json:"group_by"`
+	BannerIDs   []int                   `json:"banner_ids"`
+	Series      []*db.BannerClickSeries `json:"series"`
+}
+
+// StatsQueryHandler handles POST /api/v1/stats:query
+func (h *APIHandler) StatsQueryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.sendError(w, http.StatusMethodNotAllowed, "Method not allowed", "Use POST to query stats")
+		return
+	}
+
+	var req StatsQueryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.sendError(w, http.StatusBadRequest, "Invalid request body", "Failed to parse JSON")
+		return
+	}
+
+	if req.TsFrom.IsZero() || req.TsTo.IsZero() {
+		h.sendError(w, http.StatusBadRequest, "Invalid time range", "ts_from and ts_to are required")
+		return
+	}
+
+	if req.TsFrom.After(req.TsTo) {
+		h.sendError(w, http.StatusBadRequest, "Invalid time range", "ts_from must be before ts_to")
+		return
+	}
+
+	granularity, err := db.ParseRollupLevel(req.Granularity)
+	if err != nil {
+		h.sendError(w, http.StatusBadRequest, "Invalid granularity", err.Error())
+		return
+	}
+
+	loc, err := db.LoadTimeZone(req.TZ)
+	if err != nil {
+		h.sendError(w, http.StatusBadRequest, "Invalid time zone", err.Error())
+		return
+	}
+
+	if req.GroupBy == "" {
+		req.GroupBy = StatsGroupByBanner
+	}
+	if req.GroupBy != StatsGroupByBanner && req.GroupBy != StatsGroupByNone {
+		h.sendError(w, http.StatusBadRequest, "Invalid group_by", fmt.Sprintf("group_by must be %s or %s", StatsGroupByBanner, StatsGroupByNone))
+		return
+	}
+
+	if req.Name != "" && len(req.BannerIDs) > 0 {
+		h.sendError(w, http.StatusBadRequest, "Invalid banners", "Give either banner_ids or name, not both")
+		return
+	}
+	if req.Name == "" && len(req.BannerIDs) == 0 {
+		h.sendError(w, http.StatusBadRequest, "Invalid banners", "banner_ids or name is required")
+		return
+	}
+
+	// Resolve a name search to banner IDs
+	if req.Name != "" {
+		banners, err := h.cachedRepo.SearchBannersByName(req.Name)
+		if err != nil {
+			log.Printf("Failed to search banners by name %q: %v", req.Name, err)
+			h.sendError(w, http.StatusInternalServerError, "Failed to search banners", "Internal server error")
+			return
+		}
+		for _, banner := range banners {
+			req.BannerIDs = append(req.BannerIDs, banner.ID)
+		}
+	}
+
+	bannerIDs, err := distinctBannerIDs(req.BannerIDs)
+	if err != nil {
+		h.sendError(w, http.StatusBadRequest, "Invalid banners", err.Error())
+		return
+	}
+
+	response := StatsQueryResponse{
+		PeriodStart: req.TsFrom,
+		PeriodEnd:   req.TsTo,
+		Source:      db.ChooseRollupLevel(req.TsFrom, req.TsTo, granularity, loc),
+		TZ:          loc.String(),
+		GroupBy:     req.GroupBy,
+		BannerIDs:   bannerIDs,
+		Series:      []*db.BannerClickSeries{},
+	}
+	if granularity != db.RollupRaw {
+		response.Granularity = granularity
+	}
+
+	if len(bannerIDs) > 0 {
+		clickService := app.NewClickService(h.service)
+		response.Series, err = clickService.GetClickSeriesForBanners(bannerIDs, req.TsFrom, req.TsTo, granularity, loc, req.GroupBy == StatsGroupByBanner)
+		if err != nil {
+			log.Printf("Failed to query stats for %d banners: %v", len(bannerIDs), err)
+			h.sendError(w, http.StatusInternalServerError, "Failed to query stats", "Internal server error")
+			return
+		}
+	}
+
+	h.sendJSON(w, response)
+}
+
+// distinctBannerIDs validates banner IDs and drops duplicates, keeping their order
+func distinctBannerIDs(ids []int) ([]int, error) {
+	seen := make(map[int]bool, len(ids))
+	bannerIDs := make([]int, 0, len(ids))
+	for _, id := range ids {
+		if id <= 0 {
+			return nil, fmt.Errorf("banner ID %d must be positive", id)
+		}
+		if !seen[id] {
+			seen[id] = true
+			bannerIDs = append(bannerIDs, id)
+		}
+	}
+
+	if len(bannerIDs) > MaxStatsQueryBanners {
+		return nil, fmt.Errorf("a query covers at most %d banners, got %d", MaxStatsQueryBanners, len(bannerIDs))
+	}
+	return bannerIDs, nil
+}
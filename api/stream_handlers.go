@@ -0,0 +1,199 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tyagnii/ecom_test/db"
+	"github.com/tyagnii/ecom_test/events"
+)
+
+// MaxStreamSnapshotBanners bounds the banners included in the snapshots of an
+// unfiltered stream
+const MaxStreamSnapshotBanners = 100
+
+// streamRetry is the reconnection delay suggested to stream clients
+const streamRetry = 3 * time.Second
+
+// StreamSnapshot represents the periodic counter snapshot of a click stream
+type StreamSnapshot struct {
+	Time    time.Time        `json:"time"`
+	Banners []*db.ClickStats `json:"banners"`
+}
+
+// StreamDropped represents the final event of a stream closed by the server
+type StreamDropped struct {
+	Reason string `json:"reason"`
+}
+
+// StreamClicksHandler handles GET /api/v1/stream/clicks. Clicks of the banners
+// given by banner_id (repeated or comma separated; all banners if omitted) are
+// streamed as Server-Sent Events, together with periodic counter snapshots.
+func (h *APIHandler) StreamClicksHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.sendError(w, http.StatusMethodNotAllowed, "Method not allowed", "Only GET method is allowed")
+		return
+	}
+
+	if h.events == nil {
+		h.sendError(w, http.StatusServiceUnavailable, "Streaming disabled", "Live click streaming is not enabled")
+		return
+	}
+
+	bannerIDs, err := parseStreamBannerIDs(r.URL.Query()["banner_id"])
+	if err != nil {
+		h.sendError(w, http.StatusBadRequest, "Invalid banner ID", err.Error())
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		h.sendError(w, http.StatusInternalServerError, "Streaming unsupported", "The connection does not support streaming")
+		return
+	}
+
+	sub, err := h.events.Subscribe(bannerIDs...)
+	if err != nil {
+		h.sendError(w, http.StatusServiceUnavailable, "Too many subscribers", "The maximum number of stream subscribers has been reached")
+		return
+	}
+	defer sub.Close()
+
+	// The stream outlives the server write timeout
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("Failed to clear stream write deadline: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", streamRetry.Milliseconds())
+
+	// Unfiltered streams snapshot the banners clicked since the client connected
+	seen := make(map[int]bool)
+	snapshotBanners := func() []int {
+		if bannerIDs != nil {
+			return bannerIDs
+		}
+		ids := make([]int, 0, len(seen))
+		for id := range seen {
+			ids = append(ids, id)
+		}
+		sort.Ints(ids)
+		return ids
+	}
+
+	if err := h.writeStreamSnapshot(w, flusher, snapshotBanners()); err != nil {
+		return
+	}
+
+	interval := h.streamSnapshotInterval
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case click, ok := <-sub.Events():
+			if !ok {
+				reason := "closed"
+				switch {
+				case errors.Is(sub.Err(), events.ErrSlowConsumer):
+					reason = "slow_consumer"
+				case errors.Is(sub.Err(), events.ErrBusClosed):
+					reason = "shutdown"
+				}
+				writeStreamEvent(w, flusher, "dropped", "", &StreamDropped{Reason: reason})
+				return
+			}
+			if bannerIDs == nil && !seen[click.BannerID] && len(seen) < MaxStreamSnapshotBanners {
+				seen[click.BannerID] = true
+			}
+			id := ""
+			if click.ClickID > 0 {
+				id = strconv.Itoa(click.ClickID)
+			}
+			if err := writeStreamEvent(w, flusher, "click", id, click); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err := h.writeStreamSnapshot(w, flusher, snapshotBanners()); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// writeStreamSnapshot writes the counters of the given banners as a snapshot event
+func (h *APIHandler) writeStreamSnapshot(w http.ResponseWriter, flusher http.Flusher, bannerIDs []int) error {
+	snapshot := &StreamSnapshot{
+		Time:    time.Now().UTC(),
+		Banners: make([]*db.ClickStats, 0, len(bannerIDs)),
+	}
+	for _, id := range bannerIDs {
+		stats, err := h.cachedRepo.GetClickStats(id)
+		if err != nil {
+			log.Printf("Failed to get click stats of banner %d for stream snapshot: %v", id, err)
+			continue
+		}
+		snapshot.Banners = append(snapshot.Banners, stats)
+	}
+	return writeStreamEvent(w, flusher, "snapshot", "", snapshot)
+}
+
+// writeStreamEvent writes a Server-Sent Event with a JSON payload and flushes it
+func writeStreamEvent(w http.ResponseWriter, flusher http.Flusher, event, id string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("Failed to encode %s stream event: %v", event, err)
+		return err
+	}
+
+	if id != "" {
+		if _, err := fmt.Fprintf(w, "id: %s\n", id); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload); err != nil {
+		return err
+	}
+	flusher.Flush()
+	return nil
+}
+
+// parseStreamBannerIDs parses banner_id query values, each of which may hold a
+// comma separated list. No values means all banners and returns nil.
+func parseStreamBannerIDs(values []string) ([]int, error) {
+	var ids []int
+	seen := make(map[int]bool)
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			id, err := strconv.Atoi(part)
+			if err != nil || id <= 0 {
+				return nil, fmt.Errorf("banner_id must be a positive integer, got %q", part)
+			}
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids, nil
+}
@@ -0,0 +1,305 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/tyagnii/ecom_test/db"
+	"github.com/tyagnii/ecom_test/events"
+	"github.com/tyagnii/ecom_test/ws"
+)
+
+// Live counter message types. Clients send subscribe, unsubscribe and
+// snapshot requests; the server answers with subscribed, unsubscribed,
+// snapshot and error messages and pushes delta messages as clicks arrive.
+const (
+	LiveSubscribe    = "subscribe"
+	LiveUnsubscribe  = "unsubscribe"
+	LiveSubscribed   = "subscribed"
+	LiveUnsubscribed = "unsubscribed"
+	LiveSnapshot     = "snapshot"
+	LiveDelta        = "delta"
+	LiveError        = "error"
+)
+
+// maxLiveRequestSize bounds the size of a client message
+const maxLiveRequestSize = 64 * 1024
+
+// liveWriteWait bounds writing a message to a live counter client
+const liveWriteWait = 10 * time.Second
+
+// LiveRequest represents a client message of the live counter protocol
+type LiveRequest struct {
+	Type string `json:"type"`
+	// ID is echoed in the reply to correlate it with the request
+	ID        string `json:"id,omitempty"`
+	BannerIDs []int  `json:"banner_ids,omitempty"`
+}
+
+// LiveMessage represents a server message of the live counter protocol
+type LiveMessage struct {
+	Type string `json:"type"`
+	ID   string `json:"id,omitempty"`
+	// BannerIDs lists all subscribed banners after subscribe and unsubscribe
+	BannerIDs []int            `json:"banner_ids,omitempty"`
+	Banners   []*db.ClickStats `json:"banners,omitempty"`
+	Deltas    []*CounterDelta  `json:"deltas,omitempty"`
+	Time      *time.Time       `json:"time,omitempty"`
+	Error     string           `json:"error,omitempty"`
+}
+
+// CounterDelta represents the clicks of a banner since the previous delta
+type CounterDelta struct {
+	BannerID       int       `json:"banner_id"`
+	Clicks         int       `json:"clicks"`
+	FilteredClicks int       `json:"filtered_clicks"`
+	NetClicks      int       `json:"net_clicks"`
+	LastClick      time.Time `json:"last_click"`
+}
+
+// LiveCountersHandler handles WebSocket connections on /api/v1/ws/counters.
+// A snapshot is sent for newly subscribed banners before their deltas, so a
+// click in flight while subscribing may be counted in both.
+func (h *APIHandler) LiveCountersHandler(w http.ResponseWriter, r *http.Request) {
+	if h.events == nil {
+		h.sendError(w, http.StatusServiceUnavailable, "Streaming disabled", "Live click streaming is not enabled")
+		return
+	}
+
+	// Subscribe before upgrading, so a connection over the limit is refused
+	// with a plain HTTP error
+	sub, err := h.events.SubscribeBanners(nil)
+	if err != nil {
+		h.sendError(w, http.StatusServiceUnavailable, "Too many subscribers", "The maximum number of stream subscribers has been reached")
+		return
+	}
+	defer sub.Close()
+
+	conn, err := ws.Upgrade(w, r, h.wsAllowedOrigins)
+	if err != nil {
+		var handshakeErr *ws.HandshakeError
+		if errors.As(err, &handshakeErr) {
+			h.sendError(w, handshakeErr.Status, "Invalid WebSocket request", handshakeErr.Message)
+			return
+		}
+		log.Printf("Failed to upgrade live counter connection: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	pingInterval := h.wsPingInterval
+	if pingInterval <= 0 {
+		pingInterval = 30 * time.Second
+	}
+	keepAlive := func() {
+		conn.SetReadDeadline(time.Now().Add(2 * pingInterval))
+	}
+	keepAlive()
+	conn.SetReadLimit(maxLiveRequestSize)
+	conn.SetPongHandler(func([]byte) { keepAlive() })
+
+	requests := make(chan []byte)
+	readErr := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				readErr <- err
+				return
+			}
+			keepAlive()
+			select {
+			case requests <- data:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case data := <-requests:
+			if err := h.handleLiveRequest(conn, sub, data); err != nil {
+				return
+			}
+		case err := <-readErr:
+			var closeErr *ws.CloseError
+			if !errors.As(err, &closeErr) && !errors.Is(err, io.EOF) {
+				log.Printf("Live counter connection from %s failed: %v", conn.RemoteAddr(), err)
+			}
+			return
+		case click, ok := <-sub.Events():
+			if !ok {
+				code, reason := ws.CloseNormalClosure, "closed"
+				switch {
+				case errors.Is(sub.Err(), events.ErrSlowConsumer):
+					code, reason = ws.CloseTryAgainLater, "slow consumer"
+				case errors.Is(sub.Err(), events.ErrBusClosed):
+					code, reason = ws.CloseGoingAway, "server shutting down"
+				}
+				conn.SetWriteDeadline(time.Now().Add(liveWriteWait))
+				conn.WriteClose(code, reason)
+				return
+			}
+			if err := writeLiveDeltas(conn, sub, click); err != nil {
+				return
+			}
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(liveWriteWait))
+			if err := conn.Ping(nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// handleLiveRequest answers a client message. Invalid requests get an error
+// message; the returned error means the connection is unusable.
+func (h *APIHandler) handleLiveRequest(conn *ws.Conn, sub *events.Subscription, data []byte) error {
+	var req LiveRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return writeLive(conn, &LiveMessage{Type: LiveError, Error: "Invalid JSON: " + err.Error()})
+	}
+
+	bannerIDs, err := distinctBannerIDs(req.BannerIDs)
+	if err != nil {
+		return writeLive(conn, &LiveMessage{Type: LiveError, ID: req.ID, Error: err.Error()})
+	}
+
+	switch req.Type {
+	case LiveSubscribe:
+		if len(bannerIDs) == 0 {
+			return writeLive(conn, &LiveMessage{Type: LiveError, ID: req.ID, Error: "banner_ids is required"})
+		}
+
+		added := make([]int, 0, len(bannerIDs))
+		for _, id := range bannerIDs {
+			if !sub.Matches(id) {
+				added = append(added, id)
+			}
+		}
+		if h.wsMaxBanners > 0 && len(sub.Banners())+len(added) > h.wsMaxBanners {
+			return writeLive(conn, &LiveMessage{
+				Type:  LiveError,
+				ID:    req.ID,
+				Error: fmt.Sprintf("at most %d banners can be subscribed per connection", h.wsMaxBanners),
+			})
+		}
+
+		sub.Add(added...)
+		if err := writeLive(conn, &LiveMessage{Type: LiveSubscribed, ID: req.ID, BannerIDs: sub.Banners()}); err != nil {
+			return err
+		}
+		return h.writeLiveSnapshot(conn, req.ID, added)
+
+	case LiveUnsubscribe:
+		if len(bannerIDs) == 0 {
+			bannerIDs = sub.Banners()
+		}
+		sub.Remove(bannerIDs...)
+		return writeLive(conn, &LiveMessage{Type: LiveUnsubscribed, ID: req.ID, BannerIDs: sub.Banners()})
+
+	case LiveSnapshot:
+		if len(bannerIDs) == 0 {
+			bannerIDs = sub.Banners()
+		}
+		for _, id := range bannerIDs {
+			if !sub.Matches(id) {
+				return writeLive(conn, &LiveMessage{
+					Type:  LiveError,
+					ID:    req.ID,
+					Error: fmt.Sprintf("banner %d is not subscribed", id),
+				})
+			}
+		}
+		return h.writeLiveSnapshot(conn, req.ID, bannerIDs)
+
+	default:
+		return writeLive(conn, &LiveMessage{Type: LiveError, ID: req.ID, Error: fmt.Sprintf("unknown message type %q", req.Type)})
+	}
+}
+
+// writeLiveSnapshot sends the counters of the given banners
+func (h *APIHandler) writeLiveSnapshot(conn *ws.Conn, id string, bannerIDs []int) error {
+	now := time.Now().UTC()
+	msg := &LiveMessage{
+		Type:    LiveSnapshot,
+		ID:      id,
+		Banners: make([]*db.ClickStats, 0, len(bannerIDs)),
+		Time:    &now,
+	}
+	for _, bannerID := range bannerIDs {
+		stats, err := h.cachedRepo.GetClickStats(bannerID)
+		if err != nil {
+			log.Printf("Failed to get click stats of banner %d for live snapshot: %v", bannerID, err)
+			return writeLive(conn, &LiveMessage{Type: LiveError, ID: id, Error: "Failed to retrieve click statistics"})
+		}
+		msg.Banners = append(msg.Banners, stats)
+	}
+	return writeLive(conn, msg)
+}
+
+// writeLiveDeltas sends one delta message for click and the clicks already
+// buffered behind it
+func writeLiveDeltas(conn *ws.Conn, sub *events.Subscription, click *events.Click) error {
+	var deltas []*CounterDelta
+	byBanner := make(map[int]*CounterDelta)
+
+	add := func(click *events.Click) {
+		// Clicks buffered before an unsubscribe are still delivered
+		if !sub.Matches(click.BannerID) {
+			return
+		}
+		delta, ok := byBanner[click.BannerID]
+		if !ok {
+			delta = &CounterDelta{BannerID: click.BannerID}
+			byBanner[click.BannerID] = delta
+			deltas = append(deltas, delta)
+		}
+		delta.Clicks++
+		if click.FilteredReason != "" {
+			delta.FilteredClicks++
+		} else {
+			delta.NetClicks++
+		}
+		if click.Time.After(delta.LastClick) {
+			delta.LastClick = click.Time
+		}
+	}
+
+	add(click)
+drain:
+	for {
+		select {
+		case next, ok := <-sub.Events():
+			if !ok {
+				break drain
+			}
+			add(next)
+		default:
+			break drain
+		}
+	}
+
+	if len(deltas) == 0 {
+		return nil
+	}
+	return writeLive(conn, &LiveMessage{Type: LiveDelta, Deltas: deltas})
+}
+
+// writeLive writes a message to a live counter client
+func writeLive(conn *ws.Conn, msg *LiveMessage) error {
+	conn.SetWriteDeadline(time.Now().Add(liveWriteWait))
+	return conn.WriteJSON(msg)
+}
@@ -0,0 +1,344 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tyagnii/ecom_test/app"
+	"github.com/tyagnii/ecom_test/cache"
+	"github.com/tyagnii/ecom_test/config"
+	"github.com/tyagnii/ecom_test/db"
+	"github.com/tyagnii/ecom_test/events"
+	"github.com/tyagnii/ecom_test/logger"
+	"github.com/tyagnii/ecom_test/ws"
+)
+
+// liveTimeout bounds waiting for a message in the live counter tests
+const liveTimeout = 5 * time.Second
+
+// liveServer serves the live counter endpoint with the click statistics of
+// banners 1 to 3 cached, so snapshots do not need a database
+func liveServer(t *testing.T, configure func(*config.Config)) (*httptest.Server, *events.Bus) {
+	t.Helper()
+
+	cfg := config.Default()
+	cfg.ClickTokenSecret = "test"
+	cfg.Stream.AllowedOrigins = []string{"https://dashboard.example.com"}
+	if configure != nil {
+		configure(cfg)
+	}
+
+	repo := db.NewRepository(nil)
+	service := app.NewServiceWithLogger(repo, logger.NewStructuredLogger(logger.WARN, io.Discard))
+	cacheInstance := cache.NewInMemoryCache(cache.DefaultCleanupInterval)
+	t.Cleanup(cacheInstance.Stop)
+	for id := 1; id <= 3; id++ {
+		cacheInstance.SetClickStats(id, &db.ClickStats{BannerID: id, TotalClicks: id * 10, NetClicks: id * 10}, time.Hour)
+	}
+
+	bus := events.NewBus(cfg.Stream.BufferSize, cfg.Stream.MaxSubscribers)
+	handler := NewAPIHandler(service, cache.NewCachedRepository(repo, cacheInstance), cfg)
+	handler.SetEventBus(bus)
+	t.Cleanup(handler.Close)
+
+	server := httptest.NewServer(handler.SetupRoutes())
+	t.Cleanup(server.Close)
+	return server, bus
+}
+
+// liveClient reads the messages of a live counter connection in the background
+type liveClient struct {
+	conn     *ws.Conn
+	messages chan *LiveMessage
+	err      chan error
+}
+
+// dialLive connects to the live counter endpoint of a test server
+func dialLive(t *testing.T, server *httptest.Server, header http.Header) *liveClient {
+	t.Helper()
+
+	conn, err := ws.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/api/v1/ws/counters", header)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	client := &liveClient{conn: conn, messages: make(chan *LiveMessage, 16), err: make(chan error, 1)}
+	go func() {
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				client.err <- err
+				return
+			}
+			var msg LiveMessage
+			if err := json.Unmarshal(data, &msg); err != nil {
+				client.err <- err
+				return
+			}
+			client.messages <- &msg
+		}
+	}()
+	return client
+}
+
+// send writes a request to the server
+func (c *liveClient) send(t *testing.T, req *LiveRequest) {
+	t.Helper()
+	if err := c.conn.WriteJSON(req); err != nil {
+		t.Fatalf("failed to send %s: %v", req.Type, err)
+	}
+}
+
+// expect returns the next message and fails unless it has the given type
+func (c *liveClient) expect(t *testing.T, messageType string) *LiveMessage {
+	t.Helper()
+	select {
+	case msg := <-c.messages:
+		if msg.Type != messageType {
+			t.Fatalf("received %s message %+v, want %s", msg.Type, msg, messageType)
+		}
+		return msg
+	case err := <-c.err:
+		t.Fatalf("connection failed waiting for %s: %v", messageType, err)
+	case <-time.After(liveTimeout):
+		t.Fatalf("no %s message received", messageType)
+	}
+	return nil
+}
+
+// closed waits for the connection to end and returns why
+func (c *liveClient) closed(t *testing.T) error {
+	t.Helper()
+	for {
+		select {
+		case msg := <-c.messages:
+			t.Fatalf("received %s message while waiting for the connection to close", msg.Type)
+		case err := <-c.err:
+			return err
+		case <-time.After(liveTimeout):
+			t.Fatal("connection was not closed")
+		}
+	}
+}
+
+// subscribe subscribes to banners and consumes the reply and the snapshot
+func (c *liveClient) subscribe(t *testing.T, bannerIDs ...int) *LiveMessage {
+	t.Helper()
+	c.send(t, &LiveRequest{Type: LiveSubscribe, ID: "sub", BannerIDs: bannerIDs})
+	c.expect(t, LiveSubscribed)
+	return c.expect(t, LiveSnapshot)
+}
+
+// waitSubscribers waits until the bus has n subscribers
+func waitSubscribers(t *testing.T, bus *events.Bus, n int) {
+	t.Helper()
+	deadline := time.Now().Add(liveTimeout)
+	for bus.Stats().Subscribers != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("bus has %d subscribers, want %d", bus.Stats().Subscribers, n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestLiveCountersHandshake(t *testing.T) {
+	server, bus := liveServer(t, nil)
+
+	dialLive(t, server, http.Header{"Origin": {"https://dashboard.example.com"}})
+	waitSubscribers(t, bus, 1)
+
+	_, err := ws.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/api/v1/ws/counters",
+		http.Header{"Origin": {"https://evil.example.com"}})
+	var handshakeErr *ws.HandshakeError
+	if !errors.As(err, &handshakeErr) || handshakeErr.Status != http.StatusForbidden {
+		t.Errorf("Dial from another origin = %v, want status %d", err, http.StatusForbidden)
+	}
+
+	resp, err := http.Get(server.URL + "/api/v1/ws/counters")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("plain GET returned %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+	waitSubscribers(t, bus, 1)
+}
+
+func TestLiveCountersSubscribe(t *testing.T) {
+	server, bus := liveServer(t, nil)
+	client := dialLive(t, server, nil)
+
+	client.send(t, &LiveRequest{Type: LiveSubscribe, ID: "1", BannerIDs: []int{2, 1, 2}})
+	subscribed := client.expect(t, LiveSubscribed)
+	if subscribed.ID != "1" || !reflect.DeepEqual(subscribed.BannerIDs, []int{1, 2}) {
+		t.Errorf("subscribed = %+v, want ID 1 and banners [1 2]", subscribed)
+	}
+
+	// The snapshot precedes the deltas of the subscribed banners
+	snapshot := client.expect(t, LiveSnapshot)
+	if len(snapshot.Banners) != 2 || snapshot.Banners[0].TotalClicks != 20 || snapshot.Banners[1].TotalClicks != 10 {
+		t.Errorf("snapshot = %+v, want the cached counters of banners 2 and 1", snapshot.Banners)
+	}
+
+	now := time.Now().UTC()
+	bus.Publish(&events.Click{BannerID: 3, Time: now})
+	bus.Publish(&events.Click{BannerID: 1, Time: now})
+	bus.Publish(&events.Click{BannerID: 1, Time: now, FilteredReason: "bot"})
+
+	var clicks, filtered int
+	for clicks < 2 {
+		delta := client.expect(t, LiveDelta)
+		for _, d := range delta.Deltas {
+			if d.BannerID != 1 {
+				t.Fatalf("received a delta of unsubscribed banner %d", d.BannerID)
+			}
+			clicks += d.Clicks
+			filtered += d.FilteredClicks
+		}
+	}
+	if clicks != 2 || filtered != 1 {
+		t.Errorf("deltas counted %d clicks, %d filtered, want 2 and 1", clicks, filtered)
+	}
+
+	client.send(t, &LiveRequest{Type: LiveUnsubscribe, ID: "2", BannerIDs: []int{1}})
+	unsubscribed := client.expect(t, LiveUnsubscribed)
+	if !reflect.DeepEqual(unsubscribed.BannerIDs, []int{2}) {
+		t.Errorf("unsubscribed left banners %v, want [2]", unsubscribed.BannerIDs)
+	}
+
+	bus.Publish(&events.Click{BannerID: 1, Time: now})
+	bus.Publish(&events.Click{BannerID: 2, Time: now})
+	delta := client.expect(t, LiveDelta)
+	if len(delta.Deltas) != 1 || delta.Deltas[0].BannerID != 2 {
+		t.Errorf("delta after unsubscribing = %+v, want banner 2 only", delta.Deltas)
+	}
+
+	client.send(t, &LiveRequest{Type: LiveSnapshot, ID: "3", BannerIDs: []int{1}})
+	if msg := client.expect(t, LiveError); msg.ID != "3" {
+		t.Errorf("error for a snapshot of an unsubscribed banner has ID %q, want 3", msg.ID)
+	}
+}
+
+func TestLiveCountersInvalidRequests(t *testing.T) {
+	server, _ := liveServer(t, nil)
+	client := dialLive(t, server, nil)
+
+	if err := client.conn.WriteMessage(ws.TextMessage, []byte("{")); err != nil {
+		t.Fatal(err)
+	}
+	client.expect(t, LiveError)
+
+	for _, req := range []*LiveRequest{
+		{Type: LiveSubscribe},
+		{Type: LiveSubscribe, BannerIDs: []int{0}},
+		{Type: "publish", BannerIDs: []int{1}},
+	} {
+		client.send(t, req)
+		client.expect(t, LiveError)
+	}
+
+	// The connection is still usable
+	client.subscribe(t, 1)
+}
+
+func TestLiveCountersBannerLimit(t *testing.T) {
+	server, _ := liveServer(t, func(cfg *config.Config) {
+		cfg.Stream.MaxBannersPerConn = 2
+	})
+	client := dialLive(t, server, nil)
+
+	client.subscribe(t, 1, 2)
+
+	client.send(t, &LiveRequest{Type: LiveSubscribe, ID: "over", BannerIDs: []int{3}})
+	if msg := client.expect(t, LiveError); msg.ID != "over" {
+		t.Errorf("limit error has ID %q, want over", msg.ID)
+	}
+
+	// Subscribing to banners already subscribed does not count against the limit
+	client.send(t, &LiveRequest{Type: LiveSubscribe, BannerIDs: []int{1}})
+	if msg := client.expect(t, LiveSubscribed); !reflect.DeepEqual(msg.BannerIDs, []int{1, 2}) {
+		t.Errorf("subscribed = %v, want [1 2]", msg.BannerIDs)
+	}
+}
+
+func TestLiveCountersSubscriberLimit(t *testing.T) {
+	server, bus := liveServer(t, func(cfg *config.Config) {
+		cfg.Stream.MaxSubscribers = 1
+	})
+	first := dialLive(t, server, nil)
+	waitSubscribers(t, bus, 1)
+
+	_, err := ws.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/api/v1/ws/counters", nil)
+	var handshakeErr *ws.HandshakeError
+	if !errors.As(err, &handshakeErr) || handshakeErr.Status != http.StatusServiceUnavailable {
+		t.Fatalf("Dial over the subscriber limit = %v, want status %d", err, http.StatusServiceUnavailable)
+	}
+
+	// Closing the first connection frees its slot
+	first.conn.WriteClose(ws.CloseNormalClosure, "")
+	first.closed(t)
+	waitSubscribers(t, bus, 0)
+	dialLive(t, server, nil)
+	waitSubscribers(t, bus, 1)
+}
+
+func TestLiveCountersPingTimeout(t *testing.T) {
+	const interval = 50 * time.Millisecond
+	server, bus := liveServer(t, func(cfg *config.Config) {
+		cfg.Stream.PingInterval = interval
+	})
+
+	// A client answering pings stays connected past the timeout
+	alive := dialLive(t, server, nil)
+	time.Sleep(4 * interval)
+	alive.subscribe(t, 1)
+
+	// A client that does not answer is disconnected after two intervals
+	conn, err := ws.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/api/v1/ws/counters", nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	waitSubscribers(t, bus, 2)
+	waitSubscribers(t, bus, 1)
+
+	conn.SetReadDeadline(time.Now().Add(liveTimeout))
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			if strings.Contains(err.Error(), "timeout") {
+				t.Fatalf("server did not close the silent connection: %v", err)
+			}
+			break
+		}
+	}
+}
+
+func TestLiveCountersClose(t *testing.T) {
+	server, bus := liveServer(t, nil)
+
+	client := dialLive(t, server, nil)
+	client.subscribe(t, 1)
+	client.conn.WriteClose(ws.CloseNormalClosure, "done")
+	var closeErr *ws.CloseError
+	if err := client.closed(t); !errors.As(err, &closeErr) || closeErr.Code != ws.CloseNormalClosure {
+		t.Errorf("client close answered with %v, want close %d", err, ws.CloseNormalClosure)
+	}
+	waitSubscribers(t, bus, 0)
+
+	// Shutting the bus down closes connections as going away
+	client = dialLive(t, server, nil)
+	client.subscribe(t, 1)
+	bus.Close()
+	if err := client.closed(t); !errors.As(err, &closeErr) || closeErr.Code != ws.CloseGoingAway {
+		t.Errorf("bus shutdown closed the connection with %v, want close %d", err, ws.CloseGoingAway)
+	}
+}
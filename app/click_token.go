@@ -0,0 +1,94 @@
+package app
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tyagnii/ecom_test/dto"
+)
+
+// ErrInvalidClickToken is returned when a click token is malformed or its signature does not match
+var ErrInvalidClickToken = errors.New("invalid click token")
+
+// ErrExpiredClickToken is returned when a click token is older than the attribution window
+var ErrExpiredClickToken = errors.New("expired click token")
+
+// clickTokenSignatureSize is the number of HMAC bytes kept in a token
+const clickTokenSignatureSize = 16
+
+// DefaultAttributionWindow is how long a click token can be converted
+const DefaultAttributionWindow = 30 * 24 * time.Hour
+
+// ClickTokenSigner issues and verifies signed click IDs used for conversion attribution
+type ClickTokenSigner struct {
+	secret []byte
+	maxAge time.Duration
+}
+
+// NewClickTokenSigner creates a new click token signer whose tokens are
+// accepted for maxAge after they were issued
+func NewClickTokenSigner(secret []byte, maxAge time.Duration) *ClickTokenSigner {
+	if maxAge <= 0 {
+		maxAge = DefaultAttributionWindow
+	}
+	return &ClickTokenSigner{secret: secret, maxAge: maxAge}
+}
+
+// Sign returns a token identifying the given click. The payload carries the
+// click ID and the time the token was issued, both covered by the signature.
+func (s *ClickTokenSigner) Sign(click *dto.Click) string {
+	payload := strconv.Itoa(click.ID) + ":" + strconv.FormatInt(time.Now().Unix(), 10)
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(payload))
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(s.signature(encoded))
+}
+
+// Verify checks the token signature and age and returns the click ID it
+// carries. Tokens without an issue time predate expiry and are rejected.
+func (s *ClickTokenSigner) Verify(token string) (int, error) {
+	encoded, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return 0, ErrInvalidClickToken
+	}
+
+	gotSig, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(gotSig, s.signature(encoded)) {
+		return 0, ErrInvalidClickToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return 0, ErrInvalidClickToken
+	}
+
+	idPart, issuedPart, ok := strings.Cut(string(payload), ":")
+	if !ok {
+		return 0, ErrExpiredClickToken
+	}
+
+	clickID, err := strconv.Atoi(idPart)
+	if err != nil || clickID <= 0 {
+		return 0, ErrInvalidClickToken
+	}
+
+	issued, err := strconv.ParseInt(issuedPart, 10, 64)
+	if err != nil {
+		return 0, ErrInvalidClickToken
+	}
+	if time.Since(time.Unix(issued, 0)) > s.maxAge {
+		return 0, ErrExpiredClickToken
+	}
+
+	return clickID, nil
+}
+
+// signature computes the truncated HMAC of the encoded payload
+func (s *ClickTokenSigner) signature(encoded string) []byte {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(encoded))
+	return mac.Sum(nil)[:clickTokenSignatureSize]
+}
@@ -0,0 +1,231 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/tyagnii/ecom_test/db"
+	"github.com/tyagnii/ecom_test/dto"
+	"github.com/tyagnii/ecom_test/logger"
+	"github.com/tyagnii/ecom_test/wal"
+)
+
+// ClickWAL keeps clicks in a local write-ahead log while the database is
+// unavailable and replays them once it is reachable again
+type ClickWAL struct {
+	log      *wal.Log
+	repo     *db.Repository
+	logger   logger.Logger
+	onReplay func(clicks []*dto.Click)
+
+	mu       sync.Mutex
+	stats    ClickWALStats
+	ticker   *time.Ticker
+	stopChan chan struct{}
+}
+
+// ClickWALStats provides click WAL metrics
+type ClickWALStats struct {
+	Appended int64 `json:"appended"`
+	Replayed int64 `json:"replayed"`
+	Dropped  int64 `json:"dropped"`
+	Rejected int64 `json:"rejected"`
+	Corrupt  int64 `json:"corrupt"`
+}
+
+// NewClickWAL creates a click WAL on top of an open log
+func NewClickWAL(log *wal.Log, repo *db.Repository, logger logger.Logger) *ClickWAL {
+	w := &ClickWAL{
+		log:    log,
+		repo:   repo,
+		logger: logger,
+	}
+	log.OnCorrupt(w.reportCorrupt)
+	return w
+}
+
+// OnReplay sets a callback invoked with every batch of clicks written to the database
+func (w *ClickWAL) OnReplay(fn func(clicks []*dto.Click)) {
+	w.onReplay = fn
+}
+
+// Append stores a click in the log
+func (w *ClickWAL) Append(click *dto.Click) error {
+	data, err := json.Marshal(click)
+	if err != nil {
+		return fmt.Errorf("failed to encode click: %w", err)
+	}
+
+	if err := w.log.Append(data); err != nil {
+		w.mu.Lock()
+		w.stats.Rejected++
+		w.mu.Unlock()
+		return err
+	}
+
+	w.mu.Lock()
+	w.stats.Appended++
+	w.mu.Unlock()
+	return nil
+}
+
+// Replay drains the log into the database. Clicks for banners that no longer
+// exist are dropped. It returns the number of clicks inserted. Replay is
+// at-least-once: clicks carry no idempotency key, so a batch inserted right
+// before a crash is inserted again when the log is replayed after restart.
+func (w *ClickWAL) Replay() (int, error) {
+	inserted := 0
+	_, err := w.log.Replay(wal.DefaultReplayBatchSize, func(records [][]byte) error {
+		clicks := make([]*dto.Click, 0, len(records))
+		for _, record := range records {
+			click := &dto.Click{}
+			if err := json.Unmarshal(record, click); err != nil {
+				w.logger.Warn("Dropping undecodable WAL record",
+					logger.NewField("error", err.Error()))
+				w.countDropped(1)
+				continue
+			}
+			click.ID = 0
+			clicks = append(clicks, click)
+		}
+
+		clicks, err := w.dropUnknownBanners(clicks)
+		if err != nil {
+			return err
+		}
+
+		if err := w.repo.CreateClicks(clicks); err != nil {
+			return err
+		}
+
+		inserted += len(clicks)
+		w.mu.Lock()
+		w.stats.Replayed += int64(len(clicks))
+		w.mu.Unlock()
+
+		if w.onReplay != nil && len(clicks) > 0 {
+			w.onReplay(clicks)
+		}
+		return nil
+	})
+
+	return inserted, err
+}
+
+// dropUnknownBanners removes clicks whose banner does not exist, since banner
+// existence is not checked while the database is down
+func (w *ClickWAL) dropUnknownBanners(clicks []*dto.Click) ([]*dto.Click, error) {
+	var bannerIDs []int
+	seen := make(map[int]bool)
+	for _, click := range clicks {
+		if !seen[click.BannerID] {
+			seen[click.BannerID] = true
+			bannerIDs = append(bannerIDs, click.BannerID)
+		}
+	}
+
+	existing, err := w.repo.GetExistingBannerIDs(bannerIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	kept := clicks[:0]
+	for _, click := range clicks {
+		if existing[click.BannerID] {
+			kept = append(kept, click)
+		}
+	}
+
+	if dropped := len(clicks) - len(kept); dropped > 0 {
+		w.logger.Warn("Dropping WAL clicks for unknown banners",
+			logger.NewField("dropped", dropped))
+		w.countDropped(dropped)
+	}
+
+	return kept, nil
+}
+
+// Start launches the background replayer. It checks database health every
+// interval and drains the log while the database is reachable.
+func (w *ClickWAL) Start(interval time.Duration) {
+	w.ticker = time.NewTicker(interval)
+	w.stopChan = make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-w.ticker.C:
+				w.replayIfHealthy()
+			case <-w.stopChan:
+				return
+			}
+		}
+	}()
+}
+
+// replayIfHealthy replays pending clicks when the database answers a ping
+func (w *ClickWAL) replayIfHealthy() {
+	if w.log.Size() == 0 {
+		return
+	}
+
+	if err := w.repo.Ping(); err != nil {
+		return
+	}
+
+	inserted, err := w.Replay()
+	if err != nil {
+		w.logger.Error("Failed to replay click WAL",
+			logger.NewField("replayed", inserted),
+			logger.NewField("error", err.Error()))
+		return
+	}
+
+	if inserted > 0 {
+		w.logger.Info("Replayed clicks from WAL",
+			logger.NewField("replayed", inserted))
+	}
+}
+
+// Stop stops the background replayer and closes the log
+func (w *ClickWAL) Stop() error {
+	if w.ticker != nil {
+		w.ticker.Stop()
+		close(w.stopChan)
+	}
+	return w.log.Close()
+}
+
+// Stats returns click WAL statistics
+func (w *ClickWAL) Stats() ClickWALStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.stats
+}
+
+// Size returns the size of pending clicks in bytes
+func (w *ClickWAL) Size() int64 {
+	return w.log.Size()
+}
+
+// reportCorrupt logs and counts a segment tail that could not be replayed
+func (w *ClickWAL) reportCorrupt(tail wal.CorruptTail) {
+	w.logger.Error("Skipping corrupt WAL segment tail",
+		logger.NewField("segment", tail.Segment),
+		logger.NewField("offset", tail.Offset),
+		logger.NewField("skipped_bytes", tail.Skipped),
+		logger.NewField("error", tail.Err.Error()))
+
+	w.mu.Lock()
+	w.stats.Corrupt++
+	w.mu.Unlock()
+}
+
+// countDropped records clicks that were discarded during replay
+func (w *ClickWAL) countDropped(n int) {
+	w.mu.Lock()
+	w.stats.Dropped += int64(n)
+	w.mu.Unlock()
+}
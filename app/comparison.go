@@ -0,0 +1,130 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/tyagnii/ecom_test/db"
+	"github.com/tyagnii/ecom_test/logger"
+)
+
+// Comparison periods
+const (
+	// ComparePreviousPeriod compares to the equally long period right before
+	ComparePreviousPeriod = "previous_period"
+	// ComparePreviousWeek compares to the same period one week earlier
+	ComparePreviousWeek = "previous_week"
+	// ComparePreviousMonth compares to the same period one calendar month earlier
+	ComparePreviousMonth = "previous_month"
+	// ComparePreviousYear compares to the same period one calendar year earlier
+	ComparePreviousYear = "previous_year"
+)
+
+// ErrIncomparableRange is wrapped by errors for ranges that cannot be compared
+var ErrIncomparableRange = errors.New("ranges cannot be compared")
+
+// ComparisonRange returns the range [start, end) is compared to. Calendar
+// shifts are applied in loc, so a week earlier is the same local wall time
+// across a DST transition. Days past the end of the target month are clamped
+// to its last day, so March 31 a month earlier is the last day of February.
+func ComparisonRange(start, end time.Time, period string, loc *time.Location) (time.Time, time.Time, error) {
+	shift := func(years, months, days int) (time.Time, time.Time, error) {
+		compareStart := shiftDate(start, years, months, days, loc)
+		compareEnd := shiftDate(end, years, months, days, loc)
+		if start.Before(end) && !compareStart.Before(compareEnd) {
+			return time.Time{}, time.Time{}, fmt.Errorf("%w: the %s of %s to %s is empty",
+				ErrIncomparableRange, period, start.In(loc).Format(time.RFC3339), end.In(loc).Format(time.RFC3339))
+		}
+		return compareStart, compareEnd, nil
+	}
+
+	switch period {
+	case ComparePreviousPeriod:
+		length := end.Sub(start)
+		return start.Add(-length), start, nil
+	case ComparePreviousWeek:
+		return shift(0, 0, -7)
+	case ComparePreviousMonth:
+		return shift(0, -1, 0)
+	case ComparePreviousYear:
+		return shift(-1, 0, 0)
+	default:
+		return time.Time{}, time.Time{}, fmt.Errorf("unknown comparison period %q: expected %s, %s, %s or %s",
+			period, ComparePreviousPeriod, ComparePreviousWeek, ComparePreviousMonth, ComparePreviousYear)
+	}
+}
+
+// shiftDate moves t by the given years, months and days in loc like AddDate,
+// but clamps the day to the last day of the target month instead of
+// overflowing into the following month
+func shiftDate(t time.Time, years, months, days int, loc *time.Location) time.Time {
+	t = t.In(loc)
+	year, month, day := t.Date()
+	first := time.Date(year+years, month+time.Month(months), 1, 0, 0, 0, 0, loc)
+	if last := first.AddDate(0, 1, -1).Day(); day > last {
+		day = last
+	}
+	hour, min, sec := t.Clock()
+	return time.Date(first.Year(), first.Month(), day+days, hour, min, sec, t.Nanosecond(), loc)
+}
+
+// SeriesDelta is the change of a series relative to the series it is compared to
+type SeriesDelta struct {
+	Clicks         int `json:"clicks"`
+	NetClicks      int `json:"net_clicks"`
+	FilteredClicks int `json:"filtered_clicks"`
+	// Percentages are omitted when the compared series has no clicks
+	ClicksPercent    *float64 `json:"clicks_percent,omitempty"`
+	NetClicksPercent *float64 `json:"net_clicks_percent,omitempty"`
+}
+
+// NewSeriesDelta returns the change from previous to current
+func NewSeriesDelta(current, previous *db.ClickSeries) *SeriesDelta {
+	return &SeriesDelta{
+		Clicks:           current.Clicks - previous.Clicks,
+		NetClicks:        current.NetClicks - previous.NetClicks,
+		FilteredClicks:   current.FilteredClicks - previous.FilteredClicks,
+		ClicksPercent:    percentChange(current.Clicks, previous.Clicks),
+		NetClicksPercent: percentChange(current.NetClicks, previous.NetClicks),
+	}
+}
+
+// percentChange returns the change from previous to current in percent, or nil if previous is zero
+func percentChange(current, previous int) *float64 {
+	if previous == 0 {
+		return nil
+	}
+	change := float64(current-previous) / float64(previous) * 100
+	return &change
+}
+
+// CompareClickSeries returns the clicks of a banner in [start, end) and in
+// [compareStart, compareEnd), bucketed like GetClickSeries and read in one
+// round trip, together with the change between them
+func (s *ClickService) CompareClickSeries(bannerID int, start, end, compareStart, compareEnd time.Time, granularity db.RollupLevel, loc *time.Location) (*db.ClickSeries, *db.ClickSeries, *SeriesDelta, error) {
+	if bannerID <= 0 {
+		return nil, nil, nil, fmt.Errorf("invalid banner ID: %d", bannerID)
+	}
+
+	if start.After(end) || compareStart.After(compareEnd) {
+		return nil, nil, nil, fmt.Errorf("%w: start date cannot be after end date", ErrIncomparableRange)
+	}
+
+	ranges := []db.ClickRange{
+		{From: start, To: end, Source: db.ChooseRollupLevel(start, end, granularity, loc)},
+		{From: compareStart, To: compareEnd, Source: db.ChooseRollupLevel(compareStart, compareEnd, granularity, loc)},
+	}
+	s.logger.Debug("Reading compared click series",
+		logger.NewField("banner_id", bannerID),
+		logger.NewField("source", ranges[0].Source),
+		logger.NewField("compare_source", ranges[1].Source),
+		logger.NewField("granularity", granularity))
+
+	series, err := s.repo.GetClickSeriesSet(bannerID, ranges, granularity, loc)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return series[0], series[1], NewSeriesDelta(series[0], series[1]), nil
+}
@@ -0,0 +1,107 @@
+package app
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestComparisonRange(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatalf("failed to load time zone: %v", err)
+	}
+	day := func(year int, month time.Month, d int) time.Time {
+		return time.Date(year, month, d, 0, 0, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name      string
+		start     time.Time
+		end       time.Time
+		period    string
+		loc       *time.Location
+		wantStart time.Time
+		wantEnd   time.Time
+		wantErr   error
+	}{
+		{
+			name:      "previous period",
+			start:     day(2025, 3, 10),
+			end:       day(2025, 3, 17),
+			period:    ComparePreviousPeriod,
+			loc:       time.UTC,
+			wantStart: day(2025, 3, 3),
+			wantEnd:   day(2025, 3, 10),
+		},
+		{
+			name:      "previous month",
+			start:     day(2025, 3, 1),
+			end:       day(2025, 4, 1),
+			period:    ComparePreviousMonth,
+			loc:       time.UTC,
+			wantStart: day(2025, 2, 1),
+			wantEnd:   day(2025, 3, 1),
+		},
+		{
+			name:      "previous month clamps the day",
+			start:     day(2025, 3, 31),
+			end:       day(2025, 4, 1),
+			period:    ComparePreviousMonth,
+			loc:       time.UTC,
+			wantStart: day(2025, 2, 28),
+			wantEnd:   day(2025, 3, 1),
+		},
+		{
+			name:      "previous year from a leap day",
+			start:     day(2024, 2, 29),
+			end:       day(2024, 3, 1),
+			period:    ComparePreviousYear,
+			loc:       time.UTC,
+			wantStart: day(2023, 2, 28),
+			wantEnd:   day(2023, 3, 1),
+		},
+		{
+			name:      "previous week across DST keeps the wall time",
+			start:     time.Date(2025, 4, 2, 0, 0, 0, 0, berlin),
+			end:       time.Date(2025, 4, 3, 0, 0, 0, 0, berlin),
+			period:    ComparePreviousWeek,
+			loc:       berlin,
+			wantStart: time.Date(2025, 3, 26, 0, 0, 0, 0, berlin),
+			wantEnd:   time.Date(2025, 3, 27, 0, 0, 0, 0, berlin),
+		},
+		{
+			name:    "previous month collapses to nothing",
+			start:   day(2025, 3, 30),
+			end:     day(2025, 3, 31),
+			period:  ComparePreviousMonth,
+			loc:     time.UTC,
+			wantErr: ErrIncomparableRange,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end, err := ComparisonRange(tt.start, tt.end, tt.period, tt.loc)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("ComparisonRange error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ComparisonRange failed: %v", err)
+			}
+			if !start.Equal(tt.wantStart) || !end.Equal(tt.wantEnd) {
+				t.Errorf("ComparisonRange = [%s, %s), want [%s, %s)", start, end, tt.wantStart, tt.wantEnd)
+			}
+		})
+	}
+}
+
+func TestComparisonRangeUnknownPeriod(t *testing.T) {
+	start := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	if _, _, err := ComparisonRange(start, start.AddDate(0, 0, 1), "previous_decade", time.UTC); err == nil {
+		t.Fatal("ComparisonRange accepted an unknown period")
+	}
+}
@@ -0,0 +1,73 @@
+package app
+
+import (
+	"time"
+
+	"github.com/tyagnii/ecom_test/db"
+	"github.com/tyagnii/ecom_test/logger"
+)
+
+// PartitionManager keeps monthly click partitions created ahead of time so that
+// new clicks do not land in the default partition
+type PartitionManager struct {
+	repo        *db.Repository
+	logger      logger.Logger
+	monthsAhead int
+
+	ticker   *time.Ticker
+	stopChan chan struct{}
+}
+
+// NewPartitionManager creates a partition manager that keeps monthsAhead
+// partitions after the current month
+func NewPartitionManager(repo *db.Repository, monthsAhead int, logger logger.Logger) *PartitionManager {
+	return &PartitionManager{
+		repo:        repo,
+		logger:      logger,
+		monthsAhead: monthsAhead,
+	}
+}
+
+// Run creates missing partitions from the current month onwards and returns their names
+func (m *PartitionManager) Run() ([]string, error) {
+	created, err := m.repo.EnsureClickPartitions(time.Now(), m.monthsAhead)
+	for _, name := range created {
+		m.logger.Info("Created click partition",
+			logger.NewField("partition", name))
+	}
+	return created, err
+}
+
+// Start runs the manager immediately and then every interval
+func (m *PartitionManager) Start(interval time.Duration) {
+	m.ticker = time.NewTicker(interval)
+	m.stopChan = make(chan struct{})
+
+	go func() {
+		m.runLogged()
+		for {
+			select {
+			case <-m.ticker.C:
+				m.runLogged()
+			case <-m.stopChan:
+				return
+			}
+		}
+	}()
+}
+
+// runLogged runs the manager and logs failures
+func (m *PartitionManager) runLogged() {
+	if _, err := m.Run(); err != nil {
+		m.logger.Error("Failed to create click partitions",
+			logger.NewField("error", err.Error()))
+	}
+}
+
+// Stop stops the background schedule
+func (m *PartitionManager) Stop() {
+	if m.ticker != nil {
+		m.ticker.Stop()
+		close(m.stopChan)
+	}
+}
@@ -0,0 +1,169 @@
+package app
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/tyagnii/ecom_test/db"
+	"github.com/tyagnii/ecom_test/logger"
+)
+
+// RetentionPolicy defines how long each kind of data is kept. A zero
+// duration keeps the data forever.
+type RetentionPolicy struct {
+	// Raw is the retention of individual clicks
+	Raw time.Duration
+	// Minute, Hour and Day are the retentions of the click rollups. Hourly
+	// visitor sketches are kept as long as hour rollups.
+	Minute time.Duration
+	Hour   time.Duration
+	Day    time.Duration
+	// BatchSize bounds the rows deleted per statement
+	BatchSize int
+}
+
+// Enabled reports whether the policy purges anything
+func (p RetentionPolicy) Enabled() bool {
+	return p.Raw > 0 || p.Minute > 0 || p.Hour > 0 || p.Day > 0
+}
+
+// Validate checks that coarser rollups are kept at least as long as finer ones
+func (p RetentionPolicy) Validate() error {
+	tiers := []struct {
+		level     db.RollupLevel
+		retention time.Duration
+	}{
+		{db.RollupMinute, p.Minute},
+		{db.RollupHour, p.Hour},
+		{db.RollupDay, p.Day},
+	}
+
+	for i := 1; i < len(tiers); i++ {
+		finer, coarser := tiers[i-1], tiers[i]
+		if coarser.retention > 0 && (finer.retention == 0 || finer.retention > coarser.retention) {
+			return fmt.Errorf("%s rollups must be kept at least as long as %s rollups", coarser.level, finer.level)
+		}
+	}
+	return nil
+}
+
+// RetentionJob enforces a retention policy
+type RetentionJob struct {
+	repo   *db.Repository
+	policy RetentionPolicy
+	logger logger.Logger
+
+	ticker   *time.Ticker
+	stopChan chan struct{}
+}
+
+// NewRetentionJob creates a retention job for the given policy
+func NewRetentionJob(repo *db.Repository, policy RetentionPolicy, logger logger.Logger) *RetentionJob {
+	return &RetentionJob{
+		repo:   repo,
+		policy: policy,
+		logger: logger,
+	}
+}
+
+// Run purges expired data. Raw clicks are only purged once they are contained
+// in the rollups, and rollups are purged from the finest to the coarsest level.
+// With dryRun it only reports what would be removed.
+func (j *RetentionJob) Run(dryRun bool) ([]*db.PurgeResult, error) {
+	if err := j.policy.Validate(); err != nil {
+		return nil, err
+	}
+
+	var results []*db.PurgeResult
+	now := time.Now()
+
+	purges := []struct {
+		retention time.Duration
+		purge     func(cutoff time.Time) (*db.PurgeResult, error)
+	}{
+		{j.policy.Raw, func(cutoff time.Time) (*db.PurgeResult, error) {
+			return j.repo.PurgeClicks(cutoff, j.policy.BatchSize, dryRun)
+		}},
+		{j.policy.Minute, j.rollupPurge(db.RollupMinute, dryRun)},
+		{j.policy.Hour, j.rollupPurge(db.RollupHour, dryRun)},
+		{j.policy.Hour, func(cutoff time.Time) (*db.PurgeResult, error) {
+			return j.repo.PurgeVisitorSketches(cutoff, j.policy.BatchSize, dryRun)
+		}},
+		{j.policy.Day, j.rollupPurge(db.RollupDay, dryRun)},
+	}
+
+	for _, p := range purges {
+		if p.retention <= 0 {
+			continue
+		}
+
+		result, err := p.purge(now.Add(-p.retention))
+		if result != nil {
+			j.logResult(result)
+			results = append(results, result)
+		}
+		if err != nil {
+			return results, err
+		}
+	}
+
+	return results, nil
+}
+
+// rollupPurge returns a purge function for a rollup level
+func (j *RetentionJob) rollupPurge(level db.RollupLevel, dryRun bool) func(cutoff time.Time) (*db.PurgeResult, error) {
+	return func(cutoff time.Time) (*db.PurgeResult, error) {
+		return j.repo.PurgeRollups(level, cutoff, j.policy.BatchSize, dryRun)
+	}
+}
+
+// logResult logs what a purge removed
+func (j *RetentionJob) logResult(result *db.PurgeResult) {
+	message := "Purged expired data"
+	if result.DryRun {
+		message = "Expired data found (dry run)"
+	}
+
+	if result.Skipped != "" {
+		j.logger.Warn("Retention purge skipped",
+			logger.NewField("table", result.Table),
+			logger.NewField("cutoff", result.Cutoff),
+			logger.NewField("reason", result.Skipped))
+		return
+	}
+
+	j.logger.Info(message,
+		logger.NewField("table", result.Table),
+		logger.NewField("cutoff", result.Cutoff),
+		logger.NewField("dropped_partitions", result.DroppedPartitions),
+		logger.NewField("dropped_rows_estimate", result.DroppedRows),
+		logger.NewField("deleted_rows", result.DeletedRows))
+}
+
+// Start runs the job every interval
+func (j *RetentionJob) Start(interval time.Duration) {
+	j.ticker = time.NewTicker(interval)
+	j.stopChan = make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-j.ticker.C:
+				if _, err := j.Run(false); err != nil {
+					j.logger.Error("Retention run failed",
+						logger.NewField("error", err.Error()))
+				}
+			case <-j.stopChan:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the background schedule
+func (j *RetentionJob) Stop() {
+	if j.ticker != nil {
+		j.ticker.Stop()
+		close(j.stopChan)
+	}
+}
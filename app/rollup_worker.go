@@ -0,0 +1,98 @@
+package app
+
+import (
+	"sync"
+	"time"
+
+	"github.com/tyagnii/ecom_test/db"
+	"github.com/tyagnii/ecom_test/logger"
+)
+
+// rollupWindow bounds the ingestion time folded into the rollups per transaction
+const rollupWindow = time.Hour
+
+// RollupWorker folds newly ingested clicks into the minute, hour and day rollups
+type RollupWorker struct {
+	repo   *db.Repository
+	lag    time.Duration
+	logger logger.Logger
+
+	mu        sync.Mutex
+	watermark time.Time
+
+	ticker   *time.Ticker
+	stopChan chan struct{}
+}
+
+// NewRollupWorker creates a rollup worker. Clicks are rolled up once they were
+// ingested at least lag ago by the database clock and every transaction that
+// started before them has finished.
+func NewRollupWorker(repo *db.Repository, lag time.Duration, logger logger.Logger) *RollupWorker {
+	return &RollupWorker{
+		repo:   repo,
+		lag:    lag,
+		logger: logger,
+	}
+}
+
+// Run rolls up clicks until the watermark catches up and returns the number of clicks folded in
+func (w *RollupWorker) Run() (int64, error) {
+	var total int64
+	for {
+		rolled, watermark, caughtUp, err := w.repo.RollUpClicks(w.lag, rollupWindow)
+		if err != nil {
+			return total, err
+		}
+		total += rolled
+
+		w.mu.Lock()
+		w.watermark = watermark
+		w.mu.Unlock()
+
+		if caughtUp {
+			return total, nil
+		}
+	}
+}
+
+// Start runs the worker every interval
+func (w *RollupWorker) Start(interval time.Duration) {
+	w.ticker = time.NewTicker(interval)
+	w.stopChan = make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-w.ticker.C:
+				rolled, err := w.Run()
+				if err != nil {
+					w.logger.Error("Failed to roll up clicks",
+						logger.NewField("rolled", rolled),
+						logger.NewField("error", err.Error()))
+					continue
+				}
+				if rolled > 0 {
+					w.logger.Debug("Rolled up clicks",
+						logger.NewField("rolled", rolled))
+				}
+			case <-w.stopChan:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the background schedule
+func (w *RollupWorker) Stop() {
+	if w.ticker != nil {
+		w.ticker.Stop()
+		close(w.stopChan)
+	}
+}
+
+// Watermark returns the last watermark reached by this worker
+func (w *RollupWorker) Watermark() time.Time {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.watermark
+}
@@ -1,18 +1,35 @@
 package app
 
 import (
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/tyagnii/ecom_test/db"
 	"github.com/tyagnii/ecom_test/dto"
+	"github.com/tyagnii/ecom_test/events"
+	"github.com/tyagnii/ecom_test/filter"
 	"github.com/tyagnii/ecom_test/logger"
 )
 
+// BannerStore writes banners. The cached repository implements it, keeping
+// the cache, including banners cached as not existing, consistent with the
+// writes.
+type BannerStore interface {
+	CreateBanner(banner *dto.Banner) error
+	UpdateBanner(banner *dto.Banner) error
+	DeleteBanner(id int) error
+}
+
 // Service provides business logic layer
 type Service struct {
-	repo   *db.Repository
-	logger logger.Logger
+	repo        *db.Repository
+	banners     BannerStore
+	logger      logger.Logger
+	clickFilter *filter.Chain
+	clickWAL    *ClickWAL
+	events      *events.Bus
 }
 
 // NewService creates a new service instance
@@ -31,6 +48,49 @@ func NewServiceWithLogger(repo *db.Repository, logger logger.Logger) *Service {
 	}
 }
 
+// SetBannerStore sets the store banner writes go through instead of the
+// repository
+func (s *Service) SetBannerStore(store BannerStore) {
+	s.banners = store
+}
+
+// bannerStore returns the store banner writes go through
+func (s *Service) bannerStore() BannerStore {
+	if s.banners == nil {
+		return s.repo
+	}
+	return s.banners
+}
+
+// SetClickFilter sets the filter chain used to classify non-human clicks
+func (s *Service) SetClickFilter(chain *filter.Chain) {
+	s.clickFilter = chain
+}
+
+// SetClickWAL sets the write-ahead log used to keep clicks while the database is unavailable
+func (s *Service) SetClickWAL(clickWAL *ClickWAL) {
+	s.clickWAL = clickWAL
+}
+
+// SetEventBus sets the bus accepted clicks are published to
+func (s *Service) SetEventBus(bus *events.Bus) {
+	s.events = bus
+}
+
+// publishClick publishes an accepted click to the event bus, if any
+func (s *Service) publishClick(click *dto.Click, queued bool) {
+	if s.events == nil {
+		return
+	}
+	s.events.Publish(&events.Click{
+		ClickID:        click.ID,
+		BannerID:       click.BannerID,
+		Time:           click.Timestamp,
+		FilteredReason: click.FilteredReason,
+		Queued:         queued,
+	})
+}
+
 // Repo returns the repository (for internal use)
 func (s *Service) Repo() interface{} {
 	return s.repo
@@ -79,7 +139,7 @@ func (s *BannerService) CreateBanner(name string) (*dto.Banner, error) {
 		UpdatedAt: time.Now(),
 	}
 	
-	if err := s.repo.CreateBanner(banner); err != nil {
+	if err := s.bannerStore().CreateBanner(banner); err != nil {
 		s.logger.Error("Failed to create banner in database", 
 			logger.NewField("error", err.Error()))
 		return nil, fmt.Errorf("failed to create banner: %w", err)
@@ -168,7 +228,7 @@ func (s *BannerService) UpdateBanner(id int, name string) (*dto.Banner, error) {
 	existingBanner.Name = name
 	existingBanner.UpdatedAt = time.Now()
 	
-	if err := s.repo.UpdateBanner(existingBanner); err != nil {
+	if err := s.bannerStore().UpdateBanner(existingBanner); err != nil {
 		return nil, fmt.Errorf("failed to update banner: %w", err)
 	}
 	
@@ -188,7 +248,7 @@ func (s *BannerService) DeleteBanner(id int) error {
 	}
 	
 	// Delete banner (clicks will be deleted due to CASCADE)
-	if err := s.repo.DeleteBanner(id); err != nil {
+	if err := s.bannerStore().DeleteBanner(id); err != nil {
 		return fmt.Errorf("failed to delete banner: %w", err)
 	}
 	
@@ -205,8 +265,12 @@ func NewClickService(service *Service) *ClickService {
 	return &ClickService{Service: service}
 }
 
-// RecordClick records a new click for a banner
-func (s *ClickService) RecordClick(bannerID int, timestamp time.Time) (*dto.Click, error) {
+// RecordClick records a new click for a banner. Clicks matched by the click
+// filter are stored with their filtered reason instead of being dropped.
+// Banner existence is checked by the database on insert and reported as
+// db.ErrNotFound. When the database is unreachable and a click WAL is configured, the click is
+// appended to the WAL instead and returned with a zero ID.
+func (s *ClickService) RecordClick(bannerID int, timestamp time.Time, source *dto.ClickSource) (*dto.Click, error) {
 	s.logger.Info("Recording click", 
 		logger.NewField("banner_id", bannerID),
 		logger.NewField("timestamp", timestamp),
@@ -219,15 +283,6 @@ func (s *ClickService) RecordClick(bannerID int, timestamp time.Time) (*dto.Clic
 		return nil, fmt.Errorf("invalid banner ID: %d", bannerID)
 	}
 	
-	// Check if banner exists
-	_, err := s.repo.GetBannerByID(bannerID)
-	if err != nil {
-		s.logger.Error("Banner not found for click", 
-			logger.NewField("banner_id", bannerID),
-			logger.NewField("error", err.Error()))
-		return nil, fmt.Errorf("banner with ID %d not found: %w", bannerID, err)
-	}
-	
 	// Use current time if timestamp is zero
 	if timestamp.IsZero() {
 		timestamp = time.Now()
@@ -242,7 +297,24 @@ func (s *ClickService) RecordClick(bannerID int, timestamp time.Time) (*dto.Clic
 		CreatedAt: time.Now(),
 	}
 	
+	// Classify non-human traffic
+	if reason := s.clickFilter.Evaluate(bannerID, source); reason != "" {
+		click.FilteredReason = reason
+		s.logger.Debug("Click matched filter", 
+			logger.NewField("banner_id", bannerID),
+			logger.NewField("filtered_reason", reason))
+	}
+	
 	if err := s.repo.CreateClick(click); err != nil {
+		if s.deferrable(err) {
+			return s.deferClick(click, err)
+		}
+		// Banner existence is enforced by the foreign key on insert
+		if errors.Is(err, db.ErrNotFound) {
+			s.logger.Error("Banner not found for click", 
+				logger.NewField("banner_id", bannerID))
+			return nil, err
+		}
 		s.logger.Error("Failed to record click in database", 
 			logger.NewField("banner_id", bannerID),
 			logger.NewField("error", err.Error()))
@@ -254,9 +326,146 @@ func (s *ClickService) RecordClick(bannerID int, timestamp time.Time) (*dto.Clic
 		logger.NewField("banner_id", click.BannerID),
 		logger.NewField("timestamp", click.Timestamp))
 	
+	s.publishClick(click, false)
 	return click, nil
 }
 
+// deferrable reports whether a failed write can be deferred to the click WAL
+func (s *ClickService) deferrable(err error) bool {
+	return s.clickWAL != nil && db.IsConnectionError(err)
+}
+
+// deferClick appends a click to the WAL after the database write failed
+func (s *ClickService) deferClick(click *dto.Click, dbErr error) (*dto.Click, error) {
+	if err := s.clickWAL.Append(click); err != nil {
+		s.logger.Error("Failed to append click to WAL", 
+			logger.NewField("banner_id", click.BannerID),
+			logger.NewField("db_error", dbErr.Error()),
+			logger.NewField("error", err.Error()))
+		return nil, fmt.Errorf("failed to record click: %w", dbErr)
+	}
+	
+	s.logger.Warn("Database unavailable, click appended to WAL", 
+		logger.NewField("banner_id", click.BannerID),
+		logger.NewField("db_error", dbErr.Error()))
+	
+	s.publishClick(click, true)
+	return click, nil
+}
+
+// BatchClickItem represents a click submitted through batch ingestion
+type BatchClickItem struct {
+	BannerID  int       `json:"banner_id"`
+	Timestamp time.Time `json:"timestamp"`
+	IP        string    `json:"ip,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+}
+
+// BatchClickResult reports the outcome of a single batch item
+type BatchClickResult struct {
+	Index          int    `json:"index"`
+	Accepted       bool   `json:"accepted"`
+	ClickID        int    `json:"click_id,omitempty"`
+	FilteredReason string `json:"filtered_reason,omitempty"`
+	Error          string `json:"error,omitempty"`
+}
+
+// MaxClickClockSkew is how far in the future a submitted click timestamp may be
+const MaxClickClockSkew = 5 * time.Minute
+
+// MaxBatchClickAge is how far in the past a submitted click timestamp may be
+const MaxBatchClickAge = 24 * time.Hour
+
+// RecordClickBatch validates batch items and writes the valid ones in a single
+// round trip. Items that are nil have already failed decoding and are reported
+// as rejected. It returns one result per item and the clicks that were stored,
+// which are published to the event bus like single clicks.
+func (s *ClickService) RecordClickBatch(items []*BatchClickItem) ([]*BatchClickResult, []*dto.Click, error) {
+	s.logger.Info("Recording click batch", 
+		logger.NewField("batch_size", len(items)),
+		logger.NewField("operation", "record_click_batch"))
+	
+	results := make([]*BatchClickResult, len(items))
+	
+	// Resolve banner existence for the whole batch at once
+	var bannerIDs []int
+	seen := make(map[int]bool)
+	for _, item := range items {
+		if item != nil && item.BannerID > 0 && !seen[item.BannerID] {
+			seen[item.BannerID] = true
+			bannerIDs = append(bannerIDs, item.BannerID)
+		}
+	}
+	
+	existing, err := s.repo.GetExistingBannerIDs(bannerIDs)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to validate banners: %w", err)
+	}
+	
+	now := time.Now()
+	var clicks []*dto.Click
+	var clickIndexes []int
+	for i, item := range items {
+		result := &BatchClickResult{Index: i}
+		results[i] = result
+		
+		switch {
+		case item == nil:
+			result.Error = "malformed item"
+			continue
+		case item.BannerID <= 0:
+			result.Error = fmt.Sprintf("invalid banner ID: %d", item.BannerID)
+			continue
+		case !existing[item.BannerID]:
+			result.Error = fmt.Sprintf("banner with ID %d not found", item.BannerID)
+			continue
+		case item.Timestamp.After(now.Add(MaxClickClockSkew)):
+			result.Error = "timestamp is in the future"
+			continue
+		case !item.Timestamp.IsZero() && item.Timestamp.Before(now.Add(-MaxBatchClickAge)):
+			result.Error = fmt.Sprintf("timestamp is older than %v", MaxBatchClickAge)
+			continue
+		}
+		
+		click := &dto.Click{
+			Timestamp: item.Timestamp,
+			BannerID:  item.BannerID,
+			CreatedAt: now,
+		}
+		if click.Timestamp.IsZero() {
+			click.Timestamp = now
+		}
+		click.FilteredReason = s.clickFilter.Evaluate(item.BannerID, &dto.ClickSource{
+			IP:        item.IP,
+			UserAgent: item.UserAgent,
+		})
+		
+		clicks = append(clicks, click)
+		clickIndexes = append(clickIndexes, i)
+	}
+	
+	if err := s.repo.CreateClicks(clicks); err != nil {
+		s.logger.Error("Failed to record click batch in database", 
+			logger.NewField("batch_size", len(clicks)),
+			logger.NewField("error", err.Error()))
+		return nil, nil, fmt.Errorf("failed to record clicks: %w", err)
+	}
+	
+	for i, click := range clicks {
+		result := results[clickIndexes[i]]
+		result.Accepted = true
+		result.ClickID = click.ID
+		result.FilteredReason = click.FilteredReason
+		s.publishClick(click, false)
+	}
+	
+	s.logger.Info("Click batch recorded", 
+		logger.NewField("accepted", len(clicks)),
+		logger.NewField("rejected", len(items)-len(clicks)))
+	
+	return results, clicks, nil
+}
+
 // GetClick retrieves a click by ID
 func (s *ClickService) GetClick(id int) (*dto.Click, error) {
 	if id <= 0 {
@@ -290,6 +499,52 @@ func (s *ClickService) GetClicksInDateRange(start, end time.Time) ([]*dto.Click,
 	return s.repo.GetClicksByDateRange(start, end)
 }
 
+// GetClickSeries returns the clicks of a banner in [start, end) bucketed by
+// granularity in loc. It reads the coarsest rollup whose buckets line up with the
+// range and the zone, falling back to raw clicks. db.RollupRaw as granularity
+// returns totals only.
+func (s *ClickService) GetClickSeries(bannerID int, start, end time.Time, granularity db.RollupLevel, loc *time.Location) (*db.ClickSeries, error) {
+	if bannerID <= 0 {
+		return nil, fmt.Errorf("invalid banner ID: %d", bannerID)
+	}
+	
+	if start.After(end) {
+		return nil, fmt.Errorf("start date cannot be after end date")
+	}
+	
+	source := db.ChooseRollupLevel(start, end, granularity, loc)
+	s.logger.Debug("Reading click series", 
+		logger.NewField("banner_id", bannerID),
+		logger.NewField("source", source),
+		logger.NewField("granularity", granularity),
+		logger.NewField("tz", loc.String()))
+	
+	return s.repo.GetClickSeries(bannerID, start, end, source, granularity, loc)
+}
+
+// GetClickSeriesForBanners returns the clicks of several banners in [start, end)
+// bucketed by granularity in loc, read in one query from the coarsest rollup
+// that lines up. With perBanner there is one series per banner, otherwise a
+// single combined series.
+func (s *ClickService) GetClickSeriesForBanners(bannerIDs []int, start, end time.Time, granularity db.RollupLevel, loc *time.Location, perBanner bool) ([]*db.BannerClickSeries, error) {
+	if len(bannerIDs) == 0 {
+		return nil, fmt.Errorf("no banners given")
+	}
+	
+	if start.After(end) {
+		return nil, fmt.Errorf("start date cannot be after end date")
+	}
+	
+	source := db.ChooseRollupLevel(start, end, granularity, loc)
+	s.logger.Debug("Reading click series for banners", 
+		logger.NewField("banner_count", len(bannerIDs)),
+		logger.NewField("source", source),
+		logger.NewField("granularity", granularity),
+		logger.NewField("per_banner", perBanner))
+	
+	return s.repo.GetClickSeriesForBanners(bannerIDs, start, end, source, granularity, loc, perBanner)
+}
+
 // GetClicksForBannerInDateRange retrieves clicks for a specific banner within a date range
 func (s *ClickService) GetClicksForBannerInDateRange(bannerID int, start, end time.Time) ([]*dto.Click, error) {
 	if bannerID <= 0 {
@@ -354,6 +609,88 @@ func (s *ClickService) DeleteClick(id int) error {
 	return s.repo.DeleteClick(id)
 }
 
+// ConversionService provides conversion business logic
+type ConversionService struct {
+	*Service
+}
+
+// NewConversionService creates a new conversion service
+func NewConversionService(service *Service) *ConversionService {
+	return &ConversionService{Service: service}
+}
+
+// RecordConversion records a conversion attributed to a click
+func (s *ConversionService) RecordConversion(clickID int, value float64, currency string) (*dto.Conversion, error) {
+	s.logger.Info("Recording conversion",
+		logger.NewField("click_id", clickID),
+		logger.NewField("currency", currency),
+		logger.NewField("operation", "record_conversion"))
+
+	// Validate input
+	if clickID <= 0 {
+		return nil, fmt.Errorf("invalid click ID: %d", clickID)
+	}
+
+	if value < 0 {
+		return nil, fmt.Errorf("conversion value cannot be negative")
+	}
+
+	currency = strings.ToUpper(currency)
+	if len(currency) != 3 {
+		return nil, fmt.Errorf("currency must be a 3-letter ISO 4217 code")
+	}
+
+	// Resolve the attributed click
+	click, err := s.repo.GetClickByID(clickID)
+	if err != nil {
+		s.logger.Error("Click not found for conversion",
+			logger.NewField("click_id", clickID),
+			logger.NewField("error", err.Error()))
+		return nil, err
+	}
+
+	conversion := &dto.Conversion{
+		ClickID:   click.ID,
+		BannerID:  click.BannerID,
+		Value:     value,
+		Currency:  currency,
+		Timestamp: time.Now(),
+		CreatedAt: time.Now(),
+	}
+
+	if err := s.repo.CreateConversion(conversion); err != nil {
+		if errors.Is(err, db.ErrDuplicate) {
+			s.logger.Warn("Conversion already recorded for click",
+				logger.NewField("click_id", clickID))
+			return nil, err
+		}
+		s.logger.Error("Failed to record conversion in database",
+			logger.NewField("click_id", clickID),
+			logger.NewField("error", err.Error()))
+		return nil, fmt.Errorf("failed to record conversion: %w", err)
+	}
+
+	s.logger.Info("Conversion recorded successfully",
+		logger.NewField("conversion_id", conversion.ID),
+		logger.NewField("click_id", conversion.ClickID),
+		logger.NewField("banner_id", conversion.BannerID))
+
+	return conversion, nil
+}
+
+// GetConversionStats retrieves conversion statistics for a banner within a date range
+func (s *ConversionService) GetConversionStats(bannerID int, start, end time.Time) (*db.ConversionStats, error) {
+	if bannerID <= 0 {
+		return nil, fmt.Errorf("invalid banner ID: %d", bannerID)
+	}
+
+	if start.After(end) {
+		return nil, fmt.Errorf("start date cannot be after end date")
+	}
+
+	return s.repo.GetConversionStats(bannerID, start, end)
+}
+
 // AnalyticsService provides analytics functionality
 type AnalyticsService struct {
 	*Service
@@ -364,53 +701,25 @@ func NewAnalyticsService(service *Service) *AnalyticsService {
 	return &AnalyticsService{Service: service}
 }
 
-// GetBannerPerformance retrieves performance metrics for all banners
-func (s *AnalyticsService) GetBannerPerformance() ([]*BannerPerformance, error) {
+// GetBannerPerformance retrieves one page of banner performance metrics,
+// computed for all banners in a single grouped query
+func (s *AnalyticsService) GetBannerPerformance(query *db.AnalyticsQuery) (*db.BannerPerformancePage, error) {
 	s.logger.Info("Retrieving banner performance metrics", 
-		logger.NewField("operation", "get_banner_performance"))
+		logger.NewField("operation", "get_banner_performance"),
+		logger.NewField("sort", query.Sort),
+		logger.NewField("limit", query.Limit),
+		logger.NewField("offset", query.Offset))
 	
-	// Get all banners
-	banners, err := s.repo.GetAllBanners()
+	page, err := s.repo.GetBannerPerformance(query)
 	if err != nil {
-		s.logger.Error("Failed to get banners for performance", 
+		s.logger.Error("Failed to get banner performance", 
 			logger.NewField("error", err.Error()))
-		return nil, fmt.Errorf("failed to get banners: %w", err)
-	}
-	
-	s.logger.Debug("Retrieved banners for performance analysis", 
-		logger.NewField("banner_count", len(banners)))
-	
-	var performances []*BannerPerformance
-	for _, banner := range banners {
-		stats, err := s.repo.GetClickStats(banner.ID)
-		if err != nil {
-			s.logger.Warn("Failed to get stats for banner", 
-				logger.NewField("banner_id", banner.ID),
-				logger.NewField("error", err.Error()))
-			// Continue with other banners instead of failing completely
-			continue
-		}
-		
-		performance := &BannerPerformance{
-			Banner:      banner,
-			TotalClicks: stats.TotalClicks,
-			FirstClick:  stats.FirstClick,
-			LastClick:   stats.LastClick,
-		}
-		
-		performances = append(performances, performance)
+		return nil, fmt.Errorf("failed to get banner performance: %w", err)
 	}
 	
 	s.logger.Info("Banner performance metrics retrieved", 
-		logger.NewField("performance_count", len(performances)))
+		logger.NewField("performance_count", len(page.Items)),
+		logger.NewField("total", page.Total))
 	
-	return performances, nil
-}
-
-// BannerPerformance represents banner performance metrics
-type BannerPerformance struct {
-	Banner      *dto.Banner `json:"banner"`
-	TotalClicks int         `json:"total_clicks"`
-	FirstClick  time.Time   `json:"first_click"`
-	LastClick   time.Time   `json:"last_click"`
+	return page, nil
 }
\ No newline at end of file
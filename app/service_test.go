@@ -0,0 +1,251 @@
+package app
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tyagnii/ecom_test/cache"
+	"github.com/tyagnii/ecom_test/db"
+	"github.com/tyagnii/ecom_test/dto"
+	"github.com/tyagnii/ecom_test/events"
+	"github.com/tyagnii/ecom_test/logger"
+)
+
+// bannerDriver is a database driver holding a banners table in memory. It
+// answers the banner queries of db.Repository that banner creation runs and
+// assigns IDs to clicks inserted in batches.
+type bannerDriver struct {
+	mu      sync.Mutex
+	banners []dto.Banner
+	clicks  int
+}
+
+func (d *bannerDriver) Open(string) (driver.Conn, error) {
+	return &bannerConn{d: d}, nil
+}
+
+type bannerConn struct {
+	d *bannerDriver
+}
+
+func (c *bannerConn) Prepare(query string) (driver.Stmt, error) {
+	return &bannerStmt{d: c.d, query: strings.Join(strings.Fields(query), " ")}, nil
+}
+
+func (c *bannerConn) Close() error {
+	return nil
+}
+
+func (c *bannerConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions not supported")
+}
+
+type bannerStmt struct {
+	d     *bannerDriver
+	query string
+}
+
+func (s *bannerStmt) Close() error {
+	return nil
+}
+
+func (s *bannerStmt) NumInput() int {
+	return -1
+}
+
+func (s *bannerStmt) Exec([]driver.Value) (driver.Result, error) {
+	return nil, errors.New("unsupported statement: " + s.query)
+}
+
+func (s *bannerStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+
+	rows := &bannerRows{}
+	switch {
+	case strings.HasPrefix(s.query, "INSERT INTO banners"):
+		banner := dto.Banner{
+			ID:        len(s.d.banners) + 1,
+			Name:      args[0].(string),
+			CreatedAt: args[1].(time.Time),
+			UpdatedAt: args[2].(time.Time),
+		}
+		s.d.banners = append(s.d.banners, banner)
+		rows.values = [][]driver.Value{{int64(banner.ID)}}
+	case strings.HasSuffix(s.query, "WHERE id = $1"):
+		for _, banner := range s.d.banners {
+			if int64(banner.ID) == args[0].(int64) {
+				rows.values = append(rows.values, []driver.Value{int64(banner.ID), banner.Name, banner.CreatedAt, banner.UpdatedAt})
+			}
+		}
+	case strings.HasSuffix(s.query, "WHERE name = $1"):
+		for _, banner := range s.d.banners {
+			if banner.Name == args[0].(string) {
+				rows.values = append(rows.values, []driver.Value{int64(banner.ID), banner.Name, banner.CreatedAt, banner.UpdatedAt})
+			}
+		}
+	case strings.HasSuffix(s.query, "WHERE id = ANY($1)"):
+		ids := arrayElements(args[0])
+		for _, banner := range s.d.banners {
+			for _, id := range ids {
+				if id == strconv.Itoa(banner.ID) {
+					rows.values = append(rows.values, []driver.Value{int64(banner.ID)})
+				}
+			}
+		}
+	case strings.HasPrefix(s.query, "WITH input AS"):
+		rows.columns = []string{"ord", "id"}
+		for i := range arrayElements(args[1]) {
+			s.d.clicks++
+			rows.values = append(rows.values, []driver.Value{int64(i + 1), int64(s.d.clicks)})
+		}
+	default:
+		return nil, errors.New("unsupported query: " + s.query)
+	}
+	return rows, nil
+}
+
+type bannerRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *bannerRows) Columns() []string {
+	if r.columns != nil {
+		return r.columns
+	}
+	if len(r.values) > 0 && len(r.values[0]) == 1 {
+		return []string{"id"}
+	}
+	return []string{"id", "name", "created_at", "updated_at"}
+}
+
+func (r *bannerRows) Close() error {
+	return nil
+}
+
+func (r *bannerRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+// arrayElements splits a PostgreSQL array literal of numbers
+func arrayElements(value driver.Value) []string {
+	var literal string
+	switch v := value.(type) {
+	case string:
+		literal = v
+	case []byte:
+		literal = string(v)
+	}
+	literal = strings.Trim(literal, "{}")
+	if literal == "" {
+		return nil
+	}
+	return strings.Split(literal, ",")
+}
+
+func init() {
+	sql.Register("banners", &bannerDriver{})
+}
+
+func TestCreateBannerClearsMissingMarker(t *testing.T) {
+	database, err := sql.Open("banners", "")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer database.Close()
+
+	repo := db.NewRepository(database)
+	bannerCache := cache.NewInMemoryCache(cache.DefaultCleanupInterval)
+	defer bannerCache.Stop()
+	cachedRepo := cache.NewCachedRepository(repo, bannerCache)
+	defer cachedRepo.Stop()
+
+	service := NewServiceWithLogger(repo, logger.NewStructuredLogger(logger.WARN, io.Discard))
+	service.SetBannerStore(cachedRepo)
+
+	// A lookup of the next ID caches it as not existing
+	if _, err := cachedRepo.GetBannerByID(1); !errors.Is(err, db.ErrNotFound) {
+		t.Fatalf("GetBannerByID before creation: %v, want not found", err)
+	}
+	if !bannerCache.IsBannerMissing(1) {
+		t.Fatal("banner not cached as missing")
+	}
+
+	banner, err := NewBannerService(service).CreateBanner("spring sale")
+	if err != nil {
+		t.Fatalf("CreateBanner: %v", err)
+	}
+	if banner.ID != 1 {
+		t.Fatalf("created banner %d, want 1", banner.ID)
+	}
+
+	if bannerCache.IsBannerMissing(1) {
+		t.Error("banner still cached as missing after it was created")
+	}
+	got, err := cachedRepo.GetBannerByID(1)
+	if err != nil || got.Name != "spring sale" {
+		t.Errorf("GetBannerByID after creation = %+v, %v", got, err)
+	}
+}
+
+func TestRecordClickBatchPublishesClicks(t *testing.T) {
+	sql.Register("batch_clicks", &bannerDriver{banners: []dto.Banner{{ID: 1}, {ID: 2}}})
+	database, err := sql.Open("batch_clicks", "")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer database.Close()
+
+	service := NewServiceWithLogger(db.NewRepository(database), logger.NewStructuredLogger(logger.WARN, io.Discard))
+	bus := events.NewBus(16, 1)
+	defer bus.Close()
+	service.SetEventBus(bus)
+	sub, err := bus.Subscribe()
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	clickTime := time.Now().Add(-time.Minute).Truncate(time.Second)
+	items := []*BatchClickItem{
+		{BannerID: 1, Timestamp: clickTime},
+		{BannerID: 3, Timestamp: clickTime},
+		nil,
+		{BannerID: 2, Timestamp: clickTime},
+	}
+	_, clicks, err := NewClickService(service).RecordClickBatch(items)
+	if err != nil {
+		t.Fatalf("RecordClickBatch: %v", err)
+	}
+	if len(clicks) != 2 {
+		t.Fatalf("stored %d clicks, want 2", len(clicks))
+	}
+
+	for _, click := range clicks {
+		select {
+		case event := <-sub.Events():
+			if event.ClickID != click.ID || event.BannerID != click.BannerID || !event.Time.Equal(clickTime) || event.Queued {
+				t.Errorf("published %+v for click %+v", event, click)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("click %d not published", click.ID)
+		}
+	}
+	select {
+	case event := <-sub.Events():
+		t.Errorf("published %+v for a rejected item", event)
+	default:
+	}
+}
@@ -0,0 +1,190 @@
+package app
+
+import (
+	"sync"
+	"time"
+
+	"github.com/tyagnii/ecom_test/db"
+	"github.com/tyagnii/ecom_test/hll"
+	"github.com/tyagnii/ecom_test/logger"
+)
+
+// VisitorTrackerStats describes the sketches buffered by a visitor tracker
+type VisitorTrackerStats struct {
+	// Pending is the number of (banner, hour) sketches waiting to be flushed
+	Pending int `json:"pending"`
+	// Flushed is the number of sketches merged into the database
+	Flushed int64 `json:"flushed"`
+	// Dropped is the number of visits lost because the buffer was full
+	Dropped int64 `json:"dropped"`
+	// Failures is the number of failed flushes
+	Failures int64 `json:"failures"`
+}
+
+// VisitorTracker estimates unique visitors per banner and hour. Visits are
+// added to in-memory HyperLogLog sketches, which are periodically merged into
+// the stored sketches; reads merge stored and buffered sketches.
+type VisitorTracker struct {
+	repo       *db.Repository
+	precision  int
+	maxPending int
+	logger     logger.Logger
+
+	// flushMu serializes flushes
+	flushMu sync.Mutex
+
+	mu       sync.Mutex
+	pending  map[db.VisitorSketchKey]*hll.Sketch
+	flushing map[db.VisitorSketchKey]*hll.Sketch
+	stats    VisitorTrackerStats
+
+	ticker   *time.Ticker
+	stopChan chan struct{}
+	done     chan struct{}
+}
+
+// NewVisitorTracker creates a visitor tracker keeping at most maxPending
+// sketches between flushes; zero means unbounded
+func NewVisitorTracker(repo *db.Repository, precision, maxPending int, logger logger.Logger) (*VisitorTracker, error) {
+	// Validate the precision once instead of on every visit
+	if _, err := hll.New(precision); err != nil {
+		return nil, err
+	}
+
+	return &VisitorTracker{
+		repo:       repo,
+		precision:  precision,
+		maxPending: maxPending,
+		logger:     logger,
+		pending:    make(map[db.VisitorSketchKey]*hll.Sketch),
+	}, nil
+}
+
+// Track records a visit of a banner by the identified visitor at the given time
+func (t *VisitorTracker) Track(bannerID int, visitorID string, at time.Time) {
+	key := db.NewVisitorSketchKey(bannerID, at)
+	hash := hll.Hash(visitorID)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	sketch, ok := t.pending[key]
+	if !ok {
+		if t.maxPending > 0 && len(t.pending) >= t.maxPending {
+			t.stats.Dropped++
+			return
+		}
+		sketch, _ = hll.New(t.precision)
+		t.pending[key] = sketch
+	}
+	sketch.AddHash(hash)
+}
+
+// Flush merges the buffered sketches into the database. On failure they are
+// put back and merged on the next flush; merging a sketch twice is harmless.
+func (t *VisitorTracker) Flush() error {
+	t.flushMu.Lock()
+	defer t.flushMu.Unlock()
+
+	t.mu.Lock()
+	batch := t.pending
+	t.flushing = batch
+	t.pending = make(map[db.VisitorSketchKey]*hll.Sketch)
+	t.mu.Unlock()
+
+	if len(batch) == 0 {
+		t.mu.Lock()
+		t.flushing = nil
+		t.mu.Unlock()
+		return nil
+	}
+
+	err := t.repo.MergeVisitorSketches(batch)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.flushing = nil
+	if err != nil {
+		t.stats.Failures++
+		for key, sketch := range batch {
+			if current, ok := t.pending[key]; ok {
+				sketch.Merge(current)
+			}
+			t.pending[key] = sketch
+		}
+		return err
+	}
+
+	t.stats.Flushed += int64(len(batch))
+	return nil
+}
+
+// UniqueVisitors estimates the distinct visitors of a banner in the hours
+// covering [start, end), including visits not flushed yet
+func (t *VisitorTracker) UniqueVisitors(bannerID int, start, end time.Time) (uint64, error) {
+	union, err := t.repo.GetVisitorSketch(bannerID, start, end, t.precision)
+	if err != nil {
+		return 0, err
+	}
+
+	from, to := db.VisitorHours(start, end)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, buffered := range []map[db.VisitorSketchKey]*hll.Sketch{t.pending, t.flushing} {
+		for key, sketch := range buffered {
+			if key.BannerID == bannerID && !key.Bucket.Before(from) && key.Bucket.Before(to) {
+				union.Merge(sketch)
+			}
+		}
+	}
+
+	return union.Estimate(), nil
+}
+
+// Stats returns the tracker statistics
+func (t *VisitorTracker) Stats() VisitorTrackerStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	stats := t.stats
+	stats.Pending = len(t.pending)
+	return stats
+}
+
+// Start flushes buffered sketches every interval
+func (t *VisitorTracker) Start(interval time.Duration) {
+	t.ticker = time.NewTicker(interval)
+	t.stopChan = make(chan struct{})
+	t.done = make(chan struct{})
+
+	go func() {
+		defer close(t.done)
+		for {
+			select {
+			case <-t.ticker.C:
+				if err := t.Flush(); err != nil {
+					t.logger.Error("Failed to flush visitor sketches",
+						logger.NewField("error", err.Error()))
+				}
+			case <-t.stopChan:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the background schedule and flushes the remaining sketches
+func (t *VisitorTracker) Stop() {
+	if t.ticker != nil {
+		t.ticker.Stop()
+		close(t.stopChan)
+		<-t.done
+	}
+
+	if err := t.Flush(); err != nil {
+		t.logger.Error("Failed to flush visitor sketches on shutdown",
+			logger.NewField("error", err.Error()),
+			logger.NewField("pending", t.Stats().Pending))
+	}
+}
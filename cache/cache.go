@@ -1,11 +1,16 @@
 package cache
 
 import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/tyagnii/ecom_test/db"
 	"github.com/tyagnii/ecom_test/dto"
+	"github.com/tyagnii/ecom_test/resp"
 )
 
 // Cache interface defines cache operations
@@ -16,9 +21,15 @@ type Cache interface {
 	DeleteBanner(id int)
 	InvalidateBanner(id int)
 
+	// Banners known not to exist; deleting or invalidating a banner removes
+	// its marker
+	IsBannerMissing(id int) bool
+	SetBannerMissing(id int, ttl time.Duration)
+
 	// Click statistics
 	GetClickStats(bannerID int) (*db.ClickStats, bool)
 	SetClickStats(bannerID int, stats *db.ClickStats, ttl time.Duration)
+	IncrementClickStats(click *dto.Click) (*db.ClickStats, bool)
 	InvalidateClickStats(bannerID int)
 
 	// Banner with stats
@@ -35,9 +46,35 @@ type Cache interface {
 	Clear()
 	Size() int
 	Stats() CacheStats
+	Inspect(pattern string, limit int) ([]KeyInfo, error)
+	// TTL returns the remaining time to live of a live key, negative for keys
+	// without expiry
+	TTL(key string) (time.Duration, bool)
 	Stop()
 }
 
+// ErrInvalidPattern is returned by Inspect for a malformed key pattern
+var ErrInvalidPattern = errors.New("invalid key pattern")
+
+// KeyInfo describes a live cache key
+type KeyInfo struct {
+	Key string `json:"key"`
+	// TTLMillis is the remaining time to live, -1 for keys without expiry
+	TTLMillis int64 `json:"ttl_ms"`
+	// Tier is the tier of a tiered cache holding the key
+	Tier string `json:"tier,omitempty"`
+}
+
+// checkPattern validates a glob key pattern with the syntax of Redis, where *
+// matches any characters including /, ? one character and [...] a character
+// class
+func checkPattern(pattern string) error {
+	if err := resp.CheckPattern(pattern); err != nil {
+		return fmt.Errorf("%w %q", ErrInvalidPattern, pattern)
+	}
+	return nil
+}
+
 // CacheItem represents a cached item with expiration
 type CacheItem struct {
 	Value     interface{}
@@ -65,7 +102,17 @@ type CacheStats struct {
 	Sets       int64 `json:"sets"`
 	Deletes    int64 `json:"deletes"`
 	Expirations int64 `json:"expirations"`
+	// NegativeHits and NegativeSets count lookups and stores of banners
+	// cached as not existing
+	NegativeHits int64 `json:"negative_hits"`
+	NegativeSets int64 `json:"negative_sets"`
+	// Size is the number of cached entries, or -1 if the backend cannot tell
+	// cheaply
 	Size       int   `json:"size"`
+	// Errors counts failed requests to a remote backend
+	Errors     int64 `json:"errors,omitempty"`
+	// Tiers holds the statistics of each tier of a tiered cache
+	Tiers      map[string]CacheStats `json:"tiers,omitempty"`
 }
 
 // NewInMemoryCache creates a new in-memory cache
@@ -164,7 +211,7 @@ func (c *InMemoryCache) delete(key string) {
 
 // GetBanner retrieves a banner from cache
 func (c *InMemoryCache) GetBanner(id int) (*dto.Banner, bool) {
-	key := "banner:" + string(rune(id))
+	key := bannerKey(id)
 	value, found := c.get(key)
 	if !found {
 		return nil, false
@@ -175,14 +222,14 @@ func (c *InMemoryCache) GetBanner(id int) (*dto.Banner, bool) {
 
 // SetBanner stores a banner in cache
 func (c *InMemoryCache) SetBanner(banner *dto.Banner, ttl time.Duration) {
-	key := "banner:" + string(rune(banner.ID))
+	key := bannerKey(banner.ID)
 	c.set(key, banner, ttl)
 }
 
-// DeleteBanner removes a banner from cache
+// DeleteBanner removes a banner and its not-found marker from cache
 func (c *InMemoryCache) DeleteBanner(id int) {
-	key := "banner:" + string(rune(id))
-	c.delete(key)
+	c.delete(bannerKey(id))
+	c.delete(missingBannerKey(id))
 }
 
 // InvalidateBanner invalidates banner and related data
@@ -193,11 +240,43 @@ func (c *InMemoryCache) InvalidateBanner(id int) {
 	c.InvalidateTopBanners()
 }
 
+// IsBannerMissing reports whether a banner is cached as not existing
+func (c *InMemoryCache) IsBannerMissing(id int) bool {
+	key := missingBannerKey(id)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	item, exists := c.items[key]
+	if !exists {
+		return false
+	}
+	if item.IsExpired() {
+		delete(c.items, key)
+		c.stats.Expirations++
+		return false
+	}
+	c.stats.NegativeHits++
+	return true
+}
+
+// SetBannerMissing caches that a banner does not exist
+func (c *InMemoryCache) SetBannerMissing(id int, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.items[missingBannerKey(id)] = &CacheItem{
+		Value:     true,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	c.stats.NegativeSets++
+}
+
 // Click statistics operations
 
 // GetClickStats retrieves click statistics from cache
 func (c *InMemoryCache) GetClickStats(bannerID int) (*db.ClickStats, bool) {
-	key := "click_stats:" + string(rune(bannerID))
+	key := clickStatsKey(bannerID)
 	value, found := c.get(key)
 	if !found {
 		return nil, false
@@ -208,13 +287,57 @@ func (c *InMemoryCache) GetClickStats(bannerID int) (*db.ClickStats, bool) {
 
 // SetClickStats stores click statistics in cache
 func (c *InMemoryCache) SetClickStats(bannerID int, stats *db.ClickStats, ttl time.Duration) {
-	key := "click_stats:" + string(rune(bannerID))
+	key := clickStatsKey(bannerID)
 	c.set(key, stats, ttl)
 }
 
+// IncrementClickStats counts a newly recorded click in the cached statistics of
+// its banner. The entry keeps its original expiry so that it is periodically
+// reloaded from the database. It returns false when no statistics are cached.
+func (c *InMemoryCache) IncrementClickStats(click *dto.Click) (*db.ClickStats, bool) {
+	key := clickStatsKey(click.BannerID)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	item, exists := c.items[key]
+	if !exists || item.IsExpired() {
+		return nil, false
+	}
+	cached, ok := item.Value.(*db.ClickStats)
+	if !ok {
+		return nil, false
+	}
+
+	// Replace rather than mutate, readers may hold the cached pointer
+	stats := countClick(cached, click)
+	item.Value = stats
+	c.stats.Hits++
+
+	return stats, true
+}
+
+// countClick returns a copy of stats that counts click
+func countClick(cached *db.ClickStats, click *dto.Click) *db.ClickStats {
+	stats := *cached
+	stats.TotalClicks++
+	if click.FilteredReason != "" {
+		stats.FilteredClicks++
+	} else {
+		stats.NetClicks++
+	}
+	if stats.FirstClick.IsZero() || click.Timestamp.Before(stats.FirstClick) {
+		stats.FirstClick = click.Timestamp
+	}
+	if click.Timestamp.After(stats.LastClick) {
+		stats.LastClick = click.Timestamp
+	}
+	return &stats
+}
+
 // InvalidateClickStats removes click statistics from cache
 func (c *InMemoryCache) InvalidateClickStats(bannerID int) {
-	key := "click_stats:" + string(rune(bannerID))
+	key := clickStatsKey(bannerID)
 	c.delete(key)
 }
 
@@ -222,7 +345,7 @@ func (c *InMemoryCache) InvalidateClickStats(bannerID int) {
 
 // GetBannerWithStats retrieves banner with stats from cache
 func (c *InMemoryCache) GetBannerWithStats(id int) (*db.BannerWithStats, bool) {
-	key := "banner_stats:" + string(rune(id))
+	key := bannerStatsKey(id)
 	value, found := c.get(key)
 	if !found {
 		return nil, false
@@ -233,13 +356,13 @@ func (c *InMemoryCache) GetBannerWithStats(id int) (*db.BannerWithStats, bool) {
 
 // SetBannerWithStats stores banner with stats in cache
 func (c *InMemoryCache) SetBannerWithStats(id int, stats *db.BannerWithStats, ttl time.Duration) {
-	key := "banner_stats:" + string(rune(id))
+	key := bannerStatsKey(id)
 	c.set(key, stats, ttl)
 }
 
 // InvalidateBannerWithStats removes banner with stats from cache
 func (c *InMemoryCache) InvalidateBannerWithStats(id int) {
-	key := "banner_stats:" + string(rune(id))
+	key := bannerStatsKey(id)
 	c.delete(key)
 }
 
@@ -247,7 +370,7 @@ func (c *InMemoryCache) InvalidateBannerWithStats(id int) {
 
 // GetTopBanners retrieves top banners from cache
 func (c *InMemoryCache) GetTopBanners(limit int) ([]*db.BannerClickCount, bool) {
-	key := "top_banners:" + string(rune(limit))
+	key := topBannersKey(limit)
 	value, found := c.get(key)
 	if !found {
 		return nil, false
@@ -258,7 +381,7 @@ func (c *InMemoryCache) GetTopBanners(limit int) ([]*db.BannerClickCount, bool)
 
 // SetTopBanners stores top banners in cache
 func (c *InMemoryCache) SetTopBanners(limit int, banners []*db.BannerClickCount, ttl time.Duration) {
-	key := "top_banners:" + string(rune(limit))
+	key := topBannersKey(limit)
 	c.set(key, banners, ttl)
 }
 
@@ -269,7 +392,7 @@ func (c *InMemoryCache) InvalidateTopBanners() {
 
 	// Remove all top_banners keys
 	for key := range c.items {
-		if len(key) > 11 && key[:11] == "top_banners:" {
+		if strings.HasPrefix(key, topBannersKeyPrefix) {
 			delete(c.items, key)
 			c.stats.Deletes++
 		}
@@ -293,6 +416,46 @@ func (c *InMemoryCache) Size() int {
 	return len(c.items)
 }
 
+// Inspect lists up to limit live keys matching a glob pattern, sorted by key.
+// A limit of zero lists all matching keys.
+func (c *InMemoryCache) Inspect(pattern string, limit int) ([]KeyInfo, error) {
+	if err := checkPattern(pattern); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	keys := []KeyInfo{}
+
+	c.mu.RLock()
+	for key, item := range c.items {
+		if item.IsExpired() {
+			continue
+		}
+		if resp.MatchPattern(pattern, key) {
+			keys = append(keys, KeyInfo{Key: key, TTLMillis: item.ExpiresAt.Sub(now).Milliseconds()})
+		}
+	}
+	c.mu.RUnlock()
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Key < keys[j].Key })
+	if limit > 0 && len(keys) > limit {
+		keys = keys[:limit]
+	}
+	return keys, nil
+}
+
+// TTL returns the remaining time to live of a live key
+func (c *InMemoryCache) TTL(key string) (time.Duration, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	item, exists := c.items[key]
+	if !exists || item.IsExpired() {
+		return 0, false
+	}
+	return time.Until(item.ExpiresAt), true
+}
+
 // Stats returns cache performance statistics
 func (c *InMemoryCache) Stats() CacheStats {
 	c.mu.RLock()
@@ -309,5 +472,6 @@ const (
 	DefaultClickStatsTTL  = 2 * time.Minute
 	DefaultBannerStatsTTL = 3 * time.Minute
 	DefaultTopBannersTTL  = 1 * time.Minute
+	DefaultMissingBannerTTL = 10 * time.Second
 	DefaultCleanupInterval = 30 * time.Second
 )
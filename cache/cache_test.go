@@ -0,0 +1,76 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tyagnii/ecom_test/db"
+	"github.com/tyagnii/ecom_test/dto"
+)
+
+func TestInMemoryCacheIncrementClickStatsMiss(t *testing.T) {
+	c := NewInMemoryCache(time.Hour)
+	defer c.Stop()
+
+	if _, found := c.IncrementClickStats(&dto.Click{BannerID: 1, Timestamp: time.Now()}); found {
+		t.Fatal("IncrementClickStats found statistics that were never cached")
+	}
+	if _, found := c.GetClickStats(1); found {
+		t.Fatal("IncrementClickStats cached statistics on a miss")
+	}
+}
+
+func TestInMemoryCacheIncrementClickStats(t *testing.T) {
+	base := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	cached := &db.ClickStats{
+		BannerID:    1,
+		TotalClicks: 2,
+		NetClicks:   2,
+		FirstClick:  base,
+		LastClick:   base.Add(time.Hour),
+	}
+
+	tests := []struct {
+		name  string
+		click *dto.Click
+		want  db.ClickStats
+	}{
+		{
+			name:  "net click",
+			click: &dto.Click{BannerID: 1, Timestamp: base.Add(2 * time.Hour)},
+			want:  db.ClickStats{BannerID: 1, TotalClicks: 3, NetClicks: 3, FirstClick: base, LastClick: base.Add(2 * time.Hour)},
+		},
+		{
+			name:  "filtered click",
+			click: &dto.Click{BannerID: 1, Timestamp: base.Add(30 * time.Minute), FilteredReason: "bot"},
+			want:  db.ClickStats{BannerID: 1, TotalClicks: 3, NetClicks: 2, FilteredClicks: 1, FirstClick: base, LastClick: base.Add(time.Hour)},
+		},
+		{
+			name:  "earlier click",
+			click: &dto.Click{BannerID: 1, Timestamp: base.Add(-time.Hour)},
+			want:  db.ClickStats{BannerID: 1, TotalClicks: 3, NetClicks: 3, FirstClick: base.Add(-time.Hour), LastClick: base.Add(time.Hour)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewInMemoryCache(time.Hour)
+			defer c.Stop()
+			c.SetClickStats(1, cached, time.Minute)
+
+			stats, found := c.IncrementClickStats(tt.click)
+			if !found {
+				t.Fatal("IncrementClickStats missed cached statistics")
+			}
+			if *stats != tt.want {
+				t.Errorf("IncrementClickStats = %+v, want %+v", *stats, tt.want)
+			}
+			if got, _ := c.GetClickStats(1); *got != tt.want {
+				t.Errorf("cached statistics = %+v, want %+v", *got, tt.want)
+			}
+			if cached.TotalClicks != 2 {
+				t.Error("IncrementClickStats mutated the cached value in place")
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package cache
 
 import (
+	"errors"
 	"fmt"
 	"time"
 
@@ -10,15 +11,69 @@ import (
 
 // CachedRepository wraps a repository with caching functionality
 type CachedRepository struct {
-	repo  *db.Repository
-	cache Cache
+	repo        *db.Repository
+	cache       Cache
+	invalidator *Invalidator
+	policies    Policies
+	fresh       *freshness
+	flight      flightGroup
+	refresher   *refresher
 }
 
 // NewCachedRepository creates a new cached repository
 func NewCachedRepository(repo *db.Repository, cache Cache) *CachedRepository {
 	return &CachedRepository{
-		repo:  repo,
-		cache: cache,
+		repo:     repo,
+		cache:    cache,
+		policies:  DefaultPolicies(),
+		fresh:     newFreshness(),
+		refresher: newRefresher(revalidateWorkers, revalidateQueue),
+	}
+}
+
+// SetPolicies sets the TTL policies of the cached entries
+func (r *CachedRepository) SetPolicies(policies Policies) {
+	r.policies = policies
+}
+
+// ttl returns the TTL to cache the entry for key with under policy, and
+// records when the entry goes stale. Stale entries are kept for the stale
+// window so that they can be served while they are refreshed.
+func (r *CachedRepository) ttl(key string, policy TTLPolicy) time.Duration {
+	ttl := policy.jittered()
+	if policy.StaleWindow <= 0 {
+		return ttl
+	}
+	now := time.Now()
+	r.fresh.set(key, now.Add(ttl), now.Add(ttl+policy.StaleWindow))
+	return ttl + policy.StaleWindow
+}
+
+// revalidate refreshes the entry for key in the background if it is stale
+func (r *CachedRepository) revalidate(key string, load func() (interface{}, error)) {
+	if !r.fresh.claimStale(key) {
+		return
+	}
+	queued := r.refresher.submit(func() {
+		_, err := r.flight.Do(key, load)
+		r.fresh.done(key, err)
+	})
+	if !queued {
+		r.fresh.drop(key)
+	}
+}
+
+// Stop stops the background refreshes
+func (r *CachedRepository) Stop() {
+	r.refresher.Stop()
+}
+
+// SetInvalidator sets the invalidator that propagates invalidations to other
+// instances
+func (r *CachedRepository) SetInvalidator(invalidator *Invalidator) {
+	r.invalidator = invalidator
+	if invalidator != nil {
+		invalidator.fresh = r.fresh
 	}
 }
 
@@ -31,34 +86,73 @@ func (r *CachedRepository) CreateBanner(banner *dto.Banner) error {
 		return err
 	}
 
+	// Invalidate related caches, including a not-found marker of the ID
+	r.fresh.invalidateBanner(banner.ID)
+	r.cache.InvalidateBanner(banner.ID)
+	r.invalidator.Banner(banner.ID)
+
 	// Cache the new banner
-	r.cache.SetBanner(banner, DefaultBannerTTL)
-	
-	// Invalidate related caches
-	r.cache.InvalidateTopBanners()
+	r.cache.SetBanner(banner, r.ttl(bannerKey(banner.ID), r.policies.Banner))
 
 	return nil
 }
 
-// GetBannerByID retrieves a banner with caching
+// GetBannerByID retrieves a banner with caching. A stale banner is returned
+// while it is refreshed in the background, and banners that were not found are
+// reported as db.ErrNotFound from the cache for a short while.
 func (r *CachedRepository) GetBannerByID(id int) (*dto.Banner, error) {
+	key := bannerKey(id)
+	load := func() (interface{}, error) {
+		return r.loadBanner(id)
+	}
+
 	// Try cache first
 	if banner, found := r.cache.GetBanner(id); found {
+		r.revalidate(key, load)
 		return banner, nil
 	}
+	if r.policies.MissingBannerTTL > 0 && r.cache.IsBannerMissing(id) {
+		return nil, fmt.Errorf("banner with ID %d %w", id, db.ErrNotFound)
+	}
 
-	// Get from database
-	banner, err := r.repo.GetBannerByID(id)
+	// Get from database, once for concurrent misses
+	value, err := r.flight.Do(key, load)
 	if err != nil {
 		return nil, err
 	}
+	return value.(*dto.Banner), nil
+}
 
-	// Cache the result
-	r.cache.SetBanner(banner, DefaultBannerTTL)
-
+// loadBanner gets a banner from the database and caches it, or caches that
+// it does not exist
+func (r *CachedRepository) loadBanner(id int) (*dto.Banner, error) {
+	key := bannerKey(id)
+	load := r.fresh.begin(key)
+	banner, err := r.repo.GetBannerByID(id)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) && r.policies.MissingBannerTTL > 0 {
+			// A stale copy may still be cached when the banner was deleted
+			r.cache.DeleteBanner(id)
+			r.cache.SetBannerMissing(id, r.policies.MissingBannerTTL)
+		}
+		// Deleting the banner also removes the not-found marker
+		if r.fresh.end(key, load) {
+			r.cache.DeleteBanner(id)
+		}
+		return nil, err
+	}
+	r.cache.SetBanner(banner, r.ttl(key, r.policies.Banner))
+	if r.fresh.end(key, load) {
+		r.cache.DeleteBanner(id)
+	}
 	return banner, nil
 }
 
+// CountBanners returns the number of banners (not cached, the count is cheap)
+func (r *CachedRepository) CountBanners() (int, error) {
+	return r.repo.CountBanners()
+}
+
 // GetAllBanners retrieves all banners (not cached due to frequent changes)
 func (r *CachedRepository) GetAllBanners() ([]*dto.Banner, error) {
 	return r.repo.GetAllBanners()
@@ -70,12 +164,14 @@ func (r *CachedRepository) UpdateBanner(banner *dto.Banner) error {
 	if err != nil {
 		return err
 	}
+	r.fresh.invalidateBanner(banner.ID)
 
 	// Update cache
-	r.cache.SetBanner(banner, DefaultBannerTTL)
+	r.cache.SetBanner(banner, r.ttl(bannerKey(banner.ID), r.policies.Banner))
 	
 	// Invalidate related caches
 	r.cache.InvalidateBanner(banner.ID)
+	r.invalidator.Banner(banner.ID)
 
 	return nil
 }
@@ -88,7 +184,9 @@ func (r *CachedRepository) DeleteBanner(id int) error {
 	}
 
 	// Invalidate all related cache entries
+	r.fresh.invalidateBanner(id)
 	r.cache.InvalidateBanner(id)
+	r.invalidator.Banner(id)
 
 	return nil
 }
@@ -119,7 +217,9 @@ func (r *CachedRepository) CreateClick(click *dto.Click) error {
 		return err
 	}
 
-	// Invalidate click-related caches for this banner
+	// Invalidate click-related caches for this banner; other instances
+	// pick the click up when their entries expire
+	r.fresh.invalidateClicks(click.BannerID)
 	r.cache.InvalidateClickStats(click.BannerID)
 	r.cache.InvalidateBannerWithStats(click.BannerID)
 	r.cache.InvalidateTopBanners()
@@ -127,6 +227,26 @@ func (r *CachedRepository) CreateClick(click *dto.Click) error {
 	return nil
 }
 
+// CountClick updates cached statistics for a click that was already written to
+// the database and returns the banner's current statistics. The cached counter
+// is incremented in place. When nothing is cached the aggregate is queried but
+// not cached: it may already include clicks of other instances that are about
+// to increment the entry, which would count them twice. Other instances are
+// not notified; their statistics catch up when they expire.
+func (r *CachedRepository) CountClick(click *dto.Click) (*db.ClickStats, error) {
+	// A load of the statistics in progress may have read them before the
+	// click was written; the increment keeps the entry fresh
+	r.fresh.supersede(clickStatsKey(click.BannerID))
+	r.fresh.invalidatePrefix(topBannersKeyPrefix)
+	r.cache.InvalidateBannerWithStats(click.BannerID)
+	r.cache.InvalidateTopBanners()
+
+	if stats, found := r.cache.IncrementClickStats(click); found {
+		return stats, nil
+	}
+	return r.repo.GetClickStats(click.BannerID)
+}
+
 // GetClickByID retrieves a click by ID (not cached due to low frequency)
 func (r *CachedRepository) GetClickByID(id int) (*dto.Click, error) {
 	return r.repo.GetClickByID(id)
@@ -166,6 +286,7 @@ func (r *CachedRepository) DeleteClick(id int) error {
 	}
 
 	// Invalidate click-related caches for this banner
+	r.fresh.invalidateClicks(click.BannerID)
 	r.cache.InvalidateClickStats(click.BannerID)
 	r.cache.InvalidateBannerWithStats(click.BannerID)
 	r.cache.InvalidateTopBanners()
@@ -173,52 +294,90 @@ func (r *CachedRepository) DeleteClick(id int) error {
 	return nil
 }
 
-// GetClickStats retrieves click statistics with caching
+// GetClickStats retrieves click statistics with caching. Stale statistics are
+// returned while they are refreshed in the background.
 func (r *CachedRepository) GetClickStats(bannerID int) (*db.ClickStats, error) {
+	key := clickStatsKey(bannerID)
+	load := func() (interface{}, error) {
+		return r.loadClickStats(bannerID)
+	}
+
 	// Try cache first
 	if stats, found := r.cache.GetClickStats(bannerID); found {
+		r.revalidate(key, load)
 		return stats, nil
 	}
 
-	// Get from database
-	stats, err := r.repo.GetClickStats(bannerID)
+	// Get from database, once for concurrent misses
+	value, err := r.flight.Do(key, load)
 	if err != nil {
 		return nil, err
 	}
+	return value.(*db.ClickStats), nil
+}
 
-	// Cache the result
-	r.cache.SetClickStats(bannerID, stats, DefaultClickStatsTTL)
-
+// loadClickStats gets click statistics from the database and caches them
+func (r *CachedRepository) loadClickStats(bannerID int) (*db.ClickStats, error) {
+	key := clickStatsKey(bannerID)
+	load := r.fresh.begin(key)
+	stats, err := r.repo.GetClickStats(bannerID)
+	if err != nil {
+		r.fresh.end(key, load)
+		return nil, err
+	}
+	r.cache.SetClickStats(bannerID, stats, r.ttl(key, r.policies.ClickStats))
+	if r.fresh.end(key, load) {
+		r.cache.InvalidateClickStats(bannerID)
+	}
 	return stats, nil
 }
 
-// GetTopBanners retrieves top banners with caching
+// GetTopBanners retrieves top banners with caching. Stale top banners are
+// returned while they are refreshed in the background.
 func (r *CachedRepository) GetTopBanners(limit int) ([]*db.BannerClickCount, error) {
+	key := topBannersKey(limit)
+	load := func() (interface{}, error) {
+		return r.loadTopBanners(limit)
+	}
+
 	// Try cache first
 	if banners, found := r.cache.GetTopBanners(limit); found {
+		r.revalidate(key, load)
 		return banners, nil
 	}
 
-	// Get from database
-	banners, err := r.repo.GetTopBanners(limit)
+	// Get from database, once for concurrent misses
+	value, err := r.flight.Do(key, load)
 	if err != nil {
 		return nil, err
 	}
+	return value.([]*db.BannerClickCount), nil
+}
 
-	// Cache the result
-	r.cache.SetTopBanners(limit, banners, DefaultTopBannersTTL)
-
+// loadTopBanners gets top banners from the database and caches them
+func (r *CachedRepository) loadTopBanners(limit int) ([]*db.BannerClickCount, error) {
+	key := topBannersKey(limit)
+	load := r.fresh.begin(key)
+	banners, err := r.repo.GetTopBanners(limit)
+	if err != nil {
+		r.fresh.end(key, load)
+		return nil, err
+	}
+	r.cache.SetTopBanners(limit, banners, r.ttl(key, r.policies.TopBanners))
+	if r.fresh.end(key, load) {
+		r.cache.InvalidateTopBanners()
+	}
 	return banners, nil
 }
 
 // GetClicksByHour retrieves hourly clicks (not cached due to low frequency)
-func (r *CachedRepository) GetClicksByHour(bannerID int, date time.Time) ([]*db.HourlyClicks, error) {
-	return r.repo.GetClicksByHour(bannerID, date)
+func (r *CachedRepository) GetClicksByHour(bannerID int, date time.Time, loc *time.Location) ([]*db.HourlyClicks, error) {
+	return r.repo.GetClicksByHour(bannerID, date, loc)
 }
 
 // GetClicksByDay retrieves daily clicks (not cached due to low frequency)
-func (r *CachedRepository) GetClicksByDay(bannerID int, startDate, endDate time.Time) ([]*db.DailyClicks, error) {
-	return r.repo.GetClicksByDay(bannerID, startDate, endDate)
+func (r *CachedRepository) GetClicksByDay(bannerID int, startDate, endDate time.Time, loc *time.Location) ([]*db.DailyClicks, error) {
+	return r.repo.GetClicksByDay(bannerID, startDate, endDate, loc)
 }
 
 // Cache management methods
@@ -228,17 +387,48 @@ func (r *CachedRepository) GetCacheStats() CacheStats {
 	return r.cache.Stats()
 }
 
-// ClearCache clears all cached data
+// InspectCache lists up to limit live cache keys matching a glob pattern
+func (r *CachedRepository) InspectCache(pattern string, limit int) ([]KeyInfo, error) {
+	return r.cache.Inspect(pattern, limit)
+}
+
+// RevalidationStats returns stale-while-revalidate statistics
+func (r *CachedRepository) RevalidationStats() RevalidationStats {
+	return r.fresh.Stats()
+}
+
+// ClearCache clears all cached data, on other instances too
 func (r *CachedRepository) ClearCache() {
+	r.fresh.invalidateAll()
 	r.cache.Clear()
+	r.invalidator.All()
 }
 
 // InvalidateBannerCache invalidates all cache entries for a banner
 func (r *CachedRepository) InvalidateBannerCache(bannerID int) {
+	r.fresh.invalidateBanner(bannerID)
 	r.cache.InvalidateBanner(bannerID)
+	r.invalidator.Banner(bannerID)
+}
+
+// InvalidateClickCaches invalidates the local click-related cache entries
+// after a bulk write, touching each banner once and top banners once for the
+// whole batch
+func (r *CachedRepository) InvalidateClickCaches(bannerIDs []int) {
+	if len(bannerIDs) == 0 {
+		return
+	}
+
+	for _, bannerID := range bannerIDs {
+		r.fresh.invalidateClicks(bannerID)
+		r.cache.InvalidateClickStats(bannerID)
+		r.cache.InvalidateBannerWithStats(bannerID)
+	}
+	r.cache.InvalidateTopBanners()
 }
 
-// WarmCache preloads frequently accessed data
+// WarmCache preloads frequently accessed data. The TTL jitter spreads the
+// expiry of the preloaded entries.
 func (r *CachedRepository) WarmCache() error {
 	// Get all banners and cache them
 	banners, err := r.repo.GetAllBanners()
@@ -247,7 +437,7 @@ func (r *CachedRepository) WarmCache() error {
 	}
 
 	for _, banner := range banners {
-		r.cache.SetBanner(banner, DefaultBannerTTL)
+		r.cache.SetBanner(banner, r.ttl(bannerKey(banner.ID), r.policies.Banner))
 	}
 
 	// Cache click stats for all banners
@@ -256,13 +446,13 @@ func (r *CachedRepository) WarmCache() error {
 		if err != nil {
 			continue // Skip if stats can't be retrieved
 		}
-		r.cache.SetClickStats(banner.ID, stats, DefaultClickStatsTTL)
+		r.cache.SetClickStats(banner.ID, stats, r.ttl(clickStatsKey(banner.ID), r.policies.ClickStats))
 	}
 
 	// Cache top banners
 	topBanners, err := r.repo.GetTopBanners(10)
 	if err == nil {
-		r.cache.SetTopBanners(10, topBanners, DefaultTopBannersTTL)
+		r.cache.SetTopBanners(10, topBanners, r.ttl(topBannersKey(10), r.policies.TopBanners))
 	}
 
 	return nil
@@ -0,0 +1,36 @@
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// codecVersion is the version of the encoding of values stored outside the
+// process. Bump it when a cached type changes incompatibly: entries of other
+// versions are treated as misses, so instances of different versions can share
+// a cache during a deploy.
+const codecVersion = 1
+
+// errCodecVersion is returned when decoding a value of another codec version
+var errCodecVersion = errors.New("cache: value encoded with another codec version")
+
+// encodeValue encodes a value as the codec version followed by its JSON encoding
+func encodeValue(value interface{}) ([]byte, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("cache: failed to encode value: %w", err)
+	}
+	return append([]byte{codecVersion}, data...), nil
+}
+
+// decodeValue decodes a value encoded by encodeValue into value
+func decodeValue(data []byte, value interface{}) error {
+	if len(data) == 0 || data[0] != codecVersion {
+		return errCodecVersion
+	}
+	if err := json.Unmarshal(data[1:], value); err != nil {
+		return fmt.Errorf("cache: failed to decode value: %w", err)
+	}
+	return nil
+}
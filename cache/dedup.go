@@ -0,0 +1,135 @@
+package cache
+
+import (
+	"container/list"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tyagnii/ecom_test/dto"
+)
+
+// dedupEntry tracks the first click seen for a dedup key
+type dedupEntry struct {
+	key       string
+	click     *dto.Click
+	expiresAt time.Time
+}
+
+// ClickDeduplicator suppresses repeated clicks for the same key within a time window.
+// It keeps at most maxEntries keys and evicts the oldest ones first.
+type ClickDeduplicator struct {
+	mu         sync.Mutex
+	window     time.Duration
+	maxEntries int
+	entries    map[string]*list.Element
+	order      *list.List
+	stats      DedupStats
+}
+
+// DedupStats provides deduplication metrics
+type DedupStats struct {
+	Claims     int64 `json:"claims"`
+	Suppressed int64 `json:"suppressed"`
+	Evictions  int64 `json:"evictions"`
+	Size       int   `json:"size"`
+}
+
+// NewClickDeduplicator creates a new click deduplicator
+func NewClickDeduplicator(window time.Duration, maxEntries int) *ClickDeduplicator {
+	if maxEntries <= 0 {
+		maxEntries = DefaultDedupMaxEntries
+	}
+
+	return &ClickDeduplicator{
+		window:     window,
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+	}
+}
+
+// DedupKey builds the dedup key for a banner and client fingerprint
+func DedupKey(bannerID int, fingerprint string) string {
+	return strconv.Itoa(bannerID) + ":" + fingerprint
+}
+
+// Claim registers a click for key. It returns true when the click is the first one
+// within the window and should be counted. Otherwise it returns the click that was
+// counted for the key, which is nil while that click is still being recorded.
+func (d *ClickDeduplicator) Claim(key string) (*dto.Click, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	d.pruneExpired(now)
+
+	if elem, exists := d.entries[key]; exists {
+		d.stats.Suppressed++
+		return elem.Value.(*dedupEntry).click, false
+	}
+
+	for d.order.Len() >= d.maxEntries {
+		d.removeElement(d.order.Back())
+		d.stats.Evictions++
+	}
+
+	entry := &dedupEntry{
+		key:       key,
+		expiresAt: now.Add(d.window),
+	}
+	d.entries[key] = d.order.PushFront(entry)
+	d.stats.Claims++
+
+	return nil, true
+}
+
+// Complete stores the recorded click for a claimed key
+func (d *ClickDeduplicator) Complete(key string, click *dto.Click) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if elem, exists := d.entries[key]; exists {
+		elem.Value.(*dedupEntry).click = click
+	}
+}
+
+// Release forgets a claimed key, e.g. when recording the click failed
+func (d *ClickDeduplicator) Release(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if elem, exists := d.entries[key]; exists {
+		d.removeElement(elem)
+	}
+}
+
+// Stats returns deduplication statistics
+func (d *ClickDeduplicator) Stats() DedupStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	stats := d.stats
+	stats.Size = len(d.entries)
+	return stats
+}
+
+// pruneExpired drops expired entries. Entries share one window, so the
+// list is ordered by expiry and pruning stops at the first live entry.
+func (d *ClickDeduplicator) pruneExpired(now time.Time) {
+	for elem := d.order.Back(); elem != nil; elem = d.order.Back() {
+		if now.Before(elem.Value.(*dedupEntry).expiresAt) {
+			return
+		}
+		d.removeElement(elem)
+	}
+}
+
+// removeElement removes an entry from both the list and the index
+func (d *ClickDeduplicator) removeElement(elem *list.Element) {
+	d.order.Remove(elem)
+	delete(d.entries, elem.Value.(*dedupEntry).key)
+}
+
+// DefaultDedupMaxEntries bounds the deduplicator when no explicit size is given
+const DefaultDedupMaxEntries = 100000
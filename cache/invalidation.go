@@ -0,0 +1,336 @@
+package cache
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/tyagnii/ecom_test/db"
+	"github.com/tyagnii/ecom_test/logger"
+)
+
+// DefaultInvalidationChannel is the Postgres channel invalidations are sent on
+const DefaultInvalidationChannel = "cache_invalidation"
+
+// Reconnect bounds of the invalidation listener, and how often its connection
+// is checked
+const (
+	listenerMinReconnect = time.Second
+	listenerMaxReconnect = 30 * time.Second
+	listenerPingInterval = 90 * time.Second
+)
+
+// Invalidation is the payload of an invalidation notification
+type Invalidation struct {
+	// Origin identifies the sending instance, which ignores its own notifications
+	Origin string `json:"origin"`
+	// Banners are invalidated with InvalidateBanner
+	Banners []int `json:"banners,omitempty"`
+	// All clears the whole cache
+	All bool `json:"all,omitempty"`
+}
+
+// InvalidatorStats describes the invalidations sent and received by an instance
+type InvalidatorStats struct {
+	// Pending is true while invalidations wait to be sent
+	Pending  bool  `json:"pending"`
+	Sent     int64 `json:"sent"`
+	Received int64 `json:"received"`
+	// Ignored counts received notifications that were sent by this instance
+	Ignored  int64 `json:"ignored"`
+	Failures int64 `json:"failures"`
+	// Resyncs counts cache clears after the listener reconnected
+	Resyncs int64 `json:"resyncs"`
+}
+
+// Invalidator keeps the banners cached by several instances consistent. Banner
+// writes queue invalidations, which are coalesced and sent with NOTIFY; every
+// instance LISTENs and applies the invalidations of the others to its own
+// cache. Clicks are not propagated: every click would otherwise clear the
+// click statistics and top banners of all instances, so those are updated
+// locally and expire by their TTL. A nil invalidator ignores invalidations.
+type Invalidator struct {
+	repo     *db.Repository
+	cache    Cache
+	channel  string
+	origin   string
+	listener *pq.Listener
+	logger   logger.Logger
+	// fresh is the freshness of the cached repository the invalidator is set
+	// on, whose loads in progress invalidations supersede
+	fresh *freshness
+
+	// flushMu serializes flushes
+	flushMu sync.Mutex
+
+	mu      sync.Mutex
+	pending *invalidationSet
+	stats   InvalidatorStats
+
+	stopChan chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewInvalidator creates an invalidator for cache sending on and listening to
+// channel; dsn is used for the dedicated listener connection
+func NewInvalidator(repo *db.Repository, cache Cache, dsn, channel string, logger logger.Logger) (*Invalidator, error) {
+	origin, err := newOrigin()
+	if err != nil {
+		return nil, err
+	}
+
+	i := &Invalidator{
+		repo:    repo,
+		cache:   cache,
+		channel: channel,
+		origin:  origin,
+		logger:  logger,
+		pending: newInvalidationSet(),
+	}
+	i.listener = pq.NewListener(dsn, listenerMinReconnect, listenerMaxReconnect, i.listenerEvent)
+	return i, nil
+}
+
+// newOrigin returns an identifier unique to this process
+func newOrigin() (string, error) {
+	var nonce [4]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return "", fmt.Errorf("failed to generate invalidation origin: %w", err)
+	}
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(nonce[:])), nil
+}
+
+// Banner queues the invalidation of a banner and everything derived from it
+func (i *Invalidator) Banner(id int) {
+	if i == nil {
+		return
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.pending.banners[id] = true
+}
+
+// All queues clearing the whole cache
+func (i *Invalidator) All() {
+	if i == nil {
+		return
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.pending.all = true
+}
+
+// Flush sends the queued invalidations in one notification. On failure they
+// are queued again and sent with the next flush.
+func (i *Invalidator) Flush() error {
+	i.flushMu.Lock()
+	defer i.flushMu.Unlock()
+
+	i.mu.Lock()
+	batch := i.pending
+	i.pending = newInvalidationSet()
+	i.mu.Unlock()
+
+	if batch.empty() {
+		return nil
+	}
+
+	err := i.repo.Notify(i.channel, batch.encode(i.origin))
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if err != nil {
+		i.stats.Failures++
+		i.pending.merge(batch)
+		return err
+	}
+	i.stats.Sent++
+	return nil
+}
+
+// Stats returns the invalidator statistics
+func (i *Invalidator) Stats() InvalidatorStats {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	stats := i.stats
+	stats.Pending = !i.pending.empty()
+	return stats
+}
+
+// Start listens for invalidations of other instances and sends queued ones
+// every interval
+func (i *Invalidator) Start(interval time.Duration) {
+	i.stopChan = make(chan struct{})
+
+	i.wg.Add(2)
+	go i.listen()
+	go func() {
+		defer i.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := i.Flush(); err != nil {
+					i.logger.Error("Failed to send cache invalidations",
+						logger.NewField("error", err.Error()))
+				}
+			case <-i.stopChan:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops listening and sends the remaining invalidations
+func (i *Invalidator) Stop() {
+	if i.stopChan != nil {
+		close(i.stopChan)
+	}
+	i.listener.Close()
+	i.wg.Wait()
+
+	if err := i.Flush(); err != nil {
+		i.logger.Error("Failed to send cache invalidations on shutdown",
+			logger.NewField("error", err.Error()))
+	}
+}
+
+// listen applies notifications until the listener is closed
+func (i *Invalidator) listen() {
+	defer i.wg.Done()
+
+	// Listen blocks until the connection is up, so it is not called from Start
+	if err := i.listener.Listen(i.channel); err != nil {
+		select {
+		case <-i.stopChan:
+		default:
+			i.logger.Error("Failed to listen for cache invalidations",
+				logger.NewField("channel", i.channel),
+				logger.NewField("error", err.Error()))
+		}
+		return
+	}
+
+	ping := time.NewTicker(listenerPingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case notification, ok := <-i.listener.Notify:
+			if !ok {
+				return
+			}
+			if notification == nil {
+				// Notifications sent while disconnected are lost
+				i.fresh.invalidateAll()
+				i.cache.Clear()
+				i.mu.Lock()
+				i.stats.Resyncs++
+				i.mu.Unlock()
+				i.logger.Warn("Cache invalidation listener reconnected, cache cleared")
+				continue
+			}
+			i.apply(notification.Extra)
+		case <-ping.C:
+			// Detects dead connections, after which the listener reconnects
+			i.listener.Ping()
+		case <-i.stopChan:
+			return
+		}
+	}
+}
+
+// apply applies a received notification to the local cache
+func (i *Invalidator) apply(payload string) {
+	var inv Invalidation
+	if err := json.Unmarshal([]byte(payload), &inv); err != nil {
+		i.logger.Warn("Ignoring malformed cache invalidation",
+			logger.NewField("error", err.Error()))
+		return
+	}
+
+	i.mu.Lock()
+	i.stats.Received++
+	own := inv.Origin == i.origin
+	if own {
+		i.stats.Ignored++
+	}
+	i.mu.Unlock()
+	if own {
+		return
+	}
+
+	if inv.All {
+		i.fresh.invalidateAll()
+		i.cache.Clear()
+		return
+	}
+	for _, id := range inv.Banners {
+		i.fresh.invalidateBanner(id)
+		i.cache.InvalidateBanner(id)
+	}
+}
+
+// listenerEvent logs changes of the listener connection
+func (i *Invalidator) listenerEvent(event pq.ListenerEventType, err error) {
+	switch event {
+	case pq.ListenerEventDisconnected:
+		i.logger.Warn("Cache invalidation listener disconnected",
+			logger.NewField("error", err.Error()))
+	case pq.ListenerEventConnectionAttemptFailed:
+		i.logger.Warn("Cache invalidation listener failed to reconnect",
+			logger.NewField("error", err.Error()))
+	case pq.ListenerEventReconnected:
+		i.logger.Info("Cache invalidation listener reconnected")
+	}
+}
+
+// invalidationSet collects queued invalidations
+type invalidationSet struct {
+	banners map[int]bool
+	all     bool
+}
+
+func newInvalidationSet() *invalidationSet {
+	return &invalidationSet{
+		banners: make(map[int]bool),
+	}
+}
+
+func (s *invalidationSet) empty() bool {
+	return !s.all && len(s.banners) == 0
+}
+
+func (s *invalidationSet) merge(other *invalidationSet) {
+	for id := range other.banners {
+		s.banners[id] = true
+	}
+	s.all = s.all || other.all
+}
+
+// encode returns the notification payload. A set too large for one
+// notification is sent as clearing the whole cache.
+func (s *invalidationSet) encode(origin string) string {
+	inv := Invalidation{Origin: origin, All: s.all}
+	if !s.all {
+		for id := range s.banners {
+			inv.Banners = append(inv.Banners, id)
+		}
+		sort.Ints(inv.Banners)
+	}
+
+	payload, _ := json.Marshal(&inv)
+	if len(payload) > db.MaxNotifyPayload {
+		payload, _ = json.Marshal(&Invalidation{Origin: origin, All: true})
+	}
+	return string(payload)
+}
@@ -0,0 +1,33 @@
+package cache
+
+import "strconv"
+
+// Key prefixes of the cached kinds of values
+const (
+	bannerKeyPrefix      = "banner:"
+	clickStatsKeyPrefix  = "click_stats:"
+	bannerStatsKeyPrefix = "banner_stats:"
+	topBannersKeyPrefix  = "top_banners:"
+	// missingBannerKeyPrefix marks banners known not to exist
+	missingBannerKeyPrefix = "missing_banner:"
+)
+
+func bannerKey(id int) string {
+	return bannerKeyPrefix + strconv.Itoa(id)
+}
+
+func missingBannerKey(id int) string {
+	return missingBannerKeyPrefix + strconv.Itoa(id)
+}
+
+func clickStatsKey(bannerID int) string {
+	return clickStatsKeyPrefix + strconv.Itoa(bannerID)
+}
+
+func bannerStatsKey(id int) string {
+	return bannerStatsKeyPrefix + strconv.Itoa(id)
+}
+
+func topBannersKey(limit int) string {
+	return topBannersKeyPrefix + strconv.Itoa(limit)
+}
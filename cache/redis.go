@@ -0,0 +1,654 @@
+package cache
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tyagnii/ecom_test/db"
+	"github.com/tyagnii/ecom_test/dto"
+	"github.com/tyagnii/ecom_test/logger"
+	"github.com/tyagnii/ecom_test/resp"
+)
+
+// Cache backends selectable through configuration
+const (
+	BackendMemory = "memory"
+	BackendRedis  = "redis"
+)
+
+// maxIncrementAttempts bounds the optimistic transactions of IncrementClickStats
+const maxIncrementAttempts = 3
+
+// scanCount is the number of keys requested per SCAN call
+const scanCount = 1000
+
+// topBannersTag names the set of the cached top banners keys
+const topBannersTag = "tag:top_banners"
+
+// RedisOptions configures a Redis cache
+type RedisOptions struct {
+	Addr     string
+	Password string
+	DB       int
+	// KeyPrefix is prepended to every key, separating the environments or
+	// applications sharing a server
+	KeyPrefix string
+	// PoolSize is the number of idle connections kept open
+	PoolSize int
+	// MaxConns bounds the connections open at once; requests wait up to
+	// Timeout for one to become available
+	MaxConns int
+	// Timeout bounds connecting and every request, and waiting for a connection
+	Timeout time.Duration
+}
+
+// RedisCache implements Cache on a server speaking the Redis protocol, so that
+// instances share one cache. Values are stored with a versioned codec. Failed
+// requests are counted and treated as misses, so the repository falls back to
+// the database while the server is unavailable.
+type RedisCache struct {
+	pool   *resp.Pool
+	prefix string
+	logger logger.Logger
+
+	mu      sync.Mutex
+	stats   CacheStats
+	failing bool
+}
+
+// NewRedisCache connects to a Redis server and returns a cache backed by it
+func NewRedisCache(opts RedisOptions, logger logger.Logger) (*RedisCache, error) {
+	pool := resp.NewPool(opts.Addr, resp.Options{
+		Password:    opts.Password,
+		DB:          opts.DB,
+		DialTimeout: opts.Timeout,
+		IOTimeout:   opts.Timeout,
+	}, resp.PoolOptions{
+		MaxIdle:     opts.PoolSize,
+		MaxOpen:     opts.MaxConns,
+		WaitTimeout: opts.Timeout,
+	})
+
+	if _, err := pool.Do("PING"); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("failed to connect to Redis cache: %w", err)
+	}
+
+	return &RedisCache{
+		pool:   pool,
+		prefix: opts.KeyPrefix,
+		logger: logger,
+	}, nil
+}
+
+// key returns the server key of a cache key
+func (c *RedisCache) key(key string) string {
+	return c.prefix + key
+}
+
+// do sends a command and records whether the server is reachable
+func (c *RedisCache) do(args ...interface{}) (interface{}, error) {
+	reply, err := c.pool.Do(args...)
+	c.record(err)
+	return reply, err
+}
+
+// doConn sends a command on a connection taken from the pool and counts
+// failures
+func (c *RedisCache) doConn(conn *resp.Conn, args ...interface{}) (interface{}, error) {
+	reply, err := conn.Do(args...)
+	c.record(err)
+	return reply, err
+}
+
+// pipeline sends commands in one round trip and returns their replies. Error
+// replies are returned in place; the error is set when the round trip failed.
+func (c *RedisCache) pipeline(conn *resp.Conn, commands ...[]interface{}) ([]interface{}, error) {
+	for _, command := range commands {
+		if err := conn.Send(command...); err != nil {
+			c.record(err)
+			return nil, err
+		}
+	}
+	if err := conn.Flush(); err != nil {
+		c.record(err)
+		return nil, err
+	}
+
+	replies := make([]interface{}, len(commands))
+	var replyErr error
+	for i := range commands {
+		reply, err := conn.Receive()
+		if err != nil {
+			var serverErr resp.Error
+			if !errors.As(err, &serverErr) {
+				c.record(err)
+				return nil, err
+			}
+			reply = serverErr
+			if replyErr == nil {
+				replyErr = serverErr
+			}
+		}
+		replies[i] = reply
+	}
+	c.record(replyErr)
+	return replies, nil
+}
+
+// record counts a failed request and logs when the server becomes
+// unavailable and available again
+func (c *RedisCache) record(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err != nil {
+		c.stats.Errors++
+		if !c.failing {
+			c.failing = true
+			c.logger.Warn("Redis cache request failed, falling back to the database",
+				logger.NewField("error", err.Error()))
+		}
+		return
+	}
+	if c.failing {
+		c.failing = false
+		c.logger.Info("Redis cache requests succeed again")
+	}
+}
+
+// get decodes the value of key into value and reports whether it was found
+func (c *RedisCache) get(key string, value interface{}) bool {
+	data, err := resp.Bytes(c.do("GET", c.key(key)))
+	if err == nil {
+		err = decodeValue(data, value)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		c.stats.Misses++
+		return false
+	}
+	c.stats.Hits++
+	return true
+}
+
+// set stores value under key with a TTL
+func (c *RedisCache) set(key string, value interface{}, ttl time.Duration) {
+	data, err := encodeValue(value)
+	if err != nil {
+		c.record(err)
+		return
+	}
+	if _, err := c.do("SET", c.key(key), data, "PX", ttlMillis(ttl)); err != nil {
+		return
+	}
+
+	c.mu.Lock()
+	c.stats.Sets++
+	c.mu.Unlock()
+}
+
+// delete removes keys, given without the prefix
+func (c *RedisCache) delete(keys ...string) {
+	args := make([]interface{}, 0, len(keys)+1)
+	args = append(args, "DEL")
+	for _, key := range keys {
+		args = append(args, c.key(key))
+	}
+	c.deleteKeys(args)
+}
+
+// deleteKeys sends a DEL command with server keys and counts the deletions
+func (c *RedisCache) deleteKeys(args []interface{}) {
+	c.countDeletes(resp.Int64(c.do(args...)))
+}
+
+// countDeletes counts the deletions of a DEL reply
+func (c *RedisCache) countDeletes(deleted int64, err error) {
+	if err != nil {
+		return
+	}
+
+	c.mu.Lock()
+	c.stats.Deletes += deleted
+	c.mu.Unlock()
+}
+
+// ttlMillis converts a TTL for PX, which requires a positive value
+func ttlMillis(ttl time.Duration) int64 {
+	if ms := ttl.Milliseconds(); ms > 0 {
+		return ms
+	}
+	return 1
+}
+
+// Banner operations
+
+// GetBanner retrieves a banner from cache
+func (c *RedisCache) GetBanner(id int) (*dto.Banner, bool) {
+	var banner dto.Banner
+	if !c.get(bannerKey(id), &banner) {
+		return nil, false
+	}
+	return &banner, true
+}
+
+// SetBanner stores a banner in cache
+func (c *RedisCache) SetBanner(banner *dto.Banner, ttl time.Duration) {
+	c.set(bannerKey(banner.ID), banner, ttl)
+}
+
+// DeleteBanner removes a banner and its not-found marker from cache
+func (c *RedisCache) DeleteBanner(id int) {
+	c.delete(bannerKey(id), missingBannerKey(id))
+}
+
+// InvalidateBanner invalidates banner and related data
+func (c *RedisCache) InvalidateBanner(id int) {
+	c.delete(bannerKey(id), missingBannerKey(id), clickStatsKey(id), bannerStatsKey(id))
+	c.InvalidateTopBanners()
+}
+
+// IsBannerMissing reports whether a banner is cached as not existing
+func (c *RedisCache) IsBannerMissing(id int) bool {
+	exists, err := resp.Int64(c.do("EXISTS", c.key(missingBannerKey(id))))
+	if err != nil || exists == 0 {
+		return false
+	}
+
+	c.mu.Lock()
+	c.stats.NegativeHits++
+	c.mu.Unlock()
+	return true
+}
+
+// SetBannerMissing caches that a banner does not exist
+func (c *RedisCache) SetBannerMissing(id int, ttl time.Duration) {
+	if _, err := c.do("SET", c.key(missingBannerKey(id)), "1", "PX", ttlMillis(ttl)); err != nil {
+		return
+	}
+
+	c.mu.Lock()
+	c.stats.NegativeSets++
+	c.mu.Unlock()
+}
+
+// Click statistics operations
+
+// GetClickStats retrieves click statistics from cache
+func (c *RedisCache) GetClickStats(bannerID int) (*db.ClickStats, bool) {
+	var stats db.ClickStats
+	if !c.get(clickStatsKey(bannerID), &stats) {
+		return nil, false
+	}
+	return &stats, true
+}
+
+// SetClickStats stores click statistics in cache
+func (c *RedisCache) SetClickStats(bannerID int, stats *db.ClickStats, ttl time.Duration) {
+	c.set(clickStatsKey(bannerID), stats, ttl)
+}
+
+// IncrementClickStats counts a newly recorded click in the cached statistics of
+// its banner, keeping the entry's expiry. Concurrent increments from other
+// instances are detected with WATCH; after repeated conflicts the entry is
+// dropped so that the next read reloads it from the database.
+func (c *RedisCache) IncrementClickStats(click *dto.Click) (*db.ClickStats, bool) {
+	key := c.key(clickStatsKey(click.BannerID))
+
+	conn, err := c.pool.Get()
+	if err != nil {
+		c.record(err)
+		return nil, false
+	}
+	defer c.pool.Put(conn)
+
+	for attempt := 0; attempt < maxIncrementAttempts; attempt++ {
+		replies, err := c.pipeline(conn,
+			[]interface{}{"WATCH", key},
+			[]interface{}{"GET", key},
+			[]interface{}{"PTTL", key})
+		if err != nil {
+			return nil, false
+		}
+
+		data, dataErr := resp.Bytes(replies[1], nil)
+		ttl, ttlErr := resp.Int64(replies[2], nil)
+		var cached db.ClickStats
+		if dataErr == nil {
+			dataErr = decodeValue(data, &cached)
+		}
+		if dataErr != nil || ttlErr != nil || ttl <= 0 {
+			c.pipeline(conn, []interface{}{"UNWATCH"})
+			return nil, false
+		}
+
+		stats := countClick(&cached, click)
+		encoded, err := encodeValue(stats)
+		if err != nil {
+			c.pipeline(conn, []interface{}{"UNWATCH"})
+			return nil, false
+		}
+
+		replies, err = c.pipeline(conn,
+			[]interface{}{"MULTI"},
+			[]interface{}{"SET", key, encoded, "PX", ttl},
+			[]interface{}{"EXEC"})
+		if err != nil {
+			return nil, false
+		}
+		if _, committed := replies[2].([]interface{}); committed {
+			c.mu.Lock()
+			c.stats.Hits++
+			c.mu.Unlock()
+			return stats, true
+		}
+		if _, failed := replies[2].(resp.Error); failed {
+			return nil, false
+		}
+		// A nil EXEC reply means the entry changed after WATCH
+	}
+
+	c.delete(clickStatsKey(click.BannerID))
+	return nil, false
+}
+
+// InvalidateClickStats removes click statistics from cache
+func (c *RedisCache) InvalidateClickStats(bannerID int) {
+	c.delete(clickStatsKey(bannerID))
+}
+
+// Banner with stats operations
+
+// GetBannerWithStats retrieves banner with stats from cache
+func (c *RedisCache) GetBannerWithStats(id int) (*db.BannerWithStats, bool) {
+	var stats db.BannerWithStats
+	if !c.get(bannerStatsKey(id), &stats) {
+		return nil, false
+	}
+	return &stats, true
+}
+
+// SetBannerWithStats stores banner with stats in cache
+func (c *RedisCache) SetBannerWithStats(id int, stats *db.BannerWithStats, ttl time.Duration) {
+	c.set(bannerStatsKey(id), stats, ttl)
+}
+
+// InvalidateBannerWithStats removes banner with stats from cache
+func (c *RedisCache) InvalidateBannerWithStats(id int) {
+	c.delete(bannerStatsKey(id))
+}
+
+// Top banners operations
+
+// GetTopBanners retrieves top banners from cache
+func (c *RedisCache) GetTopBanners(limit int) ([]*db.BannerClickCount, bool) {
+	var banners []*db.BannerClickCount
+	if !c.get(topBannersKey(limit), &banners) {
+		return nil, false
+	}
+	return banners, true
+}
+
+// SetTopBanners stores top banners in cache and tags the key for invalidation
+func (c *RedisCache) SetTopBanners(limit int, banners []*db.BannerClickCount, ttl time.Duration) {
+	data, err := encodeValue(banners)
+	if err != nil {
+		c.record(err)
+		return
+	}
+
+	conn, err := c.pool.Get()
+	if err != nil {
+		c.record(err)
+		return
+	}
+	defer c.pool.Put(conn)
+
+	key := c.key(topBannersKey(limit))
+	replies, err := c.pipeline(conn,
+		[]interface{}{"SET", key, data, "PX", ttlMillis(ttl)},
+		[]interface{}{"SADD", c.key(topBannersTag), key})
+	if err != nil {
+		return
+	}
+	if _, failed := replies[0].(resp.Error); failed {
+		return
+	}
+
+	c.mu.Lock()
+	c.stats.Sets++
+	c.mu.Unlock()
+}
+
+// InvalidateTopBanners removes all top banners keys. The tag set is renamed
+// first, so keys tagged meanwhile land in a new set and are not lost.
+func (c *RedisCache) InvalidateTopBanners() {
+	var nonce [8]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		c.record(err)
+		return
+	}
+	tag := c.key(topBannersTag)
+	claimed := tag + ":" + hex.EncodeToString(nonce[:])
+
+	if _, err := c.pool.Do("RENAME", tag, claimed); err != nil {
+		// Nothing is tagged when the set does not exist
+		var serverErr resp.Error
+		if !errors.As(err, &serverErr) || !strings.Contains(string(serverErr), "no such key") {
+			c.record(err)
+		}
+		return
+	}
+
+	keys, err := resp.Strings(c.do("SMEMBERS", claimed))
+	if err != nil {
+		c.do("DEL", claimed)
+		return
+	}
+
+	args := make([]interface{}, 0, len(keys)+2)
+	args = append(args, "DEL")
+	for _, key := range keys {
+		args = append(args, key)
+	}
+	c.deleteKeys(args)
+	c.do("DEL", claimed)
+}
+
+// Cache management
+
+// Clear removes all keys under the prefix
+func (c *RedisCache) Clear() {
+	c.scan(func(conn *resp.Conn, keys []string) {
+		args := make([]interface{}, 0, len(keys)+1)
+		args = append(args, "DEL")
+		for _, key := range keys {
+			args = append(args, key)
+		}
+		c.countDeletes(resp.Int64(c.doConn(conn, args...)))
+	})
+}
+
+// Size returns the number of keys under the prefix. It scans the whole key
+// space, so Stats does not call it.
+func (c *RedisCache) Size() int {
+	size := 0
+	c.scan(func(_ *resp.Conn, keys []string) {
+		size += len(keys)
+	})
+	return size
+}
+
+// TTL returns the remaining time to live of a live key, given without the
+// prefix
+func (c *RedisCache) TTL(key string) (time.Duration, bool) {
+	ttl, err := resp.Int64(c.do("PTTL", c.key(key)))
+	// -2 means the key does not exist, -1 that it has no expiry
+	if err != nil || ttl == -2 {
+		return 0, false
+	}
+	if ttl < 0 {
+		return -1, true
+	}
+	return time.Duration(ttl) * time.Millisecond, true
+}
+
+// Inspect lists up to limit live keys matching a glob pattern, with the
+// prefix removed, in the order the server returns them. A limit of zero lists
+// all matching keys. SCAN may return a key more than once; it is listed once.
+func (c *RedisCache) Inspect(pattern string, limit int) ([]KeyInfo, error) {
+	if err := checkPattern(pattern); err != nil {
+		return nil, err
+	}
+
+	conn, err := c.pool.Get()
+	if err != nil {
+		c.record(err)
+		return nil, err
+	}
+	defer c.pool.Put(conn)
+
+	keys := []KeyInfo{}
+	seen := make(map[string]bool)
+	var inspectErr error
+	err = c.scanMatch(conn, escapeGlob(c.prefix)+pattern, func(scanned []string) bool {
+		var batch []string
+		for _, key := range scanned {
+			if !seen[key] {
+				seen[key] = true
+				batch = append(batch, key)
+			}
+		}
+		if len(batch) == 0 {
+			return true
+		}
+
+		commands := make([][]interface{}, len(batch))
+		for i, key := range batch {
+			commands[i] = []interface{}{"PTTL", key}
+		}
+		replies, err := c.pipeline(conn, commands...)
+		if err != nil {
+			inspectErr = err
+			return false
+		}
+
+		for i, key := range batch {
+			ttl, ok := replies[i].(int64)
+			// -2 means the key expired since it was scanned
+			if !ok || ttl == -2 {
+				continue
+			}
+			keys = append(keys, KeyInfo{Key: strings.TrimPrefix(key, c.prefix), TTLMillis: ttl})
+			if limit > 0 && len(keys) == limit {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if inspectErr != nil {
+		return nil, inspectErr
+	}
+	return keys, nil
+}
+
+// scan calls fn with batches of the server keys under the prefix, and the
+// connection scanning them for further commands
+func (c *RedisCache) scan(fn func(conn *resp.Conn, keys []string)) {
+	conn, err := c.pool.Get()
+	if err != nil {
+		c.record(err)
+		return
+	}
+	defer c.pool.Put(conn)
+
+	c.scanMatch(conn, escapeGlob(c.prefix)+"*", func(keys []string) bool {
+		fn(conn, keys)
+		return true
+	})
+}
+
+// scanMatch calls fn with batches of the server keys matching a SCAN MATCH
+// pattern, scanned on conn, until fn returns false
+func (c *RedisCache) scanMatch(conn *resp.Conn, pattern string, fn func(keys []string) bool) error {
+	cursor := "0"
+	for {
+		reply, err := c.doConn(conn, "SCAN", cursor, "MATCH", pattern, "COUNT", scanCount)
+		if err != nil {
+			return err
+		}
+		parts, ok := reply.([]interface{})
+		if !ok || len(parts) != 2 {
+			err := fmt.Errorf("unexpected SCAN reply %T", reply)
+			c.record(err)
+			return err
+		}
+		next, err := resp.Bytes(parts[0], nil)
+		if err != nil {
+			c.record(err)
+			return err
+		}
+		keys, err := resp.Strings(parts[1], nil)
+		if err != nil {
+			c.record(err)
+			return err
+		}
+
+		if len(keys) > 0 && !fn(keys) {
+			return nil
+		}
+		cursor = string(next)
+		if cursor == "0" {
+			return nil
+		}
+	}
+}
+
+// escapeGlob escapes the characters SCAN MATCH patterns treat specially
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Stats returns cache performance statistics. Without a key prefix the cache
+// owns the database and its size is read with DBSIZE; a prefixed cache shares
+// the database and reports its size as unknown, since counting the keys under
+// the prefix takes a full scan.
+func (c *RedisCache) Stats() CacheStats {
+	size := -1
+	if c.prefix == "" {
+		if n, err := resp.Int64(c.do("DBSIZE")); err == nil {
+			size = int(n)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Size = size
+	return stats
+}
+
+// Stop closes the connections to the server
+func (c *RedisCache) Stop() {
+	c.pool.Close()
+}
@@ -0,0 +1,305 @@
+package cache
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tyagnii/ecom_test/db"
+	"github.com/tyagnii/ecom_test/dto"
+	"github.com/tyagnii/ecom_test/logger"
+	"github.com/tyagnii/ecom_test/resp/resptest"
+)
+
+// newTestRedisCache returns a cache with the given key prefix backed by a fake
+// server
+func newTestRedisCache(t *testing.T, prefix string) (*RedisCache, *resptest.Server) {
+	t.Helper()
+	return newTestRedisCacheWithConns(t, prefix, 4)
+}
+
+// newTestRedisCacheWithConns returns a cache opening at most maxConns
+// connections to a fake server
+func newTestRedisCacheWithConns(t *testing.T, prefix string, maxConns int) (*RedisCache, *resptest.Server) {
+	t.Helper()
+	server, err := resptest.NewServer()
+	if err != nil {
+		t.Fatalf("failed to start fake server: %v", err)
+	}
+	t.Cleanup(server.Close)
+
+	c, err := NewRedisCache(RedisOptions{
+		Addr:      server.Addr,
+		KeyPrefix: prefix,
+		PoolSize:  2,
+		MaxConns:  maxConns,
+		Timeout:   time.Second,
+	}, logger.NewStructuredLogger(logger.WARN, io.Discard))
+	if err != nil {
+		t.Fatalf("NewRedisCache: %v", err)
+	}
+	t.Cleanup(c.Stop)
+	return c, server
+}
+
+func TestRedisCacheBanner(t *testing.T) {
+	c, server := newTestRedisCache(t, "test:")
+
+	if _, found := c.GetBanner(1); found {
+		t.Fatal("GetBanner found a banner that was never cached")
+	}
+
+	banner := &dto.Banner{ID: 1, Name: "spring sale", CreatedAt: time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)}
+	c.SetBanner(banner, time.Minute)
+	got, found := c.GetBanner(1)
+	if !found || got.Name != banner.Name || !got.CreatedAt.Equal(banner.CreatedAt) {
+		t.Fatalf("GetBanner = %+v, %v, want %+v", got, found, banner)
+	}
+	if ttl := server.TTL("test:" + bannerKey(1)); ttl <= 0 || ttl > time.Minute {
+		t.Errorf("banner stored with TTL %v, want up to a minute", ttl)
+	}
+
+	c.SetBannerMissing(2, time.Minute)
+	if !c.IsBannerMissing(2) || c.IsBannerMissing(1) {
+		t.Error("IsBannerMissing does not reflect the not-found marker")
+	}
+
+	c.InvalidateBanner(2)
+	if c.IsBannerMissing(2) {
+		t.Error("InvalidateBanner kept the not-found marker")
+	}
+
+	stats := c.Stats()
+	if stats.Hits != 1 || stats.Misses != 1 || stats.Sets != 1 || stats.NegativeSets != 1 || stats.NegativeHits != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestRedisCacheIncrementClickStats(t *testing.T) {
+	base := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	cached := &db.ClickStats{BannerID: 1, TotalClicks: 2, NetClicks: 2, FirstClick: base, LastClick: base}
+	click := &dto.Click{BannerID: 1, Timestamp: base.Add(time.Hour)}
+	want := db.ClickStats{BannerID: 1, TotalClicks: 3, NetClicks: 3, FirstClick: base, LastClick: base.Add(time.Hour)}
+
+	t.Run("miss", func(t *testing.T) {
+		c, server := newTestRedisCache(t, "")
+		if _, found := c.IncrementClickStats(click); found {
+			t.Fatal("IncrementClickStats found statistics that were never cached")
+		}
+		if n := server.Count("EXEC"); n != 0 {
+			t.Errorf("miss ran %d transactions", n)
+		}
+	})
+
+	t.Run("committed", func(t *testing.T) {
+		c, server := newTestRedisCache(t, "")
+		c.SetClickStats(1, cached, time.Minute)
+
+		stats, found := c.IncrementClickStats(click)
+		if !found || *stats != want {
+			t.Fatalf("IncrementClickStats = %+v, %v, want %+v", stats, found, want)
+		}
+		if got, _ := c.GetClickStats(1); *got != want {
+			t.Errorf("stored statistics = %+v, want %+v", *got, want)
+		}
+		if ttl := server.TTL(clickStatsKey(1)); ttl <= 50*time.Second {
+			t.Errorf("increment did not keep the expiry, TTL %v", ttl)
+		}
+	})
+
+	t.Run("retried after a conflict", func(t *testing.T) {
+		c, server := newTestRedisCache(t, "")
+		c.SetClickStats(1, cached, time.Minute)
+
+		// Another instance increments the entry between WATCH and EXEC once
+		conflicts := 1
+		server.SetBeforeExec(func(s *resptest.Server) {
+			if conflicts > 0 {
+				conflicts--
+				data, _ := s.Get(clickStatsKey(1))
+				s.Set(clickStatsKey(1), data, time.Minute)
+			}
+		})
+
+		stats, found := c.IncrementClickStats(click)
+		if !found || *stats != want {
+			t.Fatalf("IncrementClickStats = %+v, %v, want %+v", stats, found, want)
+		}
+		if n := server.Count("EXEC"); n != 2 {
+			t.Errorf("ran %d transactions, want 2", n)
+		}
+	})
+
+	t.Run("dropped after repeated conflicts", func(t *testing.T) {
+		c, server := newTestRedisCache(t, "")
+		c.SetClickStats(1, cached, time.Minute)
+
+		server.SetBeforeExec(func(s *resptest.Server) {
+			data, _ := s.Get(clickStatsKey(1))
+			s.Set(clickStatsKey(1), data, time.Minute)
+		})
+
+		if _, found := c.IncrementClickStats(click); found {
+			t.Fatal("IncrementClickStats reported an increment that never committed")
+		}
+		if n := server.Count("EXEC"); n != maxIncrementAttempts {
+			t.Errorf("ran %d transactions, want %d", n, maxIncrementAttempts)
+		}
+		if _, found := c.GetClickStats(1); found {
+			t.Error("entry kept after repeated conflicts")
+		}
+	})
+}
+
+func TestRedisCacheTopBanners(t *testing.T) {
+	c, server := newTestRedisCache(t, "test:")
+
+	top := []*db.BannerClickCount{{BannerID: 1, ClickCount: 10}}
+	c.SetTopBanners(5, top, time.Minute)
+	c.SetTopBanners(10, top, time.Minute)
+	server.Set("test:unrelated", []byte("1"), 0)
+
+	if got, found := c.GetTopBanners(5); !found || !reflect.DeepEqual(got, top) {
+		t.Fatalf("GetTopBanners = %v, %v", got, found)
+	}
+
+	c.InvalidateTopBanners()
+	if got, want := server.Keys(), []string{"test:unrelated"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("keys after InvalidateTopBanners = %v, want %v", got, want)
+	}
+
+	// Invalidating without tagged keys is not an error
+	c.InvalidateTopBanners()
+	if n := c.Stats().Errors; n != 0 {
+		t.Errorf("%d errors counted", n)
+	}
+}
+
+func TestRedisCacheClear(t *testing.T) {
+	c, server := newTestRedisCache(t, "test:")
+
+	for id := 1; id <= 2500; id++ {
+		c.SetBanner(&dto.Banner{ID: id}, time.Minute)
+	}
+	server.Set("other:banner:1", []byte("1"), 0)
+
+	c.Clear()
+	if got, want := server.Keys(), []string{"other:banner:1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("keys after Clear = %v, want %v", got, want)
+	}
+	if size := c.Size(); size != 0 {
+		t.Errorf("Size after Clear = %d, want 0", size)
+	}
+}
+
+func TestRedisCacheStatsSize(t *testing.T) {
+	t.Run("unprefixed", func(t *testing.T) {
+		c, server := newTestRedisCache(t, "")
+		c.SetBanner(&dto.Banner{ID: 1}, time.Minute)
+		c.SetBanner(&dto.Banner{ID: 2}, time.Minute)
+
+		if size := c.Stats().Size; size != 2 {
+			t.Errorf("Size = %d, want 2", size)
+		}
+		if n := server.Count("SCAN"); n != 0 {
+			t.Errorf("Stats ran %d scans", n)
+		}
+	})
+
+	t.Run("prefixed", func(t *testing.T) {
+		c, server := newTestRedisCache(t, "test:")
+		c.SetBanner(&dto.Banner{ID: 1}, time.Minute)
+
+		if size := c.Stats().Size; size != -1 {
+			t.Errorf("Size = %d, want -1 for unknown", size)
+		}
+		if n := server.Count("SCAN") + server.Count("DBSIZE"); n != 0 {
+			t.Errorf("Stats of a prefixed cache sent %d SCAN or DBSIZE commands", n)
+		}
+	})
+}
+
+func TestRedisCacheUnavailable(t *testing.T) {
+	c, server := newTestRedisCache(t, "")
+	c.SetBanner(&dto.Banner{ID: 1}, time.Minute)
+	server.Close()
+
+	if _, found := c.GetBanner(1); found {
+		t.Fatal("GetBanner found a banner on an unavailable server")
+	}
+	c.SetBanner(&dto.Banner{ID: 2}, time.Minute)
+	c.InvalidateBanner(1)
+
+	stats := c.Stats()
+	if stats.Errors == 0 || stats.Misses != 1 {
+		t.Errorf("stats on an unavailable server = %+v, want errors and a miss", stats)
+	}
+}
+
+func TestRedisCacheInspect(t *testing.T) {
+	// Scanning and reading TTLs must share the one connection
+	c, server := newTestRedisCacheWithConns(t, "test:", 1)
+	for id := 1; id <= 1500; id++ {
+		c.SetBanner(&dto.Banner{ID: id}, time.Minute)
+	}
+	c.SetTopBanners(5, nil, time.Minute)
+	server.Set("other:banner:1", []byte("1"), 0)
+	server.Set("test:a/b", []byte("1"), 0)
+
+	keys, err := c.Inspect("banner:1?", 0)
+	if err != nil {
+		t.Fatalf("Inspect: %v", err)
+	}
+	if len(keys) != 10 {
+		t.Errorf("Inspect listed %d keys, want 10", len(keys))
+	}
+	for _, key := range keys {
+		if key.TTLMillis <= 0 || key.TTLMillis > time.Minute.Milliseconds() {
+			t.Errorf("key %s has TTL %dms", key.Key, key.TTLMillis)
+		}
+	}
+
+	keys, err = c.Inspect("banner:*", 100)
+	if err != nil || len(keys) != 100 {
+		t.Errorf("Inspect with a limit listed %d keys, %v, want 100", len(keys), err)
+	}
+
+	// * crosses / as on Redis, and keys without expiry report -1
+	keys, err = c.Inspect("a*", 0)
+	if want := []KeyInfo{{Key: "a/b", TTLMillis: -1}}; err != nil || !reflect.DeepEqual(keys, want) {
+		t.Errorf("Inspect(a*) = %v, %v, want %v", keys, err, want)
+	}
+
+	if _, err := c.Inspect("banner:[", 0); !errors.Is(err, ErrInvalidPattern) {
+		t.Errorf("Inspect with a malformed pattern = %v, want ErrInvalidPattern", err)
+	}
+}
+
+func TestRedisCacheInspectDuplicates(t *testing.T) {
+	c, server := newTestRedisCache(t, "test:")
+	for _, key := range []string{"a", "b", "c"} {
+		server.Set("test:"+key, []byte("1"), 0)
+	}
+
+	// SCAN returns keys again when the keyspace is rehashed during a scan
+	server.SetHandler(func(args []string) (string, bool) {
+		if strings.ToUpper(args[0]) != "SCAN" {
+			return "", false
+		}
+		if args[1] == "0" {
+			return "*2\r\n$1\r\n1\r\n*2\r\n$6\r\ntest:a\r\n$6\r\ntest:b\r\n", true
+		}
+		return "*2\r\n$1\r\n0\r\n*3\r\n$6\r\ntest:b\r\n$6\r\ntest:a\r\n$6\r\ntest:c\r\n", true
+	})
+
+	want := []KeyInfo{{Key: "a", TTLMillis: -1}, {Key: "b", TTLMillis: -1}, {Key: "c", TTLMillis: -1}}
+	if keys, err := c.Inspect("*", 0); err != nil || !reflect.DeepEqual(keys, want) {
+		t.Errorf("Inspect = %v, %v, want %v", keys, err, want)
+	}
+	if keys, err := c.Inspect("*", 3); err != nil || !reflect.DeepEqual(keys, want) {
+		t.Errorf("Inspect with a limit = %v, %v, want %v", keys, err, want)
+	}
+}
@@ -0,0 +1,396 @@
+package cache
+
+import (
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TTLPolicy configures how long entries of one kind are cached
+type TTLPolicy struct {
+	// TTL is how long an entry is fresh
+	TTL time.Duration
+	// Jitter spreads the TTL of each entry randomly by up to this fraction in
+	// either direction, so entries cached together do not expire together
+	Jitter float64
+	// StaleWindow is how long an entry is served after it went stale while it
+	// is refreshed in the background; zero disables revalidation
+	StaleWindow time.Duration
+}
+
+// Policies holds the TTL policy of each kind of entry cached by a
+// CachedRepository
+type Policies struct {
+	Banner     TTLPolicy
+	ClickStats TTLPolicy
+	TopBanners TTLPolicy
+	// MissingBannerTTL is how long a banner that was not found is cached as
+	// not existing; zero disables negative caching
+	MissingBannerTTL time.Duration
+}
+
+// DefaultPolicies returns the default TTL policies
+func DefaultPolicies() Policies {
+	return Policies{
+		Banner:     TTLPolicy{TTL: DefaultBannerTTL, Jitter: 0.1, StaleWindow: time.Minute},
+		ClickStats: TTLPolicy{TTL: DefaultClickStatsTTL, Jitter: 0.1, StaleWindow: 30 * time.Second},
+		TopBanners: TTLPolicy{TTL: DefaultTopBannersTTL, Jitter: 0.1, StaleWindow: 30 * time.Second},
+
+		MissingBannerTTL: DefaultMissingBannerTTL,
+	}
+}
+
+// jittered returns the TTL spread by the jitter
+func (p TTLPolicy) jittered() time.Duration {
+	if p.Jitter <= 0 {
+		return p.TTL
+	}
+	ttl := p.TTL + time.Duration((rand.Float64()*2-1)*p.Jitter*float64(p.TTL))
+	if ttl <= 0 {
+		return p.TTL
+	}
+	return ttl
+}
+
+// RevalidationStats provides stale-while-revalidate metrics
+type RevalidationStats struct {
+	Stale     int64 `json:"stale"`
+	Refreshes int64 `json:"refreshes"`
+	Failures  int64 `json:"failures"`
+	// Dropped counts refreshes skipped because the refresh queue was full
+	Dropped int64 `json:"dropped"`
+	// Superseded counts loads whose result was discarded because the entry
+	// was invalidated while they ran
+	Superseded int64 `json:"superseded"`
+}
+
+// Background refreshes run on a fixed number of workers; stale entries beyond
+// the queue are served until a later read finds room
+const (
+	revalidateWorkers = 4
+	revalidateQueue   = 256
+)
+
+// freshnessSweepInterval is how often records of expired entries are pruned
+const freshnessSweepInterval = time.Minute
+
+// freshEntry records when a cached entry goes stale and expires
+type freshEntry struct {
+	staleAt    time.Time
+	expiresAt  time.Time
+	refreshing bool
+}
+
+// pendingLoad is a load from the database in progress. It is superseded when
+// the entry it loads is invalidated before its result is cached.
+type pendingLoad struct {
+	refs       int
+	superseded bool
+}
+
+// freshness tracks when the entries cached by this instance go stale, and the
+// loads of entries in progress. Entries it has no record of, such as ones
+// cached by another instance in a shared cache, count as fresh until they
+// expire.
+type freshness struct {
+	mu        sync.Mutex
+	entries   map[string]*freshEntry
+	loads     map[string]*pendingLoad
+	nextSweep time.Time
+	stats     RevalidationStats
+}
+
+func newFreshness() *freshness {
+	return &freshness{
+		entries: make(map[string]*freshEntry),
+		loads:   make(map[string]*pendingLoad),
+	}
+}
+
+// set records when the entry for key goes stale and expires, and prunes the
+// records of expired entries now and then
+func (f *freshness) set(key string, staleAt, expiresAt time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.entries[key] = &freshEntry{staleAt: staleAt, expiresAt: expiresAt}
+
+	now := time.Now()
+	if now.Before(f.nextSweep) {
+		return
+	}
+	f.nextSweep = now.Add(freshnessSweepInterval)
+	for key, entry := range f.entries {
+		if !entry.refreshing && now.After(entry.expiresAt) {
+			delete(f.entries, key)
+		}
+	}
+}
+
+// claimStale reports whether the entry for key is stale and not already being
+// refreshed, and marks it as being refreshed
+func (f *freshness) claimStale(key string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	entry, exists := f.entries[key]
+	if !exists || entry.refreshing {
+		return false
+	}
+	now := time.Now()
+	if now.After(entry.expiresAt) {
+		delete(f.entries, key)
+		return false
+	}
+	if now.Before(entry.staleAt) {
+		return false
+	}
+	entry.refreshing = true
+	f.stats.Stale++
+	return true
+}
+
+// done records the outcome of a refresh; after a failure the next read
+// retries it
+func (f *freshness) done(key string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.stats.Refreshes++
+	if err == nil {
+		return
+	}
+	f.stats.Failures++
+	if entry, exists := f.entries[key]; exists {
+		entry.refreshing = false
+	}
+}
+
+// drop records a refresh that could not be queued; the next read retries it
+func (f *freshness) drop(key string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.stats.Dropped++
+	if entry, exists := f.entries[key]; exists {
+		entry.refreshing = false
+	}
+}
+
+// begin records the start of a load of the entry for key. It must be called
+// before the database is read.
+func (f *freshness) begin(key string) *pendingLoad {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	load, exists := f.loads[key]
+	if !exists {
+		load = &pendingLoad{}
+		f.loads[key] = load
+	}
+	load.refs++
+	return load
+}
+
+// end records the end of a load after its result was cached, and reports
+// whether the entry was invalidated in the meantime; the caller then removes
+// what it cached.
+func (f *freshness) end(key string, load *pendingLoad) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	load.refs--
+	if load.refs == 0 && f.loads[key] == load {
+		delete(f.loads, key)
+	}
+	if !load.superseded {
+		return false
+	}
+	delete(f.entries, key)
+	f.stats.Superseded++
+	return true
+}
+
+// supersede marks the loads in progress of the given keys as superseded. It
+// must be called before the cached entries are changed, so that a load caching
+// its result afterwards notices.
+func (f *freshness) supersede(keys ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, key := range keys {
+		if load, exists := f.loads[key]; exists {
+			load.superseded = true
+		}
+	}
+}
+
+// invalidate supersedes the loads of the given keys and forgets their entries
+func (f *freshness) invalidate(keys ...string) {
+	f.supersede(keys...)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, key := range keys {
+		delete(f.entries, key)
+	}
+}
+
+// invalidatePrefix invalidates every key with the prefix
+func (f *freshness) invalidatePrefix(prefix string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for key, load := range f.loads {
+		if strings.HasPrefix(key, prefix) {
+			load.superseded = true
+		}
+	}
+	for key := range f.entries {
+		if strings.HasPrefix(key, prefix) {
+			delete(f.entries, key)
+		}
+	}
+}
+
+// invalidateAll invalidates every key. A nil freshness ignores invalidations.
+func (f *freshness) invalidateAll() {
+	if f == nil {
+		return
+	}
+	f.invalidatePrefix("")
+}
+
+// invalidateBanner invalidates the entries Cache.InvalidateBanner removes. A
+// nil freshness ignores invalidations.
+func (f *freshness) invalidateBanner(id int) {
+	if f == nil {
+		return
+	}
+	f.invalidate(bannerKey(id), clickStatsKey(id))
+	f.invalidatePrefix(topBannersKeyPrefix)
+}
+
+// invalidateClicks invalidates the entries derived from the clicks of a banner
+func (f *freshness) invalidateClicks(bannerID int) {
+	f.invalidate(clickStatsKey(bannerID))
+	f.invalidatePrefix(topBannersKeyPrefix)
+}
+
+// Stats returns the revalidation statistics
+func (f *freshness) Stats() RevalidationStats {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.stats
+}
+
+// refresher runs background refreshes on a bounded number of workers, started
+// with the first refresh
+type refresher struct {
+	workers int
+	jobs    chan func()
+	start   sync.Once
+
+	mu       sync.Mutex
+	stopped  bool
+	stopChan chan struct{}
+	wg       sync.WaitGroup
+}
+
+func newRefresher(workers, queue int) *refresher {
+	return &refresher{
+		workers:  workers,
+		jobs:     make(chan func(), queue),
+		stopChan: make(chan struct{}),
+	}
+}
+
+// submit queues a refresh and reports whether it was queued; it is not when
+// the queue is full or the refresher stopped
+func (p *refresher) submit(job func()) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stopped {
+		return false
+	}
+	p.start.Do(func() {
+		for i := 0; i < p.workers; i++ {
+			p.wg.Add(1)
+			go p.run()
+		}
+	})
+
+	select {
+	case p.jobs <- job:
+		return true
+	default:
+		return false
+	}
+}
+
+// run runs queued refreshes until the refresher stops
+func (p *refresher) run() {
+	defer p.wg.Done()
+	for {
+		select {
+		case job := <-p.jobs:
+			job()
+		case <-p.stopChan:
+			return
+		}
+	}
+}
+
+// Stop stops the workers after the refreshes they are running; queued
+// refreshes are discarded
+func (p *refresher) Stop() {
+	p.mu.Lock()
+	if p.stopped {
+		p.mu.Unlock()
+		return
+	}
+	p.stopped = true
+	close(p.stopChan)
+	p.mu.Unlock()
+
+	p.wg.Wait()
+}
+
+// flightCall is a load in progress
+type flightCall struct {
+	wg    sync.WaitGroup
+	value interface{}
+	err   error
+}
+
+// flightGroup runs one load per key at a time; concurrent callers for the
+// same key wait for and share its result
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+// Do runs load for key unless a load for key is in progress, and returns its
+// result
+func (g *flightGroup) Do(key string, load func() (interface{}, error)) (interface{}, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+	if call, exists := g.calls[key]; exists {
+		g.mu.Unlock()
+		call.wg.Wait()
+		return call.value, call.err
+	}
+	call := &flightCall{}
+	call.wg.Add(1)
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	call.value, call.err = load()
+	call.wg.Done()
+
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+
+	return call.value, call.err
+}
@@ -0,0 +1,120 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFreshnessSupersedesLoads(t *testing.T) {
+	f := newFreshness()
+	key := bannerKey(1)
+
+	load := f.begin(key)
+	if f.end(key, load) {
+		t.Error("load without invalidation reported as superseded")
+	}
+
+	load = f.begin(key)
+	f.invalidateBanner(1)
+	if !f.end(key, load) {
+		t.Error("load invalidated while it ran not reported as superseded")
+	}
+
+	// Invalidations after the load ended do not affect the next one
+	load = f.begin(key)
+	if f.end(key, load) {
+		t.Error("new load reported as superseded")
+	}
+	if len(f.loads) != 0 {
+		t.Errorf("%d loads left after they ended", len(f.loads))
+	}
+
+	load = f.begin(topBannersKey(10))
+	f.invalidateClicks(3)
+	if !f.end(topBannersKey(10), load) {
+		t.Error("top banners load not superseded by a click invalidation")
+	}
+	if stats := f.Stats(); stats.Superseded != 2 {
+		t.Errorf("Superseded = %d, want 2", stats.Superseded)
+	}
+}
+
+func TestFreshnessPrunesEntries(t *testing.T) {
+	f := newFreshness()
+	now := time.Now()
+
+	f.set(bannerKey(1), now.Add(-time.Minute), now.Add(-time.Second))
+	if f.claimStale(bannerKey(1)) {
+		t.Error("expired entry claimed as stale")
+	}
+	if _, exists := f.entries[bannerKey(1)]; exists {
+		t.Error("expired entry kept after it was read")
+	}
+
+	// Entries that are never read again are pruned by a later sweep
+	f.set(bannerKey(2), now.Add(-time.Minute), now.Add(-time.Second))
+	f.nextSweep = time.Time{}
+	f.set(bannerKey(3), now.Add(time.Minute), now.Add(2*time.Minute))
+	if _, exists := f.entries[bannerKey(2)]; exists {
+		t.Error("expired entry kept after a sweep")
+	}
+
+	f.set(clickStatsKey(3), now, now.Add(time.Minute))
+	f.set(topBannersKey(5), now, now.Add(time.Minute))
+	f.invalidateBanner(3)
+	if len(f.entries) != 0 {
+		t.Errorf("entries left after invalidation: %v", f.entries)
+	}
+}
+
+func TestFreshnessClaimsStaleOnce(t *testing.T) {
+	f := newFreshness()
+	now := time.Now()
+	f.set(bannerKey(1), now.Add(-time.Second), now.Add(time.Minute))
+
+	if !f.claimStale(bannerKey(1)) {
+		t.Fatal("stale entry not claimed")
+	}
+	if f.claimStale(bannerKey(1)) {
+		t.Error("entry claimed twice while refreshing")
+	}
+	f.drop(bannerKey(1))
+	if !f.claimStale(bannerKey(1)) {
+		t.Error("dropped refresh not claimed again")
+	}
+	if stats := f.Stats(); stats.Stale != 2 || stats.Dropped != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestRefresherBounded(t *testing.T) {
+	p := newRefresher(1, 1)
+
+	running := make(chan struct{})
+	release := make(chan struct{})
+	if !p.submit(func() { close(running); <-release }) {
+		t.Fatal("first refresh not queued")
+	}
+	<-running
+
+	ran := make(chan struct{})
+	if !p.submit(func() { close(ran) }) {
+		t.Fatal("refresh not queued while the queue has room")
+	}
+	if p.submit(func() {}) {
+		t.Error("refresh queued beyond the queue size")
+	}
+
+	close(release)
+	select {
+	case <-ran:
+	case <-time.After(time.Second):
+		t.Fatal("queued refresh did not run")
+	}
+
+	p.Stop()
+	if p.submit(func() {}) {
+		t.Error("refresh queued after Stop")
+	}
+	p.Stop()
+}
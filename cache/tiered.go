@@ -0,0 +1,329 @@
+package cache
+
+import (
+	"sync"
+	"time"
+
+	"github.com/tyagnii/ecom_test/db"
+	"github.com/tyagnii/ecom_test/dto"
+)
+
+// Cache tiers
+const (
+	BackendTiered = "tiered"
+
+	tierL1 = "l1"
+	tierL2 = "l2"
+)
+
+// DefaultL1TTL is the TTL of the local tier of a tiered cache
+const DefaultL1TTL = 5 * time.Second
+
+// TieredCache chains a local L1 cache in front of a shared L2 cache. Reads
+// try L1 first and populate it from L2; writes and invalidations go to both.
+// L1 entries live at most l1TTL, which bounds how long an instance serves a
+// value another instance has changed in L2, and never longer than the L2
+// entry they copy.
+type TieredCache struct {
+	l1    Cache
+	l2    Cache
+	l1TTL time.Duration
+
+	mu    sync.Mutex
+	stats CacheStats
+}
+
+// NewTieredCache creates a tiered cache keeping L1 entries at most l1TTL
+func NewTieredCache(l1, l2 Cache, l1TTL time.Duration) *TieredCache {
+	if l1TTL <= 0 {
+		l1TTL = DefaultL1TTL
+	}
+	return &TieredCache{l1: l1, l2: l2, l1TTL: l1TTL}
+}
+
+// L1 returns the local tier
+func (c *TieredCache) L1() Cache {
+	return c.l1
+}
+
+// localTTL caps a TTL at the L1 TTL
+func (c *TieredCache) localTTL(ttl time.Duration) time.Duration {
+	if ttl <= 0 || ttl > c.l1TTL {
+		return c.l1TTL
+	}
+	return ttl
+}
+
+// populateTTL returns the TTL of an L1 copy of the L2 entry under key: the
+// remaining L2 TTL capped at the L1 TTL. It reports false if the entry has
+// left L2 since it was read.
+func (c *TieredCache) populateTTL(key string) (time.Duration, bool) {
+	remaining, ok := c.l2.TTL(key)
+	if !ok || remaining == 0 {
+		return 0, false
+	}
+	return c.localTTL(remaining), true
+}
+
+// count records whether a read was served by either tier
+func (c *TieredCache) count(found bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if found {
+		c.stats.Hits++
+	} else {
+		c.stats.Misses++
+	}
+}
+
+// countSet records a write-through set
+func (c *TieredCache) countSet() {
+	c.mu.Lock()
+	c.stats.Sets++
+	c.mu.Unlock()
+}
+
+// Banner operations
+
+// GetBanner retrieves a banner from L1, or from L2 populating L1
+func (c *TieredCache) GetBanner(id int) (*dto.Banner, bool) {
+	if banner, found := c.l1.GetBanner(id); found {
+		c.count(true)
+		return banner, true
+	}
+	banner, found := c.l2.GetBanner(id)
+	if found {
+		if ttl, ok := c.populateTTL(bannerKey(id)); ok {
+			c.l1.SetBanner(banner, ttl)
+		}
+	}
+	c.count(found)
+	return banner, found
+}
+
+// SetBanner stores a banner in both tiers
+func (c *TieredCache) SetBanner(banner *dto.Banner, ttl time.Duration) {
+	c.l2.SetBanner(banner, ttl)
+	c.l1.SetBanner(banner, c.localTTL(ttl))
+	c.countSet()
+}
+
+// DeleteBanner removes a banner from both tiers
+func (c *TieredCache) DeleteBanner(id int) {
+	c.l2.DeleteBanner(id)
+	c.l1.DeleteBanner(id)
+}
+
+// InvalidateBanner invalidates banner and related data in both tiers
+func (c *TieredCache) InvalidateBanner(id int) {
+	c.l2.InvalidateBanner(id)
+	c.l1.InvalidateBanner(id)
+}
+
+// IsBannerMissing checks L1 for a not-found marker, or L2 populating L1
+func (c *TieredCache) IsBannerMissing(id int) bool {
+	missing := c.l1.IsBannerMissing(id)
+	if !missing && c.l2.IsBannerMissing(id) {
+		if ttl, ok := c.populateTTL(missingBannerKey(id)); ok {
+			c.l1.SetBannerMissing(id, ttl)
+		}
+		missing = true
+	}
+	if missing {
+		c.mu.Lock()
+		c.stats.NegativeHits++
+		c.mu.Unlock()
+	}
+	return missing
+}
+
+// SetBannerMissing caches that a banner does not exist in both tiers
+func (c *TieredCache) SetBannerMissing(id int, ttl time.Duration) {
+	c.l2.SetBannerMissing(id, ttl)
+	c.l1.SetBannerMissing(id, c.localTTL(ttl))
+
+	c.mu.Lock()
+	c.stats.NegativeSets++
+	c.mu.Unlock()
+}
+
+// Click statistics operations
+
+// GetClickStats retrieves click statistics from L1, or from L2 populating L1
+func (c *TieredCache) GetClickStats(bannerID int) (*db.ClickStats, bool) {
+	if stats, found := c.l1.GetClickStats(bannerID); found {
+		c.count(true)
+		return stats, true
+	}
+	stats, found := c.l2.GetClickStats(bannerID)
+	if found {
+		if ttl, ok := c.populateTTL(clickStatsKey(bannerID)); ok {
+			c.l1.SetClickStats(bannerID, stats, ttl)
+		}
+	}
+	c.count(found)
+	return stats, found
+}
+
+// SetClickStats stores click statistics in both tiers
+func (c *TieredCache) SetClickStats(bannerID int, stats *db.ClickStats, ttl time.Duration) {
+	c.l2.SetClickStats(bannerID, stats, ttl)
+	c.l1.SetClickStats(bannerID, stats, c.localTTL(ttl))
+	c.countSet()
+}
+
+// IncrementClickStats counts a click in L2, which holds the authoritative
+// counter, and copies the result to L1
+func (c *TieredCache) IncrementClickStats(click *dto.Click) (*db.ClickStats, bool) {
+	stats, found := c.l2.IncrementClickStats(click)
+	if !found {
+		c.l1.InvalidateClickStats(click.BannerID)
+		return nil, false
+	}
+	if ttl, ok := c.populateTTL(clickStatsKey(click.BannerID)); ok {
+		c.l1.SetClickStats(click.BannerID, stats, ttl)
+	} else {
+		c.l1.InvalidateClickStats(click.BannerID)
+	}
+	return stats, true
+}
+
+// InvalidateClickStats removes click statistics from both tiers
+func (c *TieredCache) InvalidateClickStats(bannerID int) {
+	c.l2.InvalidateClickStats(bannerID)
+	c.l1.InvalidateClickStats(bannerID)
+}
+
+// Banner with stats operations
+
+// GetBannerWithStats retrieves banner with stats from L1, or from L2 populating L1
+func (c *TieredCache) GetBannerWithStats(id int) (*db.BannerWithStats, bool) {
+	if stats, found := c.l1.GetBannerWithStats(id); found {
+		c.count(true)
+		return stats, true
+	}
+	stats, found := c.l2.GetBannerWithStats(id)
+	if found {
+		if ttl, ok := c.populateTTL(bannerStatsKey(id)); ok {
+			c.l1.SetBannerWithStats(id, stats, ttl)
+		}
+	}
+	c.count(found)
+	return stats, found
+}
+
+// SetBannerWithStats stores banner with stats in both tiers
+func (c *TieredCache) SetBannerWithStats(id int, stats *db.BannerWithStats, ttl time.Duration) {
+	c.l2.SetBannerWithStats(id, stats, ttl)
+	c.l1.SetBannerWithStats(id, stats, c.localTTL(ttl))
+	c.countSet()
+}
+
+// InvalidateBannerWithStats removes banner with stats from both tiers
+func (c *TieredCache) InvalidateBannerWithStats(id int) {
+	c.l2.InvalidateBannerWithStats(id)
+	c.l1.InvalidateBannerWithStats(id)
+}
+
+// Top banners operations
+
+// GetTopBanners retrieves top banners from L1, or from L2 populating L1
+func (c *TieredCache) GetTopBanners(limit int) ([]*db.BannerClickCount, bool) {
+	if banners, found := c.l1.GetTopBanners(limit); found {
+		c.count(true)
+		return banners, true
+	}
+	banners, found := c.l2.GetTopBanners(limit)
+	if found {
+		if ttl, ok := c.populateTTL(topBannersKey(limit)); ok {
+			c.l1.SetTopBanners(limit, banners, ttl)
+		}
+	}
+	c.count(found)
+	return banners, found
+}
+
+// SetTopBanners stores top banners in both tiers
+func (c *TieredCache) SetTopBanners(limit int, banners []*db.BannerClickCount, ttl time.Duration) {
+	c.l2.SetTopBanners(limit, banners, ttl)
+	c.l1.SetTopBanners(limit, banners, c.localTTL(ttl))
+	c.countSet()
+}
+
+// InvalidateTopBanners removes top banners from both tiers
+func (c *TieredCache) InvalidateTopBanners() {
+	c.l2.InvalidateTopBanners()
+	c.l1.InvalidateTopBanners()
+}
+
+// Cache management
+
+// Clear clears both tiers
+func (c *TieredCache) Clear() {
+	c.l2.Clear()
+	c.l1.Clear()
+}
+
+// Size returns the number of entries in L2, which holds every cached value
+func (c *TieredCache) Size() int {
+	return c.l2.Size()
+}
+
+// Stats returns the hits and misses of the tiered cache as a whole, and the
+// statistics of each tier
+func (c *TieredCache) Stats() CacheStats {
+	l1 := c.l1.Stats()
+	l2 := c.l2.Stats()
+
+	c.mu.Lock()
+	stats := c.stats
+	c.mu.Unlock()
+
+	stats.Deletes = l2.Deletes
+	stats.Expirations = l1.Expirations + l2.Expirations
+	stats.Errors = l1.Errors + l2.Errors
+	stats.Size = l2.Size
+	stats.Tiers = map[string]CacheStats{tierL1: l1, tierL2: l2}
+	return stats
+}
+
+// Inspect lists up to limit live keys matching a glob pattern in each tier,
+// L1 keys first
+func (c *TieredCache) Inspect(pattern string, limit int) ([]KeyInfo, error) {
+	keys, err := c.l1.Inspect(pattern, limit)
+	if err != nil {
+		return nil, err
+	}
+	for i := range keys {
+		keys[i].Tier = tierL1
+	}
+	if limit > 0 && len(keys) >= limit {
+		return keys, nil
+	}
+
+	remaining := 0
+	if limit > 0 {
+		remaining = limit - len(keys)
+	}
+	l2Keys, err := c.l2.Inspect(pattern, remaining)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range l2Keys {
+		key.Tier = tierL2
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// TTL returns the remaining time to live of a key in L2, which holds every
+// cached value
+func (c *TieredCache) TTL(key string) (time.Duration, bool) {
+	return c.l2.TTL(key)
+}
+
+// Stop stops both tiers
+func (c *TieredCache) Stop() {
+	c.l1.Stop()
+	c.l2.Stop()
+}
@@ -0,0 +1,48 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tyagnii/ecom_test/db"
+	"github.com/tyagnii/ecom_test/dto"
+)
+
+func TestTieredCachePopulatesL1WithRemainingTTL(t *testing.T) {
+	l1 := NewInMemoryCache(DefaultCleanupInterval)
+	l2 := NewInMemoryCache(DefaultCleanupInterval)
+	c := NewTieredCache(l1, l2, time.Minute)
+	defer c.Stop()
+
+	l2.SetBanner(&dto.Banner{ID: 1}, 2*time.Second)
+	l2.SetBannerMissing(2, 2*time.Second)
+	l2.SetClickStats(1, &db.ClickStats{BannerID: 1}, 2*time.Second)
+	l2.SetBannerWithStats(1, &db.BannerWithStats{}, 2*time.Second)
+	l2.SetTopBanners(5, []*db.BannerClickCount{}, 2*time.Second)
+	l2.SetBanner(&dto.Banner{ID: 3}, time.Hour)
+
+	c.GetBanner(1)
+	c.IsBannerMissing(2)
+	c.GetClickStats(1)
+	c.GetBannerWithStats(1)
+	c.GetTopBanners(5)
+	c.GetBanner(3)
+
+	for _, key := range []string{bannerKey(1), missingBannerKey(2), clickStatsKey(1), bannerStatsKey(1), topBannersKey(5)} {
+		ttl, ok := l1.TTL(key)
+		if !ok || ttl > 2*time.Second {
+			t.Errorf("L1 TTL of %s = %v, %v, want at most the 2s left in L2", key, ttl, ok)
+		}
+	}
+	if ttl, ok := l1.TTL(bannerKey(3)); !ok || ttl > time.Minute || ttl < 50*time.Second {
+		t.Errorf("L1 TTL of %s = %v, %v, want the 1m L1 TTL", bannerKey(3), ttl, ok)
+	}
+
+	// An increment copies the counter with the TTL it has left in L2
+	if _, found := c.IncrementClickStats(&dto.Click{BannerID: 1, Timestamp: time.Now()}); !found {
+		t.Fatal("IncrementClickStats missed cached statistics")
+	}
+	if ttl, ok := l1.TTL(clickStatsKey(1)); !ok || ttl > 2*time.Second {
+		t.Errorf("L1 TTL after increment = %v, %v, want at most 2s", ttl, ok)
+	}
+}
@@ -5,6 +5,8 @@ Copyright © 2025 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"log"
 	"os"
 	"os/signal"
@@ -12,10 +14,12 @@ import (
 
 	"github.com/spf13/cobra"
 	"github.com/tyagnii/ecom_test/api"
+	"github.com/tyagnii/ecom_test/config"
 )
 
 var (
-	apiPort int
+	apiPort   int
+	apiConfig = config.Default()
 )
 
 // apiCmd represents the api command
@@ -31,6 +35,108 @@ var apiCmd = &cobra.Command{
 func init() {
 	rootCmd.AddCommand(apiCmd)
 	apiCmd.Flags().IntVarP(&apiPort, "port", "p", 8080, "Port to run the API server on")
+	apiCmd.Flags().StringVar(&apiConfig.ClickTokenSecret, "click-token-secret", apiConfig.ClickTokenSecret, "Secret used to sign click IDs (defaults to $CLICK_TOKEN_SECRET)")
+	apiCmd.Flags().DurationVar(&apiConfig.AttributionWindow, "attribution-window", apiConfig.AttributionWindow, "How long after a click its click ID can be converted")
+	apiCmd.Flags().BoolVar(&apiConfig.TrustProxyHeaders, "trust-proxy", apiConfig.TrustProxyHeaders, "Take the client IP from the rightmost X-Forwarded-For entry")
+	apiCmd.Flags().DurationVar(&apiConfig.Dedup.Window, "dedup-window", apiConfig.Dedup.Window, "Suppress repeated clicks from the same client within this window, e.g. 10s (disabled by default)")
+	apiCmd.Flags().IntVar(&apiConfig.Dedup.MaxEntries, "dedup-max-entries", apiConfig.Dedup.MaxEntries, "Maximum number of clients tracked by the dedup window")
+	apiCmd.Flags().StringVar(&apiConfig.Filter.UserAgentRulesFile, "bot-ua-file", apiConfig.Filter.UserAgentRulesFile, "File with bot user agent patterns (defaults to the built-in rule set)")
+	apiCmd.Flags().StringSliceVar(&apiConfig.Filter.IPDenylist, "ip-denylist", apiConfig.Filter.IPDenylist, "CIDRs whose clicks are counted as filtered")
+	apiCmd.Flags().BoolVar(&apiConfig.Filter.FilterEmptyUserAgent, "filter-empty-ua", apiConfig.Filter.FilterEmptyUserAgent, "Count clicks without a User-Agent as filtered")
+	apiCmd.Flags().Float64Var(&apiConfig.RateLimit.ClientRate, "rate-limit-client", apiConfig.RateLimit.ClientRate, "Counter requests per second allowed per client IP (0 disables; behind a proxy requires --trust-proxy)")
+	apiCmd.Flags().IntVar(&apiConfig.RateLimit.ClientBurst, "rate-limit-client-burst", apiConfig.RateLimit.ClientBurst, "Burst size of the per-client rate limit")
+	apiCmd.Flags().Float64Var(&apiConfig.RateLimit.BannerRate, "rate-limit-banner", apiConfig.RateLimit.BannerRate, "Counter requests per second allowed per banner (0 disables)")
+	apiCmd.Flags().IntVar(&apiConfig.RateLimit.BannerBurst, "rate-limit-banner-burst", apiConfig.RateLimit.BannerBurst, "Burst size of the per-banner rate limit")
+	apiCmd.Flags().DurationVar(&apiConfig.RateLimit.IdleTTL, "rate-limit-idle-ttl", apiConfig.RateLimit.IdleTTL, "Evict rate limit buckets unused for this long")
+	apiCmd.Flags().StringVar(&apiConfig.WAL.Dir, "wal-dir", apiConfig.WAL.Dir, "Absolute directory of the click WAL used while the database is down (empty disables)")
+	apiCmd.Flags().Int64Var(&apiConfig.WAL.SegmentSize, "wal-segment-size", apiConfig.WAL.SegmentSize, "Size in bytes after which a new WAL segment is started")
+	apiCmd.Flags().Int64Var(&apiConfig.WAL.MaxSize, "wal-max-size", apiConfig.WAL.MaxSize, "Maximum total WAL size in bytes (0 means unlimited)")
+	apiCmd.Flags().StringVar(&apiConfig.WAL.Sync, "wal-sync", apiConfig.WAL.Sync, "WAL fsync policy: always, interval or never")
+	apiCmd.Flags().DurationVar(&apiConfig.WAL.SyncInterval, "wal-sync-interval", apiConfig.WAL.SyncInterval, "WAL fsync period for the interval policy")
+	apiCmd.Flags().DurationVar(&apiConfig.WAL.ReplayInterval, "wal-replay-interval", apiConfig.WAL.ReplayInterval, "How often the WAL replayer checks database health")
+	apiCmd.Flags().IntVar(&apiConfig.Database.MaxAttempts, "db-max-attempts", apiConfig.Database.MaxAttempts, "Attempts per database statement on transient errors (1 disables retries)")
+	apiCmd.Flags().DurationVar(&apiConfig.Database.InitialBackoff, "db-retry-backoff", apiConfig.Database.InitialBackoff, "Initial back-off between database retries")
+	apiCmd.Flags().DurationVar(&apiConfig.Database.MaxBackoff, "db-retry-max-backoff", apiConfig.Database.MaxBackoff, "Maximum back-off between database retries")
+	apiCmd.Flags().IntVar(&apiConfig.Database.BreakerThreshold, "db-breaker-threshold", apiConfig.Database.BreakerThreshold, "Consecutive database failures that open the circuit breaker (0 disables)")
+	apiCmd.Flags().DurationVar(&apiConfig.Database.BreakerOpenTimeout, "db-breaker-timeout", apiConfig.Database.BreakerOpenTimeout, "How long the open circuit breaker fails fast before probing the database")
+	apiCmd.Flags().IntVar(&apiConfig.Partitions.MonthsAhead, "partitions-ahead", apiConfig.Partitions.MonthsAhead, "Future months of click partitions to keep created (negative disables)")
+	apiCmd.Flags().DurationVar(&apiConfig.Partitions.CheckInterval, "partitions-interval", apiConfig.Partitions.CheckInterval, "How often missing click partitions are created")
+	apiCmd.Flags().DurationVar(&apiConfig.Rollup.Interval, "rollup-interval", apiConfig.Rollup.Interval, "How often clicks are folded into rollups (0 disables the worker)")
+	apiCmd.Flags().DurationVar(&apiConfig.Rollup.Lag, "rollup-lag", apiConfig.Rollup.Lag, "How long after ingestion clicks are rolled up")
+	apiCmd.Flags().DurationVar(&apiConfig.Retention.Raw, "retention-raw", apiConfig.Retention.Raw, "How long raw clicks are kept (0 keeps them forever)")
+	apiCmd.Flags().DurationVar(&apiConfig.Retention.Minute, "retention-minute", apiConfig.Retention.Minute, "How long minute rollups are kept (0 keeps them forever)")
+	apiCmd.Flags().DurationVar(&apiConfig.Retention.Hour, "retention-hour", apiConfig.Retention.Hour, "How long hour rollups and visitor sketches are kept (0 keeps them forever)")
+	apiCmd.Flags().DurationVar(&apiConfig.Retention.Day, "retention-day", apiConfig.Retention.Day, "How long day rollups are kept (0 keeps them forever)")
+	apiCmd.Flags().IntVar(&apiConfig.Retention.BatchSize, "retention-batch-size", apiConfig.Retention.BatchSize, "Rows deleted per statement by the retention job")
+	apiCmd.Flags().DurationVar(&apiConfig.Retention.Interval, "retention-interval", apiConfig.Retention.Interval, "How often expired data is purged (0 disables the background job)")
+	apiCmd.Flags().StringVar(&apiConfig.Visitors.CookieName, "visitor-cookie", apiConfig.Visitors.CookieName, "Cookie identifying visitors for unique visitor estimation")
+	apiCmd.Flags().IntVar(&apiConfig.Visitors.Precision, "visitor-precision", apiConfig.Visitors.Precision, "HyperLogLog precision of visitor sketches (4-16)")
+	apiCmd.Flags().DurationVar(&apiConfig.Visitors.FlushInterval, "visitor-flush-interval", apiConfig.Visitors.FlushInterval, "How often visitor sketches are written to the database (0 disables tracking)")
+	apiCmd.Flags().IntVar(&apiConfig.Visitors.MaxPending, "visitor-max-pending", apiConfig.Visitors.MaxPending, "Visitor sketches buffered between flushes")
+	apiCmd.Flags().IntVar(&apiConfig.Stream.BufferSize, "stream-buffer", apiConfig.Stream.BufferSize, "Click events buffered per stream subscriber before it is dropped (0 disables streaming)")
+	apiCmd.Flags().DurationVar(&apiConfig.Stream.SnapshotInterval, "stream-snapshot-interval", apiConfig.Stream.SnapshotInterval, "How often stream subscribers receive counter snapshots")
+	apiCmd.Flags().IntVar(&apiConfig.Stream.MaxSubscribers, "stream-max-subscribers", apiConfig.Stream.MaxSubscribers, "Maximum concurrent stream subscribers")
+	apiCmd.Flags().IntVar(&apiConfig.Stream.MaxBannersPerConn, "ws-max-banners", apiConfig.Stream.MaxBannersPerConn, "Maximum banners a WebSocket connection may subscribe to")
+	apiCmd.Flags().DurationVar(&apiConfig.Stream.PingInterval, "ws-ping-interval", apiConfig.Stream.PingInterval, "How often WebSocket connections are pinged")
+	apiCmd.Flags().StringSliceVar(&apiConfig.Stream.AllowedOrigins, "ws-allowed-origins", apiConfig.Stream.AllowedOrigins, "Origins besides the API host whose pages may open WebSocket connections, e.g. https://dashboard.example.com (* allows any)")
+	apiCmd.Flags().StringVar(&apiConfig.Cache.Backend, "cache-backend", apiConfig.Cache.Backend, "Cache backend: memory (per instance), redis (shared) or tiered (memory in front of redis)")
+	apiCmd.Flags().StringVar(&apiConfig.Cache.Redis.Addr, "redis-addr", apiConfig.Cache.Redis.Addr, "Address of the Redis cache server")
+	apiCmd.Flags().StringVar(&apiConfig.Cache.Redis.Password, "redis-password", apiConfig.Cache.Redis.Password, "Password of the Redis cache server (defaults to $REDIS_PASSWORD)")
+	apiCmd.Flags().IntVar(&apiConfig.Cache.Redis.DB, "redis-db", apiConfig.Cache.Redis.DB, "Redis database of the cache")
+	apiCmd.Flags().StringVar(&apiConfig.Cache.Redis.KeyPrefix, "redis-key-prefix", apiConfig.Cache.Redis.KeyPrefix, "Prefix of the cache keys, e.g. one per environment")
+	apiCmd.Flags().IntVar(&apiConfig.Cache.Redis.PoolSize, "redis-pool-size", apiConfig.Cache.Redis.PoolSize, "Idle connections kept open to the Redis cache server")
+	apiCmd.Flags().IntVar(&apiConfig.Cache.Redis.MaxConns, "redis-max-conns", apiConfig.Cache.Redis.MaxConns, "Maximum connections open to the Redis cache server at once")
+	apiCmd.Flags().DurationVar(&apiConfig.Cache.Redis.Timeout, "redis-timeout", apiConfig.Cache.Redis.Timeout, "Timeout of Redis cache requests")
+	apiCmd.Flags().DurationVar(&apiConfig.Cache.L1TTL, "cache-l1-ttl", apiConfig.Cache.L1TTL, "How long the tiered cache keeps entries in the local tier")
+	apiCmd.Flags().DurationVar(&apiConfig.Cache.Banner.TTL, "cache-banner-ttl", apiConfig.Cache.Banner.TTL, "How long cached banners are fresh")
+	apiCmd.Flags().Float64Var(&apiConfig.Cache.Banner.Jitter, "cache-banner-jitter", apiConfig.Cache.Banner.Jitter, "Fraction the TTL of cached banners is randomly spread by")
+	apiCmd.Flags().DurationVar(&apiConfig.Cache.Banner.StaleWindow, "cache-banner-stale-window", apiConfig.Cache.Banner.StaleWindow, "How long stale banners are served while they are refreshed (0 disables)")
+	apiCmd.Flags().DurationVar(&apiConfig.Cache.ClickStats.TTL, "cache-click-stats-ttl", apiConfig.Cache.ClickStats.TTL, "How long cached click statistics are fresh")
+	apiCmd.Flags().Float64Var(&apiConfig.Cache.ClickStats.Jitter, "cache-click-stats-jitter", apiConfig.Cache.ClickStats.Jitter, "Fraction the TTL of cached click statistics is randomly spread by")
+	apiCmd.Flags().DurationVar(&apiConfig.Cache.ClickStats.StaleWindow, "cache-click-stats-stale-window", apiConfig.Cache.ClickStats.StaleWindow, "How long stale click statistics are served while they are refreshed (0 disables)")
+	apiCmd.Flags().DurationVar(&apiConfig.Cache.TopBanners.TTL, "cache-top-banners-ttl", apiConfig.Cache.TopBanners.TTL, "How long cached top banners are fresh")
+	apiCmd.Flags().Float64Var(&apiConfig.Cache.TopBanners.Jitter, "cache-top-banners-jitter", apiConfig.Cache.TopBanners.Jitter, "Fraction the TTL of cached top banners is randomly spread by")
+	apiCmd.Flags().DurationVar(&apiConfig.Cache.TopBanners.StaleWindow, "cache-top-banners-stale-window", apiConfig.Cache.TopBanners.StaleWindow, "How long stale top banners are served while they are refreshed (0 disables)")
+	apiCmd.Flags().DurationVar(&apiConfig.Cache.MissingBannerTTL, "cache-missing-banner-ttl", apiConfig.Cache.MissingBannerTTL, "How long banners that were not found are cached as missing (0 disables)")
+	apiCmd.Flags().StringVar(&apiConfig.Cache.InvalidationChannel, "cache-invalidation-channel", apiConfig.Cache.InvalidationChannel, "Postgres channel cache invalidations are exchanged on with other instances (empty disables)")
+	apiCmd.Flags().DurationVar(&apiConfig.Cache.InvalidationInterval, "cache-invalidation-interval", apiConfig.Cache.InvalidationInterval, "How long cache invalidations are coalesced before they are sent")
+	apiCmd.Flags().StringVar(&apiConfig.AdminToken, "admin-token", apiConfig.AdminToken, "Bearer token required by management endpoints (defaults to $ADMIN_TOKEN)")
+	apiCmd.Flags().StringVar(&apiConfig.IngestToken, "ingest-token", apiConfig.IngestToken, "Bearer token required by batch click ingestion (defaults to $INGEST_TOKEN)")
+}
+
+func loadAPIConfig() *config.Config {
+	apiConfig.Database.URL = getDatabaseURL()
+
+	if apiConfig.Cache.Redis.Password == "" {
+		apiConfig.Cache.Redis.Password = os.Getenv("REDIS_PASSWORD")
+	}
+
+	if apiConfig.ClickTokenSecret == "" {
+		apiConfig.ClickTokenSecret = os.Getenv("CLICK_TOKEN_SECRET")
+	}
+
+	if apiConfig.AdminToken == "" {
+		apiConfig.AdminToken = os.Getenv("ADMIN_TOKEN")
+	}
+
+	if apiConfig.AdminToken == "" {
+		log.Println("No admin token configured, management endpoints are unauthenticated")
+	}
+
+	if apiConfig.IngestToken == "" {
+		apiConfig.IngestToken = os.Getenv("INGEST_TOKEN")
+	}
+
+	if apiConfig.ClickTokenSecret == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			log.Fatalf("Failed to generate click token secret: %v", err)
+		}
+		apiConfig.ClickTokenSecret = hex.EncodeToString(secret)
+		log.Println("No click token secret configured, using a random one; issued click IDs will not survive a restart")
+	}
+
+	return apiConfig
 }
 
 func startAPIServer() {
@@ -42,7 +148,10 @@ func startAPIServer() {
 	defer database.Close()
 
 	// Create API server
-	server := api.NewServer(database)
+	server, err := api.NewServer(database, loadAPIConfig())
+	if err != nil {
+		log.Fatalf("Failed to create API server: %v", err)
+	}
 
 	// Setup graceful shutdown
 	c := make(chan os.Signal, 1)
@@ -5,27 +5,43 @@ Copyright © 2025 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/tyagnii/ecom_test/api"
 	"github.com/tyagnii/ecom_test/cache"
 	"github.com/tyagnii/ecom_test/db"
 )
 
+var (
+	cacheServer         string
+	cacheToken          string
+	cacheRequestTimeout time.Duration
+	cacheInspectLimit   int
+)
+
 // cacheCmd represents the cache command
 var cacheCmd = &cobra.Command{
 	Use:   "cache",
 	Short: "Cache management operations",
-	Long:  `Manage in-memory cache for the application.`,
+	Long: `Manage the cache of a running API server through its /api/v1/cache
+endpoints.`,
 }
 
 // cacheStatsCmd represents the cache stats command
 var cacheStatsCmd = &cobra.Command{
 	Use:   "stats",
 	Short: "Show cache statistics",
-	Long:  `Show cache performance statistics and metrics.`,
+	Long:  `Show cache performance statistics and metrics of the running server.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		showCacheStats()
 	},
@@ -35,7 +51,7 @@ var cacheStatsCmd = &cobra.Command{
 var cacheClearCmd = &cobra.Command{
 	Use:   "clear",
 	Short: "Clear all cache data",
-	Long:  `Clear all cached data from memory.`,
+	Long:  `Clear all cached data of the running server and the instances it propagates invalidations to.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		clearCache()
 	},
@@ -45,17 +61,35 @@ var cacheClearCmd = &cobra.Command{
 var cacheWarmCmd = &cobra.Command{
 	Use:   "warm",
 	Short: "Warm up the cache",
-	Long:  `Preload frequently accessed data into cache.`,
+	Long:  `Preload frequently accessed data into the cache of the running server.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		warmCache()
 	},
 }
 
+// cacheInspectCmd represents the cache inspect command
+var cacheInspectCmd = &cobra.Command{
+	Use:   "inspect [key-pattern]",
+	Short: "List live cache keys",
+	Long: `List the live cache keys of the running server matching a glob pattern,
+such as "banner:*" or "click_stats:1?", with their remaining TTLs. Patterns use
+the glob syntax of Redis, where * also matches "/". The pattern
+defaults to all keys.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		pattern := "*"
+		if len(args) > 0 {
+			pattern = args[0]
+		}
+		inspectCache(pattern)
+	},
+}
+
 // cacheTestCmd represents the cache test command
 var cacheTestCmd = &cobra.Command{
 	Use:   "test",
 	Short: "Test cache performance",
-	Long:  `Run cache performance tests and benchmarks.`,
+	Long:  `Run cache performance tests and benchmarks against a local in-memory cache.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		testCachePerformance()
 	},
@@ -66,7 +100,56 @@ func init() {
 	cacheCmd.AddCommand(cacheStatsCmd)
 	cacheCmd.AddCommand(cacheClearCmd)
 	cacheCmd.AddCommand(cacheWarmCmd)
+	cacheCmd.AddCommand(cacheInspectCmd)
 	cacheCmd.AddCommand(cacheTestCmd)
+
+	cacheCmd.PersistentFlags().StringVar(&cacheServer, "server", "http://localhost:8080", "URL of the running API server")
+	cacheCmd.PersistentFlags().StringVar(&cacheToken, "token", "", "Admin token of the API server (defaults to $ADMIN_TOKEN)")
+	cacheCmd.PersistentFlags().DurationVar(&cacheRequestTimeout, "timeout", 2*time.Minute, "Timeout of requests to the API server")
+	cacheInspectCmd.Flags().IntVar(&cacheInspectLimit, "limit", api.DefaultCacheKeysLimit, "Maximum number of keys to list")
+}
+
+// cacheRequest sends a request to a cache endpoint of the running server and
+// decodes the JSON response into out
+func cacheRequest(method, endpoint string, query url.Values, out interface{}) error {
+	target := strings.TrimRight(cacheServer, "/") + "/api/v1/cache/" + endpoint
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequest(method, target, nil)
+	if err != nil {
+		return fmt.Errorf("invalid server URL %q: %w", cacheServer, err)
+	}
+	token := cacheToken
+	if token == "" {
+		token = os.Getenv("ADMIN_TOKEN")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	client := &http.Client{Timeout: cacheRequestTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var errResp api.ErrorResponse
+		if json.NewDecoder(resp.Body).Decode(&errResp) == nil && errResp.Error != "" {
+			return fmt.Errorf("server returned %s: %s: %s", resp.Status, errResp.Error, errResp.Message)
+		}
+		return fmt.Errorf("server returned %s", resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode server response: %w", err)
+	}
+	return nil
 }
 
 func getCachedRepository() (*cache.CachedRepository, error) {
@@ -85,22 +168,42 @@ func getCachedRepository() (*cache.CachedRepository, error) {
 }
 
 func showCacheStats() {
-	cachedRepo, err := getCachedRepository()
-	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+	var response api.CacheStatsResponse
+	if err := cacheRequest(http.MethodGet, "stats", nil, &response); err != nil {
+		log.Fatalf("Failed to get cache statistics: %v", err)
 	}
-	defer cachedRepo.GetCache().Stop()
-	
-	stats := cachedRepo.GetCacheStats()
 	
 	fmt.Printf("Cache Statistics\n")
 	fmt.Printf("================\n\n")
-	fmt.Printf("Size: %d items\n", stats.Size)
+	printCacheStats(response.Stats)
+	
+	tiers := make([]string, 0, len(response.Stats.Tiers))
+	for tier := range response.Stats.Tiers {
+		tiers = append(tiers, tier)
+	}
+	sort.Strings(tiers)
+	for _, tier := range tiers {
+		fmt.Printf("\nTier %s\n", tier)
+		printCacheStats(response.Stats.Tiers[tier])
+	}
+}
+
+func printCacheStats(stats cache.CacheStats) {
+	if stats.Size < 0 {
+		fmt.Println("Size: unknown")
+	} else {
+		fmt.Printf("Size: %d items\n", stats.Size)
+	}
 	fmt.Printf("Hits: %d\n", stats.Hits)
 	fmt.Printf("Misses: %d\n", stats.Misses)
 	fmt.Printf("Sets: %d\n", stats.Sets)
 	fmt.Printf("Deletes: %d\n", stats.Deletes)
 	fmt.Printf("Expirations: %d\n", stats.Expirations)
+	fmt.Printf("Negative hits: %d\n", stats.NegativeHits)
+	fmt.Printf("Negative sets: %d\n", stats.NegativeSets)
+	if stats.Errors > 0 {
+		fmt.Printf("Errors: %d\n", stats.Errors)
+	}
 	
 	if stats.Hits+stats.Misses > 0 {
 		hitRate := float64(stats.Hits) / float64(stats.Hits+stats.Misses) * 100
@@ -111,31 +214,56 @@ func showCacheStats() {
 }
 
 func clearCache() {
-	cachedRepo, err := getCachedRepository()
-	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+	if err := cacheRequest(http.MethodPost, "clear", nil, nil); err != nil {
+		log.Fatalf("Failed to clear cache: %v", err)
 	}
-	defer cachedRepo.GetCache().Stop()
-	
-	cachedRepo.ClearCache()
 	fmt.Println("Cache cleared successfully!")
 }
 
 func warmCache() {
-	cachedRepo, err := getCachedRepository()
-	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
-	}
-	defer cachedRepo.GetCache().Stop()
-	
 	fmt.Println("Warming up cache...")
-	err = cachedRepo.WarmCache()
-	if err != nil {
+	if err := cacheRequest(http.MethodPost, "warm", nil, nil); err != nil {
 		log.Fatalf("Failed to warm cache: %v", err)
 	}
 	
-	stats := cachedRepo.GetCacheStats()
-	fmt.Printf("Cache warmed successfully! Cached %d items.\n", stats.Size)
+	var response api.CacheStatsResponse
+	if err := cacheRequest(http.MethodGet, "stats", nil, &response); err != nil {
+		log.Fatalf("Failed to get cache statistics: %v", err)
+	}
+	if response.Stats.Size < 0 {
+		fmt.Println("Cache warmed successfully!")
+		return
+	}
+	fmt.Printf("Cache warmed successfully! Cached %d items.\n", response.Stats.Size)
+}
+
+func inspectCache(pattern string) {
+	query := url.Values{}
+	query.Set("pattern", pattern)
+	query.Set("limit", strconv.Itoa(cacheInspectLimit))
+	
+	var response api.CacheKeysResponse
+	if err := cacheRequest(http.MethodGet, "keys", query, &response); err != nil {
+		log.Fatalf("Failed to inspect cache: %v", err)
+	}
+	
+	for _, key := range response.Keys {
+		ttl := "no expiry"
+		if key.TTLMillis >= 0 {
+			ttl = (time.Duration(key.TTLMillis) * time.Millisecond).String()
+		}
+		if key.Tier != "" {
+			fmt.Printf("%-3s %-40s %s\n", key.Tier, key.Key, ttl)
+		} else {
+			fmt.Printf("%-40s %s\n", key.Key, ttl)
+		}
+	}
+	
+	fmt.Printf("\n%d keys matching %q", len(response.Keys), response.Pattern)
+	if response.Truncated {
+		fmt.Printf(" (truncated, raise --limit to list more)")
+	}
+	fmt.Println()
 }
 
 func testCachePerformance() {
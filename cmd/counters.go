@@ -0,0 +1,58 @@
+/*
+Copyright © 2025 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/tyagnii/ecom_test/db"
+)
+
+var countersBannerIDs []int
+
+// countersCmd represents the counters command
+var countersCmd = &cobra.Command{
+	Use:   "counters",
+	Short: "Banner counter operations",
+	Long:  `Manage the per-banner click counters maintained on every click write.`,
+}
+
+// countersRebuildCmd represents the counters rebuild command
+var countersRebuildCmd = &cobra.Command{
+	Use:   "rebuild",
+	Short: "Recompute banner counters from raw clicks",
+	Long: `Recompute the per-banner click counters from the clicks table.
+Click writes wait until the rebuild has finished. Counters keep lifetime
+totals, so clicks removed by the retention policy are lost from rebuilt counters.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		rebuildCounters()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(countersCmd)
+	countersCmd.AddCommand(countersRebuildCmd)
+	countersRebuildCmd.Flags().IntSliceVar(&countersBannerIDs, "banner-id", nil, "Banners to rebuild (defaults to all banners)")
+}
+
+func rebuildCounters() {
+	database, err := connectToDatabase()
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer database.Close()
+
+	repo := db.NewRepository(database)
+
+	start := time.Now()
+	rebuilt, err := repo.RebuildBannerCounters(countersBannerIDs)
+	if err != nil {
+		log.Fatalf("Failed to rebuild counters: %v", err)
+	}
+
+	fmt.Printf("Rebuilt counters for %d banners in %v\n", rebuilt, time.Since(start))
+}
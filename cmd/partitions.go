@@ -0,0 +1,105 @@
+/*
+Copyright © 2025 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/tyagnii/ecom_test/db"
+)
+
+var (
+	partitionsAhead int
+	partitionsFrom  string
+)
+
+// partitionsCmd represents the partitions command
+var partitionsCmd = &cobra.Command{
+	Use:   "partitions",
+	Short: "Click partition management",
+	Long:  `Manage the monthly partitions of the clicks table.`,
+}
+
+// partitionsListCmd represents the partitions list command
+var partitionsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List click partitions",
+	Long:  `List the partitions of the clicks table with their estimated rows and size.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		listPartitions()
+	},
+}
+
+// partitionsCreateCmd represents the partitions create command
+var partitionsCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Create missing click partitions",
+	Long: `Create monthly click partitions from the given month (default: the current month)
+up to the configured number of months ahead. Clicks of those months that landed
+in the default partition are moved into the new partitions.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		createPartitions()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(partitionsCmd)
+	partitionsCmd.AddCommand(partitionsListCmd)
+	partitionsCmd.AddCommand(partitionsCreateCmd)
+	partitionsCreateCmd.Flags().IntVar(&partitionsAhead, "ahead", apiConfig.Partitions.MonthsAhead, "Number of future months to create")
+	partitionsCreateCmd.Flags().StringVar(&partitionsFrom, "from", "", "First month to create (YYYY-MM)")
+}
+
+func listPartitions() {
+	database, err := connectToDatabase()
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer database.Close()
+
+	partitions, err := db.NewRepository(database).ListClickPartitions()
+	if err != nil {
+		log.Fatalf("Failed to list partitions: %v", err)
+	}
+
+	fmt.Printf("Click Partitions\n")
+	fmt.Printf("================\n\n")
+	for _, partition := range partitions {
+		bounds := "default"
+		if !partition.Default {
+			bounds = fmt.Sprintf("%s .. %s", partition.From.Format("2006-01-02"), partition.To.Format("2006-01-02"))
+		}
+		fmt.Printf("%-20s %-24s ~%d rows, %d bytes\n", partition.Name, bounds, partition.Rows, partition.Bytes)
+	}
+}
+
+func createPartitions() {
+	from := time.Now()
+	if partitionsFrom != "" {
+		month, err := time.Parse("2006-01", partitionsFrom)
+		if err != nil {
+			log.Fatalf("Invalid --from month %q: expected YYYY-MM", partitionsFrom)
+		}
+		from = month
+	}
+
+	database, err := connectToDatabase()
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer database.Close()
+
+	repo := db.NewRepository(database)
+	created, err := repo.EnsureClickPartitions(from, partitionsAhead)
+	if err != nil {
+		log.Fatalf("Failed to create partitions: %v", err)
+	}
+	for _, name := range created {
+		fmt.Printf("Created %s\n", name)
+	}
+	fmt.Printf("Created %d partitions\n", len(created))
+}
@@ -0,0 +1,93 @@
+/*
+Copyright © 2025 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/spf13/cobra"
+	"github.com/tyagnii/ecom_test/app"
+	"github.com/tyagnii/ecom_test/db"
+	"github.com/tyagnii/ecom_test/logger"
+)
+
+var retentionDryRun bool
+
+// retentionCmd represents the retention command
+var retentionCmd = &cobra.Command{
+	Use:   "retention",
+	Short: "Data retention operations",
+	Long:  `Enforce the data retention policy.`,
+}
+
+// retentionRunCmd represents the retention run command
+var retentionRunCmd = &cobra.Command{
+	Use:   "run",
+	Short: "Purge expired data",
+	Long: `Purge data older than the retention policy. Whole partitions are dropped
+where possible, remaining rows are deleted in bounded batches. Raw clicks are
+only purged once they are contained in the rollups.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runRetention()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(retentionCmd)
+	retentionCmd.AddCommand(retentionRunCmd)
+	retentionRunCmd.Flags().BoolVar(&retentionDryRun, "dry-run", false, "Report what would be removed without removing it")
+	retentionRunCmd.Flags().DurationVar(&apiConfig.Retention.Raw, "raw", apiConfig.Retention.Raw, "How long raw clicks are kept (0 keeps them forever)")
+	retentionRunCmd.Flags().DurationVar(&apiConfig.Retention.Minute, "minute", apiConfig.Retention.Minute, "How long minute rollups are kept (0 keeps them forever)")
+	retentionRunCmd.Flags().DurationVar(&apiConfig.Retention.Hour, "hour", apiConfig.Retention.Hour, "How long hour rollups and visitor sketches are kept (0 keeps them forever)")
+	retentionRunCmd.Flags().DurationVar(&apiConfig.Retention.Day, "day", apiConfig.Retention.Day, "How long day rollups are kept (0 keeps them forever)")
+	retentionRunCmd.Flags().IntVar(&apiConfig.Retention.BatchSize, "batch-size", apiConfig.Retention.BatchSize, "Rows deleted per statement")
+}
+
+func runRetention() {
+	database, err := connectToDatabase()
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer database.Close()
+
+	job := app.NewRetentionJob(db.NewRepository(database), app.RetentionPolicy{
+		Raw:       apiConfig.Retention.Raw,
+		Minute:    apiConfig.Retention.Minute,
+		Hour:      apiConfig.Retention.Hour,
+		Day:       apiConfig.Retention.Day,
+		BatchSize: apiConfig.Retention.BatchSize,
+	}, logger.GetGlobalLogger())
+
+	results, err := job.Run(retentionDryRun)
+	printPurgeResults(results)
+	if err != nil {
+		log.Fatalf("Retention run failed: %v", err)
+	}
+}
+
+func printPurgeResults(results []*db.PurgeResult) {
+	fmt.Printf("Retention Results\n")
+	fmt.Printf("=================\n\n")
+	if len(results) == 0 {
+		fmt.Println("No retention configured")
+		return
+	}
+
+	verb := "Removed"
+	if retentionDryRun {
+		verb = "Would remove"
+	}
+	for _, result := range results {
+		fmt.Printf("%s (older than %s):\n", result.Table, result.Cutoff.Format("2006-01-02 15:04:05"))
+		if result.Skipped != "" {
+			fmt.Printf("  Skipped: %s\n", result.Skipped)
+			continue
+		}
+		for _, name := range result.DroppedPartitions {
+			fmt.Printf("  %s partition %s\n", verb, name)
+		}
+		fmt.Printf("  %s ~%d rows with partitions, %d rows in batches\n", verb, result.DroppedRows, result.DeletedRows)
+	}
+}
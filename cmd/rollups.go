@@ -0,0 +1,53 @@
+/*
+Copyright © 2025 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/spf13/cobra"
+	"github.com/tyagnii/ecom_test/app"
+	"github.com/tyagnii/ecom_test/db"
+	"github.com/tyagnii/ecom_test/logger"
+)
+
+// rollupsCmd represents the rollups command
+var rollupsCmd = &cobra.Command{
+	Use:   "rollups",
+	Short: "Click rollup operations",
+	Long:  `Manage the minute, hour and day click rollups.`,
+}
+
+// rollupsRunCmd represents the rollups run command
+var rollupsRunCmd = &cobra.Command{
+	Use:   "run",
+	Short: "Fold new clicks into the rollups",
+	Long:  `Fold clicks ingested since the rollup watermark into the minute, hour and day rollups.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runRollups()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(rollupsCmd)
+	rollupsCmd.AddCommand(rollupsRunCmd)
+	rollupsRunCmd.Flags().DurationVar(&apiConfig.Rollup.Lag, "lag", apiConfig.Rollup.Lag, "How long after ingestion clicks are rolled up")
+}
+
+func runRollups() {
+	database, err := connectToDatabase()
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer database.Close()
+
+	worker := app.NewRollupWorker(db.NewRepository(database), apiConfig.Rollup.Lag, logger.GetGlobalLogger())
+	rolled, err := worker.Run()
+	if err != nil {
+		log.Fatalf("Rollup failed after %d clicks: %v", rolled, err)
+	}
+
+	fmt.Printf("Rolled up %d clicks, watermark %s\n", rolled, worker.Watermark().Format("2006-01-02 15:04:05"))
+}
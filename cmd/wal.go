@@ -0,0 +1,104 @@
+/*
+Copyright © 2025 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/spf13/cobra"
+	"github.com/tyagnii/ecom_test/app"
+	"github.com/tyagnii/ecom_test/db"
+	"github.com/tyagnii/ecom_test/logger"
+	"github.com/tyagnii/ecom_test/wal"
+)
+
+var (
+	walDir string
+)
+
+// walCmd represents the wal command
+var walCmd = &cobra.Command{
+	Use:   "wal",
+	Short: "Click write-ahead log operations",
+	Long:  `Inspect and replay the local click WAL used while the database is unavailable.`,
+}
+
+// walStatusCmd represents the wal status command
+var walStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show WAL status",
+	Long:  `Show the number of segments, bytes and clicks waiting in the WAL.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		showWALStatus()
+	},
+}
+
+// walReplayCmd represents the wal replay command
+var walReplayCmd = &cobra.Command{
+	Use:   "replay",
+	Short: "Replay WAL into the database",
+	Long: `Replay all clicks waiting in the WAL into the database.
+The API server replays its WAL automatically; this command is meant for a WAL
+left behind by a server that is no longer running.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		replayWAL()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(walCmd)
+	walCmd.AddCommand(walStatusCmd)
+	walCmd.AddCommand(walReplayCmd)
+
+	walCmd.PersistentFlags().StringVar(&walDir, "dir", "", "WAL directory")
+	walCmd.MarkPersistentFlagRequired("dir")
+}
+
+func showWALStatus() {
+	status, err := wal.ReadStatus(walDir)
+	if err != nil {
+		log.Fatalf("Failed to read WAL status: %v", err)
+	}
+
+	fmt.Printf("WAL Status\n")
+	fmt.Printf("==========\n\n")
+	fmt.Printf("Directory: %s\n", status.Dir)
+	fmt.Printf("Segments: %d\n", status.Segments)
+	fmt.Printf("Bytes: %d\n", status.Bytes)
+	fmt.Printf("Pending clicks: %d\n", status.Records)
+	if status.Segments > 0 {
+		fmt.Printf("Oldest segment: %s\n", status.OldestSegment.Format("2006-01-02 15:04:05"))
+	}
+}
+
+func replayWAL() {
+	walLog, err := wal.Open(wal.Options{Dir: walDir, Sync: wal.SyncAlways})
+	if err != nil {
+		if errors.Is(err, wal.ErrLocked) {
+			log.Fatalf("WAL %s is in use by a running API server, which replays it automatically", walDir)
+		}
+		log.Fatalf("Failed to open WAL: %v", err)
+	}
+
+	database, err := connectToDatabase()
+	if err != nil {
+		walLog.Close()
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer database.Close()
+
+	clickWAL := app.NewClickWAL(walLog, db.NewRepository(database), logger.GetGlobalLogger())
+	defer clickWAL.Stop()
+
+	fmt.Println("Replaying WAL...")
+	inserted, err := clickWAL.Replay()
+	if err != nil {
+		log.Fatalf("Replay stopped after %d clicks: %v", inserted, err)
+	}
+
+	stats := clickWAL.Stats()
+	fmt.Printf("WAL replayed successfully! Inserted %d clicks, dropped %d.\n", inserted, stats.Dropped)
+}
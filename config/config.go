@@ -1 +1,315 @@
 package config
+
+import "time"
+
+// Config holds application configuration
+type Config struct {
+	// ClickTokenSecret is the HMAC key used to sign click IDs for conversion attribution
+	ClickTokenSecret string
+
+	// AttributionWindow is how long after a click its token can be converted
+	AttributionWindow time.Duration
+
+	// TrustProxyHeaders makes the API take the client IP from the rightmost
+	// X-Forwarded-For entry, as appended by the proxy in front of it
+	TrustProxyHeaders bool
+
+	// Dedup configures duplicate click suppression
+	Dedup DedupConfig
+
+	// Filter configures bot and crawler filtering
+	Filter FilterConfig
+
+	// RateLimit configures rate limiting of the counter endpoint
+	RateLimit RateLimitConfig
+
+	// WAL configures the local click write-ahead log
+	WAL WALConfig
+
+	// Database configures retries and the circuit breaker around database calls
+	Database DatabaseConfig
+
+	// Partitions configures the click partition manager
+	Partitions PartitionConfig
+
+	// Rollup configures the click rollup worker
+	Rollup RollupConfig
+
+	// Retention configures purging of expired data
+	Retention RetentionConfig
+
+	// Visitors configures unique visitor estimation
+	Visitors VisitorConfig
+
+	// Stream configures the live click stream
+	Stream StreamConfig
+
+	// Cache configures the banner and statistics cache
+	Cache CacheConfig
+
+	// AdminToken protects management endpoints; empty leaves them open
+	AdminToken string
+
+	// IngestToken authorizes batch click ingestion. The admin token is
+	// accepted too; with neither configured batch ingestion is disabled.
+	IngestToken string
+}
+
+// DedupConfig configures duplicate click suppression
+type DedupConfig struct {
+	// Window is how long repeated clicks from the same client are suppressed;
+	// zero, the default, disables deduplication
+	Window time.Duration
+	// MaxEntries bounds the number of remembered clients
+	MaxEntries int
+}
+
+// FilterConfig configures bot and crawler filtering
+type FilterConfig struct {
+	// UserAgentRulesFile overrides the built-in user agent rule set
+	UserAgentRulesFile string
+	// IPDenylist lists CIDRs whose clicks are filtered
+	IPDenylist []string
+	// FilterEmptyUserAgent filters clicks sent without a User-Agent
+	FilterEmptyUserAgent bool
+}
+
+// RateLimitConfig configures token bucket rate limiting of the counter endpoint.
+// A zero rate disables the corresponding limit. The per-client limit is off by
+// default: behind a proxy all clients share its IP unless TrustProxyHeaders is
+// set, and would be throttled together.
+type RateLimitConfig struct {
+	ClientRate  float64
+	ClientBurst int
+	BannerRate  float64
+	BannerBurst int
+	// IdleTTL is how long unused buckets are kept in memory
+	IdleTTL time.Duration
+}
+
+// WALConfig configures the local click write-ahead log used while the database is down
+type WALConfig struct {
+	// Dir holds the WAL segments and must be an absolute path; empty, the
+	// default, disables the WAL
+	Dir string
+	// SegmentSize is the size in bytes after which a new segment is started
+	SegmentSize int64
+	// MaxSize caps the total WAL size in bytes; zero means unlimited
+	MaxSize int64
+	// Sync is the fsync policy: always, interval or never
+	Sync string
+	// SyncInterval is the fsync period of the interval policy
+	SyncInterval time.Duration
+	// ReplayInterval is how often the replayer checks database health
+	ReplayInterval time.Duration
+}
+
+// DatabaseConfig configures retries with exponential back-off and the circuit
+// breaker wrapped around repository calls
+type DatabaseConfig struct {
+	// URL is the connection string, used by connections opened outside the
+	// pool such as the cache invalidation listener
+	URL string
+	// MaxAttempts is the number of attempts per statement; 1 disables retries
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between retries
+	MaxBackoff time.Duration
+	// BreakerThreshold is the number of consecutive transient failures that
+	// opens the circuit breaker; zero disables the breaker
+	BreakerThreshold int
+	// BreakerOpenTimeout is how long the breaker fails fast before probing again
+	BreakerOpenTimeout time.Duration
+}
+
+// PartitionConfig configures pre-creation of monthly click partitions
+type PartitionConfig struct {
+	// MonthsAhead is the number of future months kept partitioned; negative disables the manager
+	MonthsAhead int
+	// CheckInterval is how often missing partitions are created
+	CheckInterval time.Duration
+}
+
+// RollupConfig configures the worker that folds clicks into minute, hour and day rollups
+type RollupConfig struct {
+	// Interval is how often new clicks are rolled up; zero disables the worker
+	Interval time.Duration
+	// Lag is how long after ingestion a click is rolled up, leaving in-flight inserts time to commit
+	Lag time.Duration
+}
+
+// RetentionConfig configures how long data is kept. A zero retention keeps data
+// forever, and with every retention zero, the default, nothing is purged.
+type RetentionConfig struct {
+	// Raw is how long individual clicks are kept
+	Raw time.Duration
+	// Minute, Hour and Day are how long click rollups are kept; Hour also applies to visitor sketches
+	Minute time.Duration
+	Hour   time.Duration
+	Day    time.Duration
+	// BatchSize bounds the rows deleted per statement
+	BatchSize int
+	// Interval is how often expired data is purged; zero disables the background job
+	Interval time.Duration
+}
+
+// VisitorConfig configures unique visitor estimation with HyperLogLog sketches
+type VisitorConfig struct {
+	// CookieName is the cookie identifying a visitor; without it the client IP and User-Agent are used
+	CookieName string
+	// Precision is the HyperLogLog precision; sketches have 2^Precision registers
+	Precision int
+	// FlushInterval is how often buffered sketches are merged into the database; zero disables tracking
+	FlushInterval time.Duration
+	// MaxPending bounds the (banner, hour) sketches buffered between flushes
+	MaxPending int
+}
+
+// StreamConfig configures the live click stream
+type StreamConfig struct {
+	// BufferSize is the number of events buffered per subscriber before it is
+	// dropped as too slow; zero disables streaming
+	BufferSize int
+	// SnapshotInterval is how often subscribers receive banner counter snapshots
+	SnapshotInterval time.Duration
+	// MaxSubscribers bounds concurrent stream subscribers, SSE and WebSocket
+	// connections alike
+	MaxSubscribers int
+	// MaxBannersPerConn bounds the banners a WebSocket connection subscribes to
+	MaxBannersPerConn int
+	// PingInterval is how often WebSocket connections are pinged; a connection
+	// silent for two intervals is closed
+	PingInterval time.Duration
+	// AllowedOrigins lists the origins, besides the API host itself, whose
+	// pages may open WebSocket connections; "*" allows any origin
+	AllowedOrigins []string
+}
+
+// CacheConfig configures the banner and statistics cache
+type CacheConfig struct {
+	// Backend is "memory" for a cache per instance, "redis" for a cache
+	// shared by all instances or "tiered" for a local memory cache in front
+	// of the shared redis cache
+	Backend string
+	// Redis configures the redis backend
+	Redis RedisConfig
+	// L1TTL is how long the tiered backend keeps entries in the local tier
+	L1TTL time.Duration
+	// Banner, ClickStats and TopBanners configure how long each kind of
+	// entry is cached
+	Banner     CacheTTLConfig
+	ClickStats CacheTTLConfig
+	TopBanners CacheTTLConfig
+	// MissingBannerTTL is how long a banner that was not found is cached as
+	// not existing; zero disables negative caching
+	MissingBannerTTL time.Duration
+	// InvalidationChannel is the Postgres channel banner invalidations are
+	// exchanged on with other instances; empty disables propagation. Only the
+	// memory and tiered backends need it.
+	InvalidationChannel string
+	// InvalidationInterval is how long invalidations are coalesced before
+	// they are sent
+	InvalidationInterval time.Duration
+}
+
+// CacheTTLConfig configures how long one kind of cache entry is cached
+type CacheTTLConfig struct {
+	// TTL is how long an entry is fresh
+	TTL time.Duration
+	// Jitter spreads the TTL of each entry by up to this fraction
+	Jitter float64
+	// StaleWindow is how long a stale entry is served while it is refreshed;
+	// zero disables revalidation
+	StaleWindow time.Duration
+}
+
+// RedisConfig configures the connection to a Redis-compatible cache server
+type RedisConfig struct {
+	Addr     string
+	Password string
+	DB       int
+	// KeyPrefix is prepended to every key, separating environments sharing a server
+	KeyPrefix string
+	// PoolSize is the number of idle connections kept open
+	PoolSize int
+	// MaxConns bounds the connections open at once
+	MaxConns int
+	// Timeout bounds connecting and every request
+	Timeout time.Duration
+}
+
+// Default returns a configuration populated with default values
+func Default() *Config {
+	return &Config{
+		AttributionWindow: 30 * 24 * time.Hour,
+		Dedup: DedupConfig{
+			MaxEntries: 100000,
+		},
+		Filter: FilterConfig{
+			FilterEmptyUserAgent: true,
+		},
+		RateLimit: RateLimitConfig{
+			ClientBurst: 20,
+			BannerRate:  500,
+			BannerBurst: 1000,
+			IdleTTL:     5 * time.Minute,
+		},
+		WAL: WALConfig{
+			SegmentSize:    4 << 20,
+			MaxSize:        512 << 20,
+			Sync:           "interval",
+			SyncInterval:   time.Second,
+			ReplayInterval: 5 * time.Second,
+		},
+		Database: DatabaseConfig{
+			MaxAttempts:        3,
+			InitialBackoff:     50 * time.Millisecond,
+			MaxBackoff:         time.Second,
+			BreakerThreshold:   5,
+			BreakerOpenTimeout: 10 * time.Second,
+		},
+		Partitions: PartitionConfig{
+			MonthsAhead:   3,
+			CheckInterval: time.Hour,
+		},
+		Retention: RetentionConfig{
+			BatchSize: 10000,
+			Interval:  time.Hour,
+		},
+		Rollup: RollupConfig{
+			Interval: time.Minute,
+			Lag:      30 * time.Second,
+		},
+		Visitors: VisitorConfig{
+			CookieName:    "visitor_id",
+			Precision:     12,
+			FlushInterval: 10 * time.Second,
+			MaxPending:    100000,
+		},
+		Stream: StreamConfig{
+			BufferSize:        256,
+			SnapshotInterval:  5 * time.Second,
+			MaxSubscribers:    1000,
+			MaxBannersPerConn: 100,
+			PingInterval:      30 * time.Second,
+		},
+		Cache: CacheConfig{
+			Backend: "memory",
+			Redis: RedisConfig{
+				Addr:      "localhost:6379",
+				KeyPrefix: "ecom:",
+				PoolSize:  16,
+				MaxConns:  64,
+				Timeout:   500 * time.Millisecond,
+			},
+			L1TTL:                5 * time.Second,
+			Banner:               CacheTTLConfig{TTL: 5 * time.Minute, Jitter: 0.1, StaleWindow: time.Minute},
+			ClickStats:           CacheTTLConfig{TTL: 2 * time.Minute, Jitter: 0.1, StaleWindow: 30 * time.Second},
+			TopBanners:           CacheTTLConfig{TTL: time.Minute, Jitter: 0.1, StaleWindow: 30 * time.Second},
+			MissingBannerTTL:     10 * time.Second,
+			InvalidationChannel:  "cache_invalidation",
+			InvalidationInterval: 100 * time.Millisecond,
+		},
+	}
+}
@@ -0,0 +1,233 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Analytics page limits
+const (
+	DefaultAnalyticsLimit = 50
+	MaxAnalyticsLimit     = 1000
+)
+
+// analyticsSortColumns maps sort keys to result columns and their default direction
+var analyticsSortColumns = map[string]struct {
+	column string
+	desc   bool
+}{
+	"clicks":          {"clicks", true},
+	"net_clicks":      {"net_clicks", true},
+	"filtered_clicks": {"filtered_clicks", true},
+	"conversions":     {"conversions", true},
+	"conversion_rate": {"conversion_rate", true},
+	"last_click":      {"last_click", true},
+	"name":            {"banner_name", false},
+	"id":              {"banner_id", false},
+}
+
+// AnalyticsQuery selects, orders and pages banner analytics
+type AnalyticsQuery struct {
+	// From and To bound the window [From, To); with a zero From lifetime totals are used
+	From time.Time
+	To   time.Time
+	// Sort is one of clicks, net_clicks, filtered_clicks, conversions,
+	// conversion_rate, last_click, name and id
+	Sort string
+	// Order is asc or desc; empty uses the sort key's natural order
+	Order  string
+	Limit  int
+	Offset int
+}
+
+// Windowed reports whether the query is restricted to a time window
+func (q *AnalyticsQuery) Windowed() bool {
+	return !q.From.IsZero()
+}
+
+// Normalize fills in defaults and validates the query
+func (q *AnalyticsQuery) Normalize() error {
+	if q.Sort == "" {
+		q.Sort = "clicks"
+	}
+	if _, ok := analyticsSortColumns[q.Sort]; !ok {
+		keys := make([]string, 0, len(analyticsSortColumns))
+		for key := range analyticsSortColumns {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		return fmt.Errorf("unknown sort %q: expected one of %s", q.Sort, strings.Join(keys, ", "))
+	}
+
+	q.Order = strings.ToLower(q.Order)
+	if q.Order != "" && q.Order != "asc" && q.Order != "desc" {
+		return fmt.Errorf("unknown order %q: expected asc or desc", q.Order)
+	}
+
+	if q.Limit <= 0 {
+		q.Limit = DefaultAnalyticsLimit
+	}
+	if q.Limit > MaxAnalyticsLimit {
+		return fmt.Errorf("limit cannot exceed %d", MaxAnalyticsLimit)
+	}
+	if q.Offset < 0 {
+		return fmt.Errorf("offset cannot be negative")
+	}
+
+	if q.Windowed() {
+		if q.To.IsZero() {
+			q.To = time.Now()
+		}
+		if !q.From.Before(q.To) {
+			return fmt.Errorf("from must be before to")
+		}
+	} else if !q.To.IsZero() {
+		return fmt.Errorf("to requires from")
+	}
+
+	return nil
+}
+
+// orderBy returns the ORDER BY clause of the query
+func (q *AnalyticsQuery) orderBy() string {
+	key := analyticsSortColumns[q.Sort]
+	desc := key.desc
+	if q.Order != "" {
+		desc = q.Order == "desc"
+	}
+
+	direction := "ASC NULLS LAST"
+	if desc {
+		direction = "DESC NULLS LAST"
+	}
+	return fmt.Sprintf("%s %s, banner_id", key.column, direction)
+}
+
+// BannerPerformance holds the clicks and conversions of a banner, within the
+// query window if one was given. First and last click are lifetime values.
+type BannerPerformance struct {
+	BannerID       int        `json:"banner_id"`
+	BannerName     string     `json:"banner_name"`
+	Clicks         int        `json:"clicks"`
+	NetClicks      int        `json:"net_clicks"`
+	FilteredClicks int        `json:"filtered_clicks"`
+	Conversions    int        `json:"conversions"`
+	ConversionRate float64    `json:"conversion_rate"`
+	FirstClick     *time.Time `json:"first_click,omitempty"`
+	LastClick      *time.Time `json:"last_click,omitempty"`
+}
+
+// BannerPerformancePage is one page of banner performance
+type BannerPerformancePage struct {
+	Items []*BannerPerformance `json:"items"`
+	// Total is the number of banners across all pages
+	Total int `json:"total"`
+	// Source is the level windowed clicks were read from
+	Source RollupLevel `json:"source,omitempty"`
+}
+
+// GetBannerPerformance returns one page of banner performance in a single
+// grouped query. Without a window clicks come from the banner counters;
+// with a window they come from the coarsest rollup lining up with it.
+func (r *Repository) GetBannerPerformance(q *AnalyticsQuery) (*BannerPerformancePage, error) {
+	if err := q.Normalize(); err != nil {
+		return nil, err
+	}
+
+	page := &BannerPerformancePage{Items: []*BannerPerformance{}}
+	args := []interface{}{q.Limit, q.Offset}
+
+	clicksQuery := `SELECT bannerid, total_clicks AS clicks, filtered_clicks FROM banner_counters`
+	conversionsWhere := ""
+	if q.Windowed() {
+		page.Source = ChooseRollupLevel(q.From, q.To, RollupRaw, time.UTC)
+		clicksQuery = fmt.Sprintf(`
+			SELECT bannerid, SUM(clicks) AS clicks, SUM(filtered_clicks) AS filtered_clicks
+			FROM (%s) c
+			GROUP BY bannerid`, clickCountsQuery(page.Source, "TRUE", "$3", "$4"))
+		conversionsWhere = "WHERE timestamp >= $3 AND timestamp < $4"
+		args = append(args, q.From, q.To)
+	}
+
+	query := fmt.Sprintf(`
+		WITH window_clicks AS (%s
+		), window_conversions AS (
+			SELECT bannerid, COUNT(*) AS conversions
+			FROM conversions
+			%s
+			GROUP BY bannerid
+		), performance AS (
+			SELECT
+				b.id AS banner_id,
+				b.name AS banner_name,
+				COALESCE(c.clicks, 0) AS clicks,
+				COALESCE(c.clicks - c.filtered_clicks, 0) AS net_clicks,
+				COALESCE(c.filtered_clicks, 0) AS filtered_clicks,
+				COALESCE(v.conversions, 0) AS conversions,
+				bc.first_click,
+				bc.last_click
+			FROM banners b
+			LEFT JOIN window_clicks c ON c.bannerid = b.id
+			LEFT JOIN window_conversions v ON v.bannerid = b.id
+			LEFT JOIN banner_counters bc ON bc.bannerid = b.id
+		)
+		SELECT
+			banner_id, banner_name, clicks, net_clicks, filtered_clicks, conversions,
+			CASE WHEN net_clicks > 0 THEN conversions::float8 / net_clicks ELSE 0 END AS conversion_rate,
+			first_click, last_click,
+			COUNT(*) OVER () AS total
+		FROM performance
+		ORDER BY %s
+		LIMIT $1 OFFSET $2`, clicksQuery, conversionsWhere, q.orderBy())
+
+	rows, err := r.query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get banner performance: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item := &BannerPerformance{}
+		var firstClick, lastClick sql.NullTime
+		err := rows.Scan(
+			&item.BannerID,
+			&item.BannerName,
+			&item.Clicks,
+			&item.NetClicks,
+			&item.FilteredClicks,
+			&item.Conversions,
+			&item.ConversionRate,
+			&firstClick,
+			&lastClick,
+			&page.Total,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan banner performance: %w", err)
+		}
+
+		if firstClick.Valid {
+			item.FirstClick = &firstClick.Time
+		}
+		if lastClick.Valid {
+			item.LastClick = &lastClick.Time
+		}
+
+		page.Items = append(page.Items, item)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating banner performance: %w", err)
+	}
+
+	// A page past the end has no rows to carry the total
+	if len(page.Items) == 0 && q.Offset > 0 {
+		if page.Total, err = r.CountBanners(); err != nil {
+			return nil, err
+		}
+	}
+
+	return page, nil
+}
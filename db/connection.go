@@ -2,10 +2,16 @@ package db
 
 import (
 	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"os"
+	"syscall"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
+	"github.com/tyagnii/ecom_test/resilience"
 )
 
 // Config holds database configuration
@@ -48,6 +54,38 @@ func Connect(config *Config) (*sql.DB, error) {
 	return db, nil
 }
 
+// IsConnectionError reports whether err means the database could not be reached,
+// as opposed to the query itself failing
+func IsConnectionError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE) || errors.Is(err, resilience.ErrCircuitOpen) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		// Class 08 is connection exceptions; 57P01-57P03 are server shutdown and startup
+		switch pqErr.Code {
+		case "57P01", "57P02", "57P03":
+			return true
+		}
+		return pqErr.Code.Class() == "08"
+	}
+
+	return false
+}
+
 // Helper functions for environment variables
 func getEnvOrDefault(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
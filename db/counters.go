@@ -0,0 +1,82 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// bannerCountersUpsert is a CTE that adds the rows of an "inserted" CTE, which
+// must return bannerid, timestamp and filtered_reason, to banner_counters. It
+// runs in the same statement as the insert, so counters and clicks commit together.
+// Banners are locked in ID order to keep concurrent batches from deadlocking.
+const bannerCountersUpsert = `
+	counted AS (
+		INSERT INTO banner_counters AS bc (bannerid, total_clicks, filtered_clicks, first_click, last_click)
+		SELECT 
+			bannerid,
+			COUNT(*),
+			COUNT(*) FILTER (WHERE filtered_reason IS NOT NULL),
+			MIN(timestamp),
+			MAX(timestamp)
+		FROM inserted
+		GROUP BY bannerid
+		ORDER BY bannerid
+		ON CONFLICT (bannerid) DO UPDATE SET
+			total_clicks = bc.total_clicks + EXCLUDED.total_clicks,
+			filtered_clicks = bc.filtered_clicks + EXCLUDED.filtered_clicks,
+			first_click = LEAST(bc.first_click, EXCLUDED.first_click),
+			last_click = GREATEST(bc.last_click, EXCLUDED.last_click)
+	)`
+
+// RebuildBannerCounters recomputes banner counters from the clicks table. With
+// no banner IDs every banner is rebuilt. Click writes are blocked while the
+// counters are recomputed so that no click is lost between the scan and the update.
+// It returns the number of banners rebuilt.
+func (r *Repository) RebuildBannerCounters(bannerIDs []int) (int, error) {
+	query := `
+		INSERT INTO banner_counters AS bc (bannerid, total_clicks, filtered_clicks, first_click, last_click)
+		SELECT 
+			b.id,
+			COUNT(c.id),
+			COUNT(c.id) FILTER (WHERE c.filtered_reason IS NOT NULL),
+			MIN(c.timestamp),
+			MAX(c.timestamp)
+		FROM banners b
+		LEFT JOIN clicks c ON b.id = c.bannerid
+		WHERE cardinality($1::int[]) = 0 OR b.id = ANY($1)
+		GROUP BY b.id
+		ON CONFLICT (bannerid) DO UPDATE SET
+			total_clicks = EXCLUDED.total_clicks,
+			filtered_clicks = EXCLUDED.filtered_clicks,
+			first_click = EXCLUDED.first_click,
+			last_click = EXCLUDED.last_click`
+
+	if bannerIDs == nil {
+		bannerIDs = []int{}
+	}
+
+	var rebuilt int64
+	err := r.inTx("counter rebuild", func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`LOCK TABLE clicks IN SHARE MODE`); err != nil {
+			return fmt.Errorf("failed to lock clicks: %w", err)
+		}
+
+		result, err := tx.Exec(query, pq.Array(bannerIDs))
+		if err != nil {
+			return fmt.Errorf("failed to rebuild banner counters: %w", err)
+		}
+
+		rebuilt, err = result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to get rows affected: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return int(rebuilt), nil
+}
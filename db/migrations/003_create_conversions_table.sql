@@ -0,0 +1,30 @@
+-- Migration: Create conversions table
+-- Created: 2025-02-10
+
+CREATE TABLE IF NOT EXISTS conversions (
+    id SERIAL PRIMARY KEY,
+    click_id INTEGER NOT NULL,
+    bannerid INTEGER NOT NULL,
+    value NUMERIC(14, 2) NOT NULL DEFAULT 0,
+    currency CHAR(3) NOT NULL,
+    timestamp TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Link conversions to the attributed click and banner
+ALTER TABLE conversions 
+ADD CONSTRAINT fk_conversions_click_id 
+FOREIGN KEY (click_id) 
+REFERENCES clicks(id) 
+ON DELETE CASCADE;
+
+ALTER TABLE conversions 
+ADD CONSTRAINT fk_conversions_bannerid 
+FOREIGN KEY (bannerid) 
+REFERENCES banners(id) 
+ON DELETE CASCADE 
+ON UPDATE CASCADE;
+
+-- Create indexes for better performance
+CREATE INDEX IF NOT EXISTS idx_conversions_click_id ON conversions(click_id);
+CREATE INDEX IF NOT EXISTS idx_conversions_bannerid_timestamp ON conversions(bannerid, timestamp);
@@ -0,0 +1,9 @@
+-- Migration: Add filtered reason to clicks
+-- Created: 2025-02-17
+
+-- Clicks classified as bot or crawler traffic keep the reason they were filtered;
+-- NULL means the click counts towards net numbers
+ALTER TABLE clicks ADD COLUMN IF NOT EXISTS filtered_reason TEXT;
+
+-- Partial index to count filtered clicks per banner
+CREATE INDEX IF NOT EXISTS idx_clicks_bannerid_filtered ON clicks(bannerid) WHERE filtered_reason IS NOT NULL;
@@ -0,0 +1,34 @@
+-- Migration: Create banner counters table
+-- Created: 2025-03-03
+
+-- Per-banner click totals maintained by every click write, so that stats and
+-- rankings do not aggregate the clicks table
+CREATE TABLE IF NOT EXISTS banner_counters (
+    bannerid INTEGER PRIMARY KEY,
+    total_clicks BIGINT NOT NULL DEFAULT 0,
+    filtered_clicks BIGINT NOT NULL DEFAULT 0,
+    first_click TIMESTAMP WITH TIME ZONE,
+    last_click TIMESTAMP WITH TIME ZONE
+);
+
+ALTER TABLE banner_counters 
+ADD CONSTRAINT fk_banner_counters_bannerid 
+FOREIGN KEY (bannerid) 
+REFERENCES banners(id) 
+ON DELETE CASCADE 
+ON UPDATE CASCADE;
+
+-- Ranking index for top banners
+CREATE INDEX IF NOT EXISTS idx_banner_counters_total_clicks ON banner_counters(total_clicks DESC);
+
+-- Backfill from existing clicks
+INSERT INTO banner_counters (bannerid, total_clicks, filtered_clicks, first_click, last_click)
+SELECT 
+    bannerid,
+    COUNT(*),
+    COUNT(*) FILTER (WHERE filtered_reason IS NOT NULL),
+    MIN(timestamp),
+    MAX(timestamp)
+FROM clicks
+GROUP BY bannerid
+ON CONFLICT (bannerid) DO NOTHING;
@@ -0,0 +1,70 @@
+-- Migration: Partition clicks by month
+-- Created: 2025-03-10
+
+-- Partitioned tables need the partition key in every unique constraint, so the
+-- primary key becomes (id, timestamp) and clicks can no longer be the target of
+-- a foreign key. Conversions keep their click_id; RecordConversion checks that
+-- the click exists and conversions outlive purged raw clicks.
+ALTER TABLE conversions DROP CONSTRAINT IF EXISTS fk_conversions_click_id;
+
+-- Keep the ID sequence when the old table is dropped
+ALTER SEQUENCE clicks_id_seq OWNED BY NONE;
+
+ALTER TABLE clicks RENAME TO clicks_unpartitioned;
+
+CREATE TABLE clicks (
+    id INTEGER NOT NULL DEFAULT nextval('clicks_id_seq'),
+    timestamp TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    bannerid INTEGER NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    filtered_reason TEXT
+) PARTITION BY RANGE (timestamp);
+
+-- Catches clicks outside the pre-created months; the partition manager moves
+-- them into a monthly partition when it creates one
+CREATE TABLE clicks_default PARTITION OF clicks DEFAULT;
+
+-- One partition per month from the oldest click until three months ahead
+DO $$
+DECLARE
+    month_start DATE;
+    last_month DATE := date_trunc('month', CURRENT_TIMESTAMP AT TIME ZONE 'UTC')::date + INTERVAL '3 months';
+BEGIN
+    SELECT date_trunc('month', MIN(timestamp) AT TIME ZONE 'UTC')::date INTO month_start FROM clicks_unpartitioned;
+    IF month_start IS NULL OR month_start > last_month THEN
+        month_start := date_trunc('month', CURRENT_TIMESTAMP AT TIME ZONE 'UTC')::date;
+    END IF;
+
+    WHILE month_start <= last_month LOOP
+        EXECUTE format(
+            'CREATE TABLE IF NOT EXISTS %I PARTITION OF clicks FOR VALUES FROM (%L) TO (%L)',
+            'clicks_' || to_char(month_start, 'YYYY_MM'),
+            month_start::timestamp AT TIME ZONE 'UTC',
+            (month_start + INTERVAL '1 month')::timestamp AT TIME ZONE 'UTC'
+        );
+        month_start := month_start + INTERVAL '1 month';
+    END LOOP;
+END $$;
+
+INSERT INTO clicks (id, timestamp, bannerid, created_at, filtered_reason)
+SELECT id, COALESCE(timestamp, created_at, CURRENT_TIMESTAMP), bannerid, created_at, filtered_reason
+FROM clicks_unpartitioned;
+
+DROP TABLE clicks_unpartitioned;
+
+ALTER SEQUENCE clicks_id_seq OWNED BY clicks.id;
+
+ALTER TABLE clicks ADD PRIMARY KEY (id, timestamp);
+
+ALTER TABLE clicks 
+ADD CONSTRAINT fk_clicks_bannerid 
+FOREIGN KEY (bannerid) 
+REFERENCES banners(id) 
+ON DELETE CASCADE 
+ON UPDATE CASCADE;
+
+-- Indexes are created on every partition; bannerid alone is covered by the composite index
+CREATE INDEX IF NOT EXISTS idx_clicks_timestamp ON clicks(timestamp);
+CREATE INDEX IF NOT EXISTS idx_clicks_created_at ON clicks(created_at);
+CREATE INDEX IF NOT EXISTS idx_clicks_bannerid_timestamp ON clicks(bannerid, timestamp);
+CREATE INDEX IF NOT EXISTS idx_clicks_bannerid_filtered ON clicks(bannerid) WHERE filtered_reason IS NOT NULL;
@@ -0,0 +1,61 @@
+-- Migration: Create click rollups
+-- Created: 2025-03-17
+
+-- Ingestion time set by the database. Rollups advance a watermark over it, so
+-- late clicks and clicks replayed from the WAL are folded in whatever their timestamp.
+ALTER TABLE clicks ADD COLUMN IF NOT EXISTS ingested_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_clicks_ingested_at ON clicks(ingested_at);
+
+-- Per-banner click counts by UTC minute, hour and day
+CREATE TABLE IF NOT EXISTS click_rollups_minute (
+    bannerid INTEGER NOT NULL,
+    bucket TIMESTAMP WITH TIME ZONE NOT NULL,
+    clicks BIGINT NOT NULL DEFAULT 0,
+    filtered_clicks BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (bannerid, bucket)
+);
+
+CREATE TABLE IF NOT EXISTS click_rollups_hour (
+    bannerid INTEGER NOT NULL,
+    bucket TIMESTAMP WITH TIME ZONE NOT NULL,
+    clicks BIGINT NOT NULL DEFAULT 0,
+    filtered_clicks BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (bannerid, bucket)
+);
+
+CREATE TABLE IF NOT EXISTS click_rollups_day (
+    bannerid INTEGER NOT NULL,
+    bucket TIMESTAMP WITH TIME ZONE NOT NULL,
+    clicks BIGINT NOT NULL DEFAULT 0,
+    filtered_clicks BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (bannerid, bucket)
+);
+
+ALTER TABLE click_rollups_minute 
+ADD CONSTRAINT fk_click_rollups_minute_bannerid 
+FOREIGN KEY (bannerid) REFERENCES banners(id) ON DELETE CASCADE ON UPDATE CASCADE;
+
+ALTER TABLE click_rollups_hour 
+ADD CONSTRAINT fk_click_rollups_hour_bannerid 
+FOREIGN KEY (bannerid) REFERENCES banners(id) ON DELETE CASCADE ON UPDATE CASCADE;
+
+ALTER TABLE click_rollups_day 
+ADD CONSTRAINT fk_click_rollups_day_bannerid 
+FOREIGN KEY (bannerid) REFERENCES banners(id) ON DELETE CASCADE ON UPDATE CASCADE;
+
+-- Retention deletes by bucket across banners
+CREATE INDEX IF NOT EXISTS idx_click_rollups_minute_bucket ON click_rollups_minute(bucket);
+CREATE INDEX IF NOT EXISTS idx_click_rollups_hour_bucket ON click_rollups_hour(bucket);
+CREATE INDEX IF NOT EXISTS idx_click_rollups_day_bucket ON click_rollups_day(bucket);
+
+-- Clicks ingested up to the watermark are contained in the rollups
+CREATE TABLE IF NOT EXISTS rollup_watermarks (
+    name VARCHAR(64) PRIMARY KEY,
+    watermark TIMESTAMP WITH TIME ZONE NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO rollup_watermarks (name, watermark) 
+VALUES ('clicks', '1970-01-01 00:00:00+00') 
+ON CONFLICT (name) DO NOTHING;
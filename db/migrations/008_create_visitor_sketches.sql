@@ -0,0 +1,19 @@
+-- Migration: Create visitor sketches
+-- Created: 2025-03-24
+
+-- HyperLogLog sketches of the visitors clicking a banner in a UTC hour. Sketches
+-- are merged on read to estimate unique visitors over arbitrary hour ranges.
+CREATE TABLE IF NOT EXISTS visitor_sketches (
+    bannerid INTEGER NOT NULL,
+    bucket TIMESTAMP WITH TIME ZONE NOT NULL,
+    sketch BYTEA NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (bannerid, bucket)
+);
+
+ALTER TABLE visitor_sketches 
+ADD CONSTRAINT fk_visitor_sketches_bannerid 
+FOREIGN KEY (bannerid) REFERENCES banners(id) ON DELETE CASCADE ON UPDATE CASCADE;
+
+-- Retention deletes by bucket across banners
+CREATE INDEX IF NOT EXISTS idx_visitor_sketches_bucket ON visitor_sketches(bucket);
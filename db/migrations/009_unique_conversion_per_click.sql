@@ -0,0 +1,13 @@
+-- Migration: One conversion per click
+-- Created: 2025-04-07
+
+-- Replaying a click token must not count its revenue again. Keep the first
+-- conversion of every click before enforcing uniqueness.
+DELETE FROM conversions c
+USING conversions earlier
+WHERE earlier.click_id = c.click_id
+AND earlier.id < c.id;
+
+DROP INDEX IF EXISTS idx_conversions_click_id;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_conversions_click_id_unique ON conversions(click_id);
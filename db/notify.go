@@ -0,0 +1,18 @@
+package db
+
+import "fmt"
+
+// MaxNotifyPayload is the largest NOTIFY payload Postgres accepts, in bytes
+const MaxNotifyPayload = 7999
+
+// Notify sends a notification to the listeners of a Postgres channel
+func (r *Repository) Notify(channel, payload string) error {
+	if len(payload) > MaxNotifyPayload {
+		return fmt.Errorf("notification payload of %d bytes exceeds %d", len(payload), MaxNotifyPayload)
+	}
+
+	if _, err := r.exec(`SELECT pg_notify($1, $2)`, channel, payload); err != nil {
+		return fmt.Errorf("failed to notify channel %s: %w", channel, err)
+	}
+	return nil
+}
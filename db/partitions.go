@@ -0,0 +1,172 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ClickPartitionPrefix prefixes the names of monthly click partitions
+const ClickPartitionPrefix = "clicks_"
+
+// clickPartitionLayout formats the month part of a partition name
+const clickPartitionLayout = "2006_01"
+
+// ClickPartition describes a partition of the clicks table
+type ClickPartition struct {
+	Name string `json:"name"`
+	// From and To bound the month covered by the partition; both are zero for the default partition
+	From    time.Time `json:"from"`
+	To      time.Time `json:"to"`
+	Default bool      `json:"default"`
+	// Rows is the planner's row estimate
+	Rows  int64 `json:"rows"`
+	Bytes int64 `json:"bytes"`
+}
+
+// ClickPartitionName returns the name of the partition holding the given month
+func ClickPartitionName(month time.Time) string {
+	return ClickPartitionPrefix + month.UTC().Format(clickPartitionLayout)
+}
+
+// MonthStart returns the first instant of the UTC month containing t
+func MonthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// ListClickPartitions returns the partitions of the clicks table ordered by month,
+// with the default partition last
+func (r *Repository) ListClickPartitions() ([]*ClickPartition, error) {
+	query := `
+		SELECT
+			c.relname,
+			pg_get_expr(c.relpartbound, c.oid) = 'DEFAULT' as is_default,
+			GREATEST(c.reltuples, 0)::bigint as row_estimate,
+			pg_total_relation_size(c.oid) as bytes
+		FROM pg_inherits i
+		JOIN pg_class c ON c.oid = i.inhrelid
+		JOIN pg_class p ON p.oid = i.inhparent
+		WHERE p.relname = 'clicks' AND p.relnamespace = current_schema()::regnamespace
+		ORDER BY is_default, c.relname`
+
+	rows, err := r.query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list click partitions: %w", err)
+	}
+	defer rows.Close()
+
+	var partitions []*ClickPartition
+	for rows.Next() {
+		partition := &ClickPartition{}
+		if err := rows.Scan(&partition.Name, &partition.Default, &partition.Rows, &partition.Bytes); err != nil {
+			return nil, fmt.Errorf("failed to scan click partition: %w", err)
+		}
+
+		if !partition.Default {
+			month, err := time.Parse(clickPartitionLayout, strings.TrimPrefix(partition.Name, ClickPartitionPrefix))
+			if err == nil {
+				partition.From = month
+				partition.To = month.AddDate(0, 1, 0)
+			}
+		}
+
+		partitions = append(partitions, partition)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating click partitions: %w", err)
+	}
+
+	return partitions, nil
+}
+
+// CreateClickPartition creates the partition for the month containing the given
+// time unless it exists. Clicks of that month that landed in the default partition
+// are moved into the new partition before it is attached. It reports whether
+// a partition was created. Concurrent callers for the same month serialize on
+// a transaction-scoped advisory lock, so only one of them creates it.
+func (r *Repository) CreateClickPartition(month time.Time) (bool, error) {
+	from := MonthStart(month)
+	to := from.AddDate(0, 1, 0)
+	name := ClickPartitionName(from)
+
+	var exists bool
+	err := r.queryRow(`SELECT to_regclass($1) IS NOT NULL`, name).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to check partition %s: %w", name, err)
+	}
+	if exists {
+		return false, nil
+	}
+
+	table := pq.QuoteIdentifier(name)
+	statements := []struct {
+		query string
+		args  []interface{}
+	}{
+		{fmt.Sprintf(`CREATE TABLE %s (LIKE clicks INCLUDING DEFAULTS)`, table), nil},
+		{fmt.Sprintf(`
+			WITH moved AS (
+				DELETE FROM clicks_default
+				WHERE timestamp >= $1 AND timestamp < $2
+				RETURNING *
+			)
+			INSERT INTO %s SELECT * FROM moved`, table), []interface{}{from, to}},
+		{fmt.Sprintf(`ALTER TABLE clicks ATTACH PARTITION %s FOR VALUES FROM (%s) TO (%s)`,
+			table, pq.QuoteLiteral(from.Format(time.RFC3339)), pq.QuoteLiteral(to.Format(time.RFC3339))), nil},
+	}
+
+	created := false
+	err = r.inTx("partition "+name, func(tx *sql.Tx) error {
+		created = false
+		if _, err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtext($1))`, name); err != nil {
+			return fmt.Errorf("failed to lock partition %s: %w", name, err)
+		}
+
+		// Another instance may have created it while we waited for the lock
+		var exists bool
+		if err := tx.QueryRow(`SELECT to_regclass($1) IS NOT NULL`, name).Scan(&exists); err != nil {
+			return fmt.Errorf("failed to check partition %s: %w", name, err)
+		}
+		if exists {
+			return nil
+		}
+
+		for _, statement := range statements {
+			if _, err := tx.Exec(statement.query, statement.args...); err != nil {
+				return fmt.Errorf("failed to create partition %s: %w", name, err)
+			}
+		}
+		created = true
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return created, nil
+}
+
+// EnsureClickPartitions creates the partitions from the month containing from
+// up to and including the given number of months ahead. It returns the names
+// of the partitions that were created.
+func (r *Repository) EnsureClickPartitions(from time.Time, monthsAhead int) ([]string, error) {
+	var created []string
+	month := MonthStart(from)
+	for i := 0; i <= monthsAhead; i++ {
+		ok, err := r.CreateClickPartition(month)
+		if err != nil {
+			return created, err
+		}
+		if ok {
+			created = append(created, ClickPartitionName(month))
+		}
+		month = month.AddDate(0, 1, 0)
+	}
+
+	return created, nil
+}
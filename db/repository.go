@@ -2,15 +2,28 @@ package db
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"sync/atomic"
 	"time"
 
+	"github.com/lib/pq"
 	"github.com/tyagnii/ecom_test/dto"
+	"github.com/tyagnii/ecom_test/resilience"
 )
 
+// ErrNotFound is wrapped by lookup errors when the requested row does not exist
+var ErrNotFound = errors.New("not found")
+
+// ErrDuplicate is wrapped by insert errors when an equivalent row already exists
+var ErrDuplicate = errors.New("already exists")
+
 // Repository provides database operations
 type Repository struct {
-	db *sql.DB
+	db     *sql.DB
+	policy *resilience.Policy
+
+	statements atomic.Int64
 }
 
 // NewRepository creates a new repository instance
@@ -18,10 +31,18 @@ func NewRepository(db *sql.DB) *Repository {
 	return &Repository{db: db}
 }
 
+// NewResilientRepository creates a repository that runs every statement
+// through the given retry and circuit breaker policy
+func NewResilientRepository(db *sql.DB, policy *resilience.Policy) *Repository {
+	return &Repository{db: db, policy: policy}
+}
+
 // ClickStats represents click statistics
 type ClickStats struct {
 	BannerID    int       `json:"banner_id"`
 	TotalClicks int       `json:"total_clicks"`
+	FilteredClicks int    `json:"filtered_clicks"`
+	NetClicks      int    `json:"net_clicks"`
 	FirstClick  time.Time `json:"first_click"`
 	LastClick   time.Time `json:"last_click"`
 }
@@ -52,6 +73,11 @@ type DailyClicks struct {
 	ClickCount int       `json:"click_count"`
 }
 
+// Ping checks that the database is reachable
+func (r *Repository) Ping() error {
+	return r.db.Ping()
+}
+
 // Banner CRUD Operations
 
 // CreateBanner creates a new banner
@@ -61,7 +87,7 @@ func (r *Repository) CreateBanner(banner *dto.Banner) error {
 		VALUES ($1, $2, $3) 
 		RETURNING id`
 	
-	err := r.db.QueryRow(
+	err := r.queryRowWrite(
 		query,
 		banner.Name,
 		banner.CreatedAt,
@@ -83,7 +109,7 @@ func (r *Repository) GetBannerByID(id int) (*dto.Banner, error) {
 		WHERE id = $1`
 	
 	banner := &dto.Banner{}
-	err := r.db.QueryRow(query, id).Scan(
+	err := r.queryRow(query, id).Scan(
 		&banner.ID,
 		&banner.Name,
 		&banner.CreatedAt,
@@ -92,7 +118,7 @@ func (r *Repository) GetBannerByID(id int) (*dto.Banner, error) {
 	
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("banner with ID %d not found", id)
+			return nil, fmt.Errorf("banner with ID %d %w", id, ErrNotFound)
 		}
 		return nil, fmt.Errorf("failed to get banner: %w", err)
 	}
@@ -100,6 +126,15 @@ func (r *Repository) GetBannerByID(id int) (*dto.Banner, error) {
 	return banner, nil
 }
 
+// CountBanners returns the number of banners
+func (r *Repository) CountBanners() (int, error) {
+	var count int
+	if err := r.queryRow(`SELECT COUNT(*) FROM banners`).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count banners: %w", err)
+	}
+	return count, nil
+}
+
 // GetAllBanners retrieves all banners
 func (r *Repository) GetAllBanners() ([]*dto.Banner, error) {
 	query := `
@@ -107,7 +142,7 @@ func (r *Repository) GetAllBanners() ([]*dto.Banner, error) {
 		FROM banners 
 		ORDER BY created_at DESC`
 	
-	rows, err := r.db.Query(query)
+	rows, err := r.query(query)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get banners: %w", err)
 	}
@@ -142,7 +177,7 @@ func (r *Repository) UpdateBanner(banner *dto.Banner) error {
 		SET name = $1, updated_at = $2 
 		WHERE id = $3`
 	
-	result, err := r.db.Exec(query, banner.Name, banner.UpdatedAt, banner.ID)
+	result, err := r.exec(query, banner.Name, banner.UpdatedAt, banner.ID)
 	if err != nil {
 		return fmt.Errorf("failed to update banner: %w", err)
 	}
@@ -153,7 +188,7 @@ func (r *Repository) UpdateBanner(banner *dto.Banner) error {
 	}
 	
 	if rowsAffected == 0 {
-		return fmt.Errorf("banner with ID %d not found", banner.ID)
+		return fmt.Errorf("banner with ID %d %w", banner.ID, ErrNotFound)
 	}
 	
 	return nil
@@ -163,7 +198,7 @@ func (r *Repository) UpdateBanner(banner *dto.Banner) error {
 func (r *Repository) DeleteBanner(id int) error {
 	query := `DELETE FROM banners WHERE id = $1`
 	
-	result, err := r.db.Exec(query, id)
+	result, err := r.execWrite(query, id)
 	if err != nil {
 		return fmt.Errorf("failed to delete banner: %w", err)
 	}
@@ -174,7 +209,7 @@ func (r *Repository) DeleteBanner(id int) error {
 	}
 	
 	if rowsAffected == 0 {
-		return fmt.Errorf("banner with ID %d not found", id)
+		return fmt.Errorf("banner with ID %d %w", id, ErrNotFound)
 	}
 	
 	return nil
@@ -188,7 +223,7 @@ func (r *Repository) GetBannerByName(name string) (*dto.Banner, error) {
 		WHERE name = $1`
 	
 	banner := &dto.Banner{}
-	err := r.db.QueryRow(query, name).Scan(
+	err := r.queryRow(query, name).Scan(
 		&banner.ID,
 		&banner.Name,
 		&banner.CreatedAt,
@@ -197,7 +232,7 @@ func (r *Repository) GetBannerByName(name string) (*dto.Banner, error) {
 	
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("banner with name '%s' not found", name)
+			return nil, fmt.Errorf("banner with name '%s' %w", name, ErrNotFound)
 		}
 		return nil, fmt.Errorf("failed to get banner by name: %w", err)
 	}
@@ -213,7 +248,7 @@ func (r *Repository) SearchBannersByName(namePattern string) ([]*dto.Banner, err
 		WHERE name ILIKE $1 
 		ORDER BY name`
 	
-	rows, err := r.db.Query(query, "%"+namePattern+"%")
+	rows, err := r.query(query, "%"+namePattern+"%")
 	if err != nil {
 		return nil, fmt.Errorf("failed to search banners: %w", err)
 	}
@@ -246,14 +281,13 @@ func (r *Repository) GetBannersWithClickCount() ([]*BannerWithStats, error) {
 	query := `
 		SELECT 
 			b.id, b.name, b.created_at, b.updated_at,
-			COUNT(c.id) as click_count,
-			MAX(c.timestamp) as last_click
+			COALESCE(bc.total_clicks, 0) as click_count,
+			bc.last_click
 		FROM banners b
-		LEFT JOIN clicks c ON b.id = c.bannerid
-		GROUP BY b.id, b.name, b.created_at, b.updated_at
+		LEFT JOIN banner_counters bc ON b.id = bc.bannerid
 		ORDER BY click_count DESC, b.created_at DESC`
 	
-	rows, err := r.db.Query(query)
+	rows, err := r.query(query)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get banners with click count: %w", err)
 	}
@@ -294,45 +328,146 @@ func (r *Repository) GetBannersWithClickCount() ([]*BannerWithStats, error) {
 
 // Click CRUD Operations
 
-// CreateClick creates a new click
+// CreateClick creates a new click and counts it in the banner counters
 func (r *Repository) CreateClick(click *dto.Click) error {
 	query := `
-		INSERT INTO clicks (timestamp, bannerid, created_at) 
-		VALUES ($1, $2, $3) 
-		RETURNING id`
-	
-	err := r.db.QueryRow(
+		WITH inserted AS (
+			INSERT INTO clicks (timestamp, bannerid, created_at, filtered_reason) 
+			VALUES ($1, $2, $3, NULLIF($4, '')) 
+			RETURNING id, bannerid, timestamp, filtered_reason
+		),` + bannerCountersUpsert + `
+		SELECT id FROM inserted`
+	
+	err := r.queryRowWrite(
 		query,
 		click.Timestamp,
 		click.BannerID,
 		click.CreatedAt,
+		click.FilteredReason,
 	).Scan(&click.ID)
 	
 	if err != nil {
+		// The foreign key enforces banner existence without a separate lookup
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+			return fmt.Errorf("banner with ID %d %w", click.BannerID, ErrNotFound)
+		}
 		return fmt.Errorf("failed to create click: %w", err)
 	}
 	
 	return nil
 }
 
+// CreateClicks inserts clicks in a single statement, counts them in the banner
+// counters and sets their IDs. IDs are drawn per input row, numbered WITH
+// ORDINALITY, since RETURNING does not guarantee any row order.
+func (r *Repository) CreateClicks(clicks []*dto.Click) error {
+	if len(clicks) == 0 {
+		return nil
+	}
+
+	timestamps := make([]string, len(clicks))
+	bannerIDs := make([]int, len(clicks))
+	createdAt := make([]string, len(clicks))
+	reasons := make([]string, len(clicks))
+	for i, click := range clicks {
+		timestamps[i] = click.Timestamp.Format(time.RFC3339Nano)
+		bannerIDs[i] = click.BannerID
+		createdAt[i] = click.CreatedAt.Format(time.RFC3339Nano)
+		reasons[i] = click.FilteredReason
+	}
+
+	query := `
+		WITH input AS (
+			SELECT nextval('clicks_id_seq') AS id, t.*
+			FROM unnest($1::timestamptz[], $2::int[], $3::timestamptz[], $4::text[])
+				WITH ORDINALITY AS t(timestamp, bannerid, created_at, filtered_reason, ord)
+		), inserted AS (
+			INSERT INTO clicks (id, timestamp, bannerid, created_at, filtered_reason)
+			SELECT id, timestamp, bannerid, created_at, NULLIF(filtered_reason, '')
+			FROM input
+			RETURNING id, bannerid, timestamp, filtered_reason
+		),` + bannerCountersUpsert + `
+		SELECT input.ord, input.id FROM input JOIN inserted USING (id)`
+
+	rows, err := r.queryWrite(query, pq.Array(timestamps), pq.Array(bannerIDs), pq.Array(createdAt), pq.Array(reasons))
+	if err != nil {
+		return fmt.Errorf("failed to create clicks: %w", err)
+	}
+	defer rows.Close()
+
+	assigned := 0
+	for rows.Next() {
+		var ord, id int
+		if err := rows.Scan(&ord, &id); err != nil {
+			return fmt.Errorf("failed to scan click ID: %w", err)
+		}
+		if ord < 1 || ord > len(clicks) {
+			return fmt.Errorf("failed to create clicks: unexpected row %d", ord)
+		}
+		clicks[ord-1].ID = id
+		assigned++
+	}
+
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("error iterating created clicks: %w", err)
+	}
+	if assigned != len(clicks) {
+		return fmt.Errorf("failed to create clicks: %d of %d rows returned", assigned, len(clicks))
+	}
+
+	return nil
+}
+
+// GetExistingBannerIDs returns which of the given banner IDs exist
+func (r *Repository) GetExistingBannerIDs(ids []int) (map[int]bool, error) {
+	existing := make(map[int]bool)
+	if len(ids) == 0 {
+		return existing, nil
+	}
+
+	query := `SELECT id FROM banners WHERE id = ANY($1)`
+
+	rows, err := r.query(query, pq.Array(ids))
+	if err != nil {
+		return nil, fmt.Errorf("failed to check banner IDs: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan banner ID: %w", err)
+		}
+		existing[id] = true
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating banner IDs: %w", err)
+	}
+
+	return existing, nil
+}
+
 // GetClickByID retrieves a click by ID
 func (r *Repository) GetClickByID(id int) (*dto.Click, error) {
 	query := `
-		SELECT id, timestamp, bannerid, created_at 
+		SELECT id, timestamp, bannerid, created_at, COALESCE(filtered_reason, '') 
 		FROM clicks 
 		WHERE id = $1`
 	
 	click := &dto.Click{}
-	err := r.db.QueryRow(query, id).Scan(
+	err := r.queryRow(query, id).Scan(
 		&click.ID,
 		&click.Timestamp,
 		&click.BannerID,
 		&click.CreatedAt,
+		&click.FilteredReason,
 	)
 	
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("click with ID %d not found", id)
+			return nil, fmt.Errorf("click with ID %d %w", id, ErrNotFound)
 		}
 		return nil, fmt.Errorf("failed to get click: %w", err)
 	}
@@ -343,11 +478,11 @@ func (r *Repository) GetClickByID(id int) (*dto.Click, error) {
 // GetAllClicks retrieves all clicks
 func (r *Repository) GetAllClicks() ([]*dto.Click, error) {
 	query := `
-		SELECT id, timestamp, bannerid, created_at 
+		SELECT id, timestamp, bannerid, created_at, COALESCE(filtered_reason, '') 
 		FROM clicks 
 		ORDER BY timestamp DESC`
 	
-	rows, err := r.db.Query(query)
+	rows, err := r.query(query)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get clicks: %w", err)
 	}
@@ -361,6 +496,7 @@ func (r *Repository) GetAllClicks() ([]*dto.Click, error) {
 			&click.Timestamp,
 			&click.BannerID,
 			&click.CreatedAt,
+			&click.FilteredReason,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan click: %w", err)
@@ -378,12 +514,12 @@ func (r *Repository) GetAllClicks() ([]*dto.Click, error) {
 // GetClicksByBannerID retrieves clicks for a specific banner
 func (r *Repository) GetClicksByBannerID(bannerID int) ([]*dto.Click, error) {
 	query := `
-		SELECT id, timestamp, bannerid, created_at 
+		SELECT id, timestamp, bannerid, created_at, COALESCE(filtered_reason, '') 
 		FROM clicks 
 		WHERE bannerid = $1 
 		ORDER BY timestamp DESC`
 	
-	rows, err := r.db.Query(query, bannerID)
+	rows, err := r.query(query, bannerID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get clicks by banner ID: %w", err)
 	}
@@ -397,6 +533,7 @@ func (r *Repository) GetClicksByBannerID(bannerID int) ([]*dto.Click, error) {
 			&click.Timestamp,
 			&click.BannerID,
 			&click.CreatedAt,
+			&click.FilteredReason,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan click: %w", err)
@@ -414,12 +551,12 @@ func (r *Repository) GetClicksByBannerID(bannerID int) ([]*dto.Click, error) {
 // GetClicksByDateRange retrieves clicks within a date range
 func (r *Repository) GetClicksByDateRange(start, end time.Time) ([]*dto.Click, error) {
 	query := `
-		SELECT id, timestamp, bannerid, created_at 
+		SELECT id, timestamp, bannerid, created_at, COALESCE(filtered_reason, '') 
 		FROM clicks 
 		WHERE timestamp BETWEEN $1 AND $2 
 		ORDER BY timestamp DESC`
 	
-	rows, err := r.db.Query(query, start, end)
+	rows, err := r.query(query, start, end)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get clicks by date range: %w", err)
 	}
@@ -433,6 +570,7 @@ func (r *Repository) GetClicksByDateRange(start, end time.Time) ([]*dto.Click, e
 			&click.Timestamp,
 			&click.BannerID,
 			&click.CreatedAt,
+			&click.FilteredReason,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan click: %w", err)
@@ -450,12 +588,12 @@ func (r *Repository) GetClicksByDateRange(start, end time.Time) ([]*dto.Click, e
 // GetClicksByBannerIDAndDateRange retrieves clicks for a specific banner within a date range
 func (r *Repository) GetClicksByBannerIDAndDateRange(bannerID int, start, end time.Time) ([]*dto.Click, error) {
 	query := `
-		SELECT id, timestamp, bannerid, created_at 
+		SELECT id, timestamp, bannerid, created_at, COALESCE(filtered_reason, '') 
 		FROM clicks 
 		WHERE bannerid = $1 AND timestamp BETWEEN $2 AND $3 
 		ORDER BY timestamp DESC`
 	
-	rows, err := r.db.Query(query, bannerID, start, end)
+	rows, err := r.query(query, bannerID, start, end)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get clicks by banner ID and date range: %w", err)
 	}
@@ -469,6 +607,7 @@ func (r *Repository) GetClicksByBannerIDAndDateRange(bannerID int, start, end ti
 			&click.Timestamp,
 			&click.BannerID,
 			&click.CreatedAt,
+			&click.FilteredReason,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan click: %w", err)
@@ -483,22 +622,34 @@ func (r *Repository) GetClicksByBannerIDAndDateRange(bannerID int, start, end ti
 	return clicks, nil
 }
 
-// DeleteClick deletes a click by ID
+// DeleteClick deletes a click by ID and removes it from the banner counters.
+// First and last click times are recomputed only when the deleted click was one of them.
 func (r *Repository) DeleteClick(id int) error {
-	query := `DELETE FROM clicks WHERE id = $1`
+	query := `
+		WITH deleted AS (
+			DELETE FROM clicks WHERE id = $1
+			RETURNING id, bannerid, timestamp, filtered_reason
+		), counted AS (
+			UPDATE banner_counters bc SET
+				total_clicks = bc.total_clicks - 1,
+				filtered_clicks = bc.filtered_clicks - CASE WHEN d.filtered_reason IS NULL THEN 0 ELSE 1 END,
+				first_click = CASE WHEN d.timestamp > bc.first_click THEN bc.first_click ELSE
+					(SELECT MIN(timestamp) FROM clicks WHERE bannerid = d.bannerid AND id <> d.id) END,
+				last_click = CASE WHEN d.timestamp < bc.last_click THEN bc.last_click ELSE
+					(SELECT MAX(timestamp) FROM clicks WHERE bannerid = d.bannerid AND id <> d.id) END
+			FROM deleted d
+			WHERE bc.bannerid = d.bannerid
+		)
+		SELECT COUNT(*) FROM deleted`
 	
-	result, err := r.db.Exec(query, id)
+	var rowsAffected int
+	err := r.queryRowWrite(query, id).Scan(&rowsAffected)
 	if err != nil {
 		return fmt.Errorf("failed to delete click: %w", err)
 	}
 	
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		return fmt.Errorf("failed to get rows affected: %w", err)
-	}
-	
 	if rowsAffected == 0 {
-		return fmt.Errorf("click with ID %d not found", id)
+		return fmt.Errorf("click with ID %d %w", id, ErrNotFound)
 	}
 	
 	return nil
@@ -509,19 +660,21 @@ func (r *Repository) GetClickStats(bannerID int) (*ClickStats, error) {
 	query := `
 		SELECT 
 			bannerid,
-			COUNT(*) as total_clicks,
-			MIN(timestamp) as first_click,
-			MAX(timestamp) as last_click
-		FROM clicks 
-		WHERE bannerid = $1
-		GROUP BY bannerid`
+			total_clicks,
+			filtered_clicks,
+			first_click,
+			last_click
+		FROM banner_counters 
+		WHERE bannerid = $1`
 	
 	stats := &ClickStats{}
-	err := r.db.QueryRow(query, bannerID).Scan(
+	var firstClick, lastClick sql.NullTime
+	err := r.queryRow(query, bannerID).Scan(
 		&stats.BannerID,
 		&stats.TotalClicks,
-		&stats.FirstClick,
-		&stats.LastClick,
+		&stats.FilteredClicks,
+		&firstClick,
+		&lastClick,
 	)
 	
 	if err != nil {
@@ -534,6 +687,10 @@ func (r *Repository) GetClickStats(bannerID int) (*ClickStats, error) {
 		return nil, fmt.Errorf("failed to get click stats: %w", err)
 	}
 	
+	stats.NetClicks = stats.TotalClicks - stats.FilteredClicks
+	stats.FirstClick = firstClick.Time
+	stats.LastClick = lastClick.Time
+	
 	return stats, nil
 }
 
@@ -543,14 +700,13 @@ func (r *Repository) GetTopBanners(limit int) ([]*BannerClickCount, error) {
 		SELECT 
 			b.id as banner_id,
 			b.name as banner_name,
-			COUNT(c.id) as click_count
+			COALESCE(bc.total_clicks, 0) as click_count
 		FROM banners b
-		LEFT JOIN clicks c ON b.id = c.bannerid
-		GROUP BY b.id, b.name
+		LEFT JOIN banner_counters bc ON b.id = bc.bannerid
 		ORDER BY click_count DESC, b.name
 		LIMIT $1`
 	
-	rows, err := r.db.Query(query, limit)
+	rows, err := r.query(query, limit)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get top banners: %w", err)
 	}
@@ -577,83 +733,128 @@ func (r *Repository) GetTopBanners(limit int) ([]*BannerClickCount, error) {
 	return results, nil
 }
 
-// GetClicksByHour retrieves hourly click distribution for a banner on a specific date
-func (r *Repository) GetClicksByHour(bannerID int, date time.Time) ([]*HourlyClicks, error) {
-	startOfDay := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, date.Location())
-	endOfDay := startOfDay.Add(24 * time.Hour)
-	
-	query := `
-		SELECT 
-			EXTRACT(HOUR FROM timestamp) as hour,
-			COUNT(*) as click_count
-		FROM clicks 
-		WHERE bannerid = $1 
-		AND timestamp >= $2 
-		AND timestamp < $3
-		GROUP BY EXTRACT(HOUR FROM timestamp)
-		ORDER BY hour`
-	
-	rows, err := r.db.Query(query, bannerID, startOfDay, endOfDay)
+// GetClicksByHour retrieves hourly click distribution for a banner on the day
+// containing date in loc. Hour counts the hours elapsed since local midnight,
+// so days with a DST transition have 23 or 25 hours.
+func (r *Repository) GetClicksByHour(bannerID int, date time.Time, loc *time.Location) ([]*HourlyClicks, error) {
+	startOfDay := LocalMidnight(date, loc)
+	endOfDay := LocalMidnight(startOfDay.AddDate(0, 0, 1), loc)
+	
+	source := ChooseRollupLevel(startOfDay, endOfDay, RollupHour, loc)
+	series, err := r.GetClickSeries(bannerID, startOfDay, endOfDay, source, RollupHour, loc)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get clicks by hour: %w", err)
 	}
-	defer rows.Close()
 	
 	var results []*HourlyClicks
-	for rows.Next() {
-		result := &HourlyClicks{}
-		err := rows.Scan(
-			&result.Hour,
-			&result.ClickCount,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan hourly clicks: %w", err)
-		}
-		results = append(results, result)
+	for _, bucket := range series.Buckets {
+		results = append(results, &HourlyClicks{
+			Hour:       int(bucket.Start.Sub(startOfDay) / time.Hour),
+			ClickCount: bucket.Clicks,
+		})
 	}
 	
-	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating hourly clicks: %w", err)
+	return results, nil
+}
+
+// GetClicksByDay retrieves daily click distribution for a banner within a date range.
+// Both ends are whole days in loc and each date is the local midnight it starts at.
+func (r *Repository) GetClicksByDay(bannerID int, startDate, endDate time.Time, loc *time.Location) ([]*DailyClicks, error) {
+	from := LocalMidnight(startDate, loc)
+	to := LocalMidnight(LocalMidnight(endDate, loc).AddDate(0, 0, 1), loc)
+	
+	source := ChooseRollupLevel(from, to, RollupDay, loc)
+	series, err := r.GetClickSeries(bannerID, from, to, source, RollupDay, loc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get clicks by day: %w", err)
+	}
+	
+	var results []*DailyClicks
+	for _, bucket := range series.Buckets {
+		results = append(results, &DailyClicks{
+			Date:       bucket.Start.In(loc),
+			ClickCount: bucket.Clicks,
+		})
 	}
 	
 	return results, nil
 }
 
-// GetClicksByDay retrieves daily click distribution for a banner within a date range
-func (r *Repository) GetClicksByDay(bannerID int, startDate, endDate time.Time) ([]*DailyClicks, error) {
+// Conversion Operations
+
+// ConversionStats represents conversion statistics for a banner
+type ConversionStats struct {
+	BannerID    int                `json:"banner_id"`
+	Conversions int                `json:"conversions"`
+	Revenue     map[string]float64 `json:"revenue"`
+}
+
+// CreateConversion creates a new conversion. A click converts at most once;
+// a second conversion for the same click is reported as ErrDuplicate.
+func (r *Repository) CreateConversion(conversion *dto.Conversion) error {
 	query := `
-		SELECT 
-			DATE(timestamp) as date,
-			COUNT(*) as click_count
-		FROM clicks 
-		WHERE bannerid = $1 
-		AND timestamp >= $2 
-		AND timestamp <= $3
-		GROUP BY DATE(timestamp)
-		ORDER BY date`
-	
-	rows, err := r.db.Query(query, bannerID, startDate, endDate)
+		INSERT INTO conversions (click_id, bannerid, value, currency, timestamp, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (click_id) DO NOTHING
+		RETURNING id`
+
+	err := r.queryRowWrite(
+		query,
+		conversion.ClickID,
+		conversion.BannerID,
+		conversion.Value,
+		conversion.Currency,
+		conversion.Timestamp,
+		conversion.CreatedAt,
+	).Scan(&conversion.ID)
+
 	if err != nil {
-		return nil, fmt.Errorf("failed to get clicks by day: %w", err)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("conversion for click %d %w", conversion.ClickID, ErrDuplicate)
+		}
+		return fmt.Errorf("failed to create conversion: %w", err)
+	}
+
+	return nil
+}
+
+// GetConversionStats retrieves conversion count and revenue per currency for a banner within [start, end)
+func (r *Repository) GetConversionStats(bannerID int, start, end time.Time) (*ConversionStats, error) {
+	query := `
+		SELECT
+			currency,
+			COUNT(*) as conversions,
+			COALESCE(SUM(value), 0) as revenue
+		FROM conversions
+		WHERE bannerid = $1
+		AND timestamp >= $2 AND timestamp < $3
+		GROUP BY currency
+		ORDER BY currency`
+
+	rows, err := r.query(query, bannerID, start, end)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get conversion stats: %w", err)
 	}
 	defer rows.Close()
-	
-	var results []*DailyClicks
+
+	stats := &ConversionStats{
+		BannerID: bannerID,
+		Revenue:  make(map[string]float64),
+	}
 	for rows.Next() {
-		result := &DailyClicks{}
-		err := rows.Scan(
-			&result.Date,
-			&result.ClickCount,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan daily clicks: %w", err)
+		var currency string
+		var conversions int
+		var revenue float64
+		if err := rows.Scan(&currency, &conversions, &revenue); err != nil {
+			return nil, fmt.Errorf("failed to scan conversion stats: %w", err)
 		}
-		results = append(results, result)
+		stats.Conversions += conversions
+		stats.Revenue[currency] = revenue
 	}
-	
+
 	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating daily clicks: %w", err)
+		return nil, fmt.Errorf("error iterating conversion stats: %w", err)
 	}
-	
-	return results, nil
+
+	return stats, nil
 }
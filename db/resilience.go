@@ -0,0 +1,181 @@
+package db
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/lib/pq"
+)
+
+// IsTransientError reports whether a statement that failed with err may
+// succeed when retried
+func IsTransientError(err error) bool {
+	if IsConnectionError(err) {
+		return true
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		// 40001 is serialization_failure, 40P01 deadlock_detected and
+		// class 53 insufficient resources such as too_many_connections
+		switch pqErr.Code {
+		case "40001", "40P01":
+			return true
+		}
+		return pqErr.Code.Class() == "53"
+	}
+
+	return false
+}
+
+// IsRetryableWrite reports whether a statement that must not be applied twice
+// may be retried after failing with err. Only transient errors known to occur
+// before the statement took effect qualify: errors reported by the server,
+// after which the statement was rolled back, and failures to obtain a
+// connection. A connection lost while the statement was in flight leaves its
+// outcome unknown and is not retried.
+func IsRetryableWrite(err error) bool {
+	if !IsTransientError(err) {
+		return false
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) || errors.Is(err, driver.ErrBadConn) {
+		return true
+	}
+
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// do runs fn through the repository policy, if any, counting every attempt
+// as a database round trip
+func (r *Repository) do(fn func() error) error {
+	return r.doWith(fn, IsTransientError)
+}
+
+// doWrite runs a statement that must not be applied twice, such as an
+// INSERT, retrying only the errors accepted by IsRetryableWrite
+func (r *Repository) doWrite(fn func() error) error {
+	return r.doWith(fn, IsRetryableWrite)
+}
+
+// doWith runs fn through the repository policy, retrying the errors accepted
+// by canRetry
+func (r *Repository) doWith(fn func() error, canRetry func(error) bool) error {
+	attempt := func() error {
+		r.statements.Add(1)
+		return fn()
+	}
+
+	if r.policy == nil {
+		return attempt()
+	}
+	return r.policy.DoWith(attempt, canRetry)
+}
+
+// inTx runs fn in a transaction through the repository policy. A failed
+// transaction was rolled back and is retried as a whole under the rules of
+// doWrite, so a commit whose outcome is unknown is not repeated. fn may run
+// more than once and must not keep state across attempts.
+func (r *Repository) inTx(name string, fn func(tx *sql.Tx) error) error {
+	return r.doWrite(func() error {
+		tx, err := r.db.Begin()
+		if err != nil {
+			return fmt.Errorf("failed to begin %s: %w", name, err)
+		}
+		defer tx.Rollback()
+
+		if err := fn(tx); err != nil {
+			return err
+		}
+
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit %s: %w", name, err)
+		}
+		return nil
+	})
+}
+
+// Statements returns the number of statements sent to the database
+func (r *Repository) Statements() int64 {
+	return r.statements.Load()
+}
+
+// exec executes a statement that returns no rows
+func (r *Repository) exec(query string, args ...interface{}) (sql.Result, error) {
+	var result sql.Result
+	err := r.do(func() error {
+		var err error
+		result, err = r.db.Exec(query, args...)
+		return err
+	})
+	return result, err
+}
+
+// execWrite executes a statement that returns no rows and must not be applied twice
+func (r *Repository) execWrite(query string, args ...interface{}) (sql.Result, error) {
+	var result sql.Result
+	err := r.doWrite(func() error {
+		var err error
+		result, err = r.db.Exec(query, args...)
+		return err
+	})
+	return result, err
+}
+
+// query executes a statement that returns rows. Only opening the result set
+// is retried; errors while iterating are returned by rows.Err.
+func (r *Repository) query(query string, args ...interface{}) (*sql.Rows, error) {
+	var rows *sql.Rows
+	err := r.do(func() error {
+		var err error
+		rows, err = r.db.Query(query, args...)
+		return err
+	})
+	return rows, err
+}
+
+// queryWrite executes a statement that returns rows and must not be applied twice
+func (r *Repository) queryWrite(query string, args ...interface{}) (*sql.Rows, error) {
+	var rows *sql.Rows
+	err := r.doWrite(func() error {
+		var err error
+		rows, err = r.db.Query(query, args...)
+		return err
+	})
+	return rows, err
+}
+
+// row is a deferred single-row query, executed when it is scanned
+type row struct {
+	repo  *Repository
+	query string
+	args  []interface{}
+	write bool
+}
+
+// queryRow prepares a statement that returns at most one row
+func (r *Repository) queryRow(query string, args ...interface{}) *row {
+	return &row{repo: r, query: query, args: args}
+}
+
+// queryRowWrite prepares a statement that returns at most one row and must
+// not be applied twice
+func (r *Repository) queryRowWrite(query string, args ...interface{}) *row {
+	return &row{repo: r, query: query, args: args, write: true}
+}
+
+// Scan executes the query and copies the columns into dest
+func (rw *row) Scan(dest ...interface{}) error {
+	scan := func() error {
+		return rw.repo.db.QueryRow(rw.query, rw.args...).Scan(dest...)
+	}
+	if rw.write {
+		return rw.repo.doWrite(scan)
+	}
+	return rw.repo.do(scan)
+}
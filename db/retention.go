@@ -0,0 +1,128 @@
+package db
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// DefaultPurgeBatchSize is the number of rows deleted per statement when no batch size is given
+const DefaultPurgeBatchSize = 10000
+
+// PurgeResult describes the rows removed, or that would be removed, by a retention purge
+type PurgeResult struct {
+	Table  string    `json:"table"`
+	Cutoff time.Time `json:"cutoff"`
+	DryRun bool      `json:"dry_run"`
+	// DroppedPartitions lists partitions lying entirely before the cutoff
+	DroppedPartitions []string `json:"dropped_partitions,omitempty"`
+	// DroppedRows is the planner's row estimate of the dropped partitions
+	DroppedRows int64 `json:"dropped_rows"`
+	// DeletedRows is the number of rows deleted from partitions that are kept
+	DeletedRows int64 `json:"deleted_rows"`
+	// Skipped explains why nothing was purged
+	Skipped string `json:"skipped,omitempty"`
+}
+
+// PurgeClicks removes raw clicks older than cutoff. Nothing is purged until the
+// rollup watermark passed the cutoff, and clicks that are not yet contained in
+// the rollups, i.e. ingested after the watermark, are kept.
+// Monthly partitions that end before the cutoff are dropped whole once fully
+// rolled up; older rows in the remaining partitions are deleted in batches of
+// batchSize. Banner counters keep lifetime totals and are not decremented.
+// With dryRun nothing is removed.
+func (r *Repository) PurgeClicks(cutoff time.Time, batchSize int, dryRun bool) (*PurgeResult, error) {
+	if batchSize <= 0 {
+		batchSize = DefaultPurgeBatchSize
+	}
+	result := &PurgeResult{Table: "clicks", Cutoff: cutoff, DryRun: dryRun}
+
+	watermark, err := r.GetRollupWatermark()
+	if err != nil {
+		return nil, err
+	}
+	if watermark.Before(cutoff) {
+		result.Skipped = fmt.Sprintf("rollups only cover clicks ingested up to %s", watermark.Format(time.RFC3339))
+		return result, nil
+	}
+
+	partitions, err := r.ListClickPartitions()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, partition := range partitions {
+		if partition.Default || partition.To.IsZero() || partition.To.After(cutoff) {
+			continue
+		}
+
+		var pending bool
+		query := fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM %s WHERE ingested_at > $1)`, pq.QuoteIdentifier(partition.Name))
+		if err := r.queryRow(query, watermark).Scan(&pending); err != nil {
+			return nil, fmt.Errorf("failed to check partition %s: %w", partition.Name, err)
+		}
+		if pending {
+			continue
+		}
+
+		result.DroppedPartitions = append(result.DroppedPartitions, partition.Name)
+		result.DroppedRows += partition.Rows
+	}
+
+	if dryRun {
+		query := `
+			SELECT COUNT(*) FROM clicks
+			WHERE timestamp < $1 AND ingested_at <= $2 AND NOT (tableoid::regclass::text = ANY($3))`
+		err := r.queryRow(query, cutoff, watermark, pq.Array(result.DroppedPartitions)).Scan(&result.DeletedRows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count expired clicks: %w", err)
+		}
+		return result, nil
+	}
+
+	for _, name := range result.DroppedPartitions {
+		if _, err := r.exec(fmt.Sprintf(`DROP TABLE IF EXISTS %s`, pq.QuoteIdentifier(name))); err != nil {
+			return result, fmt.Errorf("failed to drop partition %s: %w", name, err)
+		}
+	}
+
+	deleted, err := r.deleteInBatches(`
+		DELETE FROM clicks
+		WHERE (id, timestamp) IN (
+			SELECT id, timestamp FROM clicks
+			WHERE timestamp < $1 AND ingested_at <= $2
+			LIMIT $3
+		)`, batchSize, cutoff, watermark)
+	result.DeletedRows = deleted
+	if err != nil {
+		return result, fmt.Errorf("failed to delete expired clicks: %w", err)
+	}
+
+	return result, nil
+}
+
+// deleteInBatches runs a DELETE statement whose last parameter is the batch
+// size until it removes fewer rows than the batch size, keeping each
+// transaction short. It returns the total number of rows deleted.
+func (r *Repository) deleteInBatches(query string, batchSize int, args ...interface{}) (int64, error) {
+	args = append(args, batchSize)
+
+	var total int64
+	for {
+		result, err := r.exec(query, args...)
+		if err != nil {
+			return total, err
+		}
+
+		deleted, err := result.RowsAffected()
+		if err != nil {
+			return total, fmt.Errorf("failed to get rows affected: %w", err)
+		}
+		total += deleted
+
+		if deleted < int64(batchSize) {
+			return total, nil
+		}
+	}
+}
@@ -0,0 +1,506 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// RollupLevel identifies the resolution of click aggregates
+type RollupLevel string
+
+const (
+	// RollupRaw reads individual clicks
+	RollupRaw RollupLevel = "raw"
+	// RollupMinute reads per-minute aggregates
+	RollupMinute RollupLevel = "minute"
+	// RollupHour reads per-hour aggregates
+	RollupHour RollupLevel = "hour"
+	// RollupDay reads per-day aggregates
+	RollupDay RollupLevel = "day"
+)
+
+// rollupWatermarkName is the watermark of the click rollups in rollup_watermarks
+const rollupWatermarkName = "clicks"
+
+// RollupLevels lists the rollup levels from the coarsest to the finest
+var RollupLevels = []RollupLevel{RollupDay, RollupHour, RollupMinute}
+
+// ParseRollupLevel parses a granularity name. An empty name yields RollupRaw.
+func ParseRollupLevel(name string) (RollupLevel, error) {
+	switch level := RollupLevel(name); level {
+	case RollupMinute, RollupHour, RollupDay:
+		return level, nil
+	case "", RollupRaw:
+		return RollupRaw, nil
+	default:
+		return "", fmt.Errorf("unknown granularity %q: expected minute, hour or day", name)
+	}
+}
+
+// Duration returns the bucket size of the level, or zero for raw clicks
+func (l RollupLevel) Duration() time.Duration {
+	switch l {
+	case RollupMinute:
+		return time.Minute
+	case RollupHour:
+		return time.Hour
+	case RollupDay:
+		return 24 * time.Hour
+	default:
+		return 0
+	}
+}
+
+// table returns the rollup table of the level
+func (l RollupLevel) table() string {
+	return "click_rollups_" + string(l)
+}
+
+// ChooseRollupLevel returns the coarsest rollup level whose UTC buckets line up
+// with both ends of the range and are no coarser than the requested granularity.
+// RollupRaw as granularity means any level will do. Otherwise the buckets of
+// the level must also line up with the granularity's buckets in loc, so day
+// rollups only serve UTC and hour rollups only serve whole-hour offsets. If no
+// rollup lines up, RollupRaw is returned.
+func ChooseRollupLevel(from, to time.Time, granularity RollupLevel, loc *time.Location) RollupLevel {
+	for _, level := range RollupLevels {
+		if granularity != RollupRaw && level.Duration() > granularity.Duration() {
+			continue
+		}
+		if !alignedUTC(from, level.Duration()) || !alignedUTC(to, level.Duration()) {
+			continue
+		}
+		if granularity != RollupRaw && !offsetsAligned(from, to, loc, level.Duration()) {
+			continue
+		}
+		return level
+	}
+	return RollupRaw
+}
+
+// alignedUTC reports whether t falls on a bucket boundary of the given size in UTC
+func alignedUTC(t time.Time, size time.Duration) bool {
+	return t.UTC().Truncate(size).Equal(t)
+}
+
+// offsetsAligned reports whether every UTC offset loc uses in [from, to) is a
+// multiple of size, i.e. local bucket boundaries are UTC bucket boundaries
+func offsetsAligned(from, to time.Time, loc *time.Location, size time.Duration) bool {
+	for t := from; t.Before(to); {
+		local := t.In(loc)
+		_, offset := local.Zone()
+		if time.Duration(offset)*time.Second%size != 0 {
+			return false
+		}
+		_, end := local.ZoneBounds()
+		if end.IsZero() {
+			return true
+		}
+		t = end
+	}
+	return true
+}
+
+// LoadTimeZone loads an IANA time zone for bucketing. An empty name yields UTC.
+// The process-local zone is rejected because the database cannot resolve it.
+func LoadTimeZone(name string) (*time.Location, error) {
+	if name == "Local" {
+		return nil, fmt.Errorf("unknown time zone %q: expected an IANA name such as Europe/Berlin", name)
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown time zone %q: expected an IANA name such as Europe/Berlin", name)
+	}
+	return loc, nil
+}
+
+// LocalMidnight returns the start of the day containing t in loc. Days spanning
+// a DST transition last 23 or 25 hours.
+func LocalMidnight(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+}
+
+// ClickBucket holds the clicks of one time bucket
+type ClickBucket struct {
+	Start          time.Time `json:"start"`
+	Clicks         int       `json:"clicks"`
+	FilteredClicks int       `json:"filtered_clicks"`
+	NetClicks      int       `json:"net_clicks"`
+}
+
+// ClickSeries holds clicks of a banner in a time range
+type ClickSeries struct {
+	// Source is the level the series was read from
+	Source RollupLevel `json:"source"`
+	// Granularity is the bucket size of Buckets
+	Granularity RollupLevel `json:"granularity"`
+	// TimeZone is the zone buckets are aligned to
+	TimeZone       string         `json:"tz"`
+	Buckets        []*ClickBucket `json:"buckets"`
+	Clicks         int            `json:"clicks"`
+	FilteredClicks int            `json:"filtered_clicks"`
+	NetClicks      int            `json:"net_clicks"`
+}
+
+// ClickRange is a time range [From, To) of clicks read from Source
+type ClickRange struct {
+	From   time.Time
+	To     time.Time
+	Source RollupLevel
+}
+
+// GetClickSeries returns the clicks of a banner in [from, to) bucketed by
+// granularity in loc, read from the source level. Days are bucketed by local
+// date; minutes and hours are counted from local midnight of from, so a day
+// with a DST transition has 23 or 25 hourly buckets. Clicks ingested after the
+// rollup watermark are read from the clicks table in the same statement, so
+// the result is exact while the rollup worker lags behind.
+func (r *Repository) GetClickSeries(bannerID int, from, to time.Time, source, granularity RollupLevel, loc *time.Location) (*ClickSeries, error) {
+	series, err := r.GetClickSeriesSet(bannerID, []ClickRange{{From: from, To: to, Source: source}}, granularity, loc)
+	if err != nil {
+		return nil, err
+	}
+	return series[0], nil
+}
+
+// GetClickSeriesSet returns one series per range like GetClickSeries, reading
+// all ranges in a single statement
+func (r *Repository) GetClickSeriesSet(bannerID int, ranges []ClickRange, granularity RollupLevel, loc *time.Location) ([]*ClickSeries, error) {
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	args := []interface{}{bannerID}
+	param := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	series := make([]*ClickSeries, len(ranges))
+	selects := make([]string, len(ranges))
+	for i, rng := range ranges {
+		level := granularity
+		if level == RollupRaw {
+			level = rng.Source
+		}
+		series[i] = &ClickSeries{Source: rng.Source, Granularity: level, TimeZone: loc.String()}
+
+		from, to := param(rng.From), param(rng.To)
+		selects[i] = fmt.Sprintf(`
+			SELECT %d AS series, %s AS start, SUM(clicks), SUM(filtered_clicks)
+			FROM (%s) b
+			GROUP BY 1, 2`, i, bucketExpr(level, rng.From, from, loc, param), clickCountsQuery(rng.Source, "bannerid = $1", from, to))
+	}
+
+	query := strings.Join(selects, "\n\t\tUNION ALL") + "\n\t\tORDER BY 1, 2"
+
+	rows, err := r.query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get click series: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var index int
+		bucket := &ClickBucket{}
+		if err := rows.Scan(&index, &bucket.Start, &bucket.Clicks, &bucket.FilteredClicks); err != nil {
+			return nil, fmt.Errorf("failed to scan click bucket: %w", err)
+		}
+		bucket.NetClicks = bucket.Clicks - bucket.FilteredClicks
+
+		s := series[index]
+		s.Buckets = append(s.Buckets, bucket)
+		s.Clicks += bucket.Clicks
+		s.FilteredClicks += bucket.FilteredClicks
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating click buckets: %w", err)
+	}
+
+	for _, s := range series {
+		s.NetClicks = s.Clicks - s.FilteredClicks
+	}
+	return series, nil
+}
+
+// BannerClickSeries is the click series of one banner. BannerID is zero for
+// series combining several banners.
+type BannerClickSeries struct {
+	BannerID int `json:"banner_id,omitempty"`
+	*ClickSeries
+}
+
+// GetClickSeriesForBanners returns the clicks of several banners in [from, to)
+// bucketed like GetClickSeries, in a single grouped statement. With perBanner
+// there is one series per banner in the order of bannerIDs, including banners
+// without clicks; otherwise a single series combines all banners.
+func (r *Repository) GetClickSeriesForBanners(bannerIDs []int, from, to time.Time, source, granularity RollupLevel, loc *time.Location, perBanner bool) ([]*BannerClickSeries, error) {
+	if granularity == RollupRaw {
+		granularity = source
+	}
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	args := []interface{}{pq.Array(bannerIDs), from, to}
+	param := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	groupExpr := "0"
+	if perBanner {
+		groupExpr = "bannerid"
+	}
+
+	query := fmt.Sprintf(`
+		SELECT %s AS bannerid, %s AS start, SUM(clicks), SUM(filtered_clicks)
+		FROM (%s) b
+		GROUP BY 1, 2
+		ORDER BY 1, 2`, groupExpr, bucketExpr(granularity, from, "$2", loc, param), clickCountsQuery(source, "bannerid = ANY($1)", "$2", "$3"))
+
+	var results []*BannerClickSeries
+	byBanner := make(map[int]*BannerClickSeries)
+	newSeries := func(bannerID int) *BannerClickSeries {
+		series := &BannerClickSeries{
+			BannerID:    bannerID,
+			ClickSeries: &ClickSeries{Source: source, Granularity: granularity, TimeZone: loc.String(), Buckets: []*ClickBucket{}},
+		}
+		byBanner[bannerID] = series
+		results = append(results, series)
+		return series
+	}
+	if perBanner {
+		for _, bannerID := range bannerIDs {
+			if _, ok := byBanner[bannerID]; !ok {
+				newSeries(bannerID)
+			}
+		}
+	} else {
+		newSeries(0)
+	}
+
+	rows, err := r.query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get click series for banners: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var bannerID int
+		bucket := &ClickBucket{}
+		if err := rows.Scan(&bannerID, &bucket.Start, &bucket.Clicks, &bucket.FilteredClicks); err != nil {
+			return nil, fmt.Errorf("failed to scan click bucket: %w", err)
+		}
+		bucket.NetClicks = bucket.Clicks - bucket.FilteredClicks
+
+		series := byBanner[bannerID]
+		series.Buckets = append(series.Buckets, bucket)
+		series.Clicks += bucket.Clicks
+		series.FilteredClicks += bucket.FilteredClicks
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating click buckets: %w", err)
+	}
+
+	for _, series := range results {
+		series.NetClicks = series.Clicks - series.FilteredClicks
+	}
+	return results, nil
+}
+
+// bucketExpr returns the expression mapping the bucket column to the start of
+// its bucket of the given level in loc, binding its parameters through param.
+// from is the start of the range and fromParam its placeholder. Raw levels map
+// every row to the range start, so the series has a single bucket.
+func bucketExpr(level RollupLevel, from time.Time, fromParam string, loc *time.Location, param func(interface{}) string) string {
+	switch level {
+	case RollupDay:
+		zone := param(loc.String())
+		return fmt.Sprintf("date_trunc('day', bucket AT TIME ZONE %[1]s::text) AT TIME ZONE %[1]s::text", zone)
+	case RollupMinute, RollupHour:
+		return fmt.Sprintf("date_bin(interval '1 %s', bucket, %s::timestamptz)", level, param(LocalMidnight(from, loc)))
+	default:
+		return fromParam + "::timestamptz"
+	}
+}
+
+// clickCountsQuery returns a query of bannerid, bucket, clicks and
+// filtered_clicks rows for clicks matching cond with a timestamp in [from, to),
+// read from the source level. Clicks ingested after the rollup watermark are
+// read from the clicks table, so the rows are exact while the rollup worker
+// lags behind. cond is a condition on bannerid; from and to are placeholders.
+func clickCountsQuery(source RollupLevel, cond, from, to string) string {
+	raw := fmt.Sprintf(`
+		SELECT bannerid, timestamp AS bucket, 1 AS clicks, CASE WHEN filtered_reason IS NULL THEN 0 ELSE 1 END AS filtered_clicks
+		FROM clicks
+		WHERE %s AND timestamp >= %s AND timestamp < %s`, cond, from, to)
+	if source == RollupRaw {
+		return raw
+	}
+
+	return fmt.Sprintf(`
+		SELECT bannerid, bucket, clicks, filtered_clicks
+		FROM %s
+		WHERE %s AND bucket >= %s AND bucket < %s
+		UNION ALL
+		%s AND ingested_at > (SELECT watermark FROM rollup_watermarks WHERE name = '%s')`,
+		source.table(), cond, from, to, raw, rollupWatermarkName)
+}
+
+// GetRollupWatermark returns the ingestion time up to which clicks are rolled up
+func (r *Repository) GetRollupWatermark() (time.Time, error) {
+	var watermark time.Time
+	err := r.queryRow(`SELECT watermark FROM rollup_watermarks WHERE name = $1`, rollupWatermarkName).Scan(&watermark)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get rollup watermark: %w", err)
+	}
+	return watermark, nil
+}
+
+// rollupHorizon is how far the rollups may advance: lag before the database
+// clock, held back to the start of the oldest transaction still running in
+// the database. ingested_at is the start time of the inserting transaction, so
+// clicks of a transaction that has not committed yet are never skipped. Other
+// roles' transactions are only visible with the pg_read_all_stats role.
+const rollupHorizon = `
+	SELECT LEAST(
+		CURRENT_TIMESTAMP - $1 * interval '1 microsecond',
+		COALESCE((
+			SELECT MIN(xact_start) FROM pg_stat_activity
+			WHERE datname = current_database() AND pid <> pg_backend_pid()
+		), 'infinity'))`
+
+// RollUpClicks folds clicks ingested after the watermark into the minute, hour
+// and day rollups and advances the watermark, all in one transaction. The
+// watermark advances to the horizon given by lag and the oldest running
+// transaction, by at most maxWindow of ingestion time per call. It returns the
+// number of clicks folded in, the new watermark and whether it reached the
+// horizon; callers loop until it did.
+func (r *Repository) RollUpClicks(lag, maxWindow time.Duration) (int64, time.Time, bool, error) {
+	var rolled int64
+	var end time.Time
+	var caughtUp bool
+	err := r.inTx("rollup", func(tx *sql.Tx) error {
+		rolled = 0
+
+		// Locking the watermark serializes rollups of concurrent instances
+		var watermark time.Time
+		err := tx.QueryRow(`SELECT watermark FROM rollup_watermarks WHERE name = $1 FOR UPDATE`, rollupWatermarkName).Scan(&watermark)
+		if err != nil {
+			return fmt.Errorf("failed to lock rollup watermark: %w", err)
+		}
+
+		var until time.Time
+		if err := tx.QueryRow(rollupHorizon, lag.Microseconds()).Scan(&until); err != nil {
+			return fmt.Errorf("failed to get rollup horizon: %w", err)
+		}
+		if !until.After(watermark) {
+			end = watermark
+			caughtUp = true
+			return nil
+		}
+
+		// Skip idle periods instead of stepping through them window by window
+		var next sql.NullTime
+		err = tx.QueryRow(`SELECT MIN(ingested_at) FROM clicks WHERE ingested_at > $1`, watermark).Scan(&next)
+		if err != nil {
+			return fmt.Errorf("failed to find next click to roll up: %w", err)
+		}
+
+		end = until
+		caughtUp = true
+		if next.Valid && next.Time.Add(maxWindow).Before(end) {
+			end = next.Time.Add(maxWindow)
+			caughtUp = false
+		}
+
+		if next.Valid && !next.Time.After(end) {
+			err = tx.QueryRow(`
+				WITH delta AS (
+					SELECT
+						bannerid,
+						date_trunc('minute', timestamp AT TIME ZONE 'UTC') AT TIME ZONE 'UTC' AS bucket,
+						COUNT(*) AS clicks,
+						COUNT(*) FILTER (WHERE filtered_reason IS NOT NULL) AS filtered_clicks
+					FROM clicks
+					WHERE ingested_at > $1 AND ingested_at <= $2
+					GROUP BY 1, 2
+				), minute AS (
+					INSERT INTO click_rollups_minute AS t (bannerid, bucket, clicks, filtered_clicks)
+					SELECT bannerid, bucket, clicks, filtered_clicks FROM delta
+					ON CONFLICT (bannerid, bucket) DO UPDATE SET
+						clicks = t.clicks + EXCLUDED.clicks,
+						filtered_clicks = t.filtered_clicks + EXCLUDED.filtered_clicks
+				), hour AS (
+					INSERT INTO click_rollups_hour AS t (bannerid, bucket, clicks, filtered_clicks)
+					SELECT bannerid, date_trunc('hour', bucket AT TIME ZONE 'UTC') AT TIME ZONE 'UTC', SUM(clicks), SUM(filtered_clicks)
+					FROM delta GROUP BY 1, 2
+					ON CONFLICT (bannerid, bucket) DO UPDATE SET
+						clicks = t.clicks + EXCLUDED.clicks,
+						filtered_clicks = t.filtered_clicks + EXCLUDED.filtered_clicks
+				), day AS (
+					INSERT INTO click_rollups_day AS t (bannerid, bucket, clicks, filtered_clicks)
+					SELECT bannerid, date_trunc('day', bucket AT TIME ZONE 'UTC') AT TIME ZONE 'UTC', SUM(clicks), SUM(filtered_clicks)
+					FROM delta GROUP BY 1, 2
+					ON CONFLICT (bannerid, bucket) DO UPDATE SET
+						clicks = t.clicks + EXCLUDED.clicks,
+						filtered_clicks = t.filtered_clicks + EXCLUDED.filtered_clicks
+				)
+				SELECT COALESCE(SUM(clicks), 0) FROM delta`, watermark, end).Scan(&rolled)
+			if err != nil {
+				return fmt.Errorf("failed to roll up clicks: %w", err)
+			}
+		}
+
+		_, err = tx.Exec(`UPDATE rollup_watermarks SET watermark = $2, updated_at = CURRENT_TIMESTAMP WHERE name = $1`,
+			rollupWatermarkName, end)
+		if err != nil {
+			return fmt.Errorf("failed to advance rollup watermark: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, time.Time{}, false, err
+	}
+
+	return rolled, end, caughtUp, nil
+}
+
+// PurgeRollups removes aggregates of the given level whose bucket starts before cutoff
+func (r *Repository) PurgeRollups(level RollupLevel, cutoff time.Time, batchSize int, dryRun bool) (*PurgeResult, error) {
+	if batchSize <= 0 {
+		batchSize = DefaultPurgeBatchSize
+	}
+	table := level.table()
+	result := &PurgeResult{Table: table, Cutoff: cutoff, DryRun: dryRun}
+
+	if dryRun {
+		err := r.queryRow(fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE bucket < $1`, table), cutoff).Scan(&result.DeletedRows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count expired %s rollups: %w", level, err)
+		}
+		return result, nil
+	}
+
+	deleted, err := r.deleteInBatches(fmt.Sprintf(`
+		DELETE FROM %[1]s
+		WHERE (bannerid, bucket) IN (
+			SELECT bannerid, bucket FROM %[1]s
+			WHERE bucket < $1
+			LIMIT $2
+		)`, table), batchSize, cutoff)
+	result.DeletedRows = deleted
+	if err != nil {
+		return result, fmt.Errorf("failed to delete expired %s rollups: %w", level, err)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,256 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+// mustLoad loads an IANA time zone or fails the test
+func mustLoad(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatalf("failed to load time zone %s: %v", name, err)
+	}
+	return loc
+}
+
+func TestLocalMidnight(t *testing.T) {
+	tests := []struct {
+		name    string
+		zone    string
+		t       time.Time
+		want    time.Time
+		dayLong time.Duration
+	}{
+		{
+			name:    "UTC",
+			zone:    "UTC",
+			t:       time.Date(2025, 3, 10, 15, 4, 5, 0, time.UTC),
+			want:    time.Date(2025, 3, 10, 0, 0, 0, 0, time.UTC),
+			dayLong: 24 * time.Hour,
+		},
+		{
+			name:    "previous local day",
+			zone:    "America/New_York",
+			t:       time.Date(2025, 3, 11, 2, 0, 0, 0, time.UTC),
+			want:    time.Date(2025, 3, 10, 4, 0, 0, 0, time.UTC),
+			dayLong: 24 * time.Hour,
+		},
+		{
+			name:    "23 hour day",
+			zone:    "Europe/Berlin",
+			t:       time.Date(2025, 3, 30, 13, 0, 0, 0, time.UTC),
+			want:    time.Date(2025, 3, 29, 23, 0, 0, 0, time.UTC),
+			dayLong: 23 * time.Hour,
+		},
+		{
+			name:    "25 hour day after the transition",
+			zone:    "Europe/Berlin",
+			t:       time.Date(2025, 10, 26, 22, 30, 0, 0, time.UTC),
+			want:    time.Date(2025, 10, 25, 22, 0, 0, 0, time.UTC),
+			dayLong: 25 * time.Hour,
+		},
+		{
+			name:    "25 hour day in the repeated hour",
+			zone:    "Europe/Berlin",
+			t:       time.Date(2025, 10, 26, 0, 30, 0, 0, time.UTC),
+			want:    time.Date(2025, 10, 25, 22, 0, 0, 0, time.UTC),
+			dayLong: 25 * time.Hour,
+		},
+		{
+			name:    "half hour offset",
+			zone:    "Asia/Kolkata",
+			t:       time.Date(2025, 3, 10, 20, 0, 0, 0, time.UTC),
+			want:    time.Date(2025, 3, 10, 18, 30, 0, 0, time.UTC),
+			dayLong: 24 * time.Hour,
+		},
+		{
+			name:    "quarter hour offset",
+			zone:    "Asia/Kathmandu",
+			t:       time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC),
+			want:    time.Date(2025, 3, 9, 18, 15, 0, 0, time.UTC),
+			dayLong: 24 * time.Hour,
+		},
+		{
+			name:    "half hour DST shift",
+			zone:    "Australia/Lord_Howe",
+			t:       time.Date(2025, 4, 6, 12, 0, 0, 0, time.UTC),
+			want:    time.Date(2025, 4, 5, 13, 0, 0, 0, time.UTC),
+			dayLong: 24*time.Hour + 30*time.Minute,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loc := mustLoad(t, tt.zone)
+
+			got := LocalMidnight(tt.t, loc)
+			if !got.Equal(tt.want) {
+				t.Errorf("LocalMidnight(%s) = %s, want %s", tt.t, got.UTC(), tt.want)
+			}
+			if got.Location() != loc {
+				t.Errorf("LocalMidnight returned location %s, want %s", got.Location(), loc)
+			}
+
+			next := LocalMidnight(got.AddDate(0, 0, 1), loc)
+			if length := next.Sub(got); length != tt.dayLong {
+				t.Errorf("day starting %s lasts %v, want %v", got, length, tt.dayLong)
+			}
+		})
+	}
+}
+
+func TestChooseRollupLevel(t *testing.T) {
+	utcDay := time.Date(2025, 3, 10, 0, 0, 0, 0, time.UTC)
+
+	berlin := mustLoad(t, "Europe/Berlin")
+	kolkata := mustLoad(t, "Asia/Kolkata")
+	kathmandu := mustLoad(t, "Asia/Kathmandu")
+	lordHowe := mustLoad(t, "Australia/Lord_Howe")
+
+	tests := []struct {
+		name        string
+		from, to    time.Time
+		granularity RollupLevel
+		loc         *time.Location
+		want        RollupLevel
+	}{
+		{
+			name:        "whole UTC days",
+			from:        utcDay,
+			to:          utcDay.AddDate(0, 0, 7),
+			granularity: RollupRaw,
+			loc:         time.UTC,
+			want:        RollupDay,
+		},
+		{
+			name:        "daily in UTC",
+			from:        utcDay,
+			to:          utcDay.AddDate(0, 0, 7),
+			granularity: RollupDay,
+			loc:         time.UTC,
+			want:        RollupDay,
+		},
+		{
+			name:        "no coarser than the granularity",
+			from:        utcDay,
+			to:          utcDay.AddDate(0, 0, 7),
+			granularity: RollupHour,
+			loc:         time.UTC,
+			want:        RollupHour,
+		},
+		{
+			name:        "minute granularity",
+			from:        utcDay,
+			to:          utcDay.AddDate(0, 0, 1),
+			granularity: RollupMinute,
+			loc:         time.UTC,
+			want:        RollupMinute,
+		},
+		{
+			name:        "whole hours",
+			from:        utcDay.Add(3 * time.Hour),
+			to:          utcDay.Add(9 * time.Hour),
+			granularity: RollupRaw,
+			loc:         time.UTC,
+			want:        RollupHour,
+		},
+		{
+			name:        "unaligned range",
+			from:        utcDay.Add(3*time.Hour + 30*time.Second),
+			to:          utcDay.Add(9 * time.Hour),
+			granularity: RollupRaw,
+			loc:         time.UTC,
+			want:        RollupRaw,
+		},
+		{
+			name:        "daily in a whole hour zone",
+			from:        LocalMidnight(time.Date(2025, 3, 10, 12, 0, 0, 0, berlin), berlin),
+			to:          LocalMidnight(time.Date(2025, 3, 17, 12, 0, 0, 0, berlin), berlin),
+			granularity: RollupDay,
+			loc:         berlin,
+			want:        RollupHour,
+		},
+		{
+			name:        "daily across a 23 hour day",
+			from:        LocalMidnight(time.Date(2025, 3, 29, 12, 0, 0, 0, berlin), berlin),
+			to:          LocalMidnight(time.Date(2025, 4, 1, 12, 0, 0, 0, berlin), berlin),
+			granularity: RollupDay,
+			loc:         berlin,
+			want:        RollupHour,
+		},
+		{
+			name:        "daily across a 25 hour day",
+			from:        LocalMidnight(time.Date(2025, 10, 25, 12, 0, 0, 0, berlin), berlin),
+			to:          LocalMidnight(time.Date(2025, 10, 28, 12, 0, 0, 0, berlin), berlin),
+			granularity: RollupDay,
+			loc:         berlin,
+			want:        RollupHour,
+		},
+		{
+			name:        "daily in a half hour zone",
+			from:        LocalMidnight(time.Date(2025, 3, 10, 12, 0, 0, 0, kolkata), kolkata),
+			to:          LocalMidnight(time.Date(2025, 3, 17, 12, 0, 0, 0, kolkata), kolkata),
+			granularity: RollupDay,
+			loc:         kolkata,
+			want:        RollupMinute,
+		},
+		{
+			name:        "hourly in a quarter hour zone",
+			from:        utcDay,
+			to:          utcDay.AddDate(0, 0, 1),
+			granularity: RollupHour,
+			loc:         kathmandu,
+			want:        RollupMinute,
+		},
+		{
+			name:        "hourly across a half hour DST shift",
+			from:        time.Date(2025, 4, 5, 0, 0, 0, 0, time.UTC),
+			to:          time.Date(2025, 4, 7, 0, 0, 0, 0, time.UTC),
+			granularity: RollupHour,
+			loc:         lordHowe,
+			want:        RollupMinute,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ChooseRollupLevel(tt.from, tt.to, tt.granularity, tt.loc); got != tt.want {
+				t.Errorf("ChooseRollupLevel(%s, %s, %s, %s) = %s, want %s",
+					tt.from, tt.to, tt.granularity, tt.loc, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOffsetsAligned(t *testing.T) {
+	year := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		zone     string
+		from, to time.Time
+		size     time.Duration
+		want     bool
+	}{
+		{"UTC days", "UTC", year, year.AddDate(1, 0, 0), 24 * time.Hour, true},
+		{"DST zone hours", "Europe/Berlin", year, year.AddDate(1, 0, 0), time.Hour, true},
+		{"DST zone days", "Europe/Berlin", year, year.AddDate(1, 0, 0), 24 * time.Hour, false},
+		{"half hour zone hours", "Asia/Kolkata", year, year.AddDate(0, 1, 0), time.Hour, false},
+		{"half hour zone minutes", "Asia/Kolkata", year, year.AddDate(0, 1, 0), time.Minute, true},
+		{"quarter hour zone minutes", "Asia/Kathmandu", year, year.AddDate(0, 1, 0), time.Minute, true},
+		{"whole hour period of a half hour shift", "Australia/Lord_Howe", year, year.AddDate(0, 1, 0), time.Hour, true},
+		{"across a half hour shift", "Australia/Lord_Howe", year, year.AddDate(0, 6, 0), time.Hour, false},
+		{"empty range", "Asia/Kolkata", year, year, time.Hour, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loc := mustLoad(t, tt.zone)
+			if got := offsetsAligned(tt.from, tt.to, loc, tt.size); got != tt.want {
+				t.Errorf("offsetsAligned(%s, %s, %s, %v) = %v, want %v", tt.from, tt.to, tt.zone, tt.size, got, tt.want)
+			}
+		})
+	}
+}
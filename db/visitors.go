@@ -0,0 +1,201 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/tyagnii/ecom_test/hll"
+)
+
+// VisitorSketchKey identifies the visitor sketch of a banner in a UTC hour
+type VisitorSketchKey struct {
+	BannerID int
+	Bucket   time.Time
+}
+
+// NewVisitorSketchKey returns the key of the hour containing t
+func NewVisitorSketchKey(bannerID int, t time.Time) VisitorSketchKey {
+	return VisitorSketchKey{BannerID: bannerID, Bucket: t.UTC().Truncate(time.Hour)}
+}
+
+// VisitorHours returns the hour range [from, to) covering [start, end), i.e.
+// start rounded down and end rounded up to whole UTC hours
+func VisitorHours(start, end time.Time) (time.Time, time.Time) {
+	from := start.UTC().Truncate(time.Hour)
+	to := end.UTC().Truncate(time.Hour)
+	if to.Before(end) {
+		to = to.Add(time.Hour)
+	}
+	return from, to
+}
+
+// MergeVisitorSketches merges sketches into the stored ones in one transaction.
+// Rows are created empty first and then locked, so concurrent instances
+// flushing the same hour serialize instead of overwriting each other. Sketches
+// of banners deleted in the meantime are dropped.
+func (r *Repository) MergeVisitorSketches(sketches map[VisitorSketchKey]*hll.Sketch) error {
+	if len(sketches) == 0 {
+		return nil
+	}
+
+	// Lock rows in a stable order to avoid deadlocks between instances
+	keys := make([]VisitorSketchKey, 0, len(sketches))
+	for key := range sketches {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].BannerID != keys[j].BannerID {
+			return keys[i].BannerID < keys[j].BannerID
+		}
+		return keys[i].Bucket.Before(keys[j].Bucket)
+	})
+
+	positions := make(map[VisitorSketchKey]int, len(keys))
+	bannerIDs := make([]int64, len(keys))
+	buckets := make([]string, len(keys))
+	for i, key := range keys {
+		positions[key] = i
+		bannerIDs[i] = int64(key.BannerID)
+		buckets[i] = key.Bucket.Format(time.RFC3339)
+	}
+
+	return r.inTx("visitor sketch merge", func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			INSERT INTO visitor_sketches (bannerid, bucket, sketch)
+			SELECT k.bannerid, k.bucket, ''::bytea
+			FROM unnest($1::int[], $2::timestamptz[]) AS k(bannerid, bucket)
+			JOIN banners b ON b.id = k.bannerid
+			ON CONFLICT (bannerid, bucket) DO NOTHING`, pq.Array(bannerIDs), pq.Array(buckets))
+		if err != nil {
+			return fmt.Errorf("failed to create visitor sketches: %w", err)
+		}
+
+		rows, err := tx.Query(`
+			SELECT s.bannerid, s.bucket, s.sketch
+			FROM visitor_sketches s
+			JOIN unnest($1::int[], $2::timestamptz[]) AS k(bannerid, bucket)
+				ON s.bannerid = k.bannerid AND s.bucket = k.bucket
+			ORDER BY s.bannerid, s.bucket
+			FOR UPDATE OF s`, pq.Array(bannerIDs), pq.Array(buckets))
+		if err != nil {
+			return fmt.Errorf("failed to lock visitor sketches: %w", err)
+		}
+
+		// Keys without a row keep an empty sketch and are not updated
+		merged := make([][]byte, len(keys))
+		for rows.Next() {
+			var key VisitorSketchKey
+			var data []byte
+			if err := rows.Scan(&key.BannerID, &key.Bucket, &data); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan visitor sketch: %w", err)
+			}
+			key.Bucket = key.Bucket.UTC()
+
+			index, ok := positions[key]
+			if !ok {
+				continue
+			}
+			sketch := sketches[key]
+			if len(data) > 0 {
+				stored, err := hll.Decode(data)
+				if err != nil {
+					rows.Close()
+					return fmt.Errorf("failed to decode visitor sketch of banner %d at %s: %w", key.BannerID, key.Bucket, err)
+				}
+				stored.Merge(sketch)
+				sketch = stored
+			}
+
+			if merged[index], err = sketch.MarshalBinary(); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to encode visitor sketch: %w", err)
+			}
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return fmt.Errorf("error iterating visitor sketches: %w", err)
+		}
+
+		_, err = tx.Exec(`
+			UPDATE visitor_sketches s
+			SET sketch = k.sketch, updated_at = CURRENT_TIMESTAMP
+			FROM unnest($1::int[], $2::timestamptz[], $3::bytea[]) AS k(bannerid, bucket, sketch)
+			WHERE s.bannerid = k.bannerid AND s.bucket = k.bucket AND length(k.sketch) > 0`,
+			pq.Array(bannerIDs), pq.Array(buckets), pq.ByteaArray(merged))
+		if err != nil {
+			return fmt.Errorf("failed to update visitor sketches: %w", err)
+		}
+		return nil
+	})
+}
+
+// GetVisitorSketch returns the union of the visitor sketches of a banner in
+// the hours covering [start, end). Without stored sketches an empty sketch of
+// the given precision is returned.
+func (r *Repository) GetVisitorSketch(bannerID int, start, end time.Time, precision int) (*hll.Sketch, error) {
+	from, to := VisitorHours(start, end)
+
+	rows, err := r.query(`
+		SELECT sketch FROM visitor_sketches
+		WHERE bannerid = $1 AND bucket >= $2 AND bucket < $3 AND sketch <> ''::bytea`, bannerID, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get visitor sketches: %w", err)
+	}
+	defer rows.Close()
+
+	union, err := hll.New(precision)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("failed to scan visitor sketch: %w", err)
+		}
+		sketch, err := hll.Decode(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode visitor sketch: %w", err)
+		}
+		union.Merge(sketch)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating visitor sketches: %w", err)
+	}
+
+	return union, nil
+}
+
+// PurgeVisitorSketches removes visitor sketches whose hour starts before cutoff
+func (r *Repository) PurgeVisitorSketches(cutoff time.Time, batchSize int, dryRun bool) (*PurgeResult, error) {
+	if batchSize <= 0 {
+		batchSize = DefaultPurgeBatchSize
+	}
+	result := &PurgeResult{Table: "visitor_sketches", Cutoff: cutoff, DryRun: dryRun}
+
+	if dryRun {
+		err := r.queryRow(`SELECT COUNT(*) FROM visitor_sketches WHERE bucket < $1`, cutoff).Scan(&result.DeletedRows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count expired visitor sketches: %w", err)
+		}
+		return result, nil
+	}
+
+	deleted, err := r.deleteInBatches(`
+		DELETE FROM visitor_sketches
+		WHERE (bannerid, bucket) IN (
+			SELECT bannerid, bucket FROM visitor_sketches
+			WHERE bucket < $1
+			LIMIT $2
+		)`, batchSize, cutoff)
+	result.DeletedRows = deleted
+	if err != nil {
+		return result, fmt.Errorf("failed to delete expired visitor sketches: %w", err)
+	}
+
+	return result, nil
+}
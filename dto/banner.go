@@ -16,4 +16,23 @@ type Click struct {
 	Timestamp time.Time `json:"timestamp" db:"timestamp"`
 	BannerID  int       `json:"banner_id" db:"bannerid"`
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	// FilteredReason is set when the click was classified as non-human traffic
+	FilteredReason string `json:"filtered_reason,omitempty" db:"filtered_reason"`
+}
+
+// ClickSource describes the client a click originated from
+type ClickSource struct {
+	IP        string `json:"ip,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+}
+
+// Conversion represents a conversion attributed to a click
+type Conversion struct {
+	ID        int       `json:"id" db:"id"`
+	ClickID   int       `json:"click_id" db:"click_id"`
+	BannerID  int       `json:"banner_id" db:"bannerid"`
+	Value     float64   `json:"value" db:"value"`
+	Currency  string    `json:"currency" db:"currency"`
+	Timestamp time.Time `json:"timestamp" db:"timestamp"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
 }
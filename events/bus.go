@@ -0,0 +1,255 @@
+package events
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrSlowConsumer is the reason a subscription is closed when its buffer overflows
+var ErrSlowConsumer = errors.New("events: subscriber too slow, buffer full")
+
+// ErrTooManySubscribers is returned when subscribing to a bus at its subscriber limit
+var ErrTooManySubscribers = errors.New("events: too many subscribers")
+
+// ErrBusClosed is the reason subscriptions are closed when the bus shuts down
+var ErrBusClosed = errors.New("events: bus closed")
+
+// Click is published for every click accepted by the service
+type Click struct {
+	ClickID  int       `json:"click_id,omitempty"`
+	BannerID int       `json:"banner_id"`
+	Time     time.Time `json:"timestamp"`
+	// FilteredReason is set for clicks classified as non-human traffic
+	FilteredReason string `json:"filtered_reason,omitempty"`
+	// Queued is set for clicks kept in the WAL while the database is unavailable
+	Queued bool `json:"queued,omitempty"`
+}
+
+// BusStats describes the subscribers of a bus
+type BusStats struct {
+	Subscribers int   `json:"subscribers"`
+	Published   int64 `json:"published"`
+	// Dropped is the number of subscribers closed for falling behind
+	Dropped int64 `json:"dropped"`
+}
+
+// Bus is an in-process publish/subscribe channel for click events. Publishing
+// never blocks: a subscriber whose buffer is full is dropped.
+type Bus struct {
+	bufferSize     int
+	maxSubscribers int
+
+	mu     sync.RWMutex
+	subs   map[*Subscription]struct{}
+	closed bool
+	stats  BusStats
+}
+
+// NewBus creates a bus giving each subscriber a buffer of bufferSize events
+// and accepting up to maxSubscribers subscribers; zero means no limit
+func NewBus(bufferSize, maxSubscribers int) *Bus {
+	if bufferSize <= 0 {
+		bufferSize = 1
+	}
+	return &Bus{
+		bufferSize:     bufferSize,
+		maxSubscribers: maxSubscribers,
+		subs:           make(map[*Subscription]struct{}),
+	}
+}
+
+// Subscription receives the click events of a set of banners
+type Subscription struct {
+	bus     *Bus
+	events  chan *Click
+	banners map[int]bool
+
+	mu   sync.Mutex
+	done bool
+	err  error
+}
+
+// Subscribe subscribes to the clicks of the given banners, or of all banners
+// if none are given. It returns ErrTooManySubscribers at the subscriber limit.
+func (b *Bus) Subscribe(bannerIDs ...int) (*Subscription, error) {
+	if len(bannerIDs) == 0 {
+		return b.subscribe(nil)
+	}
+	return b.SubscribeBanners(bannerIDs)
+}
+
+// SubscribeBanners subscribes to the clicks of a set of banners, which may be
+// empty and is changed with Add and Remove. It returns ErrTooManySubscribers
+// at the subscriber limit.
+func (b *Bus) SubscribeBanners(bannerIDs []int) (*Subscription, error) {
+	banners := make(map[int]bool, len(bannerIDs))
+	for _, id := range bannerIDs {
+		banners[id] = true
+	}
+	return b.subscribe(banners)
+}
+
+// subscribe registers a subscription to the given banners; nil means all
+// banners. The limit is checked under the same lock as the subscription is
+// registered, so concurrent subscribers cannot exceed it.
+func (b *Bus) subscribe(banners map[int]bool) (*Subscription, error) {
+	sub := &Subscription{
+		bus:     b,
+		events:  make(chan *Click, b.bufferSize),
+		banners: banners,
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		sub.finish(ErrBusClosed)
+		return sub, nil
+	}
+	if b.maxSubscribers > 0 && len(b.subs) >= b.maxSubscribers {
+		return nil, ErrTooManySubscribers
+	}
+	b.subs[sub] = struct{}{}
+	return sub, nil
+}
+
+// Publish delivers a click to every interested subscriber
+func (b *Bus) Publish(click *Click) {
+	var slow []*Subscription
+
+	b.mu.RLock()
+	for sub := range b.subs {
+		if sub.banners != nil && !sub.banners[click.BannerID] {
+			continue
+		}
+		select {
+		case sub.events <- click:
+		default:
+			slow = append(slow, sub)
+		}
+	}
+	b.mu.RUnlock()
+
+	b.mu.Lock()
+	b.stats.Published++
+	for _, sub := range slow {
+		if _, ok := b.subs[sub]; ok {
+			delete(b.subs, sub)
+			b.stats.Dropped++
+			sub.finish(ErrSlowConsumer)
+		}
+	}
+	b.mu.Unlock()
+}
+
+// Stats returns the bus statistics
+func (b *Bus) Stats() BusStats {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	stats := b.stats
+	stats.Subscribers = len(b.subs)
+	return stats
+}
+
+// Close closes all subscriptions; later subscriptions are closed immediately
+func (b *Bus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for sub := range b.subs {
+		sub.finish(ErrBusClosed)
+	}
+	b.subs = make(map[*Subscription]struct{})
+}
+
+// Events returns the channel of click events. It is closed when the
+// subscription ends; Err then tells why.
+func (s *Subscription) Events() <-chan *Click {
+	return s.events
+}
+
+// Matches reports whether the subscription receives clicks of the banner
+func (s *Subscription) Matches(bannerID int) bool {
+	s.bus.mu.RLock()
+	defer s.bus.mu.RUnlock()
+	return s.banners == nil || s.banners[bannerID]
+}
+
+// Banners returns the subscribed banner IDs in ascending order, or nil for
+// all banners
+func (s *Subscription) Banners() []int {
+	s.bus.mu.RLock()
+	defer s.bus.mu.RUnlock()
+
+	if s.banners == nil {
+		return nil
+	}
+	ids := make([]int, 0, len(s.banners))
+	for id := range s.banners {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// Add adds banners to a subscription created by SubscribeBanners. Clicks
+// already buffered are not affected.
+func (s *Subscription) Add(bannerIDs ...int) {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+
+	if s.banners == nil {
+		return
+	}
+	for _, id := range bannerIDs {
+		s.banners[id] = true
+	}
+}
+
+// Remove removes banners from a subscription created by SubscribeBanners.
+// Clicks already buffered are still delivered; use Matches to skip them.
+func (s *Subscription) Remove(bannerIDs ...int) {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+
+	if s.banners == nil {
+		return
+	}
+	for _, id := range bannerIDs {
+		delete(s.banners, id)
+	}
+}
+
+// Err returns why the subscription ended: ErrSlowConsumer, ErrBusClosed or
+// nil if it was closed by the subscriber or is still open
+func (s *Subscription) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Close ends the subscription
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+
+	delete(s.bus.subs, s)
+	s.finish(nil)
+}
+
+// finish closes the event channel once. The caller holds the bus lock, so no
+// publisher is sending on the channel.
+func (s *Subscription) finish(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.done {
+		return
+	}
+	s.done = true
+	s.err = err
+	close(s.events)
+}
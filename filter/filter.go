@@ -0,0 +1,49 @@
+package filter
+
+import (
+	"github.com/tyagnii/ecom_test/dto"
+)
+
+// Filter decides whether a click comes from non-human traffic
+type Filter interface {
+	// Name identifies the filter in logs and configuration
+	Name() string
+	// Match returns the reason a click should be filtered, or false if it passes
+	Match(bannerID int, source *dto.ClickSource) (string, bool)
+}
+
+// Chain evaluates filters in order and stops at the first match
+type Chain struct {
+	filters []Filter
+}
+
+// NewChain creates a new filter chain
+func NewChain(filters ...Filter) *Chain {
+	return &Chain{filters: filters}
+}
+
+// Add appends a filter to the chain
+func (c *Chain) Add(f Filter) {
+	c.filters = append(c.filters, f)
+}
+
+// Filters returns the filters in evaluation order
+func (c *Chain) Filters() []Filter {
+	return c.filters
+}
+
+// Evaluate returns the reason of the first matching filter, or an empty string
+// if the click passes all of them
+func (c *Chain) Evaluate(bannerID int, source *dto.ClickSource) string {
+	if c == nil || source == nil {
+		return ""
+	}
+
+	for _, f := range c.filters {
+		if reason, matched := f.Match(bannerID, source); matched {
+			return reason
+		}
+	}
+
+	return ""
+}
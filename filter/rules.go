@@ -0,0 +1,181 @@
+package filter
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/tyagnii/ecom_test/dto"
+)
+
+// Filtered reasons stored with clicks
+const (
+	ReasonNoUserAgent  = "no_user_agent"
+	ReasonBotUserAgent = "bot_user_agent"
+	ReasonIPDenylist   = "ip_denylist"
+)
+
+//go:embed bot_user_agents.txt
+var defaultUserAgentRules string
+
+// EmptyUserAgentFilter filters clicks without a User-Agent header
+type EmptyUserAgentFilter struct{}
+
+// Name returns the filter name
+func (EmptyUserAgentFilter) Name() string {
+	return "no_user_agent"
+}
+
+// Match filters clicks with an empty User-Agent
+func (EmptyUserAgentFilter) Match(bannerID int, source *dto.ClickSource) (string, bool) {
+	if strings.TrimSpace(source.UserAgent) == "" {
+		return ReasonNoUserAgent, true
+	}
+	return "", false
+}
+
+// UserAgentFilter filters clicks whose User-Agent contains a known bot token
+type UserAgentFilter struct {
+	patterns []string
+}
+
+// NewUserAgentFilter creates a filter from case-insensitive substring patterns
+func NewUserAgentFilter(patterns []string) *UserAgentFilter {
+	lowered := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		lowered = append(lowered, strings.ToLower(pattern))
+	}
+	return &UserAgentFilter{patterns: lowered}
+}
+
+// Name returns the filter name
+func (f *UserAgentFilter) Name() string {
+	return "user_agent"
+}
+
+// Match filters clicks whose User-Agent matches one of the patterns
+func (f *UserAgentFilter) Match(bannerID int, source *dto.ClickSource) (string, bool) {
+	userAgent := strings.ToLower(source.UserAgent)
+	for _, pattern := range f.patterns {
+		if strings.Contains(userAgent, pattern) {
+			return ReasonBotUserAgent + ":" + pattern, true
+		}
+	}
+	return "", false
+}
+
+// IPDenylistFilter filters clicks from denied networks
+type IPDenylistFilter struct {
+	networks []*net.IPNet
+}
+
+// NewIPDenylistFilter creates a filter from CIDRs or single IP addresses
+func NewIPDenylistFilter(cidrs []string) (*IPDenylistFilter, error) {
+	f := &IPDenylistFilter{}
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+
+		if !strings.Contains(cidr, "/") {
+			if ip := net.ParseIP(cidr); ip != nil && ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid denylist entry %q: %w", cidr, err)
+		}
+		f.networks = append(f.networks, network)
+	}
+	return f, nil
+}
+
+// Name returns the filter name
+func (f *IPDenylistFilter) Name() string {
+	return "ip_denylist"
+}
+
+// Match filters clicks whose IP falls in a denied network
+func (f *IPDenylistFilter) Match(bannerID int, source *dto.ClickSource) (string, bool) {
+	ip := net.ParseIP(source.IP)
+	if ip == nil {
+		return "", false
+	}
+
+	for _, network := range f.networks {
+		if network.Contains(ip) {
+			return ReasonIPDenylist, true
+		}
+	}
+	return "", false
+}
+
+// ParseUserAgentRules reads one pattern per line, ignoring blank lines and # comments
+func ParseUserAgentRules(r io.Reader) ([]string, error) {
+	var patterns []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read user agent rules: %w", err)
+	}
+
+	return patterns, nil
+}
+
+// LoadUserAgentRules reads user agent patterns from a file. An empty path
+// returns the built-in rule set.
+func LoadUserAgentRules(path string) ([]string, error) {
+	if path == "" {
+		return ParseUserAgentRules(strings.NewReader(defaultUserAgentRules))
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open user agent rules: %w", err)
+	}
+	defer file.Close()
+
+	return ParseUserAgentRules(file)
+}
+
+// NewDefaultChain builds the built-in chain: the "no UA" rule, the IP denylist
+// and the user agent rule set loaded from rulesFile
+func NewDefaultChain(rulesFile string, denylist []string, filterEmptyUserAgent bool) (*Chain, error) {
+	chain := NewChain()
+
+	if filterEmptyUserAgent {
+		chain.Add(EmptyUserAgentFilter{})
+	}
+
+	if len(denylist) > 0 {
+		ipFilter, err := NewIPDenylistFilter(denylist)
+		if err != nil {
+			return nil, err
+		}
+		chain.Add(ipFilter)
+	}
+
+	patterns, err := LoadUserAgentRules(rulesFile)
+	if err != nil {
+		return nil, err
+	}
+	chain.Add(NewUserAgentFilter(patterns))
+
+	return chain, nil
+}
@@ -0,0 +1,238 @@
+package hll
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/bits"
+)
+
+// Precision bounds. A sketch of precision p has 2^p registers and a relative
+// standard error of about 1.04/sqrt(2^p).
+const (
+	MinPrecision     = 4
+	MaxPrecision     = 16
+	DefaultPrecision = 12
+)
+
+// Encoding of a sketch: a version byte, the precision, the register format and
+// the registers. Sparse sketches store (index uint16, value uint8) pairs of the
+// non-zero registers, which keeps the sketches of quiet hours small.
+const (
+	encodingVersion = 1
+	formatDense     = 0
+	formatSparse    = 1
+	headerSize      = 3
+	sparseEntrySize = 3
+)
+
+// Sketch is a HyperLogLog estimator of the number of distinct values added to
+// it. It is not safe for concurrent use.
+type Sketch struct {
+	precision uint8
+	registers []uint8
+}
+
+// New creates an empty sketch with the given precision
+func New(precision int) (*Sketch, error) {
+	if precision < MinPrecision || precision > MaxPrecision {
+		return nil, fmt.Errorf("hll: precision %d out of range [%d, %d]", precision, MinPrecision, MaxPrecision)
+	}
+	return &Sketch{
+		precision: uint8(precision),
+		registers: make([]uint8, 1<<precision),
+	}, nil
+}
+
+// Precision returns the precision of the sketch
+func (s *Sketch) Precision() int {
+	return int(s.precision)
+}
+
+// Hash returns the 64-bit hash under which a value is added to a sketch
+func Hash(value string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(value))
+	return mix64(h.Sum64())
+}
+
+// mix64 is the MurmurHash3 finalizer; it spreads FNV's weak high bits over the
+// whole word, which the register index is taken from
+func mix64(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+// Add adds a value to the sketch
+func (s *Sketch) Add(value string) {
+	s.AddHash(Hash(value))
+}
+
+// AddHash adds a value given by its hash to the sketch
+func (s *Sketch) AddHash(hash uint64) {
+	p := s.precision
+	index := hash >> (64 - p)
+	// The guard bit bounds the rank when the remaining bits are all zero
+	rank := uint8(bits.LeadingZeros64(hash<<p|1<<(p-1))) + 1
+	if rank > s.registers[index] {
+		s.registers[index] = rank
+	}
+}
+
+// Merge adds all values of other to the sketch. Sketches of different
+// precision, such as ones stored before the precision was changed, are merged
+// at the lower precision, to which the sketch is reduced if necessary.
+func (s *Sketch) Merge(other *Sketch) {
+	if other.precision > s.precision {
+		other = other.fold(s.precision)
+	} else if other.precision < s.precision {
+		*s = *s.fold(other.precision)
+	}
+	for i, value := range other.registers {
+		if value > s.registers[i] {
+			s.registers[i] = value
+		}
+	}
+}
+
+// fold returns a copy of the sketch reduced to a lower precision, holding the
+// registers the values of the sketch would have set at that precision. The
+// index bits that are dropped become the leading bits of the rank.
+func (s *Sketch) fold(precision uint8) *Sketch {
+	shift := s.precision - precision
+	folded := &Sketch{
+		precision: precision,
+		registers: make([]uint8, 1<<precision),
+	}
+	for i, value := range s.registers {
+		if value == 0 {
+			continue
+		}
+		rank := value + shift
+		if low := uint(i) & (1<<shift - 1); low != 0 {
+			rank = shift - uint8(bits.Len(low)) + 1
+		}
+		if index := i >> shift; rank > folded.registers[index] {
+			folded.registers[index] = rank
+		}
+	}
+	return folded
+}
+
+// Estimate returns the estimated number of distinct values added to the sketch
+func (s *Sketch) Estimate() uint64 {
+	m := float64(len(s.registers))
+
+	var sum float64
+	var zeros int
+	for _, value := range s.registers {
+		sum += math.Ldexp(1, -int(value))
+		if value == 0 {
+			zeros++
+		}
+	}
+
+	estimate := alpha(len(s.registers)) * m * m / sum
+
+	// Linear counting is more accurate while many registers are still empty
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+
+	return uint64(estimate + 0.5)
+}
+
+// alpha is the bias correction constant for m registers
+func alpha(m int) float64 {
+	switch m {
+	case 16:
+		return 0.673
+	case 32:
+		return 0.697
+	case 64:
+		return 0.709
+	default:
+		return 0.7213 / (1 + 1.079/float64(m))
+	}
+}
+
+// MarshalBinary encodes the sketch in the sparse or the dense format, whichever is smaller
+func (s *Sketch) MarshalBinary() ([]byte, error) {
+	var nonZero int
+	for _, value := range s.registers {
+		if value != 0 {
+			nonZero++
+		}
+	}
+
+	if nonZero*sparseEntrySize >= len(s.registers) {
+		data := make([]byte, headerSize, headerSize+len(s.registers))
+		data[0], data[1], data[2] = encodingVersion, s.precision, formatDense
+		return append(data, s.registers...), nil
+	}
+
+	data := make([]byte, headerSize, headerSize+nonZero*sparseEntrySize)
+	data[0], data[1], data[2] = encodingVersion, s.precision, formatSparse
+	for i, value := range s.registers {
+		if value != 0 {
+			data = binary.BigEndian.AppendUint16(data, uint16(i))
+			data = append(data, value)
+		}
+	}
+	return data, nil
+}
+
+// UnmarshalBinary decodes a sketch encoded by MarshalBinary
+func (s *Sketch) UnmarshalBinary(data []byte) error {
+	if len(data) < headerSize {
+		return errors.New("hll: sketch too short")
+	}
+	if data[0] != encodingVersion {
+		return fmt.Errorf("hll: unsupported encoding version %d", data[0])
+	}
+
+	sketch, err := New(int(data[1]))
+	if err != nil {
+		return err
+	}
+
+	body := data[headerSize:]
+	switch data[2] {
+	case formatDense:
+		if len(body) != len(sketch.registers) {
+			return fmt.Errorf("hll: dense sketch has %d registers, expected %d", len(body), len(sketch.registers))
+		}
+		copy(sketch.registers, body)
+	case formatSparse:
+		if len(body)%sparseEntrySize != 0 {
+			return errors.New("hll: truncated sparse sketch")
+		}
+		for ; len(body) > 0; body = body[sparseEntrySize:] {
+			index := int(binary.BigEndian.Uint16(body))
+			if index >= len(sketch.registers) {
+				return fmt.Errorf("hll: register index %d out of range", index)
+			}
+			sketch.registers[index] = body[2]
+		}
+	default:
+		return fmt.Errorf("hll: unknown register format %d", data[2])
+	}
+
+	*s = *sketch
+	return nil
+}
+
+// Decode returns the sketch encoded in data
+func Decode(data []byte) (*Sketch, error) {
+	sketch := &Sketch{}
+	if err := sketch.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	return sketch, nil
+}
@@ -0,0 +1,216 @@
+package hll
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+// mustNew creates a sketch or fails the test
+func mustNew(t *testing.T, precision int) *Sketch {
+	t.Helper()
+	s, err := New(precision)
+	if err != nil {
+		t.Fatalf("New(%d): %v", precision, err)
+	}
+	return s
+}
+
+// addRange adds the values prefix0 to prefix(n-1) to the sketch
+func addRange(s *Sketch, prefix string, n int) {
+	for i := 0; i < n; i++ {
+		s.Add(fmt.Sprintf("%s%d", prefix, i))
+	}
+}
+
+// withinError reports whether the estimate is within three standard errors of want
+func withinError(s *Sketch, want int) bool {
+	stdErr := 1.04 / math.Sqrt(float64(len(s.registers)))
+	return math.Abs(float64(s.Estimate())-float64(want)) <= 3*stdErr*float64(want)
+}
+
+func TestNewPrecisionBounds(t *testing.T) {
+	for _, precision := range []int{MinPrecision - 1, MaxPrecision + 1} {
+		if _, err := New(precision); err == nil {
+			t.Errorf("New(%d) accepted a precision out of range", precision)
+		}
+	}
+}
+
+func TestEstimateErrorBounds(t *testing.T) {
+	for _, precision := range []int{MinPrecision, 10, DefaultPrecision, MaxPrecision} {
+		for _, n := range []int{0, 1, 100, 10000, 200000} {
+			t.Run(fmt.Sprintf("p%d/n%d", precision, n), func(t *testing.T) {
+				s := mustNew(t, precision)
+				addRange(s, "visitor-", n)
+				// Repeated values do not change the estimate
+				addRange(s, "visitor-", n)
+				if !withinError(s, n) {
+					t.Errorf("estimate %d of %d values is off by more than three standard errors", s.Estimate(), n)
+				}
+			})
+		}
+	}
+}
+
+func TestMarshalSparseToDense(t *testing.T) {
+	s := mustNew(t, DefaultPrecision)
+	m := len(s.registers)
+
+	data, err := s.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data[2] != formatSparse || len(data) != headerSize {
+		t.Fatalf("empty sketch encoded as format %d in %d bytes, want sparse header only", data[2], len(data))
+	}
+
+	// The encoding switches once the sparse entries are no smaller than the registers
+	for i := 0; s.nonZero()*sparseEntrySize < m; i++ {
+		data, err = s.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data[2] != formatSparse {
+			t.Fatalf("sketch with %d of %d registers set encoded as dense", s.nonZero(), m)
+		}
+		if want := headerSize + s.nonZero()*sparseEntrySize; len(data) != want {
+			t.Fatalf("sparse encoding is %d bytes, want %d", len(data), want)
+		}
+		s.Add(fmt.Sprintf("visitor-%d", i))
+	}
+
+	data, err = s.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data[2] != formatDense || len(data) != headerSize+m {
+		t.Errorf("sketch with %d of %d registers set encoded as format %d in %d bytes, want dense",
+			s.nonZero(), m, data[2], len(data))
+	}
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	for _, n := range []int{0, 10, 100000} {
+		t.Run(fmt.Sprintf("n%d", n), func(t *testing.T) {
+			s := mustNew(t, DefaultPrecision)
+			addRange(s, "visitor-", n)
+
+			data, err := s.MarshalBinary()
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := Decode(data)
+			if err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			if decoded.Precision() != s.Precision() {
+				t.Errorf("decoded precision %d, want %d", decoded.Precision(), s.Precision())
+			}
+			for i := range s.registers {
+				if decoded.registers[i] != s.registers[i] {
+					t.Fatalf("register %d decoded as %d, want %d", i, decoded.registers[i], s.registers[i])
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"too short", []byte{encodingVersion, DefaultPrecision}},
+		{"unknown version", []byte{encodingVersion + 1, DefaultPrecision, formatSparse}},
+		{"precision out of range", []byte{encodingVersion, MaxPrecision + 1, formatSparse}},
+		{"unknown format", []byte{encodingVersion, DefaultPrecision, 2}},
+		{"short dense", []byte{encodingVersion, MinPrecision, formatDense, 0}},
+		{"truncated sparse", []byte{encodingVersion, MinPrecision, formatSparse, 0, 1}},
+		{"sparse index out of range", []byte{encodingVersion, MinPrecision, formatSparse, 0, 16, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Decode(tt.data); err == nil {
+				t.Error("Decode accepted an invalid sketch")
+			}
+		})
+	}
+}
+
+func TestMerge(t *testing.T) {
+	a := mustNew(t, DefaultPrecision)
+	b := mustNew(t, DefaultPrecision)
+	union := mustNew(t, DefaultPrecision)
+
+	// Half of the values of each sketch are shared
+	addRange(a, "a-", 5000)
+	addRange(a, "shared-", 5000)
+	addRange(b, "b-", 5000)
+	addRange(b, "shared-", 5000)
+	addRange(union, "a-", 5000)
+	addRange(union, "b-", 5000)
+	addRange(union, "shared-", 5000)
+
+	a.Merge(b)
+	for i := range union.registers {
+		if a.registers[i] != union.registers[i] {
+			t.Fatalf("merged register %d is %d, want %d", i, a.registers[i], union.registers[i])
+		}
+	}
+	if !withinError(a, 15000) {
+		t.Errorf("merged estimate %d of 15000 values is off by more than three standard errors", a.Estimate())
+	}
+}
+
+func TestMergeMixedPrecision(t *testing.T) {
+	const low, high = 10, 14
+
+	tests := []struct {
+		name           string
+		target, source int
+		wantPrecision  int
+	}{
+		{"higher into lower", low, high, low},
+		{"lower into higher", high, low, low},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := mustNew(t, tt.target)
+			source := mustNew(t, tt.source)
+			addRange(target, "target-", 20000)
+			addRange(source, "source-", 20000)
+
+			// The merge holds the registers the values would have set at the lower precision
+			want := mustNew(t, low)
+			addRange(want, "target-", 20000)
+			addRange(want, "source-", 20000)
+
+			target.Merge(source)
+			if target.Precision() != tt.wantPrecision {
+				t.Fatalf("merged precision %d, want %d", target.Precision(), tt.wantPrecision)
+			}
+			for i := range want.registers {
+				if target.registers[i] != want.registers[i] {
+					t.Fatalf("merged register %d is %d, want %d", i, target.registers[i], want.registers[i])
+				}
+			}
+			if source.Precision() != tt.source {
+				t.Errorf("merge changed the precision of the merged sketch to %d", source.Precision())
+			}
+		})
+	}
+}
+
+// nonZero returns the number of registers set
+func (s *Sketch) nonZero() int {
+	var n int
+	for _, value := range s.registers {
+		if value != 0 {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,141 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Metric types as reported in the exposition format
+const (
+	TypeCounter = "counter"
+	TypeGauge   = "gauge"
+)
+
+// collector produces a single metric sample
+type collector struct {
+	name  string
+	help  string
+	kind  string
+	value func() float64
+}
+
+// Registry holds registered metrics
+type Registry struct {
+	mu         sync.RWMutex
+	collectors map[string]*collector
+}
+
+// NewRegistry creates a new empty registry
+func NewRegistry() *Registry {
+	return &Registry{
+		collectors: make(map[string]*collector),
+	}
+}
+
+// DefaultRegistry is the registry used by the package level helpers
+var DefaultRegistry = NewRegistry()
+
+// register adds a collector, replacing any previous one with the same name
+func (r *Registry) register(c *collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors[c.name] = c
+}
+
+// Counter is a monotonically increasing value
+type Counter struct {
+	value atomic.Int64
+}
+
+// Inc increments the counter by one
+func (c *Counter) Inc() {
+	c.value.Add(1)
+}
+
+// Add increments the counter by n
+func (c *Counter) Add(n int64) {
+	c.value.Add(n)
+}
+
+// Value returns the current counter value
+func (c *Counter) Value() int64 {
+	return c.value.Load()
+}
+
+// NewCounter creates a counter and registers it in the registry
+func (r *Registry) NewCounter(name, help string) *Counter {
+	counter := &Counter{}
+	r.register(&collector{
+		name:  name,
+		help:  help,
+		kind:  TypeCounter,
+		value: func() float64 { return float64(counter.Value()) },
+	})
+	return counter
+}
+
+// NewCounterFunc registers a counter whose value is read from fn
+func (r *Registry) NewCounterFunc(name, help string, fn func() float64) {
+	r.register(&collector{name: name, help: help, kind: TypeCounter, value: fn})
+}
+
+// NewGaugeFunc registers a gauge whose value is read from fn
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	r.register(&collector{name: name, help: help, kind: TypeGauge, value: fn})
+}
+
+// Write writes all metrics in the Prometheus text exposition format
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.RLock()
+	collectors := make([]*collector, 0, len(r.collectors))
+	for _, c := range r.collectors {
+		collectors = append(collectors, c)
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(collectors, func(i, j int) bool {
+		return collectors[i].name < collectors[j].name
+	})
+
+	for _, c := range collectors {
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %g\n",
+			c.name, c.help, c.name, c.kind, c.name, c.value()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Handler returns an HTTP handler serving the registry
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.WriteHeader(http.StatusOK)
+		r.Write(w)
+	})
+}
+
+// NewCounter creates a counter in the default registry
+func NewCounter(name, help string) *Counter {
+	return DefaultRegistry.NewCounter(name, help)
+}
+
+// NewCounterFunc registers a function backed counter in the default registry
+func NewCounterFunc(name, help string, fn func() float64) {
+	DefaultRegistry.NewCounterFunc(name, help, fn)
+}
+
+// NewGaugeFunc registers a function backed gauge in the default registry
+func NewGaugeFunc(name, help string, fn func() float64) {
+	DefaultRegistry.NewGaugeFunc(name, help, fn)
+}
+
+// Handler returns an HTTP handler serving the default registry
+func Handler() http.Handler {
+	return DefaultRegistry.Handler()
+}
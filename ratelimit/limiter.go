@@ -0,0 +1,161 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Limit configures a token bucket
+type Limit struct {
+	// Rate is the number of tokens added per second; zero disables limiting
+	Rate float64 `json:"rate"`
+	// Burst is the bucket capacity
+	Burst int `json:"burst"`
+}
+
+// Enabled reports whether the limit restricts anything
+func (l Limit) Enabled() bool {
+	return l.Rate > 0 && l.Burst > 0
+}
+
+// bucket is a token bucket for one key
+type bucket struct {
+	tokens   float64
+	last     time.Time
+	lastSeen time.Time
+}
+
+// Limiter keeps token buckets per key and evicts idle ones
+type Limiter struct {
+	mu       sync.Mutex
+	limit    Limit
+	buckets  map[string]*bucket
+	idleTTL  time.Duration
+	stats    Stats
+	cleanup  *time.Ticker
+	stopChan chan struct{}
+	stopOnce sync.Once
+}
+
+// Stats provides rate limiter metrics
+type Stats struct {
+	Allowed   int64 `json:"allowed"`
+	Limited   int64 `json:"limited"`
+	Evictions int64 `json:"evictions"`
+	Buckets   int   `json:"buckets"`
+}
+
+// NewLimiter creates a new limiter. Buckets not used for idleTTL are evicted.
+func NewLimiter(limit Limit, idleTTL time.Duration) *Limiter {
+	if idleTTL <= 0 {
+		idleTTL = DefaultIdleTTL
+	}
+
+	limiter := &Limiter{
+		limit:    limit,
+		buckets:  make(map[string]*bucket),
+		idleTTL:  idleTTL,
+		cleanup:  time.NewTicker(idleTTL / 2),
+		stopChan: make(chan struct{}),
+	}
+
+	// Start eviction goroutine
+	go limiter.evictIdle()
+
+	return limiter
+}
+
+// Stop stops the eviction goroutine. It is safe to call more than once.
+func (l *Limiter) Stop() {
+	l.stopOnce.Do(func() {
+		l.cleanup.Stop()
+		close(l.stopChan)
+	})
+}
+
+// Allow takes a token for key. When the bucket is empty it returns false and
+// how long the caller should wait before retrying.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.limit.Enabled() {
+		l.stats.Allowed++
+		return true, 0
+	}
+
+	now := time.Now()
+	b, exists := l.buckets[key]
+	if !exists {
+		b = &bucket{tokens: float64(l.limit.Burst), last: now}
+		l.buckets[key] = b
+	}
+
+	// Refill tokens for the elapsed time
+	elapsed := now.Sub(b.last).Seconds()
+	b.tokens = math.Min(float64(l.limit.Burst), b.tokens+elapsed*l.limit.Rate)
+	b.last = now
+	b.lastSeen = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		l.stats.Allowed++
+		return true, 0
+	}
+
+	l.stats.Limited++
+	wait := time.Duration((1 - b.tokens) / l.limit.Rate * float64(time.Second))
+	return false, wait
+}
+
+// Limit returns the current limit
+func (l *Limiter) Limit() Limit {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limit
+}
+
+// SetLimit changes the limit at runtime. Existing buckets are capped to the new burst.
+func (l *Limiter) SetLimit(limit Limit) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.limit = limit
+	for _, b := range l.buckets {
+		b.tokens = math.Min(b.tokens, float64(limit.Burst))
+	}
+}
+
+// Stats returns limiter statistics
+func (l *Limiter) Stats() Stats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	stats := l.stats
+	stats.Buckets = len(l.buckets)
+	return stats
+}
+
+// evictIdle removes buckets that have not been used for idleTTL
+func (l *Limiter) evictIdle() {
+	for {
+		select {
+		case <-l.cleanup.C:
+			l.mu.Lock()
+			cutoff := time.Now().Add(-l.idleTTL)
+			for key, b := range l.buckets {
+				if b.lastSeen.Before(cutoff) {
+					delete(l.buckets, key)
+					l.stats.Evictions++
+				}
+			}
+			l.mu.Unlock()
+		case <-l.stopChan:
+			return
+		}
+	}
+}
+
+// DefaultIdleTTL is how long an unused bucket is kept
+const DefaultIdleTTL = 5 * time.Minute
@@ -0,0 +1,140 @@
+package resilience
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned when the circuit breaker rejects a call
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// State is the state of a circuit breaker
+type State int
+
+const (
+	// StateClosed lets all calls through
+	StateClosed State = iota
+	// StateHalfOpen lets a single probe call through
+	StateHalfOpen
+	// StateOpen rejects all calls until the open timeout elapses
+	StateOpen
+)
+
+// String returns the string representation of the state
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateHalfOpen:
+		return "half_open"
+	case StateOpen:
+		return "open"
+	default:
+		return "unknown"
+	}
+}
+
+// CircuitBreaker fails fast after consecutive failures
+type CircuitBreaker struct {
+	mu               sync.Mutex
+	failureThreshold int
+	openTimeout      time.Duration
+	state            State
+	failures         int
+	openedAt         time.Time
+	probing          bool
+	stats            BreakerStats
+}
+
+// BreakerStats provides circuit breaker metrics
+type BreakerStats struct {
+	State      string    `json:"state"`
+	Failures   int       `json:"consecutive_failures"`
+	Opens      int64     `json:"opens"`
+	Rejections int64     `json:"rejections"`
+	OpenedAt   time.Time `json:"opened_at,omitempty"`
+}
+
+// NewCircuitBreaker creates a breaker that opens after failureThreshold
+// consecutive failures and probes again after openTimeout
+func NewCircuitBreaker(failureThreshold int, openTimeout time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		failureThreshold: failureThreshold,
+		openTimeout:      openTimeout,
+	}
+}
+
+// Allow reports whether a call may proceed. Every allowed call must be
+// followed by Success or Failure.
+func (b *CircuitBreaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case StateOpen:
+		if time.Since(b.openedAt) < b.openTimeout {
+			b.stats.Rejections++
+			return ErrCircuitOpen
+		}
+		b.state = StateHalfOpen
+		b.probing = true
+		return nil
+	case StateHalfOpen:
+		if b.probing {
+			b.stats.Rejections++
+			return ErrCircuitOpen
+		}
+		b.probing = true
+		return nil
+	default:
+		return nil
+	}
+}
+
+// Success records a successful call and closes the breaker
+func (b *CircuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.state = StateClosed
+	b.failures = 0
+	b.probing = false
+}
+
+// Failure records a failed call and opens the breaker when the threshold is reached
+func (b *CircuitBreaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	b.probing = false
+	if b.state == StateHalfOpen || b.failures >= b.failureThreshold {
+		if b.state != StateOpen {
+			b.stats.Opens++
+		}
+		b.state = StateOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// State returns the current state
+func (b *CircuitBreaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// Stats returns circuit breaker statistics
+func (b *CircuitBreaker) Stats() BreakerStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	stats := b.stats
+	stats.State = b.state.String()
+	stats.Failures = b.failures
+	if b.state != StateClosed {
+		stats.OpenedAt = b.openedAt
+	}
+	return stats
+}
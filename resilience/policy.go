@@ -0,0 +1,108 @@
+package resilience
+
+import (
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// RetryPolicy configures exponential back-off
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts; 1 disables retries
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between attempts
+	MaxBackoff time.Duration
+	// Multiplier grows the delay after each attempt
+	Multiplier float64
+}
+
+// backoff returns the jittered delay before the given retry (starting at 1)
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	delay := float64(p.InitialBackoff)
+	for i := 1; i < retry; i++ {
+		delay *= p.Multiplier
+	}
+	if max := float64(p.MaxBackoff); p.MaxBackoff > 0 && delay > max {
+		delay = max
+	}
+
+	// Full jitter between half and the whole delay
+	return time.Duration(delay/2 + rand.Float64()*delay/2)
+}
+
+// Policy runs calls with retries and a circuit breaker. Only errors accepted
+// by IsTransient are retried and count as breaker failures.
+type Policy struct {
+	retry       RetryPolicy
+	breaker     *CircuitBreaker
+	isTransient func(error) bool
+	retries     atomic.Int64
+}
+
+// NewPolicy creates a new policy. A nil breaker disables fail-fast behaviour.
+func NewPolicy(retry RetryPolicy, breaker *CircuitBreaker, isTransient func(error) bool) *Policy {
+	if retry.MaxAttempts < 1 {
+		retry.MaxAttempts = 1
+	}
+	if retry.Multiplier < 1 {
+		retry.Multiplier = 2
+	}
+
+	return &Policy{
+		retry:       retry,
+		breaker:     breaker,
+		isTransient: isTransient,
+	}
+}
+
+// Do runs fn until it succeeds, fails with a permanent error or runs out of attempts
+func (p *Policy) Do(fn func() error) error {
+	return p.DoWith(fn, p.isTransient)
+}
+
+// DoWith runs fn like Do but only retries the transient errors accepted by
+// canRetry, for calls that are not safe to repeat after every transient error.
+// Transient errors that are not retried still count as breaker failures.
+func (p *Policy) DoWith(fn func() error, canRetry func(error) bool) error {
+	var err error
+	for attempt := 1; attempt <= p.retry.MaxAttempts; attempt++ {
+		if attempt > 1 {
+			p.retries.Add(1)
+			time.Sleep(p.retry.backoff(attempt - 1))
+		}
+
+		if p.breaker != nil {
+			if breakerErr := p.breaker.Allow(); breakerErr != nil {
+				return breakerErr
+			}
+		}
+
+		err = fn()
+		if err == nil || !p.isTransient(err) {
+			if p.breaker != nil {
+				p.breaker.Success()
+			}
+			return err
+		}
+
+		if p.breaker != nil {
+			p.breaker.Failure()
+		}
+		if !canRetry(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// Breaker returns the circuit breaker, which may be nil
+func (p *Policy) Breaker() *CircuitBreaker {
+	return p.breaker
+}
+
+// Retries returns the number of retries performed
+func (p *Policy) Retries() int64 {
+	return p.retries.Load()
+}
@@ -0,0 +1,230 @@
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// ErrNil is returned by the reply helpers for a nil reply
+var ErrNil = errors.New("resp: nil reply")
+
+// Error is an error reply from the server
+type Error string
+
+func (e Error) Error() string {
+	return "resp: " + string(e)
+}
+
+// Options configures connections to a server
+type Options struct {
+	// Password is sent with AUTH after connecting; empty skips authentication
+	Password string
+	// DB is selected after connecting
+	DB int
+	// DialTimeout bounds connecting and authenticating
+	DialTimeout time.Duration
+	// IOTimeout bounds sending a command and receiving its reply; zero means no limit
+	IOTimeout time.Duration
+}
+
+// Conn is a connection to a server speaking RESP, the Redis protocol. It is
+// not safe for concurrent use.
+type Conn struct {
+	conn      net.Conn
+	br        *bufio.Reader
+	bw        *bufio.Writer
+	ioTimeout time.Duration
+	// broken is set after an I/O or protocol error, when the stream position
+	// is unknown and the connection must not be reused
+	broken bool
+}
+
+// Dial connects to a server and authenticates and selects the database
+func Dial(addr string, opts Options) (*Conn, error) {
+	netConn, err := net.DialTimeout("tcp", addr, opts.DialTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("resp: failed to connect to %s: %w", addr, err)
+	}
+
+	c := &Conn{
+		conn:      netConn,
+		br:        bufio.NewReader(netConn),
+		bw:        bufio.NewWriter(netConn),
+		ioTimeout: opts.DialTimeout,
+	}
+
+	if opts.Password != "" {
+		if _, err := c.Do("AUTH", opts.Password); err != nil {
+			netConn.Close()
+			return nil, fmt.Errorf("resp: failed to authenticate: %w", err)
+		}
+	}
+	if opts.DB != 0 {
+		if _, err := c.Do("SELECT", opts.DB); err != nil {
+			netConn.Close()
+			return nil, fmt.Errorf("resp: failed to select database %d: %w", opts.DB, err)
+		}
+	}
+
+	c.ioTimeout = opts.IOTimeout
+	return c, nil
+}
+
+// Broken reports whether the connection failed and must be closed
+func (c *Conn) Broken() bool {
+	return c.broken
+}
+
+// Close closes the connection
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+// Do sends a command and returns its reply
+func (c *Conn) Do(args ...interface{}) (interface{}, error) {
+	if err := c.Send(args...); err != nil {
+		return nil, err
+	}
+	if err := c.Flush(); err != nil {
+		return nil, err
+	}
+	return c.Receive()
+}
+
+// Send buffers a command. Arguments are strings, byte slices or integers.
+func (c *Conn) Send(args ...interface{}) error {
+	c.bw.WriteByte('*')
+	c.bw.WriteString(strconv.Itoa(len(args)))
+	c.bw.WriteString("\r\n")
+
+	for _, arg := range args {
+		var data []byte
+		switch v := arg.(type) {
+		case string:
+			data = []byte(v)
+		case []byte:
+			data = v
+		case int:
+			data = strconv.AppendInt(nil, int64(v), 10)
+		case int64:
+			data = strconv.AppendInt(nil, v, 10)
+		default:
+			c.broken = true
+			return fmt.Errorf("resp: unsupported argument type %T", arg)
+		}
+		c.bw.WriteByte('$')
+		c.bw.WriteString(strconv.Itoa(len(data)))
+		c.bw.WriteString("\r\n")
+		c.bw.Write(data)
+		c.bw.WriteString("\r\n")
+	}
+	return nil
+}
+
+// Flush writes the buffered commands
+func (c *Conn) Flush() error {
+	c.setDeadline()
+	if err := c.bw.Flush(); err != nil {
+		c.broken = true
+		return fmt.Errorf("resp: failed to send command: %w", err)
+	}
+	return nil
+}
+
+// Receive reads the reply of the oldest unanswered command. Replies are
+// string for simple strings, int64 for integers, []byte for bulk strings,
+// []interface{} for arrays and nil for nil bulk strings and arrays. An error
+// reply is returned as the error; inside arrays it is returned as an Error
+// element.
+func (c *Conn) Receive() (interface{}, error) {
+	c.setDeadline()
+	reply, err := c.readReply()
+	if err != nil {
+		c.broken = true
+		return nil, err
+	}
+	if replyErr, ok := reply.(Error); ok {
+		return nil, replyErr
+	}
+	return reply, nil
+}
+
+func (c *Conn) setDeadline() {
+	if c.ioTimeout > 0 {
+		c.conn.SetDeadline(time.Now().Add(c.ioTimeout))
+	}
+}
+
+// readReply reads a reply; error replies are returned as Error values
+func (c *Conn) readReply() (interface{}, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, errors.New("resp: empty reply line")
+	}
+
+	switch line[0] {
+	case '+':
+		return string(line[1:]), nil
+	case '-':
+		return Error(line[1:]), nil
+	case ':':
+		n, err := strconv.ParseInt(string(line[1:]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("resp: invalid integer reply %q", line)
+		}
+		return n, nil
+	case '$':
+		n, err := strconv.Atoi(string(line[1:]))
+		if err != nil || n < -1 {
+			return nil, fmt.Errorf("resp: invalid bulk length %q", line)
+		}
+		if n == -1 {
+			return nil, nil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(c.br, data); err != nil {
+			return nil, fmt.Errorf("resp: failed to read reply: %w", err)
+		}
+		if data[n] != '\r' || data[n+1] != '\n' {
+			return nil, errors.New("resp: bulk string not terminated by CRLF")
+		}
+		return data[:n], nil
+	case '*':
+		n, err := strconv.Atoi(string(line[1:]))
+		if err != nil || n < -1 {
+			return nil, fmt.Errorf("resp: invalid array length %q", line)
+		}
+		if n == -1 {
+			return nil, nil
+		}
+		elements := make([]interface{}, n)
+		for i := range elements {
+			if elements[i], err = c.readReply(); err != nil {
+				return nil, err
+			}
+		}
+		return elements, nil
+	default:
+		return nil, fmt.Errorf("resp: unknown reply type %q", line[0])
+	}
+}
+
+// readLine reads a CRLF terminated line without the terminator
+func (c *Conn) readLine() ([]byte, error) {
+	line, err := c.br.ReadSlice('\n')
+	if err != nil {
+		return nil, fmt.Errorf("resp: failed to read reply: %w", err)
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return nil, errors.New("resp: reply line not terminated by CRLF")
+	}
+	return line[:len(line)-2], nil
+}
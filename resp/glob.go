@@ -0,0 +1,128 @@
+package resp
+
+import "errors"
+
+// Key patterns follow the glob syntax of Redis KEYS and SCAN MATCH: * matches
+// any characters, including /, ? one character, [...] a character class with
+// ranges, negated with [^...], and \ escapes the next character.
+
+// Errors of malformed key patterns
+var (
+	ErrTrailingBackslash = errors.New("resp: trailing backslash in pattern")
+	ErrUnterminatedClass = errors.New("resp: unterminated character class in pattern")
+)
+
+// CheckPattern validates a key pattern. Redis accepts any pattern, but a
+// character class must be closed and \ must escape a character for the
+// pattern to mean what it looks like.
+func CheckPattern(pattern string) error {
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			if i++; i == len(pattern) {
+				return ErrTrailingBackslash
+			}
+		case '[':
+			n, ok := classWidth(pattern[i:])
+			if !ok {
+				return ErrUnterminatedClass
+			}
+			i += n - 1
+		}
+	}
+	return nil
+}
+
+// MatchPattern reports whether key matches a pattern accepted by CheckPattern
+func MatchPattern(pattern, key string) bool {
+	p, k := 0, 0
+	// The position after the last * and the key position it resumes at
+	star, resume := -1, 0
+	for k < len(key) {
+		if p < len(pattern) {
+			if pattern[p] == '*' {
+				star, resume = p+1, k
+				p++
+				continue
+			}
+			if n, ok := matchChar(pattern[p:], key[k]); ok {
+				p += n
+				k++
+				continue
+			}
+		}
+		// Let the last * take one more character and retry
+		if star < 0 {
+			return false
+		}
+		resume++
+		p, k = star, resume
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// matchChar matches one character against the element the pattern starts
+// with, which is not *, and returns the width of the element
+func matchChar(pattern string, c byte) (int, bool) {
+	switch pattern[0] {
+	case '?':
+		return 1, true
+	case '\\':
+		if len(pattern) > 1 {
+			return 2, pattern[1] == c
+		}
+	case '[':
+		if n, ok := classWidth(pattern); ok {
+			return n, matchClass(pattern[1:n-1], c)
+		}
+	}
+	return 1, pattern[0] == c
+}
+
+// classWidth returns the width of the character class the pattern starts
+// with, including the brackets
+func classWidth(pattern string) (int, bool) {
+	i := 1
+	if i < len(pattern) && pattern[i] == '^' {
+		i++
+	}
+	for ; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case ']':
+			return i + 1, true
+		}
+	}
+	return 0, false
+}
+
+// matchClass matches a character against the inside of a character class
+func matchClass(class string, c byte) bool {
+	negate := len(class) > 0 && class[0] == '^'
+	if negate {
+		class = class[1:]
+	}
+
+	matched := false
+	for i := 0; i < len(class); i++ {
+		switch {
+		case class[i] == '\\' && i+1 < len(class):
+			i++
+			matched = matched || class[i] == c
+		case i+2 < len(class) && class[i+1] == '-':
+			lo, hi := class[i], class[i+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			matched = matched || (lo <= c && c <= hi)
+			i += 2
+		default:
+			matched = matched || class[i] == c
+		}
+	}
+	return matched != negate
+}
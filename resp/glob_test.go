@@ -0,0 +1,69 @@
+package resp_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/tyagnii/ecom_test/resp"
+)
+
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		key     string
+		want    bool
+	}{
+		{"*", "", true},
+		{"*", "banner:1", true},
+		{"banner:*", "banner:1", true},
+		{"banner:*", "click_stats:1", false},
+		// * crosses / unlike path.Match
+		{"*", "a/b", true},
+		{"a*b", "a/x/b", true},
+		{"a*b*c", "abxbxc", true},
+		{"a*b*c", "abxbx", false},
+		{"**x", "yyx", true},
+		{"banner:?", "banner:1", true},
+		{"banner:?", "banner:10", false},
+		{"banner:[12]", "banner:2", true},
+		{"banner:[12]", "banner:3", false},
+		{"banner:[0-9]", "banner:7", true},
+		{"banner:[9-0]", "banner:7", true},
+		{"banner:[^0-9]", "banner:x", true},
+		{"banner:[^0-9]", "banner:7", false},
+		{"[]]", "]", false},
+		{"[\\]]", "]", true},
+		{"a\\*b", "a*b", true},
+		{"a\\*b", "axb", false},
+		{"a\\?", "a?", true},
+		{"", "", true},
+		{"", "a", false},
+	}
+	for _, tt := range tests {
+		if err := resp.CheckPattern(tt.pattern); err != nil {
+			t.Errorf("CheckPattern(%q) = %v", tt.pattern, err)
+			continue
+		}
+		if got := resp.MatchPattern(tt.pattern, tt.key); got != tt.want {
+			t.Errorf("MatchPattern(%q, %q) = %v, want %v", tt.pattern, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestCheckPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    error
+	}{
+		{"banner:[", resp.ErrUnterminatedClass},
+		{"banner:[^", resp.ErrUnterminatedClass},
+		{"banner:[\\]", resp.ErrUnterminatedClass},
+		{"banner:\\", resp.ErrTrailingBackslash},
+		{"banner:[a-z]*", nil},
+	}
+	for _, tt := range tests {
+		if err := resp.CheckPattern(tt.pattern); !errors.Is(err, tt.want) {
+			t.Errorf("CheckPattern(%q) = %v, want %v", tt.pattern, err, tt.want)
+		}
+	}
+}
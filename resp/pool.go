@@ -0,0 +1,234 @@
+package resp
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrPoolClosed is returned by Get after the pool was closed
+var ErrPoolClosed = errors.New("resp: pool closed")
+
+// ErrPoolExhausted is returned by Get when no connection became available in time
+var ErrPoolExhausted = errors.New("resp: all connections in use")
+
+// DefaultDialCooldown is how long Get fails fast after a failed dial
+const DefaultDialCooldown = time.Second
+
+// PoolOptions configures the connections a pool keeps
+type PoolOptions struct {
+	// MaxIdle is the number of idle connections kept open
+	MaxIdle int
+	// MaxOpen bounds the connections open at once, idle or in use; zero
+	// means MaxIdle
+	MaxOpen int
+	// WaitTimeout bounds how long Get waits for a connection when MaxOpen
+	// are in use
+	WaitTimeout time.Duration
+	// DialCooldown is how long Get returns the error of a failed dial instead
+	// of dialing again, so an unreachable server is not dialed by every
+	// request; zero means DefaultDialCooldown
+	DialCooldown time.Duration
+}
+
+// Pool keeps idle connections to a server for reuse and bounds the
+// connections open at once
+type Pool struct {
+	addr     string
+	opts     Options
+	wait     time.Duration
+	cooldown time.Duration
+
+	// idle holds idle connections; slots holds a token per open connection
+	idle  chan *Conn
+	slots chan struct{}
+
+	mu          sync.Mutex
+	closed      bool
+	dialErr     error
+	failedUntil time.Time
+}
+
+// NewPool creates a pool of connections to addr
+func NewPool(addr string, opts Options, poolOpts PoolOptions) *Pool {
+	if poolOpts.MaxIdle <= 0 {
+		poolOpts.MaxIdle = 1
+	}
+	if poolOpts.MaxOpen < poolOpts.MaxIdle {
+		poolOpts.MaxOpen = poolOpts.MaxIdle
+	}
+	if poolOpts.DialCooldown <= 0 {
+		poolOpts.DialCooldown = DefaultDialCooldown
+	}
+	return &Pool{
+		addr:     addr,
+		opts:     opts,
+		wait:     poolOpts.WaitTimeout,
+		cooldown: poolOpts.DialCooldown,
+		idle:     make(chan *Conn, poolOpts.MaxIdle),
+		slots:    make(chan struct{}, poolOpts.MaxOpen),
+	}
+}
+
+// Get returns an idle connection or dials a new one. When MaxOpen connections
+// are open it waits for one to be returned, and for a while after a failed
+// dial it returns that error without dialing. The caller returns the
+// connection with Put.
+func (p *Pool) Get() (*Conn, error) {
+	if err := p.check(); err != nil {
+		return nil, err
+	}
+
+	select {
+	case conn := <-p.idle:
+		return conn, nil
+	default:
+	}
+
+	var timeout <-chan time.Time
+	if p.wait > 0 {
+		timer := time.NewTimer(p.wait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case conn := <-p.idle:
+		return conn, nil
+	case p.slots <- struct{}{}:
+	case <-timeout:
+		return nil, ErrPoolExhausted
+	}
+
+	// The cooldown may have started while waiting
+	if err := p.check(); err != nil {
+		<-p.slots
+		return nil, err
+	}
+
+	conn, err := Dial(p.addr, p.opts)
+	if err != nil {
+		<-p.slots
+		p.mu.Lock()
+		p.dialErr = err
+		p.failedUntil = time.Now().Add(p.cooldown)
+		p.mu.Unlock()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// check returns why Get cannot dial: the pool is closed or cooling down
+// after a failed dial
+func (p *Pool) check() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return ErrPoolClosed
+	}
+	if time.Now().Before(p.failedUntil) {
+		return p.dialErr
+	}
+	return nil
+}
+
+// Put returns a connection to the pool; broken connections and connections
+// beyond the idle limit are closed
+func (p *Pool) Put(conn *Conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !conn.Broken() && !p.closed {
+		select {
+		case p.idle <- conn:
+			return
+		default:
+		}
+	}
+	conn.Close()
+	<-p.slots
+}
+
+// Do sends a command on a pooled connection and returns its reply
+func (p *Pool) Do(args ...interface{}) (interface{}, error) {
+	conn, err := p.Get()
+	if err != nil {
+		return nil, err
+	}
+	defer p.Put(conn)
+	return conn.Do(args...)
+}
+
+// Close closes the idle connections; connections in use are closed when
+// they are put back
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.closed = true
+	for {
+		select {
+		case conn := <-p.idle:
+			conn.Close()
+			<-p.slots
+		default:
+			return nil
+		}
+	}
+}
+
+// Bytes converts a bulk string or simple string reply
+func Bytes(reply interface{}, err error) ([]byte, error) {
+	if err != nil {
+		return nil, err
+	}
+	switch v := reply.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	case nil:
+		return nil, ErrNil
+	default:
+		return nil, fmt.Errorf("resp: unexpected reply type %T for bytes", reply)
+	}
+}
+
+// Int64 converts an integer reply
+func Int64(reply interface{}, err error) (int64, error) {
+	if err != nil {
+		return 0, err
+	}
+	switch v := reply.(type) {
+	case int64:
+		return v, nil
+	case nil:
+		return 0, ErrNil
+	default:
+		return 0, fmt.Errorf("resp: unexpected reply type %T for integer", reply)
+	}
+}
+
+// Strings converts an array reply of bulk strings
+func Strings(reply interface{}, err error) ([]string, error) {
+	if err != nil {
+		return nil, err
+	}
+	switch v := reply.(type) {
+	case []interface{}:
+		values := make([]string, len(v))
+		for i, element := range v {
+			data, err := Bytes(element, nil)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = string(data)
+		}
+		return values, nil
+	case nil:
+		return nil, ErrNil
+	default:
+		return nil, fmt.Errorf("resp: unexpected reply type %T for array", reply)
+	}
+}
@@ -0,0 +1,403 @@
+package resp_test
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tyagnii/ecom_test/resp"
+	"github.com/tyagnii/ecom_test/resp/resptest"
+)
+
+// startServer starts a fake server that is closed when the test ends
+func startServer(t *testing.T) *resptest.Server {
+	t.Helper()
+	server, err := resptest.NewServer()
+	if err != nil {
+		t.Fatalf("failed to start fake server: %v", err)
+	}
+	t.Cleanup(server.Close)
+	return server
+}
+
+// dial connects to a fake server
+func dial(t *testing.T, server *resptest.Server, opts resp.Options) *resp.Conn {
+	t.Helper()
+	conn, err := resp.Dial(server.Addr, opts)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestReplies(t *testing.T) {
+	server := startServer(t)
+	server.SetHandler(func(args []string) (string, bool) {
+		if args[0] != "REPLY" {
+			return "", false
+		}
+		return args[1], true
+	})
+	conn := dial(t, server, resp.Options{IOTimeout: time.Second})
+
+	tests := []struct {
+		name string
+		raw  string
+		want interface{}
+	}{
+		{"simple string", "+OK\r\n", "OK"},
+		{"integer", ":-42\r\n", int64(-42)},
+		{"bulk string", "$5\r\nhe\r\no\r\n", []byte("he\r\no")},
+		{"empty bulk string", "$0\r\n\r\n", []byte{}},
+		{"nil bulk string", "$-1\r\n", nil},
+		{"nil array", "*-1\r\n", nil},
+		{"empty array", "*0\r\n", []interface{}{}},
+		{
+			"nested array with an error",
+			"*3\r\n:1\r\n*1\r\n$1\r\na\r\n-ERR inner\r\n",
+			[]interface{}{int64(1), []interface{}{[]byte("a")}, resp.Error("ERR inner")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reply, err := conn.Do("REPLY", tt.raw)
+			if err != nil {
+				t.Fatalf("Do: %v", err)
+			}
+			if !reflect.DeepEqual(reply, tt.want) {
+				t.Errorf("reply = %#v, want %#v", reply, tt.want)
+			}
+		})
+	}
+
+	// An error reply is returned as the error and leaves the connection usable
+	_, err := conn.Do("REPLY", "-ERR failed\r\n")
+	var replyErr resp.Error
+	if !errors.As(err, &replyErr) || string(replyErr) != "ERR failed" {
+		t.Errorf("error reply = %v, want Error %q", err, "ERR failed")
+	}
+	if conn.Broken() {
+		t.Error("error reply broke the connection")
+	}
+}
+
+func TestMalformedReplies(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+	}{
+		{"unknown type", "!oops\r\n"},
+		{"invalid integer", ":abc\r\n"},
+		{"invalid bulk length", "$-2\r\n"},
+		{"unterminated bulk string", "$2\r\nabcd\r\n"},
+		{"missing CR", "+OK\n"},
+		{"empty line", "\r\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := startServer(t)
+			server.SetHandler(func(args []string) (string, bool) {
+				return tt.raw, true
+			})
+			conn := dial(t, server, resp.Options{IOTimeout: time.Second})
+
+			if _, err := conn.Do("PING"); err == nil {
+				t.Fatal("malformed reply accepted")
+			}
+			if !conn.Broken() {
+				t.Error("connection not marked broken after a malformed reply")
+			}
+		})
+	}
+}
+
+func TestUnsupportedArgument(t *testing.T) {
+	server := startServer(t)
+	conn := dial(t, server, resp.Options{})
+
+	if err := conn.Send("SET", "key", 1.5); err == nil {
+		t.Fatal("float argument accepted")
+	}
+	if !conn.Broken() {
+		t.Error("connection not marked broken after a partially buffered command")
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	server := startServer(t)
+	conn := dial(t, server, resp.Options{IOTimeout: time.Second})
+
+	commands := [][]interface{}{
+		{"SET", "a", "1"},
+		{"SET", "b", []byte("2"), "PX", int64(60000)},
+		{"GET", "a"},
+		{"GET", "missing"},
+		{"DEL", "a", "b", "c"},
+	}
+	for _, command := range commands {
+		if err := conn.Send(command...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := conn.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []interface{}{"OK", "OK", []byte("1"), nil, int64(2)}
+	for i, w := range want {
+		reply, err := conn.Receive()
+		if err != nil {
+			t.Fatalf("reply %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(reply, w) {
+			t.Errorf("reply %d = %#v, want %#v", i, reply, w)
+		}
+	}
+}
+
+func TestDialAuthAndSelect(t *testing.T) {
+	server := startServer(t)
+	server.SetPassword("secret")
+
+	conn := dial(t, server, resp.Options{Password: "secret", DB: 2})
+	if _, err := conn.Do("PING"); err != nil {
+		t.Fatalf("PING after AUTH: %v", err)
+	}
+	if got, want := server.Commands(), []string{"AUTH", "SELECT", "PING"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("commands = %v, want %v", got, want)
+	}
+
+	if _, err := resp.Dial(server.Addr, resp.Options{Password: "wrong"}); err == nil {
+		t.Error("Dial with a wrong password succeeded")
+	}
+}
+
+func TestReplyHelpers(t *testing.T) {
+	if data, err := resp.Bytes("OK", nil); err != nil || string(data) != "OK" {
+		t.Errorf("Bytes(simple string) = %q, %v", data, err)
+	}
+	if _, err := resp.Bytes(nil, nil); !errors.Is(err, resp.ErrNil) {
+		t.Errorf("Bytes(nil) error = %v, want ErrNil", err)
+	}
+	if _, err := resp.Int64([]byte("1"), nil); err == nil {
+		t.Error("Int64 accepted a bulk string")
+	}
+	values, err := resp.Strings([]interface{}{[]byte("a"), "b"}, nil)
+	if err != nil || !reflect.DeepEqual(values, []string{"a", "b"}) {
+		t.Errorf("Strings = %v, %v", values, err)
+	}
+	if _, err := resp.Strings([]interface{}{int64(1)}, nil); err == nil {
+		t.Error("Strings accepted an integer element")
+	}
+	sentinel := errors.New("failed")
+	if _, err := resp.Int64(int64(1), sentinel); err != sentinel {
+		t.Errorf("Int64 error = %v, want the passed error", err)
+	}
+}
+
+func TestPoolReusesConnections(t *testing.T) {
+	server := startServer(t)
+	pool := resp.NewPool(server.Addr, resp.Options{}, resp.PoolOptions{MaxIdle: 2})
+	defer pool.Close()
+
+	for i := 0; i < 5; i++ {
+		if _, err := pool.Do("PING"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := server.Accepted(); n != 1 {
+		t.Errorf("sequential requests opened %d connections, want 1", n)
+	}
+
+	// Broken connections are not reused
+	conn, err := pool.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Send("PING", 1.5)
+	pool.Put(conn)
+	if _, err := pool.Do("PING"); err != nil {
+		t.Fatal(err)
+	}
+	if n := server.Accepted(); n != 2 {
+		t.Errorf("%d connections after a broken one was put back, want 2", n)
+	}
+}
+
+func TestPoolMaxOpen(t *testing.T) {
+	server := startServer(t)
+	pool := resp.NewPool(server.Addr, resp.Options{}, resp.PoolOptions{MaxIdle: 1, MaxOpen: 2, WaitTimeout: 50 * time.Millisecond})
+	defer pool.Close()
+
+	first, err := pool.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := pool.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	if _, err := pool.Get(); !errors.Is(err, resp.ErrPoolExhausted) {
+		t.Fatalf("Get beyond MaxOpen = %v, want ErrPoolExhausted", err)
+	}
+	if waited := time.Since(start); waited < 50*time.Millisecond {
+		t.Errorf("Get gave up after %v, want the wait timeout", waited)
+	}
+
+	// A waiting Get receives a connection put back
+	got := make(chan *resp.Conn)
+	go func() {
+		conn, err := pool.Get()
+		if err != nil {
+			t.Errorf("waiting Get: %v", err)
+		}
+		got <- conn
+	}()
+	time.Sleep(10 * time.Millisecond)
+	pool.Put(first)
+	if conn := <-got; conn != first {
+		t.Error("waiting Get did not receive the connection put back")
+	}
+
+	// Closing a connection beyond MaxIdle frees its slot for a new one
+	pool.Put(first)
+	pool.Put(second)
+	third, err := pool.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fourth, err := pool.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fourth.Do("PING"); err != nil {
+		t.Fatal(err)
+	}
+	pool.Put(third)
+	pool.Put(fourth)
+	if n := server.Accepted(); n != 3 {
+		t.Errorf("pool opened %d connections, want 3", n)
+	}
+}
+
+func TestPoolConcurrentUse(t *testing.T) {
+	server := startServer(t)
+	pool := resp.NewPool(server.Addr, resp.Options{}, resp.PoolOptions{MaxIdle: 2, MaxOpen: 4, WaitTimeout: 5 * time.Second})
+	defer pool.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				if _, err := pool.Do("PING"); err != nil {
+					t.Errorf("Do: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := server.Accepted(); n > 4 {
+		t.Errorf("pool opened %d connections, want at most MaxOpen 4", n)
+	}
+}
+
+func TestPoolOpenLimitUnderLoad(t *testing.T) {
+	server := startServer(t)
+	var mu sync.Mutex
+	open, maxOpen := 0, 0
+	server.SetHandler(func(args []string) (string, bool) {
+		if args[0] != "SLOW" {
+			return "", false
+		}
+		mu.Lock()
+		open++
+		if open > maxOpen {
+			maxOpen = open
+		}
+		mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		open--
+		mu.Unlock()
+		return "+OK\r\n", true
+	})
+	pool := resp.NewPool(server.Addr, resp.Options{}, resp.PoolOptions{MaxIdle: 1, MaxOpen: 3, WaitTimeout: 5 * time.Second})
+	defer pool.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 12; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := pool.Do("SLOW"); err != nil {
+				t.Errorf("Do: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if maxOpen > 3 {
+		t.Errorf("%d requests in flight at once, want at most MaxOpen 3", maxOpen)
+	}
+}
+
+func TestPoolDialCooldown(t *testing.T) {
+	server := startServer(t)
+	server.SetPassword("secret")
+	pool := resp.NewPool(server.Addr, resp.Options{Password: "wrong"}, resp.PoolOptions{DialCooldown: 100 * time.Millisecond})
+	defer pool.Close()
+
+	_, err := pool.Get()
+	if err == nil {
+		t.Fatal("Get with a wrong password succeeded")
+	}
+
+	// Within the cooldown the error is returned without dialing
+	for i := 0; i < 5; i++ {
+		if _, err2 := pool.Get(); err2 != err {
+			t.Fatalf("Get during the cooldown = %v, want the dial error %v", err2, err)
+		}
+	}
+	if n := server.Accepted(); n != 1 {
+		t.Errorf("%d dials during the cooldown, want 1", n)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	if _, err := pool.Get(); err == nil {
+		t.Fatal("Get with a wrong password succeeded")
+	}
+	if n := server.Accepted(); n != 2 {
+		t.Errorf("%d dials after the cooldown, want 2", n)
+	}
+}
+
+func TestPoolClose(t *testing.T) {
+	server := startServer(t)
+	pool := resp.NewPool(server.Addr, resp.Options{}, resp.PoolOptions{MaxIdle: 2})
+
+	conn, err := pool.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.Close()
+
+	if _, err := pool.Get(); !errors.Is(err, resp.ErrPoolClosed) {
+		t.Errorf("Get after Close = %v, want ErrPoolClosed", err)
+	}
+	// Connections in use are closed when put back
+	pool.Put(conn)
+	if _, err := conn.Do("PING"); err == nil {
+		t.Error("connection put back after Close is still open")
+	}
+}
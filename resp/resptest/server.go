@@ -0,0 +1,630 @@
+// Package resptest provides an in-memory server speaking RESP, the Redis
+// protocol, for tests of RESP clients.
+package resptest
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tyagnii/ecom_test/resp"
+)
+
+// Server implements the subset of Redis commands used by the cache: PING,
+// AUTH, SELECT, GET, SET with PX, DEL, EXISTS, PTTL, DBSIZE, SCAN with MATCH
+// and COUNT, SADD, SMEMBERS, RENAME and WATCH, UNWATCH, MULTI, EXEC and
+// DISCARD. Keys expire lazily.
+type Server struct {
+	// Addr is the address the server listens on
+	Addr string
+
+	ln net.Listener
+	wg sync.WaitGroup
+
+	mu         sync.Mutex
+	password   string
+	handler    func(args []string) (string, bool)
+	beforeExec func(s *Server)
+	values     map[string]*value
+	version    int64
+	// tombstones holds the versions of deleted keys
+	tombstones map[string]int64
+	commands   []string
+	// cursors maps SCAN cursors to the last key returned
+	cursors  map[int]string
+	accepted int
+	open     map[net.Conn]bool
+	closed   bool
+}
+
+// value is a stored string or set
+type value struct {
+	data      []byte
+	set       map[string]bool
+	expiresAt time.Time
+	version   int64
+}
+
+// NewServer starts a server on a local port
+func NewServer() (*Server, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		Addr:       ln.Addr().String(),
+		ln:         ln,
+		values:     make(map[string]*value),
+		tombstones: make(map[string]int64),
+		cursors:    make(map[int]string),
+		open:       make(map[net.Conn]bool),
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// Close stops the server and closes its connections
+func (s *Server) Close() {
+	s.mu.Lock()
+	s.closed = true
+	for conn := range s.open {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.ln.Close()
+	s.wg.Wait()
+}
+
+// SetPassword requires connections to send password with AUTH before other
+// commands
+func (s *Server) SetPassword(password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.password = password
+}
+
+// SetHandler sets a function called with every command before it is
+// executed. It returns a raw reply to send instead, or false to execute the
+// command.
+func (s *Server) SetHandler(handler func(args []string) (reply string, ok bool)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handler = handler
+}
+
+// SetBeforeExec sets a function called before every EXEC is executed, e.g. to
+// modify watched keys. It is called without the server lock held.
+func (s *Server) SetBeforeExec(fn func(s *Server)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.beforeExec = fn
+}
+
+// Accepted returns the number of connections accepted
+func (s *Server) Accepted() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.accepted
+}
+
+// Commands returns the names of the commands received, in order
+func (s *Server) Commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.commands...)
+}
+
+// Count returns how often a command was received
+func (s *Server) Count(name string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, command := range s.commands {
+		if command == name {
+			n++
+		}
+	}
+	return n
+}
+
+// Set stores a string under key; a zero ttl means no expiry
+func (s *Server) Set(key string, data []byte, ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.put(key, &value{data: data}, ttl)
+}
+
+// Get returns the string stored under key
+func (s *Server) Get(key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v := s.lookup(key)
+	if v == nil || v.set != nil {
+		return nil, false
+	}
+	return v.data, true
+}
+
+// TTL returns the remaining time to live of key, or zero without expiry
+func (s *Server) TTL(key string) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v := s.lookup(key)
+	if v == nil || v.expiresAt.IsZero() {
+		return 0
+	}
+	return time.Until(v.expiresAt)
+}
+
+// Keys returns the live keys in ascending order
+func (s *Server) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.keys()
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.accepted++
+		s.open[conn] = true
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go s.handle(conn)
+	}
+}
+
+// session is the state of a connection
+type session struct {
+	authenticated bool
+	watched       map[string]int64
+	queued        [][]string
+	multi         bool
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.open, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	br := bufio.NewReader(conn)
+	bw := bufio.NewWriter(conn)
+	s.mu.Lock()
+	sess := &session{authenticated: s.password == ""}
+	s.mu.Unlock()
+	for {
+		args, err := readCommand(br)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				fmt.Fprintf(bw, "-ERR %s\r\n", err)
+				bw.Flush()
+			}
+			return
+		}
+		reply := s.command(sess, args)
+		bw.WriteString(reply)
+		// Replies of pipelined commands are sent together
+		if br.Buffered() == 0 {
+			if err := bw.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// readCommand reads a command sent as an array of bulk strings
+func readCommand(br *bufio.Reader) ([]string, error) {
+	line, err := readLine(br)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return nil, fmt.Errorf("expected array, got %q", line)
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n <= 0 {
+		return nil, fmt.Errorf("invalid array length %q", line)
+	}
+
+	args := make([]string, n)
+	for i := range args {
+		line, err := readLine(br)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(line, "$") {
+			return nil, fmt.Errorf("expected bulk string, got %q", line)
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("invalid bulk length %q", line)
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(br, data); err != nil {
+			return nil, err
+		}
+		args[i] = string(data[:size])
+	}
+	return args, nil
+}
+
+func readLine(br *bufio.Reader) (string, error) {
+	line, err := br.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(line, "\r\n"), nil
+}
+
+// command executes a command and returns the raw reply
+func (s *Server) command(sess *session, args []string) string {
+	name := strings.ToUpper(args[0])
+
+	s.mu.Lock()
+	s.commands = append(s.commands, name)
+	handler, password := s.handler, s.password
+	s.mu.Unlock()
+
+	if handler != nil {
+		if reply, ok := handler(args); ok {
+			return reply
+		}
+	}
+
+	if name == "AUTH" {
+		if len(args) != 2 || args[1] != password {
+			return "-WRONGPASS invalid password\r\n"
+		}
+		sess.authenticated = true
+		return "+OK\r\n"
+	}
+	if !sess.authenticated {
+		return "-NOAUTH Authentication required.\r\n"
+	}
+
+	if sess.multi {
+		switch name {
+		case "EXEC":
+			return s.exec(sess)
+		case "DISCARD":
+			sess.multi, sess.queued, sess.watched = false, nil, nil
+			return "+OK\r\n"
+		case "MULTI", "WATCH":
+			return "-ERR " + name + " inside MULTI is not allowed\r\n"
+		}
+		sess.queued = append(sess.queued, args)
+		return "+QUEUED\r\n"
+	}
+
+	switch name {
+	case "MULTI":
+		sess.multi = true
+		return "+OK\r\n"
+	case "EXEC":
+		return "-ERR EXEC without MULTI\r\n"
+	case "DISCARD":
+		return "-ERR DISCARD without MULTI\r\n"
+	case "WATCH":
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if sess.watched == nil {
+			sess.watched = make(map[string]int64)
+		}
+		for _, key := range args[1:] {
+			sess.watched[key] = s.versionOf(key)
+		}
+		return "+OK\r\n"
+	case "UNWATCH":
+		sess.watched = nil
+		return "+OK\r\n"
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.execute(args)
+}
+
+// exec runs the queued commands unless a watched key changed
+func (s *Server) exec(sess *session) string {
+	queued, watched := sess.queued, sess.watched
+	sess.multi, sess.queued, sess.watched = false, nil, nil
+
+	s.mu.Lock()
+	beforeExec := s.beforeExec
+	s.mu.Unlock()
+	if beforeExec != nil {
+		beforeExec(s)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, version := range watched {
+		if s.versionOf(key) != version {
+			return "*-1\r\n"
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(queued))
+	for _, args := range queued {
+		b.WriteString(s.execute(args))
+	}
+	return b.String()
+}
+
+// execute runs a data command; the caller holds s.mu
+func (s *Server) execute(args []string) string {
+	name := strings.ToUpper(args[0])
+	switch name {
+	case "PING":
+		return "+PONG\r\n"
+	case "SELECT":
+		return "+OK\r\n"
+	case "GET":
+		if len(args) != 2 {
+			return wrongArgs(name)
+		}
+		v := s.lookup(args[1])
+		if v == nil {
+			return "$-1\r\n"
+		}
+		if v.set != nil {
+			return "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"
+		}
+		return bulk(string(v.data))
+	case "SET":
+		if len(args) != 3 && len(args) != 5 {
+			return wrongArgs(name)
+		}
+		var ttl time.Duration
+		if len(args) == 5 {
+			ms, err := strconv.ParseInt(args[4], 10, 64)
+			if strings.ToUpper(args[3]) != "PX" || err != nil || ms <= 0 {
+				return "-ERR invalid expire time in 'set' command\r\n"
+			}
+			ttl = time.Duration(ms) * time.Millisecond
+		}
+		s.put(args[1], &value{data: []byte(args[2])}, ttl)
+		return "+OK\r\n"
+	case "DEL":
+		deleted := 0
+		for _, key := range args[1:] {
+			if s.lookup(key) != nil {
+				s.remove(key)
+				deleted++
+			}
+		}
+		return integer(deleted)
+	case "EXISTS":
+		exists := 0
+		for _, key := range args[1:] {
+			if s.lookup(key) != nil {
+				exists++
+			}
+		}
+		return integer(exists)
+	case "PTTL":
+		if len(args) != 2 {
+			return wrongArgs(name)
+		}
+		v := s.lookup(args[1])
+		switch {
+		case v == nil:
+			return integer(-2)
+		case v.expiresAt.IsZero():
+			return integer(-1)
+		default:
+			return integer(int(time.Until(v.expiresAt).Milliseconds()))
+		}
+	case "DBSIZE":
+		return integer(len(s.keys()))
+	case "SCAN":
+		return s.scan(args)
+	case "SADD":
+		if len(args) < 3 {
+			return wrongArgs(name)
+		}
+		v := s.lookup(args[1])
+		if v == nil {
+			v = &value{set: make(map[string]bool)}
+			s.put(args[1], v, 0)
+		} else if v.set == nil {
+			return "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"
+		}
+		added := 0
+		for _, member := range args[2:] {
+			if !v.set[member] {
+				v.set[member] = true
+				added++
+			}
+		}
+		s.touch(args[1])
+		return integer(added)
+	case "SMEMBERS":
+		if len(args) != 2 {
+			return wrongArgs(name)
+		}
+		v := s.lookup(args[1])
+		if v == nil {
+			return "*0\r\n"
+		}
+		members := make([]string, 0, len(v.set))
+		for member := range v.set {
+			members = append(members, member)
+		}
+		sort.Strings(members)
+		return array(members)
+	case "RENAME":
+		if len(args) != 3 {
+			return wrongArgs(name)
+		}
+		v := s.lookup(args[1])
+		if v == nil {
+			return "-ERR no such key\r\n"
+		}
+		s.remove(args[1])
+		s.values[args[2]] = v
+		s.touch(args[2])
+		return "+OK\r\n"
+	default:
+		return "-ERR unknown command '" + args[0] + "'\r\n"
+	}
+}
+
+// scan pages through the sorted keys. A cursor resumes after the last key
+// returned, so keys deleted during a scan do not make it skip others.
+func (s *Server) scan(args []string) string {
+	if len(args) < 2 {
+		return wrongArgs("SCAN")
+	}
+	cursor, err := strconv.Atoi(args[1])
+	if err != nil || cursor < 0 {
+		return "-ERR invalid cursor\r\n"
+	}
+	pattern, count := "*", 10
+	for i := 2; i+1 < len(args); i += 2 {
+		switch strings.ToUpper(args[i]) {
+		case "MATCH":
+			pattern = args[i+1]
+		case "COUNT":
+			if count, err = strconv.Atoi(args[i+1]); err != nil || count <= 0 {
+				return "-ERR syntax error\r\n"
+			}
+		}
+	}
+
+	keys := s.keys()
+	start := 0
+	if cursor != 0 {
+		last, ok := s.cursors[cursor]
+		if !ok {
+			return "-ERR invalid cursor\r\n"
+		}
+		delete(s.cursors, cursor)
+		start = sort.Search(len(keys), func(i int) bool { return keys[i] > last })
+	}
+	end := start + count
+	if end > len(keys) {
+		end = len(keys)
+	}
+	var matched []string
+	for _, key := range keys[start:end] {
+		if resp.MatchPattern(pattern, key) {
+			matched = append(matched, key)
+		}
+	}
+	next := 0
+	if end < len(keys) {
+		next = len(s.cursors) + 1
+		for _, used := s.cursors[next]; used; _, used = s.cursors[next] {
+			next++
+		}
+		s.cursors[next] = keys[end-1]
+	}
+	return "*2\r\n" + bulk(strconv.Itoa(next)) + array(matched)
+}
+
+// lookup returns the live value of key; the caller holds s.mu
+func (s *Server) lookup(key string) *value {
+	v, ok := s.values[key]
+	if !ok {
+		return nil
+	}
+	if !v.expiresAt.IsZero() && !time.Now().Before(v.expiresAt) {
+		s.remove(key)
+		return nil
+	}
+	return v
+}
+
+// put stores a value; the caller holds s.mu
+func (s *Server) put(key string, v *value, ttl time.Duration) {
+	if ttl > 0 {
+		v.expiresAt = time.Now().Add(ttl)
+	}
+	s.values[key] = v
+	s.touch(key)
+}
+
+// remove deletes a key; the caller holds s.mu
+func (s *Server) remove(key string) {
+	delete(s.values, key)
+	s.touch(key)
+}
+
+// touch records that key changed, for WATCH; the caller holds s.mu
+func (s *Server) touch(key string) {
+	s.version++
+	if v, ok := s.values[key]; ok {
+		v.version = s.version
+		delete(s.tombstones, key)
+		return
+	}
+	// Deleted keys keep a version so that WATCH notices the deletion
+	s.tombstones[key] = s.version
+}
+
+// versionOf returns the version of key; the caller holds s.mu
+func (s *Server) versionOf(key string) int64 {
+	if v := s.lookup(key); v != nil {
+		return v.version
+	}
+	return s.tombstones[key]
+}
+
+// keys returns the live keys in ascending order; the caller holds s.mu
+func (s *Server) keys() []string {
+	keys := make([]string, 0, len(s.values))
+	for key := range s.values {
+		if s.lookup(key) != nil {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func bulk(s string) string {
+	return "$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n"
+}
+
+func integer(n int) string {
+	return ":" + strconv.Itoa(n) + "\r\n"
+}
+
+func array(elements []string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(elements))
+	for _, element := range elements {
+		b.WriteString(bulk(element))
+	}
+	return b.String()
+}
+
+func wrongArgs(name string) string {
+	return "-ERR wrong number of arguments for '" + strings.ToLower(name) + "' command\r\n"
+}
@@ -0,0 +1,23 @@
+//go:build !unix
+
+package wal
+
+import (
+	"fmt"
+	"os"
+)
+
+// lockDir opens the lock file. Advisory locking is not available on this
+// platform, so only one process should use a WAL directory at a time.
+func lockDir(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open WAL lock file: %w", err)
+	}
+	return file, nil
+}
+
+// unlockDir releases the lock taken by lockDir
+func unlockDir(file *os.File) {
+	file.Close()
+}
@@ -0,0 +1,619 @@
+package wal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SyncPolicy controls when appended records are fsynced
+type SyncPolicy string
+
+const (
+	// SyncAlways fsyncs after every append
+	SyncAlways SyncPolicy = "always"
+	// SyncInterval fsyncs dirty segments periodically
+	SyncInterval SyncPolicy = "interval"
+	// SyncNever leaves flushing to the operating system
+	SyncNever SyncPolicy = "never"
+)
+
+// ParseSyncPolicy validates a sync policy name
+func ParseSyncPolicy(name string) (SyncPolicy, error) {
+	switch policy := SyncPolicy(strings.ToLower(name)); policy {
+	case SyncAlways, SyncInterval, SyncNever:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown WAL sync policy %q (want always, interval or never)", name)
+	}
+}
+
+var (
+	// ErrFull is returned by Append when the log reached its size cap
+	ErrFull = errors.New("wal: size cap reached")
+	// ErrLocked is returned by Open when another process owns the log directory
+	ErrLocked = errors.New("wal: directory is locked by another process")
+	// ErrClosed is returned when using a closed log
+	ErrClosed = errors.New("wal: log is closed")
+	// ErrTooLarge is returned by Append for records above MaxRecordSize
+	ErrTooLarge = errors.New("wal: record too large")
+
+	// errCorrupt means the rest of a segment is unreadable
+	errCorrupt = errors.New("wal: corrupt record")
+)
+
+const (
+	segmentExt    = ".wal"
+	checkpointExt = ".ckpt"
+	lockFileName  = "LOCK"
+	headerSize    = 8
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Options configures a write-ahead log
+type Options struct {
+	// Dir holds the segment files
+	Dir string
+	// SegmentSize is the size after which a new segment is started
+	SegmentSize int64
+	// MaxSize caps the total size of all segments; zero means unlimited
+	MaxSize int64
+	// Sync is the fsync policy
+	Sync SyncPolicy
+	// SyncInterval is the fsync period for SyncInterval
+	SyncInterval time.Duration
+}
+
+// Status describes the contents of a log directory
+type Status struct {
+	Dir           string    `json:"dir"`
+	Segments      int       `json:"segments"`
+	Bytes         int64     `json:"bytes"`
+	Records       int       `json:"records"`
+	OldestSegment time.Time `json:"oldest_segment,omitempty"`
+}
+
+// Log is a segmented append-only log of opaque records. Records are framed as
+// a 4-byte length and a 4-byte CRC32C followed by the payload.
+type Log struct {
+	mu         sync.Mutex
+	replayMu   sync.Mutex
+	opts       Options
+	lock       *os.File
+	active     *os.File
+	activeSeq  uint64
+	activeSize int64
+	totalSize  int64
+	dirty      bool
+	closed     bool
+	syncTicker *time.Ticker
+	stopChan   chan struct{}
+	onCorrupt  func(CorruptTail)
+}
+
+// CorruptTail describes the unreadable end of a segment that replay skipped.
+// Records past the corruption are lost.
+type CorruptTail struct {
+	Segment string
+	// Offset is where the first unreadable record starts
+	Offset int64
+	// Skipped is the number of bytes that were not replayed
+	Skipped int64
+	Err     error
+}
+
+// Open opens the log in opts.Dir, taking an exclusive lock on the directory.
+// Existing segments are kept for replay; new records go to a fresh segment.
+func Open(opts Options) (*Log, error) {
+	if opts.SegmentSize <= 0 {
+		opts.SegmentSize = DefaultSegmentSize
+	}
+	if opts.Sync == "" {
+		opts.Sync = SyncInterval
+	}
+	if opts.SyncInterval <= 0 {
+		opts.SyncInterval = DefaultSyncInterval
+	}
+
+	if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create WAL directory: %w", err)
+	}
+
+	lock, err := lockDir(filepath.Join(opts.Dir, lockFileName))
+	if err != nil {
+		return nil, err
+	}
+
+	segments, err := listSegments(opts.Dir)
+	if err != nil {
+		unlockDir(lock)
+		return nil, err
+	}
+
+	l := &Log{
+		opts:     opts,
+		lock:     lock,
+		stopChan: make(chan struct{}),
+	}
+	for _, seg := range segments {
+		l.totalSize += seg.size
+		l.activeSeq = seg.seq
+	}
+
+	if opts.Sync == SyncInterval {
+		l.syncTicker = time.NewTicker(opts.SyncInterval)
+		go l.syncLoop()
+	}
+
+	return l, nil
+}
+
+// Append writes a record to the active segment
+func (l *Log) Append(data []byte) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return ErrClosed
+	}
+
+	if len(data) > MaxRecordSize {
+		return ErrTooLarge
+	}
+
+	recordSize := int64(headerSize + len(data))
+	if l.opts.MaxSize > 0 && l.totalSize+recordSize > l.opts.MaxSize {
+		return ErrFull
+	}
+
+	if l.active == nil || l.activeSize+recordSize > l.opts.SegmentSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+
+	buf := make([]byte, recordSize)
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(buf[4:8], crc32.Checksum(data, crcTable))
+	copy(buf[headerSize:], data)
+
+	if _, err := l.active.Write(buf); err != nil {
+		return fmt.Errorf("failed to append WAL record: %w", err)
+	}
+	l.activeSize += recordSize
+	l.totalSize += recordSize
+
+	if l.opts.Sync == SyncAlways {
+		if err := l.active.Sync(); err != nil {
+			return fmt.Errorf("failed to sync WAL segment: %w", err)
+		}
+	} else {
+		l.dirty = true
+	}
+
+	return nil
+}
+
+// OnCorrupt sets a callback invoked for every segment whose tail is torn or
+// fails its checksum during replay
+func (l *Log) OnCorrupt(fn func(CorruptTail)) {
+	l.onCorrupt = fn
+}
+
+// Sync flushes the active segment to disk
+func (l *Log) Sync() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.syncLocked()
+}
+
+// Replay reads sealed segments oldest first and passes their records to fn in
+// batches of at most batchSize. A segment is removed once all of its records were
+// handled. Progress within a segment is checkpointed after every batch, so
+// delivery is at-least-once: a batch is only handed out again if the process
+// stops after fn succeeded but before its checkpoint was written. A torn or
+// corrupt tail ends its segment and is reported to the OnCorrupt callback.
+// Replay stops at the first error from fn.
+func (l *Log) Replay(batchSize int, fn func(records [][]byte) error) (int, error) {
+	l.replayMu.Lock()
+	defer l.replayMu.Unlock()
+
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return 0, ErrClosed
+	}
+
+	// Seal the active segment so its records are replayed too
+	if l.active != nil {
+		if err := l.sealLocked(); err != nil {
+			l.mu.Unlock()
+			return 0, err
+		}
+	}
+	lastSealed := l.activeSeq
+	l.mu.Unlock()
+
+	segments, err := listSegments(l.opts.Dir)
+	if err != nil {
+		return 0, err
+	}
+
+	replayed := 0
+	for _, seg := range segments {
+		// Segments started after sealing belong to concurrent appends
+		if seg.seq > lastSealed {
+			break
+		}
+
+		n, err := replaySegment(seg, batchSize, fn, l.onCorrupt)
+		replayed += n
+		if err != nil {
+			return replayed, err
+		}
+
+		if err := removeSegment(seg); err != nil {
+			return replayed, err
+		}
+
+		l.mu.Lock()
+		l.totalSize -= seg.size
+		l.mu.Unlock()
+	}
+
+	return replayed, nil
+}
+
+// Status returns the current log status
+func (l *Log) Status() (Status, error) {
+	return ReadStatus(l.opts.Dir)
+}
+
+// Size returns the total size of all segments in bytes
+func (l *Log) Size() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.totalSize
+}
+
+// Close syncs and closes the log and releases the directory lock
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+
+	if l.syncTicker != nil {
+		l.syncTicker.Stop()
+		close(l.stopChan)
+	}
+
+	var err error
+	if l.active != nil {
+		err = l.sealLocked()
+	}
+	unlockDir(l.lock)
+	return err
+}
+
+// rotate seals the active segment and opens the next one
+func (l *Log) rotate() error {
+	if l.active != nil {
+		if err := l.sealLocked(); err != nil {
+			return err
+		}
+	}
+
+	l.activeSeq++
+	path := filepath.Join(l.opts.Dir, segmentName(l.activeSeq))
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to create WAL segment: %w", err)
+	}
+
+	l.active = file
+	l.activeSize = 0
+	return nil
+}
+
+// sealLocked syncs and closes the active segment
+func (l *Log) sealLocked() error {
+	if err := l.syncLocked(); err != nil {
+		return err
+	}
+	if err := l.active.Close(); err != nil {
+		return fmt.Errorf("failed to close WAL segment: %w", err)
+	}
+	l.active = nil
+	l.activeSize = 0
+	return nil
+}
+
+// syncLocked fsyncs the active segment if it has unsynced writes
+func (l *Log) syncLocked() error {
+	if l.active == nil || !l.dirty {
+		return nil
+	}
+	if err := l.active.Sync(); err != nil {
+		return fmt.Errorf("failed to sync WAL segment: %w", err)
+	}
+	l.dirty = false
+	return nil
+}
+
+// syncLoop periodically fsyncs for the interval policy
+func (l *Log) syncLoop() {
+	for {
+		select {
+		case <-l.syncTicker.C:
+			l.Sync()
+		case <-l.stopChan:
+			return
+		}
+	}
+}
+
+// segment is a segment file on disk
+type segment struct {
+	seq     uint64
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// segmentName returns the file name of a segment
+func segmentName(seq uint64) string {
+	return fmt.Sprintf("%020d%s", seq, segmentExt)
+}
+
+// listSegments returns the segments in dir ordered by sequence
+func listSegments(dir string) ([]segment, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read WAL directory: %w", err)
+	}
+
+	var segments []segment
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat WAL segment %s: %w", name, err)
+		}
+
+		segments = append(segments, segment{
+			seq:     seq,
+			path:    filepath.Join(dir, name),
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		})
+	}
+
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].seq < segments[j].seq
+	})
+
+	return segments, nil
+}
+
+// replaySegment hands the records of a segment past its checkpoint to fn
+func replaySegment(seg segment, batchSize int, fn func(records [][]byte) error, onCorrupt func(CorruptTail)) (int, error) {
+	if batchSize <= 0 {
+		batchSize = DefaultReplayBatchSize
+	}
+
+	file, err := os.Open(seg.path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open WAL segment: %w", err)
+	}
+	defer file.Close()
+
+	offset, err := readCheckpoint(seg)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("failed to seek WAL segment: %w", err)
+	}
+
+	reader := bufio.NewReader(file)
+	replayed := 0
+	for {
+		batch, n, err := readRecords(reader, batchSize, seg.size-offset)
+		if len(batch) > 0 {
+			if fnErr := fn(batch); fnErr != nil {
+				return replayed, fnErr
+			}
+			replayed += len(batch)
+			offset += n
+			if err := writeCheckpoint(seg, offset); err != nil {
+				return replayed, err
+			}
+		}
+
+		// A torn or corrupt tail ends the segment
+		if err != nil {
+			if onCorrupt != nil {
+				onCorrupt(CorruptTail{
+					Segment: filepath.Base(seg.path),
+					Offset:  offset,
+					Skipped: seg.size - offset,
+					Err:     err,
+				})
+			}
+			return replayed, nil
+		}
+		if len(batch) < batchSize {
+			return replayed, nil
+		}
+	}
+}
+
+// readRecords reads up to limit records from the remaining bytes of a segment and
+// returns them with the bytes consumed. A non-nil error means the rest of the
+// segment is unreadable.
+func readRecords(r io.Reader, limit int, remaining int64) ([][]byte, int64, error) {
+	var records [][]byte
+	var consumed int64
+	header := make([]byte, headerSize)
+	for len(records) < limit {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err == io.EOF {
+				return records, consumed, nil
+			}
+			return records, consumed, err
+		}
+
+		length := binary.BigEndian.Uint32(header[0:4])
+		checksum := binary.BigEndian.Uint32(header[4:8])
+		// The length is unverified until the checksum matches
+		if length > MaxRecordSize || int64(length) > remaining-consumed-headerSize {
+			return records, consumed, fmt.Errorf("%w: length %d exceeds the segment", errCorrupt, length)
+		}
+		data := make([]byte, length)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return records, consumed, err
+		}
+		if crc32.Checksum(data, crcTable) != checksum {
+			return records, consumed, fmt.Errorf("%w: checksum mismatch", errCorrupt)
+		}
+
+		records = append(records, data)
+		consumed += int64(headerSize) + int64(length)
+	}
+	return records, consumed, nil
+}
+
+// readCheckpoint returns the replayed offset of a segment
+func readCheckpoint(seg segment) (int64, error) {
+	data, err := os.ReadFile(seg.path + checkpointExt)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to read WAL checkpoint: %w", err)
+	}
+
+	offset, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid WAL checkpoint for %s: %w", filepath.Base(seg.path), err)
+	}
+	return offset, nil
+}
+
+// writeCheckpoint atomically records the replayed offset of a segment
+func writeCheckpoint(seg segment, offset int64) error {
+	tmp := seg.path + checkpointExt + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.FormatInt(offset, 10)), 0o644); err != nil {
+		return fmt.Errorf("failed to write WAL checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp, seg.path+checkpointExt); err != nil {
+		return fmt.Errorf("failed to write WAL checkpoint: %w", err)
+	}
+	return nil
+}
+
+// removeSegment deletes a fully replayed segment and its checkpoint
+func removeSegment(seg segment) error {
+	if err := os.Remove(seg.path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove WAL segment: %w", err)
+	}
+	if err := os.Remove(seg.path + checkpointExt); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove WAL checkpoint: %w", err)
+	}
+	return nil
+}
+
+// ReadStatus inspects a log directory without locking it
+func ReadStatus(dir string) (Status, error) {
+	status := Status{Dir: dir}
+
+	segments, err := listSegments(dir)
+	if err != nil {
+		return status, err
+	}
+
+	for _, seg := range segments {
+		status.Segments++
+		status.Bytes += seg.size
+		if status.OldestSegment.IsZero() || seg.modTime.Before(status.OldestSegment) {
+			status.OldestSegment = seg.modTime
+		}
+
+		n, err := countRecords(seg)
+		if err != nil {
+			return status, err
+		}
+		status.Records += n
+	}
+
+	return status, nil
+}
+
+// countRecords counts the records of a segment that are not yet replayed
+func countRecords(seg segment) (int, error) {
+	file, err := os.Open(seg.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to open WAL segment: %w", err)
+	}
+	defer file.Close()
+
+	offset, err := readCheckpoint(seg)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("failed to seek WAL segment: %w", err)
+	}
+
+	reader := bufio.NewReader(file)
+	count := 0
+	remaining := seg.size - offset
+	for {
+		records, n, err := readRecords(reader, DefaultReplayBatchSize, remaining)
+		count += len(records)
+		remaining -= n
+		if err != nil || len(records) < DefaultReplayBatchSize {
+			return count, nil
+		}
+	}
+}
+
+// Default log settings
+const (
+	DefaultSegmentSize     = 4 << 20
+	DefaultSyncInterval    = time.Second
+	DefaultReplayBatchSize = 1000
+
+	// MaxRecordSize is the largest record Append accepts
+	MaxRecordSize = 16 << 20
+)
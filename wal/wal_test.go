@@ -0,0 +1,96 @@
+package wal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReplayCorruptLength(t *testing.T) {
+	dir := t.TempDir()
+	log, err := Open(Options{Dir: dir, Sync: SyncNever})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	for _, record := range []string{"first", "second"} {
+		if err := log.Append([]byte(record)); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+	if err := log.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// A header claiming a 4 GiB record must not be allocated
+	path := filepath.Join(dir, segmentName(1))
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("segment not found: %v", err)
+	}
+	header := make([]byte, headerSize)
+	binary.BigEndian.PutUint32(header[0:4], 0xFFFFFFFF)
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("failed to open segment: %v", err)
+	}
+	file.Write(append(header, "tail"...))
+	file.Close()
+
+	log, err = Open(Options{Dir: dir, Sync: SyncNever})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer log.Close()
+
+	var tails []CorruptTail
+	log.OnCorrupt(func(tail CorruptTail) { tails = append(tails, tail) })
+
+	var replayed []string
+	n, err := log.Replay(10, func(records [][]byte) error {
+		for _, record := range records {
+			replayed = append(replayed, string(record))
+		}
+		return nil
+	})
+	if err != nil || n != 2 {
+		t.Fatalf("Replay = %d, %v, want 2 records", n, err)
+	}
+	if want := []string{"first", "second"}; !reflect.DeepEqual(replayed, want) {
+		t.Errorf("replayed %v, want %v", replayed, want)
+	}
+
+	if len(tails) != 1 {
+		t.Fatalf("reported %d corrupt tails, want 1", len(tails))
+	}
+	if tail := tails[0]; tail.Offset != info.Size() || tail.Skipped != headerSize+4 || !errors.Is(tail.Err, errCorrupt) {
+		t.Errorf("corrupt tail = %+v, want offset %d, %d bytes skipped", tail, info.Size(), headerSize+4)
+	}
+}
+
+func TestReadRecordsLength(t *testing.T) {
+	record := func(length uint32, data string) []byte {
+		buf := make([]byte, headerSize, headerSize+len(data))
+		binary.BigEndian.PutUint32(buf[0:4], length)
+		return append(buf, data...)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"above maximum", record(MaxRecordSize+1, "x")},
+		{"past segment end", record(100, "short")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, consumed, err := readRecords(bytes.NewReader(tt.data), 10, int64(len(tt.data)))
+			if len(records) != 0 || consumed != 0 || !errors.Is(err, errCorrupt) {
+				t.Errorf("readRecords = %d records, %d bytes, %v, want errCorrupt", len(records), consumed, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,424 @@
+package ws
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Message types, i.e. the frame opcodes of RFC 6455
+const (
+	TextMessage   = 1
+	BinaryMessage = 2
+	CloseMessage  = 8
+	PingMessage   = 9
+	PongMessage   = 10
+
+	continuationFrame = 0
+)
+
+// Close codes of RFC 6455
+const (
+	CloseNormalClosure    = 1000
+	CloseGoingAway        = 1001
+	CloseProtocolError    = 1002
+	CloseUnsupportedData  = 1003
+	CloseNoStatusReceived = 1005
+	CloseInvalidPayload   = 1007
+	ClosePolicyViolation  = 1008
+	CloseMessageTooBig    = 1009
+	CloseInternalError    = 1011
+	CloseTryAgainLater    = 1013
+)
+
+// maxControlPayload is the largest payload of a control frame
+const maxControlPayload = 125
+
+// controlWriteWait bounds writing a pong or close frame from the reading
+// goroutine
+const controlWriteWait = time.Second
+
+// ErrCloseSent is returned when writing after the close frame has been sent
+var ErrCloseSent = errors.New("ws: close frame already sent")
+
+// ErrReadLimit is returned when a message exceeds the read limit
+var ErrReadLimit = errors.New("ws: message exceeds read limit")
+
+// CloseError is returned by ReadMessage when the peer closed the connection
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	if e.Text == "" {
+		return fmt.Sprintf("ws: connection closed with code %d", e.Code)
+	}
+	return fmt.Sprintf("ws: connection closed with code %d: %s", e.Code, e.Text)
+}
+
+// ProtocolError is returned by ReadMessage when the peer violated RFC 6455
+type ProtocolError struct {
+	Message string
+}
+
+func (e *ProtocolError) Error() string {
+	return "ws: protocol error: " + e.Message
+}
+
+// Conn is a WebSocket connection. One goroutine may read while others write;
+// writes are serialized, and pings are answered while reading.
+type Conn struct {
+	conn net.Conn
+	br   *bufio.Reader
+	// client connections mask the frames they send and expect unmasked
+	// frames; server connections the opposite
+	client bool
+
+	readLimit   int64
+	pongHandler func(data []byte)
+
+	writeMu   sync.Mutex
+	closeSent bool
+
+	// writeDeadline is the deadline set with SetWriteDeadline, applied to
+	// every write but the replies of the reading goroutine
+	deadlineMu    sync.Mutex
+	writeDeadline time.Time
+}
+
+func newConn(conn net.Conn, br *bufio.Reader, client bool) *Conn {
+	return &Conn{conn: conn, br: br, client: client}
+}
+
+// SetReadLimit sets the maximum size of a message; zero means no limit
+func (c *Conn) SetReadLimit(limit int64) {
+	c.readLimit = limit
+}
+
+// SetPongHandler sets a function called from ReadMessage for every pong
+func (c *Conn) SetPongHandler(handler func(data []byte)) {
+	c.pongHandler = handler
+}
+
+// SetReadDeadline sets the deadline of future reads
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the deadline of future writes. Pongs and close frames
+// sent while reading have a deadline of their own.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.deadlineMu.Lock()
+	c.writeDeadline = t
+	c.deadlineMu.Unlock()
+	return c.conn.SetWriteDeadline(t)
+}
+
+// RemoteAddr returns the address of the peer
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// Close closes the underlying connection without a closing handshake
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+// ReadMessage returns the next data message. Fragmented messages are
+// reassembled, pings are answered and pongs passed to the pong handler. A close
+// frame from the peer is echoed and returned as a *CloseError; on protocol
+// violations a close frame with the matching code is sent before returning.
+func (c *Conn) ReadMessage() (int, []byte, error) {
+	var messageType int
+	var message []byte
+
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			var protocolErr *ProtocolError
+			switch {
+			case errors.As(err, &protocolErr):
+				c.replyClose(CloseProtocolError, protocolErr.Message)
+			case errors.Is(err, ErrReadLimit):
+				c.replyClose(CloseMessageTooBig, "")
+			}
+			return 0, nil, err
+		}
+
+		switch opcode {
+		case PingMessage:
+			if err := c.writeControl(PongMessage, payload); err != nil && !errors.Is(err, ErrCloseSent) {
+				return 0, nil, err
+			}
+			continue
+		case PongMessage:
+			if c.pongHandler != nil {
+				c.pongHandler(payload)
+			}
+			continue
+		case CloseMessage:
+			closeErr, err := parseClosePayload(payload)
+			if err != nil {
+				c.replyClose(CloseProtocolError, err.Message)
+				return 0, nil, err
+			}
+			code := closeErr.Code
+			if code == CloseNoStatusReceived {
+				code = CloseNormalClosure
+			}
+			c.replyClose(code, "")
+			return 0, nil, closeErr
+		case TextMessage, BinaryMessage:
+			if messageType != 0 {
+				c.replyClose(CloseProtocolError, "expected continuation frame")
+				return 0, nil, &ProtocolError{Message: "expected continuation frame"}
+			}
+			messageType = opcode
+			message = payload
+		case continuationFrame:
+			if messageType == 0 {
+				c.replyClose(CloseProtocolError, "unexpected continuation frame")
+				return 0, nil, &ProtocolError{Message: "unexpected continuation frame"}
+			}
+			message = append(message, payload...)
+		}
+
+		if c.readLimit > 0 && int64(len(message)) > c.readLimit {
+			c.replyClose(CloseMessageTooBig, "")
+			return 0, nil, ErrReadLimit
+		}
+
+		if fin {
+			if messageType == TextMessage && !utf8.Valid(message) {
+				c.replyClose(CloseInvalidPayload, "invalid UTF-8")
+				return 0, nil, &ProtocolError{Message: "invalid UTF-8 in text message"}
+			}
+			return messageType, message, nil
+		}
+	}
+}
+
+// ReadJSON reads the next data message and decodes it into v
+func (c *Conn) ReadJSON(v interface{}) error {
+	_, data, err := c.ReadMessage()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// WriteMessage writes a data message in a single frame
+func (c *Conn) WriteMessage(messageType int, data []byte) error {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return fmt.Errorf("ws: invalid data message type %d", messageType)
+	}
+	return c.writeFrame(messageType, data, c.deadline())
+}
+
+// WriteJSON writes v encoded as JSON in a text message
+func (c *Conn) WriteJSON(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.writeFrame(TextMessage, data, c.deadline())
+}
+
+// Ping sends a ping; the peer's pong is passed to the pong handler
+func (c *Conn) Ping(data []byte) error {
+	if len(data) > maxControlPayload {
+		return errors.New("ws: ping payload too long")
+	}
+	return c.writeFrame(PingMessage, data, c.deadline())
+}
+
+// WriteClose starts the closing handshake. Nothing can be written afterwards;
+// the peer's close frame is returned by ReadMessage.
+func (c *Conn) WriteClose(code int, text string) error {
+	return c.writeFrame(CloseMessage, closePayload(code, text), c.deadline())
+}
+
+// replyClose sends a close frame in answer to the peer, without
+// letting a stuck peer block the reader; it is a no-op once one was sent
+func (c *Conn) replyClose(code int, text string) {
+	c.writeControl(CloseMessage, closePayload(code, text))
+}
+
+// writeControl writes a frame answering the peer from the reading goroutine
+// with a fresh deadline, so that neither an expired deadline of an earlier
+// write nor a stuck peer affects it
+func (c *Conn) writeControl(opcode int, payload []byte) error {
+	return c.writeFrame(opcode, payload, time.Now().Add(controlWriteWait))
+}
+
+// deadline returns the deadline set with SetWriteDeadline
+func (c *Conn) deadline() time.Time {
+	c.deadlineMu.Lock()
+	defer c.deadlineMu.Unlock()
+	return c.writeDeadline
+}
+
+// readFrame reads a frame and unmasks its payload
+func (c *Conn) readFrame() (bool, int, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.br, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+
+	fin := header[0]&0x80 != 0
+	if header[0]&0x70 != 0 {
+		return false, 0, nil, &ProtocolError{Message: "reserved bits set"}
+	}
+	opcode := int(header[0] & 0x0f)
+	switch opcode {
+	case continuationFrame, TextMessage, BinaryMessage, CloseMessage, PingMessage, PongMessage:
+	default:
+		return false, 0, nil, &ProtocolError{Message: fmt.Sprintf("unknown opcode %d", opcode)}
+	}
+
+	masked := header[1]&0x80 != 0
+	if masked == c.client {
+		if c.client {
+			return false, 0, nil, &ProtocolError{Message: "masked frame from server"}
+		}
+		return false, 0, nil, &ProtocolError{Message: "unmasked frame from client"}
+	}
+
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+		if length>>63 != 0 {
+			return false, 0, nil, &ProtocolError{Message: "invalid payload length"}
+		}
+	}
+
+	if opcode >= CloseMessage && (!fin || length > maxControlPayload) {
+		return false, 0, nil, &ProtocolError{Message: "invalid control frame"}
+	}
+	if c.readLimit > 0 && length > uint64(c.readLimit) {
+		return false, 0, nil, ErrReadLimit
+	}
+
+	var key [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.br, key[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		maskBytes(key, payload)
+	}
+
+	return fin, opcode, payload, nil
+}
+
+// writeFrame writes a final frame with a deadline, masking it on client
+// connections
+func (c *Conn) writeFrame(opcode int, payload []byte, deadline time.Time) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if c.closeSent {
+		return ErrCloseSent
+	}
+	if opcode == CloseMessage {
+		c.closeSent = true
+	}
+
+	frame := make([]byte, 0, 14+len(payload))
+	frame = append(frame, 0x80|byte(opcode))
+
+	var maskBit byte
+	if c.client {
+		maskBit = 0x80
+	}
+	switch length := len(payload); {
+	case length <= 125:
+		frame = append(frame, maskBit|byte(length))
+	case length <= 0xffff:
+		frame = append(frame, maskBit|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(length))
+	default:
+		frame = append(frame, maskBit|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(length))
+	}
+
+	if c.client {
+		var key [4]byte
+		if _, err := rand.Read(key[:]); err != nil {
+			return err
+		}
+		frame = append(frame, key[:]...)
+		start := len(frame)
+		frame = append(frame, payload...)
+		maskBytes(key, frame[start:])
+	} else {
+		frame = append(frame, payload...)
+	}
+
+	c.conn.SetWriteDeadline(deadline)
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+// maskBytes applies the masking key to data in place
+func maskBytes(key [4]byte, data []byte) {
+	for i := range data {
+		data[i] ^= key[i&3]
+	}
+}
+
+// closePayload encodes a close code and reason, truncating the reason to fit
+// a control frame
+func closePayload(code int, text string) []byte {
+	if code == CloseNoStatusReceived {
+		return nil
+	}
+	if len(text) > maxControlPayload-2 {
+		text = text[:maxControlPayload-2]
+		for !utf8.ValidString(text) {
+			text = text[:len(text)-1]
+		}
+	}
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	return append(payload, text...)
+}
+
+// parseClosePayload decodes the payload of a close frame
+func parseClosePayload(payload []byte) (*CloseError, *ProtocolError) {
+	if len(payload) == 0 {
+		return &CloseError{Code: CloseNoStatusReceived}, nil
+	}
+	if len(payload) < 2 || !utf8.Valid(payload[2:]) {
+		return nil, &ProtocolError{Message: "invalid close payload"}
+	}
+	return &CloseError{
+		Code: int(binary.BigEndian.Uint16(payload)),
+		Text: string(payload[2:]),
+	}, nil
+}
@@ -0,0 +1,320 @@
+package ws
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testServer serves WebSocket connections accepted from allowedOrigins to
+// serve, which runs on the server side of each connection
+func testServer(t *testing.T, allowedOrigins []string, serve func(*Conn)) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r, allowedOrigins)
+		if err != nil {
+			var handshakeErr *HandshakeError
+			if errors.As(err, &handshakeErr) {
+				http.Error(w, handshakeErr.Message, handshakeErr.Status)
+				return
+			}
+			t.Errorf("Upgrade: %v", err)
+			return
+		}
+		defer conn.Close()
+		serve(conn)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// wsURL returns the ws:// URL of a test server
+func wsURL(server *httptest.Server) string {
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+// echo sends every data message back until the connection is closed
+func echo(conn *Conn) {
+	for {
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if err := conn.WriteMessage(messageType, data); err != nil {
+			return
+		}
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	server := testServer(t, []string{"https://dashboard.example.com"}, echo)
+
+	tests := []struct {
+		name   string
+		origin string
+		status int
+	}{
+		{name: "no origin"},
+		{name: "same host", origin: server.URL},
+		{name: "allowed origin", origin: "https://dashboard.example.com"},
+		{name: "allowed origin in other case", origin: "https://Dashboard.Example.com"},
+		{name: "other origin", origin: "https://evil.example.com", status: http.StatusForbidden},
+		{name: "other scheme", origin: "http://dashboard.example.com", status: http.StatusForbidden},
+		{name: "invalid origin", origin: "null", status: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := make(http.Header)
+			if tt.origin != "" {
+				header.Set("Origin", tt.origin)
+			}
+
+			conn, err := Dial(wsURL(server), header)
+			if tt.status != 0 {
+				var handshakeErr *HandshakeError
+				if !errors.As(err, &handshakeErr) || handshakeErr.Status != tt.status {
+					t.Fatalf("Dial error = %v, want handshake status %d", err, tt.status)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Dial: %v", err)
+			}
+			defer conn.Close()
+
+			if err := conn.WriteMessage(TextMessage, []byte("hello")); err != nil {
+				t.Fatal(err)
+			}
+			messageType, data, err := conn.ReadMessage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if messageType != TextMessage || string(data) != "hello" {
+				t.Errorf("echo = %d %q, want text %q", messageType, data, "hello")
+			}
+		})
+	}
+}
+
+func TestHandshakeAnyOrigin(t *testing.T) {
+	server := testServer(t, []string{"*"}, echo)
+
+	conn, err := Dial(wsURL(server), http.Header{"Origin": {"https://anywhere.example.com"}})
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	conn.Close()
+}
+
+func TestUpgradeInvalidRequests(t *testing.T) {
+	server := testServer(t, nil, echo)
+
+	valid := func() *http.Request {
+		req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", "websocket")
+		req.Header.Set("Sec-WebSocket-Version", "13")
+		req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		return req
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*http.Request)
+		status int
+	}{
+		{"POST", func(r *http.Request) { r.Method = http.MethodPost }, http.StatusMethodNotAllowed},
+		{"no upgrade", func(r *http.Request) { r.Header.Del("Upgrade") }, http.StatusBadRequest},
+		{"old version", func(r *http.Request) { r.Header.Set("Sec-WebSocket-Version", "8") }, http.StatusUpgradeRequired},
+		{"invalid key", func(r *http.Request) { r.Header.Set("Sec-WebSocket-Key", "short") }, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := valid()
+			tt.modify(req)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+		})
+	}
+}
+
+func TestPingPong(t *testing.T) {
+	pongs := make(chan string, 1)
+	server := testServer(t, nil, func(conn *Conn) {
+		conn.SetPongHandler(func(data []byte) { pongs <- string(data) })
+		if err := conn.Ping([]byte("are you there")); err != nil {
+			t.Errorf("Ping: %v", err)
+			return
+		}
+		echo(conn)
+	})
+
+	conn, err := Dial(wsURL(server), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	// The client answers the ping while reading
+	go conn.ReadMessage()
+
+	select {
+	case data := <-pongs:
+		if data != "are you there" {
+			t.Errorf("pong payload = %q, want the ping payload", data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no pong received")
+	}
+}
+
+func TestPongAfterWriteDeadline(t *testing.T) {
+	readErr := make(chan error, 1)
+	server := testServer(t, nil, func(conn *Conn) {
+		conn.SetWriteDeadline(time.Now().Add(50 * time.Millisecond))
+		if err := conn.WriteMessage(TextMessage, []byte("hello")); err != nil {
+			t.Errorf("WriteMessage: %v", err)
+			return
+		}
+		// The client pings after the deadline of the last write passed
+		_, data, err := conn.ReadMessage()
+		if err == nil && string(data) != "still there" {
+			err = errors.New("unexpected message " + string(data))
+		}
+		readErr <- err
+	})
+
+	conn, err := Dial(wsURL(server), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	if _, data, err := conn.ReadMessage(); err != nil || string(data) != "hello" {
+		t.Fatalf("ReadMessage = %q, %v", data, err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	pongs := make(chan string, 1)
+	conn.SetPongHandler(func(data []byte) { pongs <- string(data) })
+	if err := conn.Ping([]byte("keep-alive")); err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+	go conn.ReadMessage()
+
+	select {
+	case data := <-pongs:
+		if data != "keep-alive" {
+			t.Errorf("pong payload = %q, want the ping payload", data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no pong received")
+	}
+
+	if err := conn.WriteMessage(TextMessage, []byte("still there")); err != nil {
+		t.Fatalf("WriteMessage: %v", err)
+	}
+	select {
+	case err := <-readErr:
+		if err != nil {
+			t.Errorf("server ReadMessage after answering a late ping = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not receive the message")
+	}
+}
+
+func TestReadDeadline(t *testing.T) {
+	readErr := make(chan error, 1)
+	server := testServer(t, nil, func(conn *Conn) {
+		conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		_, _, err := conn.ReadMessage()
+		readErr <- err
+	})
+
+	conn, err := Dial(wsURL(server), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	select {
+	case err := <-readErr:
+		if err == nil || !strings.Contains(err.Error(), "timeout") {
+			t.Errorf("ReadMessage of a silent peer = %v, want a timeout", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("read deadline not applied")
+	}
+}
+
+func TestClose(t *testing.T) {
+	serverErr := make(chan error, 1)
+	server := testServer(t, nil, func(conn *Conn) {
+		_, _, err := conn.ReadMessage()
+		serverErr <- err
+	})
+
+	conn, err := Dial(wsURL(server), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	if err := conn.WriteClose(CloseGoingAway, "bye"); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.WriteMessage(TextMessage, []byte("late")); !errors.Is(err, ErrCloseSent) {
+		t.Errorf("write after close = %v, want ErrCloseSent", err)
+	}
+
+	var closeErr *CloseError
+	if err := <-serverErr; !errors.As(err, &closeErr) || closeErr.Code != CloseGoingAway || closeErr.Text != "bye" {
+		t.Errorf("server read %v, want close %d %q", err, CloseGoingAway, "bye")
+	}
+
+	// The server echoes the close frame
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := conn.ReadMessage(); !errors.As(err, &closeErr) || closeErr.Code != CloseGoingAway {
+		t.Errorf("client read %v, want the echoed close %d", err, CloseGoingAway)
+	}
+}
+
+func TestReadLimit(t *testing.T) {
+	serverErr := make(chan error, 1)
+	server := testServer(t, nil, func(conn *Conn) {
+		conn.SetReadLimit(8)
+		_, _, err := conn.ReadMessage()
+		serverErr <- err
+	})
+
+	conn, err := Dial(wsURL(server), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	if err := conn.WriteMessage(BinaryMessage, make([]byte, 9)); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-serverErr; !errors.Is(err, ErrReadLimit) {
+		t.Errorf("server read %v, want ErrReadLimit", err)
+	}
+
+	var closeErr *CloseError
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := conn.ReadMessage(); !errors.As(err, &closeErr) || closeErr.Code != CloseMessageTooBig {
+		t.Errorf("client read %v, want close %d", err, CloseMessageTooBig)
+	}
+}